@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminPathPrefix is the prefix of the paths of the admin API
+const AdminPathPrefix = "/admin/"
+
+// AdminHeader must be set in requests to the admin API, browsers don't
+// send custom headers to other origins without a preflight request, so
+// web pages cannot use the API
+const AdminHeader = "X-Pouch-Admin"
+
+// Query parameters with the argument of each command of the admin API
+var adminCommandArgs = map[string]string{
+	ControlRefresh:      "secret",
+	ControlRerender:     "file",
+	ControlStatus:       "",
+	ControlReloadConfig: "",
+	ControlRevoke:       "secret",
+}
+
+// isLoopbackHost returns true if the host requested is a loopback address
+// or localhost, other names could be resolved to loopback addresses by
+// DNS rebinding attacks
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// adminHandler serves the commands of the control socket over HTTP, only
+// to local clients. Status is requested with GET, and other commands with
+// POST, e.g. POST /admin/refresh?secret=db.
+func (s *StatusServer) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.Admin {
+		http.NotFound(w, r)
+		return
+	}
+	if !isLoopback(r.RemoteAddr) {
+		http.Error(w, "admin API only available from localhost", http.StatusForbidden)
+		return
+	}
+	if !isLoopbackHost(r.Host) {
+		http.Error(w, "admin API only available in localhost", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get(AdminHeader) == "" {
+		http.Error(w, "header "+AdminHeader+" needed", http.StatusForbidden)
+		return
+	}
+	command := strings.TrimPrefix(r.URL.Path, AdminPathPrefix)
+	argName, found := adminCommandArgs[command]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	method := http.MethodPost
+	if command == ControlStatus {
+		method = http.MethodGet
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var args []string
+	if argName != "" {
+		arg := r.URL.Query().Get(argName)
+		if arg == "" {
+			writeAdminResponse(w, http.StatusBadRequest, ControlResponse{Error: "parameter '" + argName + "' needed"})
+			return
+		}
+		args = append(args, arg)
+	}
+	resp := s.pouch.controlCommand(r.Context(), command, args)
+	code := http.StatusOK
+	if resp.Error != "" {
+		code = http.StatusInternalServerError
+	}
+	writeAdminResponse(w, code, resp)
+}
+
+func writeAdminResponse(w http.ResponseWriter, code int, resp ControlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
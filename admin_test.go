@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Timestamp: time.Now(), LeaseID: "lease", LeaseDuration: 100},
+	}
+	p := &pouch{
+		State:   state,
+		Secrets: map[string]SecretConfig{"foo": {}, "bar": {}},
+		Events:  NewEventLog(0),
+	}
+	p.secretUpdateFailed("bar", errors.New("permission denied"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.controlRequests = make(chan controlRequest)
+	go func() {
+		for {
+			select {
+			case req := <-p.controlRequests:
+				p.runControl(req)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	s := NewStatusServer("", p)
+	request := func(method, url, remoteAddr string) (*httptest.ResponseRecorder, ControlResponse) {
+		r := httptest.NewRequest(method, url, nil)
+		r.RemoteAddr = remoteAddr
+		r.Host = "localhost:8080"
+		r.Header.Set(AdminHeader, "1")
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, r)
+		var resp ControlResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, _ := request("GET", "/admin/status", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	s.Admin = true
+	w, _ = request("GET", "/admin/status", "192.168.1.2:1234")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = request("POST", "/admin/status", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	w, _ = request("POST", "/admin/shutdown", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = request("POST", "/admin/refresh", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, resp := request("POST", "/admin/refresh?secret=baz", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "unknown secret 'baz'", resp.Error)
+
+	w, resp = request("GET", "/admin/status", "127.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	d, _ := json.Marshal(resp.Result)
+	var report StatusReport
+	assert.NoError(t, json.Unmarshal(d, &report))
+	if assert.Len(t, report.Secrets, 2) {
+		assert.Equal(t, "bar", report.Secrets[0].Name)
+		assert.Equal(t, "permission denied", report.Secrets[0].LastError)
+		assert.Equal(t, 1, report.Secrets[0].Failures)
+		assert.Nil(t, report.Secrets[0].LastFetch)
+		assert.Equal(t, "foo", report.Secrets[1].Name)
+		assert.Equal(t, "lease", report.Secrets[1].LeaseID)
+		assert.NotNil(t, report.Secrets[1].NextUpdate)
+	}
+}
+
+func TestAdminAPIForgery(t *testing.T) {
+	p := &pouch{
+		State:  NewState(""),
+		Events: NewEventLog(0),
+	}
+	s := NewStatusServer("", p)
+	s.Admin = true
+	request := func(host string, headers map[string]string) int {
+		r := httptest.NewRequest("POST", "/admin/reload-config", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Host = host
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Cross-origin requests from browsers cannot set the header
+	// without a preflight
+	assert.Equal(t, http.StatusForbidden, request("127.0.0.1:8080", nil))
+	assert.Equal(t, http.StatusForbidden, request("localhost:8080", map[string]string{"Origin": "http://example.com"}))
+
+	// Rebound names can set the header, but not the host
+	assert.Equal(t, http.StatusForbidden, request("attacker.example.com:8080", map[string]string{AdminHeader: "1"}))
+}
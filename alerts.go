@@ -0,0 +1,271 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	DefaultAlertTimeout = 30 * time.Second
+
+	// Consecutive failures to read a secret before alerting
+	DefaultAlertFailures = 3
+
+	// Portion of the life of a secret after which it is considered close
+	// to expire if it hasn't been renewed
+	LeaseExpiringRatio = 0.9
+
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+)
+
+// Events alerted by default
+var defaultAlertEvents = []string{
+	EventSecretUpdateFailed,
+	EventLeaseExpiring,
+	EventFileCheckFailed,
+}
+
+// AlertConfig defines a channel where operators are alerted about problems
+// rotating secrets, one and only one channel must be set
+type AlertConfig struct {
+	Webhook   *WebhookNotifierConfig `json:"webhook,omitempty"`
+	Slack     *SlackAlertConfig      `json:"slack,omitempty"`
+	PagerDuty *PagerDutyAlertConfig  `json:"pagerduty,omitempty"`
+
+	// Types of events alerted, secret_update_failed, lease_expiring and
+	// file_check_failed by default
+	Events []string `json:"events,omitempty"`
+
+	// Consecutive failures to read a secret before alerting, 3 by default
+	Failures int `json:"failures,omitempty"`
+}
+
+type SlackAlertConfig struct {
+	// URL of an incoming webhook
+	WebhookURL string `json:"webhook_url"`
+	Channel    string `json:"channel,omitempty"`
+}
+
+type PagerDutyAlertConfig struct {
+	// Integration key of the service in the Events API v2
+	RoutingKey string `json:"routing_key"`
+
+	// Severity of the alerts, error by default
+	Severity string `json:"severity,omitempty"`
+
+	// URL of the Events API, for testing or proxies
+	URL string `json:"url,omitempty"`
+}
+
+// alertEvent is the event sent in alerts, with the host where it happened
+type alertEvent struct {
+	Event
+	Host string `json:"host"`
+}
+
+func (c AlertConfig) validate() error {
+	count := 0
+	if c.Webhook != nil {
+		count++
+	}
+	if c.Slack != nil {
+		count++
+	}
+	if c.PagerDuty != nil {
+		count++
+	}
+	if count != 1 {
+		return fmt.Errorf("one and only one alert channel can be set")
+	}
+	return nil
+}
+
+// matches returns true if an event has to be alerted
+func (c AlertConfig) matches(e Event) bool {
+	events := c.Events
+	if len(events) == 0 {
+		events = defaultAlertEvents
+	}
+	if !stringInSlice(e.Type, events) {
+		return false
+	}
+	if e.Type == EventSecretUpdateFailed {
+		failures := c.Failures
+		if failures <= 0 {
+			failures = DefaultAlertFailures
+		}
+		// Only once for each series of failures
+		n, _ := e.Details["failures"].(int)
+		return n == failures
+	}
+	return true
+}
+
+func postJSON(ctx context.Context, url string, body interface{}) error {
+	d, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("alert rejected with status %d: %s", resp.StatusCode, out)
+	}
+	return nil
+}
+
+func (c SlackAlertConfig) send(ctx context.Context, e alertEvent) error {
+	message := map[string]string{
+		"text": fmt.Sprintf("pouch on %s: %s", e.Host, e.Message),
+	}
+	if c.Channel != "" {
+		message["channel"] = c.Channel
+	}
+	return postJSON(ctx, c.WebhookURL, message)
+}
+
+func (c PagerDutyAlertConfig) send(ctx context.Context, e alertEvent) error {
+	url := c.URL
+	if url == "" {
+		url = DefaultPagerDutyURL
+	}
+	severity := c.Severity
+	if severity == "" {
+		severity = "error"
+	}
+	// Events about the same object are grouped in the same incident
+	dedupKey := fmt.Sprintf("pouch/%s/%s/%s%s", e.Host, e.Type, e.Secret, e.File)
+	return postJSON(ctx, url, map[string]interface{}{
+		"routing_key":  c.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    dedupKey,
+		"payload": map[string]interface{}{
+			"summary":        e.Message,
+			"source":         e.Host,
+			"severity":       severity,
+			"timestamp":      e.Time.Format(time.RFC3339),
+			"component":      "pouch",
+			"class":          e.Type,
+			"custom_details": e.Details,
+		},
+	})
+}
+
+func (c AlertConfig) send(ctx context.Context, e alertEvent) error {
+	switch {
+	case c.Webhook != nil:
+		_, err := c.Webhook.send(ctx, e)
+		return err
+	case c.Slack != nil:
+		return c.Slack.send(ctx, e)
+	case c.PagerDuty != nil:
+		return c.PagerDuty.send(ctx, e)
+	}
+	return nil
+}
+
+func (p *pouch) AddAlert(name string, c AlertConfig) error {
+	err := c.validate()
+	if err != nil {
+		return fmt.Errorf("alert '%s': %v", name, err)
+	}
+	if p.alerts == nil {
+		p.alerts = make(map[string]AlertConfig)
+	}
+	p.alerts[name] = c
+	return nil
+}
+
+// alert sends an event to the alerts interested in it, in background so
+// unavailable channels don't block updates
+func (p *pouch) alert(e Event) {
+	host, _ := os.Hostname()
+	for name, c := range p.alerts {
+		if !c.matches(e) {
+			continue
+		}
+		go func(name string, c AlertConfig) {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultAlertTimeout)
+			defer cancel()
+			err := c.send(ctx, alertEvent{Event: e, Host: host})
+			if err != nil {
+				errorf("Couldn't send alert '%s': %v", name, err)
+			}
+		}(name, c)
+	}
+}
+
+// secretUpdateFailed records a failed read of a secret
+func (p *pouch) secretUpdateFailed(name string, err error) {
+	if p.secretFailures == nil {
+		p.secretFailures = make(map[string]int)
+	}
+	p.secretFailures[name]++
+	if p.secretErrors == nil {
+		p.secretErrors = make(map[string]secretError)
+	}
+	p.secretErrors[name] = secretError{Message: redactSecrets(err.Error()), Time: time.Now()}
+	p.event(Event{
+		Type:    EventSecretUpdateFailed,
+		Secret:  name,
+		Message: fmt.Sprintf("Couldn't update secret '%s': %v", name, err),
+		Details: map[string]interface{}{"failures": p.secretFailures[name]},
+	})
+}
+
+// checkLeases reports secrets close to expire that haven't been renewed,
+// once for each version of the secret
+func (p *pouch) checkLeases(now time.Time) {
+	for name, s := range p.State.Secrets {
+		expiration, known := s.Expiration()
+		if !known || s.Timestamp.IsZero() || !expiration.After(s.Timestamp) {
+			continue
+		}
+		life := expiration.Sub(s.Timestamp)
+		warning := s.Timestamp.Add(time.Duration(float64(life) * LeaseExpiringRatio))
+		if now.Before(warning) || p.leasesExpiring[name].Equal(s.Timestamp) {
+			continue
+		}
+		if p.leasesExpiring == nil {
+			p.leasesExpiring = make(map[string]time.Time)
+		}
+		p.leasesExpiring[name] = s.Timestamp
+		p.event(Event{
+			Type:    EventLeaseExpiring,
+			Secret:  name,
+			Message: fmt.Sprintf("Secret '%s' expires at %s and it hasn't been renewed", name, expiration.Format(time.RFC3339)),
+			Details: map[string]interface{}{"expiration": expiration},
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertMatches(t *testing.T) {
+	c := AlertConfig{Slack: &SlackAlertConfig{}}
+	assert.NoError(t, c.validate())
+	assert.True(t, c.matches(Event{Type: EventFileCheckFailed}))
+	assert.False(t, c.matches(Event{Type: EventFileWritten}))
+
+	for failures, expected := range map[int]bool{1: false, 3: true, 4: false} {
+		e := Event{Type: EventSecretUpdateFailed, Details: map[string]interface{}{"failures": failures}}
+		assert.Equal(t, expected, c.matches(e), fmt.Sprintf("%d failures", failures))
+	}
+
+	c.Events = []string{EventFileWritten}
+	assert.True(t, c.matches(Event{Type: EventFileWritten}))
+	assert.False(t, c.matches(Event{Type: EventFileCheckFailed}))
+
+	c.Webhook = &WebhookNotifierConfig{}
+	assert.Error(t, c.validate())
+	assert.Error(t, AlertConfig{}.validate())
+}
+
+func TestAlertChannels(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	e := alertEvent{
+		Event: Event{Type: EventFileCheckFailed, File: "/foo", Message: "Check of '/foo' failed"},
+		Host:  "node-1",
+	}
+	ctx := context.Background()
+
+	slack := AlertConfig{Slack: &SlackAlertConfig{WebhookURL: server.URL, Channel: "#ops"}}
+	assert.NoError(t, slack.send(ctx, e))
+	assert.Equal(t, "pouch on node-1: Check of '/foo' failed", received["text"])
+	assert.Equal(t, "#ops", received["channel"])
+
+	pagerduty := AlertConfig{PagerDuty: &PagerDutyAlertConfig{RoutingKey: "key", URL: server.URL}}
+	assert.NoError(t, pagerduty.send(ctx, e))
+	assert.Equal(t, "key", received["routing_key"])
+	assert.Equal(t, "pouch/node-1/file_check_failed//foo", received["dedup_key"])
+	if payload, ok := received["payload"].(map[string]interface{}); assert.True(t, ok, "payload expected") {
+		assert.Equal(t, "error", payload["severity"])
+		assert.Equal(t, "node-1", payload["source"])
+	}
+
+	webhook := AlertConfig{Webhook: &WebhookNotifierConfig{URL: server.URL}}
+	assert.NoError(t, webhook.send(ctx, e))
+	assert.Equal(t, "node-1", received["host"])
+	assert.Equal(t, "/foo", received["file"])
+}
+
+func TestCheckLeases(t *testing.T) {
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := &pouch{State: state, Events: NewEventLog(0)}
+
+	now := time.Now()
+	state.Secrets = map[string]*SecretState{
+		"db": {Name: "db", Timestamp: now.Add(-95 * time.Second), LeaseDuration: 100},
+		"ok": {Name: "ok", Timestamp: now, LeaseDuration: 100},
+	}
+	p.checkLeases(now)
+	p.checkLeases(now)
+	events := p.Events.Recent()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventLeaseExpiring, events[0].Type)
+		assert.Equal(t, "db", events[0].Secret)
+	}
+
+	// New versions of secrets are reported again if they are close to expire too
+	state.Secrets["db"].Timestamp = now.Add(-91 * time.Second)
+	p.checkLeases(now)
+	assert.Len(t, p.Events.Recent(), 2)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+const (
+	// Number of rotations kept in the state of each secret
+	MaxRotationHistory = 50
+
+	DefaultRotationAnomalyWindow       = time.Hour
+	DefaultRotationAnomalyFactor       = 10
+	DefaultRotationAnomalyMinRotations = 3
+
+	// Rotations needed before the window to know the baseline
+	minBaselineRotations = 3
+)
+
+// RotationAnomalyConfig configures the detection of secrets rotating much
+// more often than usual, what could be caused by a compromise or by a
+// misconfiguration
+type RotationAnomalyConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Period where recent rotations are counted, one hour by default
+	Window string `json:"window,omitempty"`
+
+	// A secret is anomalous if it rotates in the window this number of
+	// times more than expected from its baseline, 10 by default
+	Factor float64 `json:"factor,omitempty"`
+
+	// Minimum number of rotations in the window to consider a secret
+	// anomalous, 3 by default
+	MinRotations int `json:"min_rotations,omitempty"`
+}
+
+func (c RotationAnomalyConfig) WindowPeriod() (time.Duration, error) {
+	if c.Window == "" {
+		return DefaultRotationAnomalyWindow, nil
+	}
+	d, err := time.ParseDuration(c.Window)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("rotation anomaly window must be positive")
+	}
+	return d, nil
+}
+
+// RotationAnomaly describes the rotations of a secret in a window compared
+// with its baseline
+type RotationAnomaly struct {
+	Window    time.Duration
+	Rotations int
+
+	// Rotations expected in the window from the baseline, zero if the
+	// baseline is not known yet
+	Expected float64
+}
+
+// detectRotationAnomaly compares the rotations in the window with the rate
+// of rotations before it, it returns nil if the secret is not anomalous
+func detectRotationAnomaly(rotations []time.Time, now time.Time, c RotationAnomalyConfig) (*RotationAnomaly, error) {
+	window, err := c.WindowPeriod()
+	if err != nil {
+		return nil, err
+	}
+	factor := c.Factor
+	if factor <= 0 {
+		factor = DefaultRotationAnomalyFactor
+	}
+	minRotations := c.MinRotations
+	if minRotations <= 0 {
+		minRotations = DefaultRotationAnomalyMinRotations
+	}
+
+	start := now.Add(-window)
+	var baseline []time.Time
+	recent := 0
+	for _, t := range rotations {
+		if t.After(start) {
+			recent++
+		} else {
+			baseline = append(baseline, t)
+		}
+	}
+	if recent < minRotations || len(baseline) < minBaselineRotations {
+		return nil, nil
+	}
+
+	// Rate of rotations since the oldest one known till the window
+	period := start.Sub(baseline[0])
+	if period <= 0 {
+		return nil, nil
+	}
+	expected := float64(len(baseline)) * float64(window) / float64(period)
+	if float64(recent) <= factor*expected {
+		return nil, nil
+	}
+	return &RotationAnomaly{Window: window, Rotations: recent, Expected: expected}, nil
+}
+
+// recordRotation adds a rotation to the history of a secret and checks
+// if it is rotating more often than usual
+func (p *pouch) recordRotation(name string) {
+	state, found := p.State.Secrets[name]
+	if !found {
+		return
+	}
+	now := time.Now()
+	state.Rotations = append(state.Rotations, now)
+	if len(state.Rotations) > MaxRotationHistory {
+		state.Rotations = state.Rotations[len(state.Rotations)-MaxRotationHistory:]
+	}
+	labels := metrics.Labels{"secret": name}
+	p.Metrics.Add(MetricSecretRotations, labels, 1)
+
+	c := p.Secrets[name].RotationAnomaly
+	if c == nil {
+		c = &RotationAnomalyConfig{}
+	}
+	if c.Disabled {
+		return
+	}
+	anomaly, err := detectRotationAnomaly(state.Rotations, now, *c)
+	if err != nil {
+		warnf("Couldn't check rotations of secret '%s': %v", name, err)
+		return
+	}
+	if anomaly == nil {
+		p.Metrics.Set(MetricSecretRotationAnomaly, labels, 0)
+		return
+	}
+	p.Metrics.Set(MetricSecretRotationAnomaly, labels, 1)
+	p.event(Event{
+		Type:   EventRotationAnomaly,
+		Secret: name,
+		Message: fmt.Sprintf("Secret '%s' rotated %d times in %s, %.1f times expected",
+			name, anomaly.Rotations, anomaly.Window, anomaly.Expected),
+		Details: map[string]interface{}{
+			"window":    anomaly.Window.String(),
+			"rotations": anomaly.Rotations,
+			"expected":  anomaly.Expected,
+		},
+	})
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectRotationAnomaly(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	// Daily rotations
+	var daily []time.Time
+	for i := 10; i > 0; i-- {
+		daily = append(daily, ago(time.Duration(i)*24*time.Hour))
+	}
+
+	cases := []struct {
+		title     string
+		rotations []time.Time
+		config    RotationAnomalyConfig
+		anomalous bool
+	}{
+		{
+			title:     "no rotations",
+			rotations: nil,
+		},
+		{
+			title:     "usual rotations",
+			rotations: append(daily, ago(time.Minute)),
+		},
+		{
+			title:     "many recent rotations",
+			rotations: append(daily, ago(30*time.Minute), ago(20*time.Minute), ago(time.Minute)),
+			anomalous: true,
+		},
+		{
+			title:     "less recent rotations than minimum",
+			rotations: append(daily, ago(30*time.Minute), ago(20*time.Minute), ago(time.Minute)),
+			config:    RotationAnomalyConfig{MinRotations: 4},
+		},
+		{
+			title:     "recent rotations in a shorter window",
+			rotations: append(daily, ago(30*time.Minute), ago(20*time.Minute), ago(time.Minute)),
+			config:    RotationAnomalyConfig{Window: "10m"},
+		},
+		{
+			title:     "unknown baseline",
+			rotations: []time.Time{ago(48 * time.Hour), ago(30 * time.Minute), ago(20 * time.Minute), ago(time.Minute)},
+		},
+		{
+			title:     "frequent usual rotations",
+			rotations: []time.Time{ago(4 * time.Hour), ago(3 * time.Hour), ago(2 * time.Hour), ago(50 * time.Minute), ago(30 * time.Minute), ago(time.Minute)},
+		},
+		{
+			title:     "frequent usual rotations with small factor",
+			rotations: []time.Time{ago(4 * time.Hour), ago(3 * time.Hour), ago(2 * time.Hour), ago(50 * time.Minute), ago(30 * time.Minute), ago(time.Minute)},
+			config:    RotationAnomalyConfig{Factor: 2},
+			anomalous: true,
+		},
+	}
+
+	for _, c := range cases {
+		anomaly, err := detectRotationAnomaly(c.rotations, now, c.config)
+		assert.NoError(t, err, c.title)
+		assert.Equal(t, c.anomalous, anomaly != nil, c.title)
+	}
+
+	_, err := detectRotationAnomaly(daily, now, RotationAnomalyConfig{Window: "foo"})
+	assert.Error(t, err)
+}
+
+func TestRecordRotation(t *testing.T) {
+	state, cleanup := newTestState()
+	defer cleanup()
+
+	p := NewPouch(state, nil, map[string]SecretConfig{"foo": {}}, nil, nil).(*pouch)
+	p.State.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "1"}})
+	for i := 0; i < MaxRotationHistory+10; i++ {
+		p.recordRotation("foo")
+	}
+	assert.Len(t, p.State.Secrets["foo"].Rotations, MaxRotationHistory)
+
+	p.State.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "2"}})
+	assert.Len(t, p.State.Secrets["foo"].Rotations, MaxRotationHistory)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+)
+
+const DefaultAuditSyslogTag = "pouch-audit"
+
+// Events recorded by default in the audit log
+var defaultAuditEvents = []string{
+	EventSecretUpdated,
+	EventFileWritten,
+	EventFileHealed,
+	EventNotification,
+	EventSecretServed,
+	EventSecretAccessDenied,
+}
+
+// AuditConfig defines an append-only log of the secrets read, the files
+// written with them and the notifications sent, one and only one of
+// file or syslog must be set
+type AuditConfig struct {
+	// Path of a file where records are appended as JSON lines
+	Path string `json:"path,omitempty"`
+
+	Syslog *SyslogAuditConfig `json:"syslog,omitempty"`
+
+	// Types of events recorded, secret_updated, file_written, file_healed
+	// and notification by default
+	Events []string `json:"events,omitempty"`
+}
+
+type SyslogAuditConfig struct {
+	// Network and address of a remote syslog server, local syslog
+	// is used if not set
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	// Tag of the messages, pouch-audit by default
+	Tag string `json:"tag,omitempty"`
+}
+
+// auditRecord is an event recorded in the audit log
+type auditRecord struct {
+	Event
+	Host string `json:"host"`
+}
+
+type auditLog struct {
+	sync.Mutex
+	w      io.WriteCloser
+	host   string
+	events []string
+}
+
+func (c AuditConfig) open() (io.WriteCloser, error) {
+	switch {
+	case c.Path != "" && c.Syslog != nil:
+	case c.Path != "":
+		return os.OpenFile(c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	case c.Syslog != nil:
+		tag := c.Syslog.Tag
+		if tag == "" {
+			tag = DefaultAuditSyslogTag
+		}
+		return syslog.Dial(c.Syslog.Network, c.Syslog.Address, syslog.LOG_INFO|syslog.LOG_AUTHPRIV, tag)
+	}
+	return nil, fmt.Errorf("one and only one of path or syslog must be set")
+}
+
+func newAuditLog(c AuditConfig, w io.WriteCloser) *auditLog {
+	events := c.Events
+	if len(events) == 0 {
+		events = defaultAuditEvents
+	}
+	host, _ := os.Hostname()
+	return &auditLog{w: w, host: host, events: events}
+}
+
+// Record appends an event to the audit log if it is of an audited type
+func (l *auditLog) Record(e Event) error {
+	if !stringInSlice(e.Type, l.events) {
+		return nil
+	}
+	d, err := json.Marshal(auditRecord{Event: e, Host: l.host})
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	defer l.Unlock()
+	_, err = l.w.Write(append(d, '\n'))
+	return err
+}
+
+func (l *auditLog) Close() error {
+	return l.w.Close()
+}
+
+func (p *pouch) AuditLog(c AuditConfig) error {
+	w, err := c.open()
+	if err != nil {
+		return fmt.Errorf("couldn't open audit log: %v", err)
+	}
+	p.auditLog = newAuditLog(c, w)
+	return nil
+}
+
+// audit records an event in the audit log, if any
+func (p *pouch) audit(e Event) {
+	if p.auditLog == nil {
+		return
+	}
+	err := p.auditLog.Record(e)
+	if err != nil {
+		errorf("Couldn't write audit log: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	auditPath := path.Join(dir, "audit.log")
+
+	assert.Error(t, (&pouch{}).AuditLog(AuditConfig{}))
+	assert.Error(t, (&pouch{}).AuditLog(AuditConfig{Path: auditPath, Syslog: &SyslogAuditConfig{}}))
+
+	// Existing records are kept
+	assert.NoError(t, ioutil.WriteFile(auditPath, []byte("{}\n"), 0600))
+
+	p := &pouch{Events: NewEventLog(0)}
+	if !assert.NoError(t, p.AuditLog(AuditConfig{Path: auditPath})) {
+		return
+	}
+	defer p.auditLog.Close()
+
+	p.event(Event{Type: EventSecretUpdated, Secret: "db", Message: "Secret 'db' read"})
+	p.event(Event{Type: EventRotationAnomaly, Secret: "db", Message: "Secret 'db' rotated too often"})
+	p.event(Event{
+		Type:    EventFileWritten,
+		File:    "/etc/db.conf",
+		Message: "Written 10 bytes into /etc/db.conf",
+		Details: map[string]interface{}{"sha256": contentChecksum("0123456789"), "secrets": []string{"db"}},
+	})
+
+	f, err := os.Open(auditPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r auditRecord
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	if !assert.Len(t, records, 3) {
+		return
+	}
+	hostname, _ := os.Hostname()
+	assert.Equal(t, EventSecretUpdated, records[1].Type)
+	assert.Equal(t, "db", records[1].Secret)
+	assert.Equal(t, hostname, records[1].Host)
+	assert.Equal(t, EventFileWritten, records[2].Type)
+	assert.Equal(t, []interface{}{"db"}, records[2].Details["secrets"])
+	assert.Equal(t, contentChecksum("0123456789"), records[2].Details["sha256"])
+}
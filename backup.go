@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Mode of backups, only readable by pouch, whatever the mode of the file
+const backupMode = os.FileMode(0600)
+
+// backupPath returns the path of the n-th previous version of a file
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// backupPaths returns the paths of the n previous versions of a file
+func backupPaths(path string, n int) []string {
+	var paths []string
+	for i := 1; i <= n; i++ {
+		paths = append(paths, backupPath(path, i))
+	}
+	return paths
+}
+
+// backupFile keeps a copy of the current version of a file before it is
+// overwritten, previous copies are rotated, keeping up to n of them, the
+// most recent one first
+func backupFile(path string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	d, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for i := n; i > 1; i-- {
+		err := os.Rename(backupPath(path, i-1), backupPath(path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	f, err := os.OpenFile(backupPath(path, 1), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, backupMode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(d)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	return err
+}
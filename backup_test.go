@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := path.Join(tmpdir, "foo")
+	assert.NoError(t, backupFile(p, 2), "nothing to backup")
+
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		assert.NoError(t, backupFile(p, 2))
+		assert.NoError(t, writeFile(p, 0644, 0, content))
+	}
+
+	expected := map[string]string{"foo": "v4", "foo.bak.1": "v3", "foo.bak.2": "v2"}
+	files, _ := ioutil.ReadDir(tmpdir)
+	assert.Len(t, files, len(expected))
+	for _, f := range files {
+		d, _ := ioutil.ReadFile(path.Join(tmpdir, f.Name()))
+		assert.Equal(t, expected[f.Name()], string(d))
+		if f.Name() != "foo" {
+			assert.Equal(t, backupMode, f.Mode())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/vault"
+)
+
+const (
+	BootstrapAppRoleAuth = "approle"
+	BootstrapTokenAuth   = "token"
+
+	DefaultBootstrapFilesDir = "/etc/secrets"
+)
+
+// BootstrapPrompter interacts with the user during the bootstrap
+type BootstrapPrompter interface {
+	// Inform shows a message to the user
+	Inform(message string)
+
+	// Ask asks for a value, the default value is used if nothing
+	// is answered
+	Ask(question, def string) (string, error)
+
+	// Select asks to pick some of the options
+	Select(question string, options []string) ([]string, error)
+}
+
+// Bootstrap guides first-time users through the setup of pouch, it
+// checks the Vault server and the credentials given, lets the user pick
+// secrets and where to write them, and generates a working configuration
+type Bootstrap struct {
+	Prompter BootstrapPrompter
+
+	// Creates Vault clients, vault.New by default
+	NewVault func(vault.Config) vault.Vault
+}
+
+func (b *Bootstrap) newVault(c vault.Config) vault.Vault {
+	if b.NewVault != nil {
+		return b.NewVault(c)
+	}
+	return vault.New(c)
+}
+
+// probeVault checks that Vault can be reached and is ready to be used
+func probeVault(v vault.Vault) error {
+	_, resp, err := v.Request(http.MethodGet, vault.SysHealthURL, nil)
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests:
+			// Standby nodes can forward requests
+			return nil
+		case http.StatusNotImplemented:
+			return fmt.Errorf("vault is not initialized")
+		case http.StatusServiceUnavailable:
+			return fmt.Errorf("vault is sealed")
+		}
+	}
+	return err
+}
+
+func (b *Bootstrap) vaultConfig() (vault.Config, error) {
+	var c vault.Config
+	var err error
+	c.Address, err = b.Prompter.Ask("Address of Vault", os.Getenv("VAULT_ADDR"))
+	if err != nil {
+		return c, err
+	}
+	err = probeVault(b.newVault(c))
+	if err != nil {
+		return c, fmt.Errorf("couldn't use Vault in %s: %v", c.Address, err)
+	}
+	b.Prompter.Inform(fmt.Sprintf("Vault in %s is ready", c.Address))
+
+	method, err := b.Prompter.Ask("Authentication method (approle or token)", BootstrapAppRoleAuth)
+	if err != nil {
+		return c, err
+	}
+	switch method {
+	case BootstrapAppRoleAuth:
+		c.RoleID, err = b.Prompter.Ask("Role ID", "")
+		if err != nil {
+			return c, err
+		}
+		c.SecretID, err = b.Prompter.Ask("Secret ID", "")
+	case BootstrapTokenAuth:
+		c.Token, err = b.Prompter.Ask("Token", os.Getenv("VAULT_TOKEN"))
+	default:
+		return c, fmt.Errorf("unknown authentication method '%s'", method)
+	}
+	return c, err
+}
+
+// Run runs the bootstrap, returning the generated configuration
+func (b *Bootstrap) Run() (*Pouchfile, error) {
+	c, err := b.vaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	v := b.newVault(c)
+	err = v.Login()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't login: %v", err)
+	}
+	policies, err := tokenPolicies(v)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't validate credentials: %v", err)
+	}
+	b.Prompter.Inform(fmt.Sprintf("Authenticated with policies: %s", strings.Join(policies, ", ")))
+
+	prefixes, err := b.Prompter.Ask("Prefixes of secrets to look for, separated by commas, empty to use the paths in the policies", "")
+	if err != nil {
+		return nil, err
+	}
+	filesDir, err := b.Prompter.Ask("Directory for the files", DefaultBootstrapFilesDir)
+	if err != nil {
+		return nil, err
+	}
+	var options ScaffoldOptions
+	options.FilesDir = filesDir
+	for _, prefix := range strings.Split(prefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			options.Prefixes = append(options.Prefixes, prefix)
+		}
+	}
+	scaffold, err := Scaffold(v, options)
+	if err != nil {
+		return nil, err
+	}
+	if len(scaffold.Secrets) == 0 {
+		return nil, fmt.Errorf("no readable secrets found")
+	}
+
+	var names []string
+	for name := range scaffold.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selected, err := b.Prompter.Select("Secrets to provision", names)
+	if err != nil {
+		return nil, err
+	}
+
+	pouchfile := &Pouchfile{
+		Vault:     c,
+		Secrets:   make(map[string]SecretConfig),
+		Notifiers: make(map[string]NotifierConfig),
+	}
+	for _, name := range selected {
+		pouchfile.Secrets[name] = scaffold.Secrets[name]
+	}
+	for _, fc := range scaffold.Files {
+		if _, found := pouchfile.Secrets[fc.Secrets[0]]; !found {
+			continue
+		}
+		fc.Path, err = b.Prompter.Ask(fmt.Sprintf("Path of the file for '%s'", fc.Secrets[0]), fc.Path)
+		if err != nil {
+			return nil, err
+		}
+		service, err := b.Prompter.Ask(fmt.Sprintf("Service to restart when %s changes, empty for none", fc.Path), "")
+		if err != nil {
+			return nil, err
+		}
+		if service != "" {
+			pouchfile.Notifiers[service] = NotifierConfig{Service: service, Restart: true}
+			fc.Notify = NotifyNames(service)
+		}
+		pouchfile.Files = append(pouchfile.Files, fc)
+	}
+	return pouchfile, nil
+}
+
+// textPrompter interacts with the user through text streams, such as
+// the standard input and output of a terminal
+type textPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewTextPrompter(in io.Reader, out io.Writer) BootstrapPrompter {
+	return &textPrompter{in: bufio.NewReader(in), out: out}
+}
+
+func (p *textPrompter) Inform(message string) {
+	fmt.Fprintln(p.out, message)
+}
+
+func (p *textPrompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSpace(line), err
+}
+
+func (p *textPrompter) Ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+func (p *textPrompter) Select(question string, options []string) ([]string, error) {
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	answer, err := p.Ask("Numbers separated by commas", "all")
+	if err != nil {
+		return nil, err
+	}
+	if answer == "all" {
+		return options, nil
+	}
+	var selected []string
+	for _, field := range strings.Split(answer, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || i < 1 || i > len(options) {
+			return nil, fmt.Errorf("incorrect option: %s", field)
+		}
+		selected = append(selected, options[i-1])
+	}
+	return selected, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/tuenti/pouch/pkg/pouchtest"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBootstrapTestVault() *pouchtest.Vault {
+	v := pouchtest.NewVault()
+	v.SetResponse("GET", vault.SysHealthURL, &api.Secret{})
+	v.SetResponse("GET", vault.SelfTokenURL, &api.Secret{
+		Data: map[string]interface{}{"policies": []interface{}{"default", "myapp"}},
+	})
+	v.SetResponse(ListMethod, "/v1/secret/myapp/", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"db", "tls"}},
+	})
+	v.SetSecret("/v1/secret/myapp/db", map[string]interface{}{"password": "secret"}, 0)
+	v.SetSecret("/v1/secret/myapp/tls", map[string]interface{}{"cert": "..."}, 0)
+	v.Login()
+	return v
+}
+
+func TestBootstrap(t *testing.T) {
+	v := newBootstrapTestVault()
+	var configs []vault.Config
+	answers := strings.Join([]string{
+		"https://vault.example.com:8200",
+		"", // approle by default
+		"myrole",
+		"mysecret",
+		"secret/myapp/",
+		"/etc/myapp",
+		"1",
+		"/etc/myapp/db.conf",
+		"myapp.service",
+	}, "\n")
+	var out bytes.Buffer
+	b := Bootstrap{
+		Prompter: NewTextPrompter(strings.NewReader(answers), &out),
+		NewVault: func(c vault.Config) vault.Vault {
+			configs = append(configs, c)
+			return v
+		},
+	}
+	pouchfile, err := b.Run()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expectedConfig := vault.Config{
+		Address:  "https://vault.example.com:8200",
+		RoleID:   "myrole",
+		SecretID: "mysecret",
+	}
+	assert.Equal(t, expectedConfig, configs[len(configs)-1])
+	assert.Equal(t, expectedConfig, pouchfile.Vault)
+	assert.Equal(t, map[string]SecretConfig{
+		"myapp/db": {VaultURL: "/v1/secret/myapp/db"},
+	}, pouchfile.Secrets)
+	assert.Equal(t, []FileConfig{{
+		Path:     "/etc/myapp/db.conf",
+		Template: "password={{ secret \"myapp/db\" \"password\" }}\n",
+		Secrets:  []string{"myapp/db"},
+		Notify:   NotifyNames("myapp.service"),
+	}}, pouchfile.Files)
+	assert.Equal(t, map[string]NotifierConfig{
+		"myapp.service": {Service: "myapp.service", Restart: true},
+	}, pouchfile.Notifiers)
+
+	assert.Contains(t, out.String(), "Authenticated with policies: myapp")
+	assert.Contains(t, out.String(), "2) myapp/tls")
+}
+
+func TestBootstrapSealedVault(t *testing.T) {
+	v := newBootstrapTestVault()
+	v.Fail("GET", vault.SysHealthURL, 503, -1)
+	b := Bootstrap{
+		Prompter: NewTextPrompter(strings.NewReader("https://vault.example.com:8200\n"), &bytes.Buffer{}),
+		NewVault: func(vault.Config) vault.Vault { return v },
+	}
+	_, err := b.Run()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "sealed")
+	}
+}
+
+func TestTextPrompterSelect(t *testing.T) {
+	p := NewTextPrompter(strings.NewReader("3, 1\n\n4\n"), &bytes.Buffer{})
+	options := []string{"a", "b", "c"}
+
+	selected, err := p.Select("Pick", options)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, selected)
+
+	selected, err = p.Select("Pick", options)
+	assert.NoError(t, err)
+	assert.Equal(t, options, selected)
+
+	_, err = p.Select("Pick", options)
+	assert.Error(t, err)
+}
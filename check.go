@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+// Maximum time check commands can run
+const DefaultCheckTimeout = time.Minute
+
+// checkFile writes the content of a file in a temporary file next to it,
+// and runs the check command of the file, with %s replaced by the path of
+// the temporary file. It returns the path of the temporary file if the
+// check passes, so it can replace the file, or an error otherwise.
+func (p *pouch) checkFile(fc FileConfig, mode os.FileMode, content string) (string, error) {
+	err := parentDir(fc.Path, fc.parentDirMode(mode))
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fc.Path), "."+filepath.Base(fc.Path)+".check-")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create temporary file to check '%s': %v", fc.Path, err)
+	}
+	tmpPath := f.Name()
+	err = f.Chmod(mode)
+	if err == nil {
+		_, err = f.Write([]byte(content))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("couldn't write temporary file to check '%s': %v", fc.Path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCheckTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.Replace(fc.CheckCmd, "%s", tmpPath, -1))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmpPath)
+		warnf("Check of '%s' failed: %s", fc.Path, out)
+		p.event(Event{
+			Type:    EventFileCheckFailed,
+			File:    fc.Path,
+			Message: fmt.Sprintf("Check of new content of '%s' failed, previous version kept: %v", fc.Path, err),
+		})
+		p.Metrics.Add(MetricFileChecksFailed, metrics.Labels{"file": fc.Path}, 1)
+		return "", fmt.Errorf("check of '%s' failed, previous version kept: %v", fc.Path, err)
+	}
+	return tmpPath, nil
+}
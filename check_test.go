@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCmd(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "valid"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	p.Events = NewEventLog(10)
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Mode:     0640,
+		Template: `{{ secret "foo" "password" }}`,
+		CheckCmd: `grep -q ^valid %s`,
+		Notify:   NotifyNames("reload"),
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "valid", string(d))
+	info, err := os.Stat(fc.Path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	}
+	assert.Len(t, p.pendingNotifiers, 1)
+	p.pendingNotifiers = nil
+
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "invalid"}})
+	assert.Error(t, p.resolveFile(fc))
+	d, _ = ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "valid", string(d))
+	assert.Len(t, p.pendingNotifiers, 0)
+
+	events := p.Events.Recent()
+	if assert.NotEmpty(t, events) {
+		assert.Equal(t, EventFileCheckFailed, events[len(events)-1].Type)
+	}
+
+	// Temporary files are removed
+	files, _ := ioutil.ReadDir(tmpdir)
+	assert.Len(t, files, 1)
+}
@@ -22,8 +22,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/plugin"
+	_ "github.com/tuenti/pouch/pkg/provider/acmecert"
+	_ "github.com/tuenti/pouch/pkg/provider/awssm"
+	_ "github.com/tuenti/pouch/pkg/provider/awsssm"
+	_ "github.com/tuenti/pouch/pkg/provider/consul"
+	_ "github.com/tuenti/pouch/pkg/provider/etcd"
+	_ "github.com/tuenti/pouch/pkg/provider/kubernetes"
+	_ "github.com/tuenti/pouch/pkg/provider/static"
+	_ "github.com/tuenti/pouch/pkg/provider/svid"
+	"github.com/tuenti/pouch/pkg/remote"
+	"github.com/tuenti/pouch/pkg/supervision"
 	"github.com/tuenti/pouch/pkg/systemd"
 	"github.com/tuenti/pouch/pkg/vault"
 )
@@ -33,9 +47,16 @@ var version = "dev"
 const defaultPouchfilePath = "Pouchfile"
 
 func main() {
-	var pouchfilePath string
-	var showVersion bool
+	var pouchfilePath, renderPath, fixturesPath string
+	var dryRun, once, showVersion, showStatus, standby, validate bool
 	flag.StringVar(&pouchfilePath, "pouchfile", defaultPouchfilePath, "Path to Pouchfile")
+	flag.BoolVar(&standby, "standby", false, "Run as standby, waiting for the active instance to fail")
+	flag.BoolVar(&once, "once", false, "Read the secrets, write the files and run their notifiers once, and exit with an error if any of them fails")
+	flag.StringVar(&renderPath, "render", "", "Render a file of the Pouchfile with the secrets in -secrets, print it and exit")
+	flag.StringVar(&fixturesPath, "secrets", "", "JSON or YAML file with the data of the secrets used by -render")
+	flag.BoolVar(&validate, "validate", false, "Validate the options and the templates of the Pouchfile and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the changes that would be written in the files, without writing them nor notifying, and exit")
+	flag.BoolVar(&showStatus, "status", false, "Print the status of the secrets and exit")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.Parse()
 
@@ -49,6 +70,47 @@ func main() {
 		log.Fatalf("Couldn't load Pouchfile: %v", err)
 	}
 
+	logger, err := pouch.NewLoggerFromConfig(pouchfile.Log)
+	if err != nil {
+		log.Fatalf("Couldn't configure logs: %v", err)
+	}
+	pouch.SetLogger(logger)
+	// Messages from libraries are also sent to the logger
+	log.SetFlags(0)
+	log.SetOutput(pouch.NewLogWriter(pouch.LogInfo))
+
+	if validate {
+		errs := pouch.ValidatePouchfileFields(pouchfilePath)
+		errs = append(errs, pouch.ValidatePouchfile(pouchfile)...)
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", pouchfilePath)
+		os.Exit(0)
+	}
+
+	if showStatus {
+		err := printStatus(pouchfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't obtain status:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if renderPath != "" {
+		content, err := render(pouchfile, renderPath, fixturesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't render file:", err)
+			os.Exit(1)
+		}
+		fmt.Print(content)
+		os.Exit(0)
+	}
+
 	state, err := pouch.LoadState(pouchfile.StatePath)
 	if err == nil {
 		log.Printf("Using state stored in %s", state.Path)
@@ -58,29 +120,337 @@ func main() {
 		state = pouch.NewState(pouchfile.StatePath)
 	}
 
+	if c := pouchfile.Replication; c != nil && c.Listen != "" && standby {
+		log.Printf("Running as standby, receiving state in %s", c.Listen)
+		err = pouch.ReceiveState(*c, state)
+		if err != nil {
+			log.Fatalf("Couldn't receive state: %v", err)
+		}
+		log.Printf("Active instance not available, taking over")
+		pouchfile.Vault.Token = state.Token
+	}
+
 	vault := vault.New(pouchfile.Vault)
 
 	p := pouch.NewPouch(state, vault, pouchfile.Secrets, pouchfile.Files, pouchfile.Notifiers)
+	if path := pouchfile.Metrics.TextfilePath; path != "" {
+		p.MetricsTextfile(path)
+	}
+	if c := pouchfile.Metrics.Statsd; c != nil {
+		err := p.MetricsStatsd(*c)
+		if err != nil {
+			log.Fatalf("Couldn't configure statsd: %v", err)
+		}
+	}
+	if c := pouchfile.Tracing; c != nil {
+		err := p.Tracing(*c)
+		if err != nil {
+			log.Fatalf("Couldn't configure tracing: %v", err)
+		}
+	}
+	if path := pouchfile.Provenance.Path; path != "" {
+		p.ProvenanceFile(path)
+	}
+	if pouchfile.Status.Listen != "" {
+		p.StatusListener(pouchfile.Status)
+	}
+	if c := pouchfile.Replication; c != nil && len(c.Peers) > 0 {
+		err = p.ReplicateState(*c)
+		if err != nil {
+			log.Fatalf("Couldn't configure replication: %v", err)
+		}
+	}
+	if pouchfile.VaultEvents.Enabled {
+		p.VaultEvents(pouchfile.VaultEvents.EventType)
+	}
+	for name, c := range pouchfile.TemplateFunctions {
+		f, err := pouch.NewWasmFunction(name, c)
+		if err != nil {
+			log.Fatalf("Couldn't configure template function: %v", err)
+		}
+		p.AddTemplateFunction(name, f.Call)
+	}
+	if args := flag.Args(); len(args) > 0 {
+		if once {
+			log.Fatalf("A command cannot be run with -once")
+		}
+		if pouchfile.Exec == nil {
+			pouchfile.Exec = &pouch.ExecConfig{}
+		}
+		pouchfile.Exec.Command = args
+	}
+	if pouchfile.Exec != nil {
+		if once {
+			log.Printf("Running once, the command of exec mode is not started")
+		} else {
+			p.Exec(*pouchfile.Exec)
+		}
+	}
+	if pouchfile.ShredOnExit {
+		p.ShredOnExit()
+	}
+	err = p.OrphanedFiles(pouchfile.OrphanedFiles)
+	if err != nil {
+		log.Fatalf("Couldn't configure orphaned files: %v", err)
+	}
+	if dir := pouchfile.TemplatesDir; dir != "" {
+		templates, err := pouch.LoadTemplatesDir(dir)
+		if err != nil {
+			log.Fatalf("Couldn't load templates: %v", err)
+		}
+		for name, source := range templates {
+			p.AddTemplate(name, source)
+		}
+	}
+	for name, source := range pouchfile.Templates {
+		p.AddTemplate(name, source)
+	}
+	for name, c := range pouchfile.Expectations {
+		p.AddExpectation(name, c)
+	}
+	for name, c := range pouchfile.Alerts {
+		err := p.AddAlert(name, c)
+		if err != nil {
+			log.Fatalf("Couldn't configure alert: %v", err)
+		}
+	}
+	p.ConfigPath(pouchfilePath)
+	if pouchfile.Control != nil {
+		err := p.ControlSocket(*pouchfile.Control)
+		if err != nil {
+			log.Fatalf("Couldn't configure control socket: %v", err)
+		}
+	}
+	if pouchfile.GRPC != nil {
+		err := p.GRPC(*pouchfile.GRPC)
+		if err != nil {
+			log.Fatalf("Couldn't configure gRPC API: %v", err)
+		}
+	}
+	if pouchfile.SecretsServer != nil {
+		err := p.SecretsServer(*pouchfile.SecretsServer)
+		if err != nil {
+			log.Fatalf("Couldn't configure secrets server: %v", err)
+		}
+	}
+	if pouchfile.FUSE != nil {
+		err := p.FUSE(*pouchfile.FUSE)
+		if err != nil {
+			log.Fatalf("Couldn't configure FUSE filesystem: %v", err)
+		}
+	}
+	if pouchfile.Audit != nil {
+		err := p.AuditLog(*pouchfile.Audit)
+		if err != nil {
+			log.Fatalf("Couldn't configure audit: %v", err)
+		}
+	}
+	for name, c := range pouchfile.Providers {
+		provider, err := pouch.NewSecretProvider(name, c)
+		if err != nil {
+			log.Fatalf("Couldn't configure secret provider: %v", err)
+		}
+		p.AddSecretProvider(name, provider)
+	}
+	for name, c := range pouchfile.Plugins {
+		pl := plugin.New(name, c)
+		defer pl.Kill()
+		p.AddPlugin(pl)
+	}
+	for name, c := range pouchfile.Hosts {
+		h, err := remote.New(name, c)
+		if err != nil {
+			log.Fatalf("Couldn't configure host: %v", err)
+		}
+		p.AddHost(h)
+	}
+
+	if pouchfile.ServiceManager != nil {
+		manager, err := supervision.New(*pouchfile.ServiceManager)
+		if err != nil {
+			log.Fatalf("Couldn't configure service manager: %v", err)
+		}
+		p.ServiceReloader(manager)
+		p.AddServiceReloader(pouchfile.ServiceManager.Type, manager)
+	}
+
+	if dryRun {
+		// The state is only read, so a running pouch can be kept
+		err = p.DryRun(os.Stdout)
+		if err != nil {
+			log.Fatalf("Dry run failed: %v", err)
+		}
+		return
+	}
 
 	systemd := systemd.New(pouchfile.Systemd.Configurer())
 	if systemd.IsAvailable() {
-		p.ServiceReloader(systemd)
+		if pouchfile.ServiceManager == nil {
+			p.ServiceReloader(systemd)
+		}
+		p.AddServiceReloader("systemd", systemd)
 		if systemd.CanNotify() {
 			p.AddStatusNotifier(systemd)
 		}
 	}
 	defer systemd.Close()
 
-	if path := pouchfile.WrappedSecretIDPath; state.Token == "" && path != "" {
-		log.Printf("Waiting for a wrapped secret ID in %s", path)
-		err = p.Watch(path)
+	lock := pouch.NewStateLock(state.Path)
+	if standby {
+		// Warm the session, so it's ready when taking over
+		if state.Token != "" {
+			err = p.Login()
+			if err != nil {
+				log.Printf("Couldn't login as standby: %v", err)
+			}
+		}
+		log.Printf("Running as standby, waiting for lock in %s", lock.Path)
+		err = lock.Lock()
+		if err != nil {
+			log.Fatalf("Couldn't obtain state lock: %v", err)
+		}
+		log.Printf("State lock obtained, taking over")
+		err = p.TakeOver()
+		if err != nil {
+			log.Printf("Couldn't reload state: %s", err)
+		}
+	} else {
+		err = lock.TryLock()
+		if err != nil {
+			log.Fatalf("Couldn't obtain state lock, use -standby to run a standby instance: %v", err)
+		}
+	}
+	defer lock.Unlock()
+
+	if state.Token == "" {
+		err = waitForSecretID(p, pouchfile)
+		if err != nil {
+			log.Fatalf("Couldn't obtain secret ID: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	if once {
+		ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		err = p.RunOnce(ctx)
 		if err != nil {
-			log.Fatalf("Couldn't obtain secret ID from %s: %v", path, err)
+			log.Fatalf("Pouch failed: %v", err)
 		}
+		return
+	}
+	if pouchfile.Exec != nil || pouchfile.ShredOnExit {
+		// Stop the command and shred files when pouch is stopped
+		var cancel context.CancelFunc
+		ctx, cancel = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer cancel()
 	}
 
-	err = p.Run(context.Background())
+	err = p.Run(ctx)
+	if exited, ok := err.(*pouch.ExecExitError); ok {
+		lock.Unlock()
+		os.Exit(exited.Code)
+	}
 	if err != nil {
 		log.Fatalf("Pouch failed: %v", err)
 	}
 }
+
+// waitForSecretID waits for a wrapped secret ID in the file or network
+// channels configured, whichever receives it first, the other channels
+// are closed once it is received
+func waitForSecretID(p pouch.Pouch, pouchfile *pouch.Pouchfile) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 2)
+	waiting := 0
+	if path := pouchfile.WrappedSecretIDPath; path != "" {
+		log.Printf("Waiting for a wrapped secret ID in %s", path)
+		waiting++
+		go func() {
+			err := p.Watch(ctx, path)
+			if err != nil {
+				err = fmt.Errorf("couldn't watch %s: %v", path, err)
+			}
+			errs <- err
+		}()
+	}
+	if c := pouchfile.WrappedSecretIDListener; c != nil {
+		log.Printf("Waiting for a wrapped secret ID from the network")
+		waiting++
+		go func() {
+			errs <- p.WatchListener(ctx, *c)
+		}()
+	}
+	var err error
+	for i := 0; i < waiting; i++ {
+		err = <-errs
+		if err == nil {
+			// Wait for the other channels to be closed
+			cancel()
+			for i++; i < waiting; i++ {
+				<-errs
+			}
+			return nil
+		}
+		log.Printf("Couldn't obtain secret ID: %v", err)
+	}
+	return err
+}
+
+// printStatus prints a table with the status of the secrets, obtained
+// from the control socket of the running pouch, or from its state if it
+// cannot be queried
+func printStatus(pouchfile *pouch.Pouchfile) error {
+	if c := pouchfile.Control; c != nil {
+		socket := c.Socket
+		if socket == "" {
+			socket = pouch.DefaultControlSocket
+		}
+		report, err := pouch.QueryStatus(socket)
+		if err == nil {
+			return pouch.WriteStatusTable(os.Stdout, report, time.Now())
+		}
+		fmt.Fprintf(os.Stderr, "Couldn't query pouch, reading its state: %v\n", err)
+	}
+	state, err := pouch.LoadState(pouchfile.StatePath)
+	if err != nil {
+		return fmt.Errorf("couldn't load state: %v", err)
+	}
+	report := pouch.StateStatusReport(state, pouchfile.Secrets)
+	return pouch.WriteStatusTable(os.Stdout, report, time.Now())
+}
+
+// render renders a file of the Pouchfile with secrets from a fixtures
+// file, without Vault or any other provider
+func render(pouchfile *pouch.Pouchfile, path, fixturesPath string) (string, error) {
+	state := pouch.NewState("")
+	if fixturesPath != "" {
+		err := pouch.LoadFixtures(state, fixturesPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	p := pouch.NewPouch(state, nil, pouchfile.Secrets, pouchfile.Files, pouchfile.Notifiers)
+	for name, c := range pouchfile.TemplateFunctions {
+		f, err := pouch.NewWasmFunction(name, c)
+		if err != nil {
+			return "", err
+		}
+		p.AddTemplateFunction(name, f.Call)
+	}
+	if dir := pouchfile.TemplatesDir; dir != "" {
+		templates, err := pouch.LoadTemplatesDir(dir)
+		if err != nil {
+			return "", err
+		}
+		for name, source := range templates {
+			p.AddTemplate(name, source)
+		}
+	}
+	for name, source := range pouchfile.Templates {
+		p.AddTemplate(name, source)
+	}
+	return p.Render(path)
+}
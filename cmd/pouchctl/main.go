@@ -17,13 +17,18 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/tuenti/pouch"
 	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/ghodss/yaml"
 )
 
 var version = "dev"
@@ -62,6 +67,9 @@ func main() {
 	var role, roleId, wrappedSecretId, wrapTTL string
 	var address, token string
 	var showVersion, genSecret, showRoleId bool
+	var scaffold, bootstrap bool
+	var scaffoldPrefixes, scaffoldPolicies, filesDir string
+	var controlSocket string
 
 	flag.StringVar(&destination, "copy-to", "", "Destination for the wrapped secret")
 	flag.StringVar(&role, "role", "", "Role to request a secret from")
@@ -70,7 +78,19 @@ func main() {
 	flag.StringVar(&token, "token", "", "Token for authentication on vault, VAULT_TOKEN can be used instead")
 	flag.BoolVar(&genSecret, "gen-secret", false, "Generates a wrapped secret")
 	flag.BoolVar(&showRoleId, "show-role-id", false, "Shows role ID")
+	flag.BoolVar(&bootstrap, "bootstrap", false, "Guides through the generation of a working Pouchfile")
+	flag.BoolVar(&scaffold, "scaffold", false, "Generates a Pouchfile with the secrets readable by the token")
+	flag.StringVar(&scaffoldPrefixes, "scaffold-prefixes", "", "Comma-separated prefixes of secrets to scaffold, instead of the paths in policies")
+	flag.StringVar(&scaffoldPolicies, "scaffold-policies", "", "Comma-separated policies whose paths are scaffolded, by default the ones of the token")
+	flag.StringVar(&filesDir, "files-dir", "/etc/secrets", "Directory for the files in the scaffolded Pouchfile")
+	flag.StringVar(&controlSocket, "control-socket", pouch.DefaultControlSocket, "Control socket of pouch where commands are sent")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [command [args]]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands sent to a running pouch: refresh <secret>, rerender <file>, status, reload-config, revoke <secret>")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if showVersion {
@@ -78,6 +98,42 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.NArg() > 0 {
+		err := sendCommand(controlSocket, flag.Arg(0), flag.Args()[1:]...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Command '%s' failed: %v\n", flag.Arg(0), err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if bootstrap {
+		// Questions go to stderr so the Pouchfile can be redirected
+		b := pouch.Bootstrap{Prompter: pouch.NewTextPrompter(os.Stdin, os.Stderr)}
+		pouchfile, err := b.Run()
+		if err == nil {
+			err = printPouchfile(pouchfile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Couldn't bootstrap Pouchfile:", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if scaffold {
+		err := scaffoldPouchfile(vault.New(vault.Config{Address: address, Token: token}), pouch.ScaffoldOptions{
+			Prefixes: splitList(scaffoldPrefixes),
+			Policies: splitList(scaffoldPolicies),
+			FilesDir: filesDir,
+		})
+		if err != nil {
+			fmt.Println("Couldn't scaffold Pouchfile:", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
 	if role == "" {
 		fmt.Println("Flag -role is required")
 		os.Exit(-1)
@@ -124,3 +180,59 @@ func main() {
 		os.Exit(-1)
 	}
 }
+
+func splitList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func scaffoldPouchfile(v vault.Vault, o pouch.ScaffoldOptions) error {
+	pouchfile, err := pouch.Scaffold(v, o)
+	if err != nil {
+		return err
+	}
+	return printPouchfile(pouchfile)
+}
+
+// printPouchfile prints the sections of a Pouchfile that are generated,
+// other sections are left to be completed by hand
+func printPouchfile(p *pouch.Pouchfile) error {
+	var vaultConfig *vault.Config
+	if p.Vault.Address != "" {
+		vaultConfig = &p.Vault
+	}
+	d, err := yaml.Marshal(struct {
+		Vault     *vault.Config                   `json:"vault,omitempty"`
+		Notifiers map[string]pouch.NotifierConfig `json:"notifiers,omitempty"`
+		Secrets   map[string]pouch.SecretConfig   `json:"secrets"`
+		Files     []pouch.FileConfig              `json:"files"`
+	}{vaultConfig, p.Notifiers, p.Secrets, p.Files})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(d))
+	return nil
+}
+
+// sendCommand sends a command to a running pouch and prints its result
+func sendCommand(socket, command string, args ...string) error {
+	result, err := pouch.SendControlCommand(socket, command, args...)
+	if err != nil {
+		return err
+	}
+	if message, ok := result.(string); ok {
+		fmt.Println(message)
+		return nil
+	}
+	d, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(d))
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const (
+	// Engine for templates written for consul-template
+	ConsulTemplateEngine = "consul-template"
+
+	// Providers of the secrets referred by consul-template functions
+	consulKVProvider      = "consul"
+	consulServiceProvider = "consul-service"
+)
+
+func init() {
+	RegisterTemplateEngine(ConsulTemplateEngine, &consulTemplateEngine{})
+}
+
+// consulTemplateSecret is what the secret function returns, as in
+// consul-template, only the data is available
+type consulTemplateSecret struct {
+	Data SecretData
+}
+
+// consulTemplateService is each of the instances returned by the
+// service function
+type consulTemplateService struct {
+	ID      string
+	Name    string
+	Node    string
+	Address string
+	Port    int
+	Tags    []string
+}
+
+// consulTemplateEngine renders go templates written for consul-template,
+// its functions don't read from Vault or Consul, they return the data of
+// the configured secrets with the same location
+type consulTemplateEngine struct{}
+
+// secretLocation returns the provider and the path of a secret URL as
+// they are referred in consul-template functions
+func secretLocation(url string) (provider, path string) {
+	provider, path = splitProviderURL(url)
+	path = strings.Trim(path, "/")
+	if provider == VaultProvider {
+		path = strings.TrimPrefix(path, "v1/")
+	}
+	return provider, path
+}
+
+// findSecret returns the name of the secret of the file with the given
+// provider and path
+func findSecret(ctx *RenderContext, provider, path string) (string, error) {
+	path = strings.Trim(path, "/")
+	for _, name := range ctx.Secrets {
+		secretProvider, secretPath := secretLocation(ctx.URLs[name])
+		if secretProvider == provider && secretPath == path {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no secret configured for %s path %s", provider, path)
+}
+
+func (e *consulTemplateEngine) funcs(ctx *RenderContext) template.FuncMap {
+	funcs := template.FuncMap{}
+	for name, f := range ctx.Funcs {
+		funcs[name] = f
+	}
+
+	// Parameters to write secrets are taken from the secret configuration
+	funcs["secret"] = func(path string, parameters ...string) (*consulTemplateSecret, error) {
+		name, err := findSecret(ctx, VaultProvider, path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ctx.Secret(name)
+		if err != nil {
+			return nil, err
+		}
+		return &consulTemplateSecret{Data: data}, nil
+	}
+	key := func(path string) (string, error) {
+		name, err := findSecret(ctx, consulKVProvider, path)
+		if err != nil {
+			return "", err
+		}
+		data, err := ctx.Secret(name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(data["value"]), nil
+	}
+	funcs["key"] = key
+	funcs["keyOrDefault"] = func(path, d string) string {
+		value, err := key(path)
+		if err != nil {
+			return d
+		}
+		return value
+	}
+	funcs["service"] = func(query string) ([]consulTemplateService, error) {
+		name, err := findSecret(ctx, consulServiceProvider, query)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ctx.Secret(name)
+		if err != nil {
+			return nil, err
+		}
+		// Instances are generic values once stored in the state
+		d, err := json.Marshal(data["instances"])
+		if err != nil {
+			return nil, err
+		}
+		var services []consulTemplateService
+		err = json.Unmarshal(d, &services)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect instances of service %s: %v", query, err)
+		}
+		return services, nil
+	}
+	funcs["toJSON"] = toJSON
+	funcs["toJSONPretty"] = toPrettyJSON
+	funcs["toYAML"] = toYAML
+	funcs["toLower"] = strings.ToLower
+	funcs["toUpper"] = strings.ToUpper
+	funcs["trimSpace"] = strings.TrimSpace
+	funcs["replaceAll"] = func(old, new, s string) string { return strings.Replace(s, old, new, -1) }
+	funcs["split"] = func(sep, s string) []string { return strings.Split(s, sep) }
+	funcs["base64Encode"] = func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	funcs["base64Decode"] = b64dec
+	return funcs
+}
+
+func (e *consulTemplateEngine) Render(name, source string, ctx *RenderContext) (string, error) {
+	renderCtx := *ctx
+	renderCtx.Funcs = e.funcs(ctx)
+	return (&goTemplateEngine{}).Render(name, source, &renderCtx)
+}
@@ -0,0 +1,488 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/vault"
+)
+
+const (
+	DefaultControlSocket     = "/run/pouch/control.sock"
+	DefaultControlSocketMode = 0600
+
+	// Maximum time to wait for the reply of a command
+	controlTimeout = 5 * time.Minute
+)
+
+// Commands accepted in the control socket
+const (
+	ControlRefresh      = "refresh"
+	ControlRerender     = "rerender"
+	ControlStatus       = "status"
+	ControlReloadConfig = "reload-config"
+	ControlRevoke       = "revoke"
+)
+
+type ControlConfig struct {
+	// Path of the unix socket, /run/pouch/control.sock by default
+	Socket string `json:"socket,omitempty"`
+
+	// Permissions of the socket, in octal, 0600 by default
+	Mode string `json:"mode,omitempty"`
+}
+
+// ControlResponse is the reply to a command
+type ControlResponse struct {
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// controlRequest is an operation requested from the control socket or
+// other APIs, it is run in the main loop so it doesn't interfere with
+// updates
+type controlRequest struct {
+	run   func() (interface{}, error)
+	reply chan ControlResponse
+}
+
+// SecretStatus summarizes the state of a secret
+type SecretStatus struct {
+	Name          string     `json:"name"`
+	LastFetch     *time.Time `json:"last_fetch,omitempty"`
+	NextUpdate    *time.Time `json:"next_update,omitempty"`
+	Expiration    *time.Time `json:"expiration,omitempty"`
+	LeaseID       string     `json:"lease_id,omitempty"`
+	LeaseDuration int        `json:"lease_duration,omitempty"`
+	Renewable     bool       `json:"renewable,omitempty"`
+	Version       int        `json:"version,omitempty"`
+	Files         []string   `json:"files,omitempty"`
+
+	// Last failure reading the secret, and the number of consecutive
+	// failures since it was last read
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	Failures      int        `json:"failures,omitempty"`
+}
+
+// secretError is a failure reading a secret
+type secretError struct {
+	Message string
+	Time    time.Time
+}
+
+// StatusReport is the status of pouch and its secrets
+type StatusReport struct {
+	Status  Status         `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Secrets []SecretStatus `json:"secrets"`
+}
+
+func (p *pouch) ControlSocket(c ControlConfig) error {
+	p.controlSocket = c.Socket
+	if p.controlSocket == "" {
+		p.controlSocket = DefaultControlSocket
+	}
+	mode, err := parseSocketMode(c.Mode, DefaultControlSocketMode)
+	if err != nil {
+		return fmt.Errorf("incorrect mode of control socket: %v", err)
+	}
+	p.controlSocketMode = mode
+	return nil
+}
+
+func parseSocketMode(s string, defaultMode os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return defaultMode, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenUnix listens in a unix socket with the given permissions till the
+// context is done, stale sockets are replaced
+func listenUnix(ctx context.Context, path string, mode os.FileMode) (net.Listener, error) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	return l, nil
+}
+
+// ConfigPath sets the path of the Pouchfile, to reload it on request
+func (p *pouch) ConfigPath(path string) {
+	p.configPath = path
+}
+
+// startControlSocket listens for commands till the context is done
+func (p *pouch) startControlSocket(ctx context.Context) error {
+	if p.controlSocket == "" {
+		return nil
+	}
+	l, err := listenUnix(ctx, p.controlSocket, p.controlSocketMode)
+	if err != nil {
+		return fmt.Errorf("couldn't listen in control socket: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					errorf("Control socket failed: %v", err)
+				}
+				return
+			}
+			go p.serveControl(ctx, conn)
+		}
+	}()
+	return nil
+}
+
+// serveControl runs the command sent in a connection, as a line with
+// the command and its arguments, and replies with the response in JSON
+func (p *pouch) serveControl(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	fields := strings.Fields(line)
+	var resp ControlResponse
+	if len(fields) == 0 {
+		resp.Error = "no command"
+	} else {
+		resp = p.controlCommand(ctx, fields[0], fields[1:])
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// controlCommand sends a command to the main loop and waits for its reply
+func (p *pouch) controlCommand(ctx context.Context, command string, args []string) ControlResponse {
+	return p.inMainLoop(ctx, func() (interface{}, error) {
+		infof("Running command '%s'", strings.Join(append([]string{command}, args...), " "))
+		return p.runControlCommand(command, args)
+	})
+}
+
+// inMainLoop runs a function in the main loop and waits for its result
+func (p *pouch) inMainLoop(ctx context.Context, run func() (interface{}, error)) ControlResponse {
+	req := controlRequest{run: run, reply: make(chan ControlResponse, 1)}
+	timeout := time.After(controlTimeout)
+	select {
+	case p.controlRequests <- req:
+	case <-timeout:
+		return ControlResponse{Error: "timeout waiting to run request"}
+	case <-ctx.Done():
+		return ControlResponse{Error: "pouch is stopping"}
+	}
+	select {
+	case resp := <-req.reply:
+		return resp
+	case <-timeout:
+		return ControlResponse{Error: "timeout waiting for request"}
+	case <-ctx.Done():
+		return ControlResponse{Error: "pouch is stopping"}
+	}
+}
+
+// runControl runs a request in the main loop
+func (p *pouch) runControl(req controlRequest) {
+	result, err := req.run()
+	resp := ControlResponse{Result: result}
+	if err != nil {
+		resp = ControlResponse{Error: redactSecrets(err.Error())}
+	}
+	req.reply <- resp
+}
+
+func (p *pouch) runControlCommand(command string, args []string) (interface{}, error) {
+	arg := func() (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("command '%s' needs one argument", command)
+		}
+		return args[0], nil
+	}
+	switch command {
+	case ControlRefresh:
+		name, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		if _, found := p.Secrets[name]; !found {
+			return nil, fmt.Errorf("unknown secret '%s'", name)
+		}
+		err = p.updateSecretAndFiles(name)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("Secret '%s' refreshed", name), nil
+	case ControlRerender:
+		path, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		fc, found := p.Files[path]
+		if !found {
+			return nil, fmt.Errorf("unknown file '%s'", path)
+		}
+		err = p.resolveFile(fc)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("File '%s' rendered", path), nil
+	case ControlStatus:
+		return p.statusReport(), nil
+	case ControlReloadConfig:
+		return p.reloadConfig()
+	case ControlRevoke:
+		name, err := arg()
+		if err != nil {
+			return nil, err
+		}
+		err = p.revokeSecret(name)
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("Lease of secret '%s' revoked, secret read again", name), nil
+	}
+	return nil, fmt.Errorf("unknown command '%s'", command)
+}
+
+func (p *pouch) statusReport() StatusReport {
+	report := StateStatusReport(p.State, p.Secrets)
+	report.Status, report.Message = p.Status()
+	for i, secret := range report.Secrets {
+		report.Secrets[i].Failures = p.secretFailures[secret.Name]
+		if e, found := p.secretErrors[secret.Name]; found {
+			report.Secrets[i].LastError = e.Message
+			report.Secrets[i].LastErrorTime = &e.Time
+		}
+	}
+	return report
+}
+
+// StateStatusReport returns the status of the configured secrets and
+// the secrets in a state, without the status of pouch nor the errors,
+// that are only known by a running pouch
+func StateStatusReport(state *PouchState, secrets map[string]SecretConfig) StatusReport {
+	report := StatusReport{Secrets: []SecretStatus{}}
+	names := make(map[string]bool)
+	for name := range secrets {
+		names[name] = true
+	}
+	for name := range state.Secrets {
+		names[name] = true
+	}
+	for name := range names {
+		secret := SecretStatus{Name: name}
+		if s, found := state.Secrets[name]; found {
+			lastFetch := s.Timestamp
+			secret.LastFetch = &lastFetch
+			secret.LeaseID = s.LeaseID
+			secret.LeaseDuration = s.LeaseDuration
+			secret.Renewable = s.Renewable
+			secret.Version = s.Version
+			if ttu, known := s.TimeToUpdate(); known && !s.DisableAutoUpdate {
+				secret.NextUpdate = &ttu
+			}
+			if expiration, known := s.Expiration(); known {
+				secret.Expiration = &expiration
+			}
+			for _, f := range s.FilesUsing {
+				secret.Files = append(secret.Files, f.Path)
+			}
+		}
+		report.Secrets = append(report.Secrets, secret)
+	}
+	sort.Slice(report.Secrets, func(i, j int) bool { return report.Secrets[i].Name < report.Secrets[j].Name })
+	return report
+}
+
+// loadedConfig is a configuration of secrets, files and notifiers, with
+// glob secrets and templated paths already expanded once loaded
+type loadedConfig struct {
+	secrets     map[string]SecretConfig
+	secretGlobs map[string]SecretConfig
+	files       map[string]FileConfig
+	notifiers   map[string]NotifierConfig
+}
+
+// currentConfig returns a copy of the configuration loaded, loading it
+// again doesn't need to expand anything
+func (p *pouch) currentConfig() loadedConfig {
+	c := loadedConfig{
+		secrets:     make(map[string]SecretConfig),
+		secretGlobs: make(map[string]SecretConfig),
+		files:       make(map[string]FileConfig),
+		notifiers:   p.Notifiers,
+	}
+	for name, sc := range p.Secrets {
+		c.secrets[name] = sc
+	}
+	for name, sc := range p.secretGlobs {
+		c.secretGlobs[name] = sc
+	}
+	for path, fc := range p.Files {
+		c.files[path] = fc
+	}
+	return c
+}
+
+// loadConfig replaces the configuration and loads its secrets and files
+func (p *pouch) loadConfig(c loadedConfig) error {
+	p.Secrets = c.secrets
+	p.secretGlobs = c.secretGlobs
+	p.Files = c.files
+	p.Notifiers = c.notifiers
+	p.polls = nil
+	err := p.loadSecretsAndFiles()
+	// Named pipes still configured keep being served
+	p.stopRemovedFIFOs()
+	return err
+}
+
+// reloadConfig loads again the secrets, files and notifiers of the
+// Pouchfile, other sections need a restart to be applied. If the new
+// configuration cannot be loaded, the previous one is kept.
+func (p *pouch) reloadConfig() (string, error) {
+	if p.configPath == "" {
+		return "", fmt.Errorf("path of Pouchfile unknown")
+	}
+	pouchfile, err := LoadPouchfile(p.configPath)
+	if err != nil {
+		return "", err
+	}
+	if errs := ValidatePouchfile(pouchfile); len(errs) > 0 {
+		return "", fmt.Errorf("invalid Pouchfile: %v", errs[0])
+	}
+	files := make(map[string]FileConfig)
+	for _, fc := range pouchfile.Files {
+		files[fc.Path] = fc
+	}
+	err = checkSelfHeal(files)
+	if err != nil {
+		return "", err
+	}
+
+	previous := p.currentConfig()
+	err = p.loadConfig(loadedConfig{secrets: pouchfile.Secrets, files: files, notifiers: pouchfile.Notifiers})
+	if err != nil {
+		if perr := p.loadConfig(previous); perr != nil {
+			errorf("Couldn't load previous configuration again: %v", perr)
+		}
+		return "", fmt.Errorf("couldn't reload configuration, previous one kept: %v", err)
+	}
+
+	// Watchers are started again for the secrets and files configured now
+	err = p.restartWatchers()
+	if err != nil {
+		return "", fmt.Errorf("configuration reloaded, but watchers couldn't be started: %v", err)
+	}
+	return fmt.Sprintf("Configuration reloaded, %d secrets and %d files", len(p.Secrets), len(p.Files)), nil
+}
+
+// revokeSecret revokes the lease of a secret, e.g. if it has been
+// compromised, and reads it again
+func (p *pouch) revokeSecret(name string) error {
+	c, found := p.Secrets[name]
+	if !found {
+		return fmt.Errorf("unknown secret '%s'", name)
+	}
+	s, found := p.State.Secrets[name]
+	if !found || s.LeaseID == "" {
+		return fmt.Errorf("secret '%s' has no lease", name)
+	}
+	if c.Plugin != "" || c.Provider() != VaultProvider {
+		return fmt.Errorf("leases can only be revoked for secrets read from vault")
+	}
+	options := &vault.RequestOptions{Data: map[string]interface{}{"lease_id": s.LeaseID}}
+	_, _, err := p.Vault.Request(http.MethodPut, vault.LeaseRevokeURL, options)
+	if err != nil {
+		return fmt.Errorf("couldn't revoke lease: %v", err)
+	}
+	s.LeaseID = ""
+	s.Renewable = false
+	return p.updateSecretAndFiles(name)
+}
+
+// SendControlCommand sends a command to the control socket of a running
+// pouch, and returns its result
+func SendControlCommand(socket, command string, args ...string) (interface{}, error) {
+	var result interface{}
+	err := sendControlCommand(socket, &result, command, args...)
+	return result, err
+}
+
+// QueryStatus requests the status report to pouch through its control
+// socket
+func QueryStatus(socket string) (StatusReport, error) {
+	var report StatusReport
+	err := sendControlCommand(socket, &report, ControlStatus)
+	return report, err
+}
+
+// sendControlCommand sends a command and decodes its result
+func sendControlCommand(socket string, result interface{}, command string, args ...string) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintln(conn, strings.Join(append([]string{command}, args...), " "))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Error  string          `json:"error"`
+		Result json.RawMessage `json:"result"`
+	}
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	if len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlCommands(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	v := &DummyVault{
+		T:             t,
+		ExpectedToken: "token",
+		Token:         "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/database/creds/foo": &api.Secret{
+				LeaseID:       "lease",
+				LeaseDuration: 3600,
+				Data:          map[string]interface{}{"password": "foo"},
+			},
+			"PUT/v1/sys/leases/revoke": nil,
+		},
+	}
+	state := NewState("")
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/database/creds/foo", HTTPMethod: "GET"},
+	}
+	filePath := path.Join(tmpdir, "foo")
+	files := []FileConfig{{Path: filePath, Template: `{{ secret "foo" "password" }}`}}
+	p := NewPouch(state, v, secrets, files, nil).(*pouch)
+
+	result, err := p.runControlCommand(ControlRefresh, []string{"foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Secret 'foo' refreshed", result)
+
+	result, err = p.runControlCommand(ControlRerender, []string{filePath})
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("File '%s' rendered", filePath), result)
+	d, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, "foo", string(d))
+
+	// Files using the secret are written again when refreshed
+	os.Remove(filePath)
+	p.State.FileChecksums = nil
+	_, err = p.runControlCommand(ControlRefresh, []string{"foo"})
+	assert.NoError(t, err)
+	d, _ = ioutil.ReadFile(filePath)
+	assert.Equal(t, "foo", string(d))
+
+	_, err = p.runControlCommand(ControlRefresh, []string{"bar"})
+	assert.Error(t, err)
+	_, err = p.runControlCommand(ControlRefresh, nil)
+	assert.Error(t, err)
+	_, err = p.runControlCommand(ControlRerender, []string{"/unknown"})
+	assert.Error(t, err)
+
+	result, err = p.runControlCommand(ControlStatus, nil)
+	assert.NoError(t, err)
+	if report, ok := result.(StatusReport); assert.True(t, ok, "status report expected") && assert.Len(t, report.Secrets, 1) {
+		assert.Equal(t, StatusStarting, report.Status)
+		assert.Equal(t, "foo", report.Secrets[0].Name)
+		assert.Equal(t, "lease", report.Secrets[0].LeaseID)
+		assert.NotNil(t, report.Secrets[0].NextUpdate)
+	}
+
+	result, err = p.runControlCommand(ControlRevoke, []string{"foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Lease of secret 'foo' revoked, secret read again", result)
+
+	_, err = p.runControlCommand("shutdown", nil)
+	assert.Error(t, err)
+}
+
+func TestControlReloadConfig(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	v := &DummyVault{
+		T:             t,
+		ExpectedToken: "token",
+		Token:         "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/foo": &api.Secret{Data: map[string]interface{}{"password": "foo"}},
+		},
+	}
+	p := NewPouch(NewState(""), v, nil, nil, nil).(*pouch)
+	_, err = p.reloadConfig()
+	assert.Error(t, err)
+
+	filePath := path.Join(tmpdir, "foo")
+	pouchfilePath := path.Join(tmpdir, "Pouchfile")
+	pouchfile := fmt.Sprintf(`
+secrets:
+  foo:
+    vault_url: /v1/secret/foo
+    http_method: GET
+files:
+- path: %s
+  template: '{{ secret "foo" "password" }}'
+`, filePath)
+	assert.NoError(t, ioutil.WriteFile(pouchfilePath, []byte(pouchfile), 0600))
+	p.ConfigPath(pouchfilePath)
+
+	result, err := p.runControlCommand(ControlReloadConfig, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Configuration reloaded, 1 secrets and 1 files", result)
+	d, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, "foo", string(d))
+
+	// Configurations that cannot be loaded are not applied
+	for _, files := range []string{
+		fmt.Sprintf(`
+- path: %s/bar
+  template: '{{ secret "foo" "password" }}'
+`, filePath),
+		fmt.Sprintf(`
+- path: %s/bar
+  template: '{{ secret "foo" "password" }}'
+  fifo: true
+  self_heal: true
+`, tmpdir),
+	} {
+		assert.NoError(t, ioutil.WriteFile(pouchfilePath, []byte(`
+secrets:
+  foo:
+    vault_url: /v1/secret/foo
+    http_method: GET
+files:`+files), 0600))
+		_, err = p.reloadConfig()
+		assert.Error(t, err)
+		assert.Equal(t, []string{filePath}, p.managedFiles())
+		assert.Equal(t, PriorityFileSortedList{{Path: filePath}}, p.State.Secrets["foo"].FilesUsing)
+	}
+}
+
+func TestReloadConfigWatchers(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	provider := &watchingProvider{changes: make(chan string)}
+	p := NewPouch(NewState(""), nil, map[string]SecretConfig{
+		"foo": {VaultURL: "watching://foo"},
+	}, nil, nil).(*pouch)
+	p.AddSecretProvider("watching", provider)
+	assert.NoError(t, p.loadSecretsAndFiles())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.changed = make(chan string)
+	p.tampered = make(chan string)
+	assert.NoError(t, p.startWatchers(ctx))
+
+	pouchfilePath := path.Join(tmpdir, "Pouchfile")
+	assert.NoError(t, ioutil.WriteFile(pouchfilePath, []byte(`
+secrets:
+  bar:
+    vault_url: watching://bar
+`), 0600))
+	p.ConfigPath(pouchfilePath)
+	_, err = p.reloadConfig()
+	assert.NoError(t, err)
+
+	// Only secrets configured now are watched
+	for _, name := range []string{"foo", "bar"} {
+		select {
+		case provider.changes <- name:
+		case <-time.After(time.Second):
+			t.Fatalf("secret '%s' not watched", name)
+		}
+	}
+	select {
+	case name := <-p.changed:
+		assert.Equal(t, "bar", name)
+	case <-time.After(time.Second):
+		t.Fatal("change not received")
+	}
+}
+
+func TestControlSocket(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := NewPouch(NewState(""), nil, nil, nil, nil).(*pouch)
+	socket := path.Join(tmpdir, "control.sock")
+	assert.NoError(t, p.ControlSocket(ControlConfig{Socket: socket}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.controlRequests = make(chan controlRequest)
+	if !assert.NoError(t, p.startControlSocket(ctx)) {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case req := <-p.controlRequests:
+				p.runControl(req)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	info, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	result, err := SendControlCommand(socket, ControlStatus)
+	assert.NoError(t, err)
+	if report, ok := result.(map[string]interface{}); assert.True(t, ok, "status report expected") {
+		assert.Equal(t, string(StatusStarting), report["status"])
+	}
+
+	report, err := QueryStatus(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusStarting, report.Status)
+
+	_, err = SendControlCommand(socket, ControlRefresh, "foo")
+	assert.EqualError(t, err, "unknown secret 'foo'")
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum time searched for the next match of a cron schedule
+const cronSearchLimit = 366 * 24 * time.Hour
+
+// cronSchedule is a cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+
+	// Restrictions on days of month and of week are combined with an or,
+	// as in cron
+	anyDay, anyWeekday bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCronField parses lists of values, ranges and steps, as */15 or 1-5
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("incorrect step in %s", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			from, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("incorrect value %s", part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("incorrect value %s", part)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%s out of range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression must have %d fields: %s", len(cronFields), expr)
+	}
+	parsed := make([]map[int]bool, len(fields))
+	for i, f := range cronFields {
+		values, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect %s in cron expression: %v", f.name, err)
+		}
+		parsed[i] = values
+	}
+	// Sunday can be 0 or 7
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+	return &cronSchedule{
+		minutes:    parsed[0],
+		hours:      parsed[1],
+		days:       parsed[2],
+		months:     parsed[3],
+		weekdays:   parsed[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	if !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// matches returns true if the minute of the given time is in the schedule
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minutes[t.Minute()] && s.hours[t.Hour()] && s.dayMatches(t)
+}
+
+// next returns the start of the next minute in the schedule from the
+// given time, that is included
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	if s.matches(t) {
+		return t, true
+	}
+	limit := t.Add(cronSearchLimit)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	for t.Before(limit) {
+		switch {
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// Saturdays and Sundays between 2:00 and 4:59
+	s, err := parseCronSchedule("* 2-4 * * 6,7")
+	if !assert.NoError(t, err) {
+		return
+	}
+	saturday := time.Date(2018, time.March, 3, 3, 30, 0, 0, time.UTC)
+	assert.True(t, s.matches(saturday))
+	assert.False(t, s.matches(saturday.Add(2*time.Hour)))
+	assert.True(t, s.matches(saturday.Add(24*time.Hour)))
+
+	monday := time.Date(2018, time.March, 5, 10, 15, 30, 0, time.UTC)
+	next, found := s.next(monday)
+	assert.True(t, found)
+	assert.Equal(t, time.Date(2018, time.March, 10, 2, 0, 0, 0, time.UTC), next)
+
+	next, _ = s.next(saturday)
+	assert.Equal(t, saturday, next)
+
+	// Days of month and of week are combined with an or
+	s, err = parseCronSchedule("*/15 0 1 * 1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, s.matches(time.Date(2018, time.March, 1, 0, 45, 0, 0, time.UTC)))
+	assert.True(t, s.matches(monday.Add(-10*time.Hour-15*time.Minute-30*time.Second)))
+	assert.False(t, s.matches(time.Date(2018, time.March, 1, 0, 10, 0, 0, time.UTC)))
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := parseCronSchedule(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Encryption functions run these commands, they need to be installed to
+// use them
+var (
+	AgeCommand = "age"
+	GPGCommand = "gpg"
+)
+
+const CryptTimeout = 30 * time.Second
+
+// cryptFuncMap contains functions available in templates to encrypt
+// content, so rendered files can be sent to other machines or backups.
+// Content is the last argument, so they can be used in pipelines.
+var cryptFuncMap = template.FuncMap{
+	"ageEncrypt": ageEncrypt,
+	"ageDecrypt": ageDecrypt,
+	"gpgEncrypt": gpgEncrypt,
+	"gpgDecrypt": gpgDecrypt,
+}
+
+func runCrypt(command string, args []string, input string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CryptTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// withKeyFile writes a key to a private temporary directory, to pass it
+// to commands, it is removed after calling f
+func withKeyFile(key string, f func(dir, path string) (string, error)) (string, error) {
+	dir, err := ioutil.TempDir("", "pouch-crypt")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key")
+	err = ioutil.WriteFile(path, []byte(key), 0600)
+	if err != nil {
+		return "", err
+	}
+	return f(dir, path)
+}
+
+// ageEncrypt encrypts content for age recipients, separated by spaces or
+// line breaks, the result is armored
+func ageEncrypt(recipients, content string) (string, error) {
+	args := []string{"--encrypt", "--armor"}
+	for _, r := range strings.Fields(recipients) {
+		args = append(args, "--recipient", r)
+	}
+	if len(args) == 2 {
+		return "", fmt.Errorf("no age recipients")
+	}
+	return runCrypt(AgeCommand, args, content)
+}
+
+// ageDecrypt decrypts content with an age identity
+func ageDecrypt(identity, content string) (string, error) {
+	return withKeyFile(identity, func(dir, path string) (string, error) {
+		return runCrypt(AgeCommand, []string{"--decrypt", "--identity", path}, content)
+	})
+}
+
+// gpgImport imports keys in a temporary keyring and returns the
+// fingerprints of their primary keys
+func gpgImport(dir, path string) ([]string, error) {
+	_, err := runCrypt(GPGCommand, []string{"--homedir", dir, "--batch", "--import", path}, "")
+	if err != nil {
+		return nil, err
+	}
+	out, err := runCrypt(GPGCommand, []string{"--homedir", dir, "--batch", "--with-colons", "--list-keys"}, "")
+	if err != nil {
+		return nil, err
+	}
+	var fingerprints []string
+	primary := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			primary = true
+		case fields[0] == "fpr" && primary && len(fields) > 9:
+			fingerprints = append(fingerprints, fields[9])
+			primary = false
+		}
+	}
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no GPG keys found")
+	}
+	return fingerprints, nil
+}
+
+// gpgStopAgent stops the agent started for a temporary keyring
+func gpgStopAgent(dir string) {
+	exec.Command("gpgconf", "--homedir", dir, "--kill", "all").Run()
+}
+
+// gpgEncrypt encrypts content for the armored public keys, the result
+// is armored
+func gpgEncrypt(publicKeys, content string) (string, error) {
+	return withKeyFile(publicKeys, func(dir, path string) (string, error) {
+		defer gpgStopAgent(dir)
+		fingerprints, err := gpgImport(dir, path)
+		if err != nil {
+			return "", err
+		}
+		args := []string{"--homedir", dir, "--batch", "--armor", "--trust-model", "always", "--encrypt"}
+		for _, f := range fingerprints {
+			args = append(args, "--recipient", f)
+		}
+		return runCrypt(GPGCommand, args, content)
+	})
+}
+
+// gpgDecrypt decrypts content with an armored private key without
+// passphrase
+func gpgDecrypt(privateKey, content string) (string, error) {
+	return withKeyFile(privateKey, func(dir, path string) (string, error) {
+		defer gpgStopAgent(dir)
+		_, err := gpgImport(dir, path)
+		if err != nil {
+			return "", err
+		}
+		return runCrypt(GPGCommand, []string{"--homedir", dir, "--batch", "--decrypt"}, content)
+	})
+}
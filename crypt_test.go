@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPGFunctions(t *testing.T) {
+	if _, err := exec.LookPath(GPGCommand); err != nil {
+		t.Skip("gpg not available")
+	}
+	dir, err := ioutil.TempDir("", "pouch-gpg-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer gpgStopAgent(dir)
+
+	gpg := func(args ...string) string {
+		out, err := exec.Command(GPGCommand, append([]string{"--homedir", dir, "--batch"}, args...)...).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	gpg("--passphrase", "", "--quick-gen-key", "pouch <pouch@example.com>", "future-default", "default", "never")
+	publicKey := gpg("--armor", "--export")
+	privateKey := gpg("--armor", "--export-secret-keys")
+
+	encrypted, err := gpgEncrypt(publicKey, "password")
+	assert.NoError(t, err)
+	assert.Contains(t, encrypted, "BEGIN PGP MESSAGE")
+	assert.NotContains(t, encrypted, "password")
+
+	decrypted, err := gpgDecrypt(privateKey, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "password", decrypted)
+
+	_, err = gpgEncrypt("not a key", "password")
+	assert.Error(t, err)
+}
+
+func TestAgeFunctions(t *testing.T) {
+	_, err := ageEncrypt("", "password")
+	assert.Error(t, err)
+
+	if _, err := exec.LookPath("age-keygen"); err != nil {
+		t.Skip("age not available")
+	}
+	identity, err := exec.Command("age-keygen").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("age-keygen", "-y")
+	cmd.Stdin = bytes.NewReader(identity)
+	recipient, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := ageEncrypt(string(recipient), "password")
+	assert.NoError(t, err)
+	assert.Contains(t, encrypted, "BEGIN AGE ENCRYPTED FILE")
+
+	decrypted, err := ageDecrypt(string(identity), encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "password", decrypted)
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const randomStringAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// uuid generates a random version 4 UUID
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randomString generates a random alphanumeric string of the given length
+func randomString(length int) (string, error) {
+	max := big.NewInt(int64(len(randomStringAlphabet)))
+	b := make([]byte, length)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = randomStringAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// fqdn returns the fully qualified domain name of the host, or the
+// hostname if it cannot be resolved
+func fqdn() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	cname, err := net.LookupCNAME(hostname)
+	if err != nil || cname == "" {
+		return hostname, nil
+	}
+	return strings.TrimSuffix(cname, "."), nil
+}
+
+// ipAddresses returns the addresses of the network interfaces of the
+// host, loopback addresses excluded
+func ipAddresses() ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		ips = append(ips, ipNet.IP.String())
+	}
+	return ips, nil
+}
+
+// ipAddress returns the first IPv4 address of the host, or the first
+// IPv6 address if it has no IPv4 addresses
+func ipAddress() (string, error) {
+	ips, err := ipAddresses()
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			return ip, nil
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0], nil
+	}
+	return "", fmt.Errorf("no IP addresses found")
+}
+
+// lookupIP resolves the addresses of a host
+func lookupIP(host string) ([]string, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = ip.String()
+	}
+	return addresses, nil
+}
+
+// formatNow formats the current time in UTC, RFC 3339 by default
+func formatNow(layout ...string) string {
+	format := time.RFC3339
+	if len(layout) > 0 {
+		format = layout[0]
+	}
+	return time.Now().UTC().Format(format)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+)
+
+// Functions whose first argument refers to a secret, by engine, with the
+// provider of the paths used with them by engines that refer to secrets
+// by their location
+var discoveryFuncs = map[string]map[string]string{
+	DefaultTemplateEngine: {
+		"secret":          "",
+		"secretOrDefault": "",
+		"secretMeta":      "",
+	},
+	ConsulTemplateEngine: {
+		"secret":       VaultProvider,
+		"key":          consulKVProvider,
+		"keyOrDefault": consulKVProvider,
+		"service":      consulServiceProvider,
+	},
+}
+
+// templateReferences returns the string literals passed as first argument
+// to the given functions in a go template, with the function used
+func templateReferences(name, source string, funcs map[string]string) (map[string]string, error) {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	_, err := tree.Parse(source, "", "", trees)
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if len(n.Args) > 1 {
+				f, isIdentifier := n.Args[0].(*parse.IdentifierNode)
+				s, isString := n.Args[1].(*parse.StringNode)
+				if isIdentifier && isString {
+					if _, found := funcs[f.Ident]; found {
+						refs[s.Text] = f.Ident
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	for _, t := range trees {
+		walk(t.Root)
+	}
+	return refs, nil
+}
+
+// discoveredSecretURL returns the URL of a secret referred in a template
+// by its path, or an empty string if the reference doesn't look like one
+func (p *pouch) discoveredSecretURL(ref, provider string) string {
+	if provider == VaultProvider {
+		return "/v1/" + strings.Trim(ref, "/")
+	}
+	if provider != "" {
+		return provider + providerSchemeSeparator + ref
+	}
+	if !strings.Contains(ref, "/") {
+		return ""
+	}
+	if _, found := p.secretGlobs[strings.SplitN(ref, "/", 2)[0]]; found {
+		// Key of a glob secret not found when expanding it
+		return ""
+	}
+	if scheme, _ := splitProviderURL(ref); scheme != VaultProvider {
+		return ref
+	}
+	return "/v1/" + strings.TrimPrefix(strings.Trim(ref, "/"), "v1/")
+}
+
+// secretByLocation returns the name of a secret with the same location
+// as the given URL
+func (p *pouch) secretByLocation(url string) (string, bool) {
+	provider, path := secretLocation(url)
+	for name, c := range p.Secrets {
+		secretProvider, secretPath := secretLocation(c.VaultURL)
+		if secretProvider == provider && secretPath == path {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// discoverSecrets adds the secrets that templates refer by their paths
+// and aren't configured, so they are obtained and tracked as configured
+// secrets
+func (p *pouch) discoverSecrets() error {
+	if p.Secrets == nil {
+		p.Secrets = make(map[string]SecretConfig)
+	}
+	for filePath, fc := range p.Files {
+		engine := fc.Engine
+		if engine == "" {
+			engine = DefaultTemplateEngine
+		}
+		funcs, found := discoveryFuncs[engine]
+		if !found {
+			continue
+		}
+		name, source, err := templateSource(fc)
+		if err != nil {
+			// Reported when resolving the file
+			continue
+		}
+		refs, err := templateReferences(name, source, funcs)
+		if err != nil {
+			return fmt.Errorf("couldn't parse template of '%s': %v", filePath, err)
+		}
+		for ref, f := range refs {
+			provider := funcs[f]
+			if _, found := p.Secrets[ref]; found && provider == "" {
+				continue
+			}
+			url := p.discoveredSecretURL(ref, provider)
+			if url == "" {
+				continue
+			}
+
+			// Engines referring to secrets by name need them to be
+			// named as referred
+			secretName := ref
+			found := false
+			if provider != "" {
+				secretName, found = p.secretByLocation(url)
+				if !found {
+					secretName = url
+				}
+			}
+			if !found {
+				p.Secrets[secretName] = SecretConfig{VaultURL: url, HTTPMethod: "GET"}
+				debugf("Secret '%s' discovered in template of '%s'", secretName, filePath)
+			}
+			if len(fc.Secrets) > 0 && !stringInSlice(secretName, fc.Secrets) {
+				fc.Secrets = append(fc.Secrets, secretName)
+				p.Files[filePath] = fc
+			}
+		}
+	}
+	return nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateReferences(t *testing.T) {
+	source := `{{ secret "app" "password" }}{{ if true }}{{ with secretMeta "secret/db" "version" }}{{ . }}{{ end }}{{ end }}` +
+		`{{ range $k, $v := (secretOrDefault "secret/list" "keys" list) }}{{ $k }}{{ end }}{{ printf "%s" "secret/other" }}`
+	refs, err := templateReferences("test", source, discoveryFuncs[DefaultTemplateEngine])
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app":         "secret",
+		"secret/db":   "secretMeta",
+		"secret/list": "secretOrDefault",
+	}, refs)
+
+	_, err = templateReferences("test", `{{ secret "app"`, discoveryFuncs[DefaultTemplateEngine])
+	assert.Error(t, err)
+}
+
+func TestDiscoverSecrets(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/app": &api.Secret{
+				Data: map[string]interface{}{"password": "secret"},
+			},
+			"GET/v1/secret/db": &api.Secret{
+				Data: map[string]interface{}{"password": "dbsecret"},
+			},
+			"GET/v1/secret/data/web": &api.Secret{
+				Data: map[string]interface{}{"data": map[string]interface{}{"token": "websecret"}},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"app": {VaultURL: "/v1/secret/app", HTTPMethod: "GET"},
+	}
+	files := []FileConfig{
+		{
+			Path:     path.Join(tmpdir, "passwords"),
+			Template: `{{ secret "app" "password" }} {{ secret "secret/db" "password" }}`,
+			Secrets:  []string{"app"},
+		},
+		{
+			Path:     path.Join(tmpdir, "web"),
+			Engine:   ConsulTemplateEngine,
+			Template: `{{ with secret "secret/data/web" }}{{ .Data.data.token }}{{ end }} {{ with secret "secret/app" }}{{ .Data.password }}{{ end }}`,
+		},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, files, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.Run(ctx)
+	assert.NoError(t, err)
+
+	d, _ := ioutil.ReadFile(path.Join(tmpdir, "passwords"))
+	assert.Equal(t, "secret dbsecret", string(d))
+	d, _ = ioutil.ReadFile(path.Join(tmpdir, "web"))
+	assert.Equal(t, "websecret secret", string(d))
+
+	assert.Len(t, state.Secrets, 3)
+	assert.Contains(t, state.Secrets, "secret/db")
+	assert.Contains(t, state.Secrets, "/v1/secret/data/web")
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// Socket of the Docker API used if none is configured, it can be
+	// also the socket of compatible APIs as Podman's
+	DefaultDockerSocket = "/var/run/docker.sock"
+
+	// Seconds Docker waits for a container to stop before killing it
+	dockerStopTimeout = 10
+)
+
+type DockerNotifierConfig struct {
+	// Unix socket of the Docker API
+	Socket string `json:"socket,omitempty"`
+
+	// Name or ID of the container
+	Container string `json:"container,omitempty"`
+
+	// Command run inside the container, it is restarted if not set
+	Exec []string `json:"exec,omitempty"`
+}
+
+// DockerNotifier restarts a container, runs a command inside it or sends
+// a signal to it
+type DockerNotifier struct {
+	Config DockerNotifierConfig
+	Signal string
+}
+
+func (n *DockerNotifier) client() *http.Client {
+	socket := n.Config.Socket
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+	var d net.Dialer
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+func (n *DockerNotifier) request(ctx context.Context, client *http.Client, method, path string, body, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, "http://docker"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(d, &e) == nil && e.Message != "" {
+			return fmt.Errorf("docker API error: %s", e.Message)
+		}
+		return fmt.Errorf("docker API replied with status %d", resp.StatusCode)
+	}
+	if result != nil {
+		return json.Unmarshal(d, result)
+	}
+	return nil
+}
+
+func (n *DockerNotifier) exec(ctx context.Context, client *http.Client, container string) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := n.request(ctx, client, "POST", "/containers/"+container+"/exec", map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          true,
+		"Cmd":          n.Config.Exec,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+
+	// With a TTY the output is not multiplexed, so it can be read as is
+	req, err := http.NewRequest("POST", "http://docker/exec/"+created.ID+"/start",
+		bytes.NewReader([]byte(`{"Detach":false,"Tty":true}`)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return string(out), err
+	}
+	if resp.StatusCode/100 != 2 {
+		return string(out), fmt.Errorf("docker API replied with status %d", resp.StatusCode)
+	}
+
+	var inspect struct {
+		ExitCode int
+	}
+	err = n.request(ctx, client, "GET", "/exec/"+created.ID+"/json", nil, &inspect)
+	if err != nil {
+		return string(out), err
+	}
+	if inspect.ExitCode != 0 {
+		return string(out), fmt.Errorf("command exited with status %d", inspect.ExitCode)
+	}
+	return string(out), nil
+}
+
+func (n *DockerNotifier) Run(ctx context.Context) (string, error) {
+	if n.Config.Container == "" {
+		return "", fmt.Errorf("container is required for docker notifier")
+	}
+	container := url.PathEscape(n.Config.Container)
+	client := n.client()
+	switch {
+	case len(n.Config.Exec) > 0:
+		if n.Signal != "" {
+			return "", fmt.Errorf("signal cannot be used with exec")
+		}
+		return n.exec(ctx, client, container)
+	case n.Signal != "":
+		if _, err := parseSignal(n.Signal); err != nil {
+			return "", err
+		}
+		path := fmt.Sprintf("/containers/%s/kill?signal=%s", container, url.QueryEscape(n.Signal))
+		err := n.request(ctx, client, "POST", path, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Signal %s sent to container %s", n.Signal, n.Config.Container), nil
+	default:
+		path := fmt.Sprintf("/containers/%s/restart?t=%d", container, dockerStopTimeout)
+		err := n.request(ctx, client, "POST", path, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Container %s restarted", n.Config.Container), nil
+	}
+}
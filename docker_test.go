@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDockerTestServer(t *testing.T, handler http.Handler) (*httptest.Server, string, func()) {
+	dir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = l
+	server.Start()
+	return server, socket, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDockerNotifier(t *testing.T) {
+	var requests []string
+	var cmd []string
+	exitCode := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/containers/nginx/exec":
+			var body struct{ Cmd []string }
+			json.NewDecoder(r.Body).Decode(&body)
+			cmd = body.Cmd
+			w.Write([]byte(`{"Id":"abc"}`))
+		case "/containers/unknown/restart":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container: unknown"}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("/exec/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("reloaded"))
+	})
+	mux.HandleFunc("/exec/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]int{"ExitCode": exitCode})
+	})
+	_, socket, cleanup := newDockerTestServer(t, mux)
+	defer cleanup()
+
+	ctx := context.Background()
+	n := &DockerNotifier{Config: DockerNotifierConfig{Socket: socket, Container: "nginx"}}
+	_, err := n.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /containers/nginx/restart?t=10"}, requests)
+
+	requests = nil
+	n.Signal = "HUP"
+	_, err = n.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"POST /containers/nginx/kill?signal=HUP"}, requests)
+
+	n.Signal = ""
+	n.Config.Exec = []string{"nginx", "-s", "reload"}
+	out, err := n.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "reloaded", out)
+	assert.Equal(t, []string{"nginx", "-s", "reload"}, cmd)
+
+	exitCode = 1
+	_, err = n.Run(ctx)
+	assert.Error(t, err)
+
+	n = &DockerNotifier{Config: DockerNotifierConfig{Socket: socket, Container: "unknown"}}
+	_, err = n.Run(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "No such container")
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	SystemdEnvironmentEngine = "systemd-environment"
+	EnvironmentFileEngine    = "environment-file"
+	DockerEnvFileEngine      = "docker-env-file"
+	DotenvEngine             = "dotenv"
+
+	DefaultSystemdUnitsPath = "/etc/systemd/system"
+	DefaultDropInName       = "pouch-environment"
+
+	dropInHeader = "# Generated by pouch, do not edit\n"
+)
+
+func init() {
+	RegisterTemplateEngine(SystemdEnvironmentEngine, &environmentEngine{format: SystemdEnvironmentEngine})
+	RegisterTemplateEngine(EnvironmentFileEngine, &environmentEngine{format: EnvironmentFileEngine})
+	RegisterTemplateEngine(DockerEnvFileEngine, &environmentEngine{format: DockerEnvFileEngine})
+	RegisterTemplateEngine(DotenvEngine, &environmentEngine{format: DotenvEngine})
+}
+
+// SystemdEnvironmentConfig defines environment variables for a systemd
+// unit obtained from a secret
+type SystemdEnvironmentConfig struct {
+	Unit   string `json:"unit,omitempty"`
+	Secret string `json:"secret,omitempty"`
+
+	// Environment variables mapped to keys of the secret, if not set
+	// all keys are used, with their names in upper case
+	Keys map[string]string `json:"keys,omitempty"`
+
+	// Name of the drop-in file
+	DropInName string `json:"dropin_name,omitempty"`
+
+	// If set, variables are written in this file, and the drop-in only
+	// references it
+	EnvironmentFile string `json:"environment_file,omitempty"`
+
+	// If the unit should be restarted when variables change, true by
+	// default
+	Restart *bool `json:"restart,omitempty"`
+}
+
+// expandSystemdEnvironment adds the files and notifiers needed to
+// provide environment variables to systemd units
+func (p *Pouchfile) expandSystemdEnvironment() error {
+	unitsPath := p.Systemd.UnitsPath
+	if unitsPath == "" {
+		unitsPath = DefaultSystemdUnitsPath
+	}
+	for _, c := range p.Systemd.Environment {
+		if c.Unit == "" || c.Secret == "" {
+			return fmt.Errorf("unit and secret are required for systemd environment")
+		}
+		dropInName := c.DropInName
+		if dropInName == "" {
+			dropInName = DefaultDropInName
+		}
+		dropInPath := path.Join(unitsPath, c.Unit+".d", dropInName+".conf")
+
+		notifierName := SystemdEnvironmentEngine + ":" + c.Unit
+		notifier := NotifierConfig{DaemonReload: true}
+		if c.Restart == nil || *c.Restart {
+			notifier.Service = c.Unit
+			notifier.Restart = true
+		}
+		if p.Notifiers == nil {
+			p.Notifiers = make(map[string]NotifierConfig)
+		}
+		p.Notifiers[notifierName] = notifier
+
+		keys, err := json.Marshal(c.Keys)
+		if err != nil {
+			return err
+		}
+		if c.EnvironmentFile == "" {
+			p.Files = append(p.Files, FileConfig{
+				Path:     dropInPath,
+				Engine:   SystemdEnvironmentEngine,
+				Template: string(keys),
+				Secrets:  []string{c.Secret},
+				Notify:   NotifyNames(notifierName),
+			})
+			continue
+		}
+		p.Files = append(p.Files,
+			FileConfig{
+				Path:     c.EnvironmentFile,
+				Engine:   EnvironmentFileEngine,
+				Template: string(keys),
+				Secrets:  []string{c.Secret},
+				Notify:   NotifyNames(notifierName),
+			},
+			FileConfig{
+				Path:     dropInPath,
+				Mode:     0644,
+				Template: dropInHeader + "[Service]\nEnvironmentFile=" + c.EnvironmentFile + "\n",
+				Notify:   NotifyNames(notifierName),
+			},
+		)
+	}
+	return nil
+}
+
+// environmentName converts a key to an environment variable name
+func environmentName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// environmentEngine renders environment variables from the secrets of
+// the file, as a systemd drop-in, or as an environment file in one of
+// the supported formats. The source is a JSON object mapping variables
+// to keys.
+type environmentEngine struct {
+	format string
+}
+
+// environmentVariables obtains environment variables from the secrets
+// of the context, keys maps variables to keys of the secrets, if empty
+// all keys are used, with their names in upper case
+func environmentVariables(keys map[string]string, ctx *RenderContext) (map[string]string, error) {
+	variables := make(map[string]string)
+	for _, s := range ctx.Secrets {
+		data, err := ctx.Secret(s)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			for variable, key := range keys {
+				value, found := data[key]
+				if !found {
+					return nil, fmt.Errorf("unknown key in secret '%s': %s", s, key)
+				}
+				variables[variable] = fmt.Sprint(value)
+			}
+			continue
+		}
+		for key, value := range data {
+			variables[environmentName(key)] = fmt.Sprint(value)
+		}
+	}
+	return variables, nil
+}
+
+func (e *environmentEngine) Render(name, source string, ctx *RenderContext) (string, error) {
+	var keys map[string]string
+	if source != "" {
+		err := json.Unmarshal([]byte(source), &keys)
+		if err != nil {
+			return "", fmt.Errorf("incorrect keys for %s: %v", name, err)
+		}
+	}
+
+	variables, err := environmentVariables(keys, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for variable, value := range variables {
+		// Only dotenv files can escape line breaks
+		if e.format != DotenvEngine && strings.ContainsAny(value, "\n\r") {
+			return "", fmt.Errorf("value of %s cannot be used in the environment, it contains line breaks", variable)
+		}
+		names = append(names, variable)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(dropInHeader)
+	if e.format == SystemdEnvironmentEngine {
+		b.WriteString("[Service]\n")
+	}
+	for _, variable := range names {
+		b.WriteString(e.line(variable, variables[variable]))
+	}
+	return b.String(), nil
+}
+
+// templateOptional returns true if the engine can render files without
+// template, as environment engines do with all the keys of the secrets
+func templateOptional(e TemplateEngine) bool {
+	_, ok := e.(*environmentEngine)
+	return ok
+}
+
+// line formats a variable with the quoting and escaping of the format
+func (e *environmentEngine) line(variable, value string) string {
+	if e.format == DockerEnvFileEngine {
+		// Docker takes values literally, quotes included
+		return fmt.Sprintf("%s=%s\n", variable, value)
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	switch e.format {
+	case SystemdEnvironmentEngine:
+		// Avoid expansion of specifiers
+		value = strings.Replace(value, "%", "%%", -1)
+		return fmt.Sprintf("Environment=\"%s=%s\"\n", variable, value)
+	case DotenvEngine:
+		// Avoid expansion of variables
+		value = strings.Replace(value, "$", `\$`, -1)
+		value = strings.Replace(value, "\r", `\r`, -1)
+		value = strings.Replace(value, "\n", `\n`, -1)
+	}
+	return fmt.Sprintf("%s=\"%s\"\n", variable, value)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Lines of context in the diffs of dry runs
+const dryRunDiffContext = 3
+
+// fileChange is the content a file would be written with
+type fileChange struct {
+	Path    string
+	Content string
+
+	// Files not written locally cannot be compared with their current
+	// content, only with the checksum in the state
+	Remote bool
+}
+
+// DryRun prints a unified diff of the changes that would be written in
+// each file, without writing files, running notifiers nor saving the
+// state. Secrets in the state are used, only missing secrets are read.
+// Values of secrets are redacted in the diffs.
+func (p *pouch) DryRun(w io.Writer) (err error) {
+	defer recoverPanic(&err)
+	p.dryRun = true
+
+	err = p.Login()
+	if err != nil {
+		return err
+	}
+	err = p.expandSecrets()
+	if err != nil {
+		return err
+	}
+	err = p.discoverSecrets()
+	if err != nil {
+		return err
+	}
+	for name, c := range p.Secrets {
+		if _, found := p.State.Secrets[name]; found {
+			continue
+		}
+		infof("Secret '%s' is not in the state, reading it", name)
+		_, err = p.resolveSecret(name, c)
+		if err != nil {
+			return err
+		}
+	}
+	err = p.expandFiles()
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for path := range p.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	changed, failed := 0, 0
+	for _, path := range paths {
+		changes, err := p.fileChanges(p.Files[path])
+		if err != nil {
+			errorf("Couldn't render '%s': %v", path, err)
+			failed++
+			continue
+		}
+		for _, change := range changes {
+			diff, err := p.diffFile(change)
+			if err != nil {
+				errorf("Couldn't compare '%s': %v", change.Path, err)
+				failed++
+				continue
+			}
+			if diff == "" {
+				continue
+			}
+			changed++
+			fmt.Fprint(w, redactSecrets(diff))
+		}
+	}
+	infof("%d files would change", changed)
+	if failed > 0 {
+		return fmt.Errorf("%d files couldn't be rendered", failed)
+	}
+	return nil
+}
+
+// fileChanges renders a file, or each file of a per-key file
+func (p *pouch) fileChanges(fc FileConfig) ([]fileChange, error) {
+	remote := fc.Plugin != "" || len(fc.Hosts) > 0 || fc.Served || fc.FIFO
+	ctx := p.renderContext(fc, make(map[string]bool))
+	if fc.PerKey {
+		paths, contents, err := keyFileContents(fc, ctx)
+		if err != nil {
+			return nil, err
+		}
+		var changes []fileChange
+		for _, path := range paths {
+			changes = append(changes, fileChange{Path: path, Content: contents[path], Remote: remote})
+		}
+		return changes, nil
+	}
+	content, err := getFileContent(fc, ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = validateContent(fc, content)
+	if err != nil {
+		return nil, fmt.Errorf("rendered content is not valid: %v", err)
+	}
+	return []fileChange{{Path: fc.Path, Content: content, Remote: remote}}, nil
+}
+
+// diffFile returns the unified diff between the current content of a
+// file and its new content, empty if it doesn't change
+func (p *pouch) diffFile(change fileChange) (string, error) {
+	if change.Remote {
+		// Named pipes are not read either, as reading them would block
+		if p.State.FileChecksums[change.Path] == contentChecksum(change.Content) {
+			return "", nil
+		}
+		return fmt.Sprintf("%s: would be delivered with new content, current content not available\n", change.Path), nil
+	}
+	from := change.Path
+	current, err := ioutil.ReadFile(change.Path)
+	if os.IsNotExist(err) {
+		from = "/dev/null"
+	} else if err != nil {
+		return "", err
+	}
+	if string(current) == change.Content && from != "/dev/null" {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(string(current)),
+		B:        diffLines(change.Content),
+		FromFile: from,
+		ToFile:   change.Path,
+		Context:  dryRunDiffContext,
+	})
+}
+
+// diffLines splits content in lines, all of them ending in a new line
+func diffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	v := &DummyVault{
+		T:             t,
+		ExpectedToken: "token",
+		Token:         "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/api": &api.Secret{Data: map[string]interface{}{"token": "dry-run-api-token"}},
+		},
+	}
+	secrets := map[string]SecretConfig{
+		"db":  {VaultURL: "/v1/secret/db", HTTPMethod: "GET"},
+		"api": {VaultURL: "/v1/secret/api", HTTPMethod: "GET"},
+	}
+	changed := path.Join(tmpdir, "db.conf")
+	created := path.Join(tmpdir, "api.conf")
+	unchanged := path.Join(tmpdir, "user.conf")
+	files := []FileConfig{
+		{Path: changed, Template: "host=db\npassword={{ secret \"db\" \"password\" }}\nport=5432\n", Notify: NotifyNames("touch")},
+		{Path: created, Template: `token={{ secret "api" "token" }}`},
+		{Path: unchanged, Template: `{{ secret "db" "user" }}`},
+	}
+	notified := path.Join(tmpdir, "notified")
+	notifiers := map[string]NotifierConfig{"touch": {Command: "touch " + notified}}
+	assert.NoError(t, ioutil.WriteFile(changed, []byte("host=db\npassword=old-dry-run-password\nport=5432\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(unchanged, []byte("app"), 0600))
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.SetSecret("db", &api.Secret{Data: map[string]interface{}{"user": "app", "password": "new-dry-run-password"}})
+	secretRedactor.AddValues("old-dry-run-password")
+
+	p := NewPouch(state, v, secrets, files, notifiers).(*pouch)
+	var out bytes.Buffer
+	assert.NoError(t, p.DryRun(&out))
+
+	expected := `--- /dev/null
++++ ` + created + `
+@@ -0,0 +1 @@
++token=[REDACTED]
+--- ` + changed + `
++++ ` + changed + `
+@@ -1,3 +1,3 @@
+ host=db
+-password=[REDACTED]
++password=[REDACTED]
+ port=5432
+`
+	assert.Equal(t, expected, out.String())
+
+	// Nothing is written nor notified
+	d, _ := ioutil.ReadFile(changed)
+	assert.Equal(t, "host=db\npassword=old-dry-run-password\nport=5432\n", string(d))
+	_, err = os.Stat(created)
+	assert.True(t, os.IsNotExist(err), "new files shouldn't be created")
+	_, err = os.Stat(notified)
+	assert.True(t, os.IsNotExist(err), "notifiers shouldn't be run")
+	saved, _ := ioutil.ReadFile(state.Path)
+	assert.Empty(t, saved, "state shouldn't be saved")
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventSecretUpdated = "secret_updated"
+	EventFileWritten   = "file_written"
+	EventNotification  = "notification"
+
+	// Secret rotating much more often than usual
+	EventRotationAnomaly = "rotation_anomaly"
+
+	// Expectation failed or met again
+	EventExpectation = "expectation"
+
+	// File modified or removed externally and written again
+	EventFileHealed = "file_healed"
+
+	// New content of a file rejected by its check command
+	EventFileCheckFailed = "file_check_failed"
+
+	// Secret couldn't be read, with the number of consecutive failures
+	EventSecretUpdateFailed = "secret_update_failed"
+
+	// Secret close to expire that hasn't been renewed
+	EventLeaseExpiring = "lease_expiring"
+
+	// Secret requested to the secrets server, and served or denied
+	EventSecretServed       = "secret_served"
+	EventSecretAccessDenied = "secret_access_denied"
+
+	DefaultEventLogSize = 100
+
+	// Length of the hex-encoded fingerprints of secret values
+	fingerprintLength = 12
+)
+
+// Events logged as warnings
+var warningEvents = []string{
+	EventRotationAnomaly,
+	EventFileHealed,
+	EventFileCheckFailed,
+	EventSecretUpdateFailed,
+	EventLeaseExpiring,
+	EventSecretAccessDenied,
+}
+
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Type     string                 `json:"type"`
+	Secret   string                 `json:"secret,omitempty"`
+	File     string                 `json:"file,omitempty"`
+	Notifier string                 `json:"notifier,omitempty"`
+	Message  string                 `json:"message"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// logFields returns the event as structured fields for logs
+func (e Event) logFields() LogFields {
+	fields := LogFields{"event": e.Type}
+	if e.Secret != "" {
+		fields["secret"] = e.Secret
+	}
+	if e.File != "" {
+		fields["file"] = e.File
+	}
+	if e.Notifier != "" {
+		fields["notifier"] = e.Notifier
+	}
+	for k, v := range e.Details {
+		fields[k] = v
+	}
+	return fields
+}
+
+func (e Event) String() string {
+	s := e.Message
+	if len(e.Details) > 0 {
+		d, _ := json.Marshal(e.Details)
+		s += " " + string(d)
+	}
+	return s
+}
+
+// EventLog keeps the most recent events
+type EventLog struct {
+	sync.Mutex
+
+	size   int
+	events []Event
+}
+
+func NewEventLog(size int) *EventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	return &EventLog{size: size}
+}
+
+func (l *EventLog) Add(e Event) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, e)
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+}
+
+// Recent returns a copy of the events in the log, oldest first
+func (l *EventLog) Recent() []Event {
+	l.Lock()
+	defer l.Unlock()
+	events := make([]Event, len(l.events))
+	copy(events, l.events)
+	return events
+}
+
+func (p *pouch) event(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Message = redactSecrets(e.Message)
+	e.Details = redactFields(e.Details)
+	p.Events.Add(e)
+	level := LogInfo
+	if stringInSlice(e.Type, warningEvents) || e.Details["success"] == false {
+		level = LogWarn
+	}
+	logf(level, e.logFields(), "%s", e.Message)
+	if p.dryRun {
+		// Nothing is really done in dry runs
+		return
+	}
+	p.audit(e)
+	p.alert(e)
+	p.subscribers.publish(e)
+}
+
+// SecretDiff summarizes the changes between two versions of a secret
+// without revealing its values. Values of new and modified keys are
+// only included as fingerprints.
+type SecretDiff struct {
+	Added        []string          `json:"added,omitempty"`
+	Removed      []string          `json:"removed,omitempty"`
+	Modified     []string          `json:"modified,omitempty"`
+	Unchanged    []string          `json:"unchanged,omitempty"`
+	Fingerprints map[string]string `json:"fingerprints,omitempty"`
+}
+
+func (d *SecretDiff) Changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Modified) > 0
+}
+
+func (d *SecretDiff) String() string {
+	if !d.Changed() {
+		return "no changes"
+	}
+	var parts []string
+	if len(d.Added) > 0 {
+		parts = append(parts, "added: "+strings.Join(d.Added, ", "))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(d.Removed, ", "))
+	}
+	if len(d.Modified) > 0 {
+		parts = append(parts, "modified: "+strings.Join(d.Modified, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// fingerprintKey is a random key to compute fingerprints, so values of
+// secrets cannot be guessed from them, they can only be compared with
+// fingerprints of the same process
+var fingerprintKey = func() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("couldn't generate fingerprint key: %v", err))
+	}
+	return key
+}()
+
+// fingerprint hashes a value of a secret, the name of the secret and the
+// key are included so equal values in different keys have different
+// fingerprints
+func fingerprint(name, key string, value interface{}) string {
+	h := hmac.New(sha256.New, fingerprintKey)
+	fmt.Fprintf(h, "%s/%s:", name, key)
+	if s, ok := value.(string); ok {
+		h.Write([]byte(s))
+	} else {
+		d, _ := json.Marshal(value)
+		h.Write(d)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:fingerprintLength]
+}
+
+func diffSecretData(name string, old, new SecretData) *SecretDiff {
+	d := &SecretDiff{Fingerprints: make(map[string]string)}
+	for k, v := range new {
+		oldValue, found := old[k]
+		switch {
+		case !found:
+			d.Added = append(d.Added, k)
+			d.Fingerprints[k] = fingerprint(name, k, v)
+		case !reflect.DeepEqual(oldValue, v):
+			d.Modified = append(d.Modified, k)
+			d.Fingerprints[k] = fingerprint(name, k, v)
+		default:
+			d.Unchanged = append(d.Unchanged, k)
+		}
+	}
+	for k := range old {
+		if _, found := new[k]; !found {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Modified)
+	sort.Strings(d.Unchanged)
+	return d
+}
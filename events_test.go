@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffSecretData(t *testing.T) {
+	old := SecretData{"user": "foo", "password": "secret1", "host": "db"}
+	new := SecretData{"user": "foo", "password": "secret2", "port": 5432}
+
+	d := diffSecretData("db", old, new)
+	assert.True(t, d.Changed())
+	assert.Equal(t, []string{"port"}, d.Added)
+	assert.Equal(t, []string{"host"}, d.Removed)
+	assert.Equal(t, []string{"password"}, d.Modified)
+	assert.Equal(t, []string{"user"}, d.Unchanged)
+	assert.Equal(t, "added: port; removed: host; modified: password", d.String())
+
+	// Values must never be exposed
+	serialized, _ := json.Marshal(d)
+	for _, v := range []string{"secret1", "secret2", "5432"} {
+		assert.False(t, strings.Contains(string(serialized), v))
+	}
+	assert.Len(t, d.Fingerprints["password"], fingerprintLength)
+	assert.NotEqual(t, fingerprint("db", "password", "secret1"), d.Fingerprints["password"])
+	assert.Equal(t, fingerprint("db", "password", "secret2"), d.Fingerprints["password"])
+
+	// Fingerprints are keyed, values cannot be guessed by hashing them
+	unkeyed := sha256.Sum256([]byte("db/password:secret2"))
+	assert.NotEqual(t, hex.EncodeToString(unkeyed[:])[:fingerprintLength], d.Fingerprints["password"])
+
+	d = diffSecretData("db", new, new)
+	assert.False(t, d.Changed())
+	assert.Equal(t, "no changes", d.String())
+}
+
+func TestEventLog(t *testing.T) {
+	l := NewEventLog(2)
+	l.Add(Event{Message: "1"})
+	l.Add(Event{Message: "2"})
+	l.Add(Event{Message: "3"})
+
+	events := l.Recent()
+	assert.Len(t, events, 2)
+	assert.Equal(t, "2", events[0].Message)
+	assert.Equal(t, "3", events[1].Message)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Time to wait for the command to stop before killing it, by default
+const DefaultExecKillTimeout = 10 * time.Second
+
+// ExecConfig configures a command run by pouch with secrets in its
+// environment, so they don't need to be written to disk
+type ExecConfig struct {
+	// Command and its arguments
+	Command []string `json:"command,omitempty"`
+
+	// Secrets exposed in the environment, all of them if not set
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Environment variables mapped to keys of the secrets, if not set
+	// all keys are used, with their names in upper case
+	Keys map[string]string `json:"keys,omitempty"`
+
+	// Signal sent to the command when secrets change instead of
+	// restarting it, for commands that obtain secrets by other means
+	Signal string `json:"signal,omitempty"`
+
+	// Time to wait for the command to stop before killing it
+	KillTimeout string `json:"kill_timeout,omitempty"`
+}
+
+// ExecExitError is returned by Run when the command in exec mode exits,
+// with its exit code, so pouch can exit with it
+type ExecExitError struct {
+	Code int
+}
+
+func (e *ExecExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// execProcess is the command run in exec mode
+type execProcess struct {
+	config ExecConfig
+	cmd    *exec.Cmd
+
+	// Environment variables the command was started with
+	variables map[string]string
+
+	exited chan *ExecExitError
+}
+
+// Exec configures pouch to run a command with secrets in its environment,
+// the command is restarted when they change, and pouch exits when it does
+func (p *pouch) Exec(c ExecConfig) {
+	p.exec = &execProcess{config: c}
+}
+
+func (p *pouch) execVariables() (map[string]string, error) {
+	ctx := &RenderContext{
+		Secret: func(name string) (SecretData, error) {
+			secret, found := p.State.Secrets[name]
+			if !found {
+				return nil, fmt.Errorf("unknown secret: %s", name)
+			}
+			return secret.Data, nil
+		},
+		Secrets: p.exec.config.Secrets,
+	}
+	if len(ctx.Secrets) == 0 {
+		for name := range p.State.Secrets {
+			ctx.Secrets = append(ctx.Secrets, name)
+		}
+		sort.Strings(ctx.Secrets)
+	}
+	return environmentVariables(p.exec.config.Keys, ctx)
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// startExec starts the command with the current secrets
+func (p *pouch) startExec() error {
+	c := p.exec.config
+	if len(c.Command) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+	variables, err := p.execVariables()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Env = os.Environ()
+	for variable, value := range variables {
+		cmd.Env = append(cmd.Env, variable+"="+value)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("couldn't start '%s': %v", c.Command[0], err)
+	}
+	infof("Started '%s' with %d variables from secrets", c.Command[0], len(variables))
+
+	exited := make(chan *ExecExitError, 1)
+	go func() {
+		exited <- &ExecExitError{Code: exitCode(cmd.Wait())}
+	}()
+	p.exec.cmd = cmd
+	p.exec.variables = variables
+	p.exec.exited = exited
+	return nil
+}
+
+// stopExec stops the command, killing it if it doesn't stop in time
+func (p *pouch) stopExec() *ExecExitError {
+	timeout := DefaultExecKillTimeout
+	if t := p.exec.config.KillTimeout; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			warnf("Incorrect kill timeout '%s', using %s", t, timeout)
+		} else {
+			timeout = d
+		}
+	}
+	p.exec.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case exited := <-p.exec.exited:
+		return exited
+	case <-time.After(timeout):
+		warnf("Command didn't stop after %s, killing it", timeout)
+		p.exec.cmd.Process.Kill()
+		return <-p.exec.exited
+	}
+}
+
+// updateExec restarts or signals the command if its variables changed
+func (p *pouch) updateExec() error {
+	if p.exec == nil {
+		return nil
+	}
+	variables, err := p.execVariables()
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(variables, p.exec.variables) {
+		return nil
+	}
+	if p.exec.config.Signal != "" {
+		signal, err := parseSignal(p.exec.config.Signal)
+		if err != nil {
+			return err
+		}
+		infof("Secrets changed, sending %s to command", signal)
+		p.exec.variables = variables
+		return p.exec.cmd.Process.Signal(signal)
+	}
+	infof("Secrets changed, restarting command")
+	p.stopExec()
+	return p.startExec()
+}
+
+// execExited returns a channel that receives the exit of the command, or
+// nil if there is no command
+func (p *pouch) execExited() <-chan *ExecExitError {
+	if p.exec == nil {
+		return nil
+	}
+	return p.exec.exited
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExec(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/app": &api.Secret{
+				Data: map[string]interface{}{"password": "secret"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"app": {VaultURL: "/v1/secret/app", HTTPMethod: "GET"},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, nil, nil)
+
+	out := path.Join(tmpdir, "out")
+	p.Exec(ExecConfig{
+		Command: []string{"sh", "-c", `echo -n "$PASSWORD $APP_USER" > ` + out + `; exit 3`},
+		Keys:    map[string]string{"PASSWORD": "password"},
+	})
+	err = p.Run(context.Background())
+	assert.Equal(t, &ExecExitError{Code: 3}, err)
+
+	d, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret ", string(d))
+}
+
+func TestExecStop(t *testing.T) {
+	state := NewState("")
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	p.Exec(ExecConfig{Command: []string{"sleep", "60"}})
+	assert.NoError(t, p.startExec())
+	assert.Equal(t, &ExecExitError{Code: 128 + 15}, p.stopExec())
+
+	// Ignored signals are inherited by the executed command
+	p.Exec(ExecConfig{Command: []string{"sh", "-c", `trap "" TERM; exec sleep 60`}, KillTimeout: "100ms"})
+	assert.NoError(t, p.startExec())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, &ExecExitError{Code: 128 + 9}, p.stopExec())
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+// ExpectationConfig describes conditions that must be true after each
+// cycle, so the result of rotations is continuously verified
+type ExpectationConfig struct {
+	// File that must exist
+	File string `json:"file,omitempty"`
+
+	// Expected mode of the file
+	Mode FileMode `json:"mode,omitempty"`
+
+	// Type of a PEM block the file must contain, e.g. CERTIFICATE
+	PEM string `json:"pem,omitempty"`
+
+	// Text the file must contain
+	Contains string `json:"contains,omitempty"`
+
+	// Service that must be active
+	Service string `json:"service,omitempty"`
+}
+
+// ServiceChecker is implemented by reloaders that can check if services
+// are running
+type ServiceChecker interface {
+	IsActive(name string) (bool, error)
+}
+
+func containsPEM(d []byte, blockType string) bool {
+	for {
+		var block *pem.Block
+		block, d = pem.Decode(d)
+		if block == nil {
+			return false
+		}
+		if block.Type == blockType {
+			return true
+		}
+	}
+}
+
+// check returns an error describing why the expectation is not met
+func (c ExpectationConfig) check(services ServiceChecker) error {
+	if c.File != "" {
+		info, err := os.Stat(c.File)
+		if err != nil {
+			return err
+		}
+		if c.Mode != 0 && info.Mode().Perm() != os.FileMode(c.Mode).Perm() {
+			return fmt.Errorf("%s has mode %#o, expected %#o", c.File, info.Mode().Perm(), c.Mode)
+		}
+		if c.PEM != "" || c.Contains != "" {
+			d, err := ioutil.ReadFile(c.File)
+			if err != nil {
+				return err
+			}
+			if c.PEM != "" && !containsPEM(d, c.PEM) {
+				return fmt.Errorf("%s doesn't contain a %s PEM block", c.File, c.PEM)
+			}
+			if c.Contains != "" && !bytes.Contains(d, []byte(c.Contains)) {
+				return fmt.Errorf("%s doesn't contain the expected text", c.File)
+			}
+		}
+	}
+	if c.Service != "" {
+		if services == nil {
+			return fmt.Errorf("cannot check if service %s is active", c.Service)
+		}
+		active, err := services.IsActive(c.Service)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return fmt.Errorf("service %s is not active", c.Service)
+		}
+	}
+	return nil
+}
+
+func (p *pouch) AddExpectation(name string, c ExpectationConfig) {
+	if p.expectations == nil {
+		p.expectations = make(map[string]ExpectationConfig)
+	}
+	p.expectations[name] = c
+}
+
+// checkExpectations evaluates all the expectations, reporting the ones
+// whose result changed, it returns true if any changed
+func (p *pouch) checkExpectations() bool {
+	if len(p.expectations) == 0 {
+		return false
+	}
+	services, _ := p.Reloader.(ServiceChecker)
+	if p.expectationFailures == nil {
+		p.expectationFailures = make(map[string]string)
+	}
+	changed := false
+	for name, c := range p.expectations {
+		err := c.check(services)
+		previous, failed := p.expectationFailures[name]
+		labels := metrics.Labels{"expectation": name}
+		if err != nil {
+			p.Metrics.Set(MetricExpectationSuccess, labels, 0)
+			if failed && previous == err.Error() {
+				continue
+			}
+			p.expectationFailures[name] = err.Error()
+			p.event(Event{
+				Type:    EventExpectation,
+				Message: fmt.Sprintf("Expectation '%s' failed: %v", name, err),
+				Details: map[string]interface{}{"expectation": name, "success": false},
+			})
+			changed = true
+			continue
+		}
+		p.Metrics.Set(MetricExpectationSuccess, labels, 1)
+		if failed {
+			delete(p.expectationFailures, name)
+			p.event(Event{
+				Type:    EventExpectation,
+				Message: fmt.Sprintf("Expectation '%s' met again", name),
+				Details: map[string]interface{}{"expectation": name, "success": true},
+			})
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *pouch) failedExpectations() []string {
+	var failed []string
+	for name := range p.expectationFailures {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+	return failed
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeServices map[string]bool
+
+func (s fakeServices) Reload(context.Context, string) error  { return nil }
+func (s fakeServices) Restart(context.Context, string) error { return nil }
+func (s fakeServices) DaemonReload() error                   { return nil }
+func (s fakeServices) IsActive(name string) (bool, error)    { return s[name], nil }
+
+const testCertificatePEM = `-----BEGIN CERTIFICATE-----
+MIIBfzCCASWgAwIBAgIUZ0==
+-----END CERTIFICATE-----
+`
+
+func TestCheckExpectations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	certPath := filepath.Join(tmpdir, "cert.pem")
+
+	services := fakeServices{"nginx": true}
+	p := &pouch{State: NewState(""), Metrics: newMetricsRegistry(), Events: NewEventLog(0), Reloader: services}
+	p.AddExpectation("cert", ExpectationConfig{File: certPath, Mode: 0640, PEM: "CERTIFICATE"})
+	p.AddExpectation("nginx", ExpectationConfig{Service: "nginx"})
+
+	assert.True(t, p.checkExpectations())
+	assert.Equal(t, []string{"cert"}, p.failedExpectations())
+	status, message := p.checkStatus(time.Now())
+	assert.Equal(t, StatusDegraded, status)
+	assert.Equal(t, "failed expectations: cert", message)
+
+	// Wrong mode
+	err = ioutil.WriteFile(certPath, []byte(testCertificatePEM), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, p.checkExpectations())
+	assert.Contains(t, p.expectationFailures["cert"], "mode")
+
+	// No certificate
+	assert.NoError(t, os.Chmod(certPath, 0640))
+	assert.NoError(t, ioutil.WriteFile(certPath, []byte("foo"), 0640))
+	assert.True(t, p.checkExpectations())
+	assert.Contains(t, p.expectationFailures["cert"], "PEM")
+
+	assert.NoError(t, ioutil.WriteFile(certPath, []byte(testCertificatePEM), 0640))
+	assert.True(t, p.checkExpectations())
+	assert.Empty(t, p.failedExpectations())
+	assert.False(t, p.checkExpectations())
+
+	services["nginx"] = false
+	assert.True(t, p.checkExpectations())
+	assert.Equal(t, []string{"nginx"}, p.failedExpectations())
+
+	// Services cannot be checked without a reloader supporting it
+	p.Reloader = nil
+	p.expectationFailures = nil
+	assert.True(t, p.checkExpectations())
+	assert.Equal(t, []string{"nginx"}, p.failedExpectations())
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Interval to check if there is a reader waiting in a named pipe
+	fifoPollInterval = 100 * time.Millisecond
+
+	inotifyBufferSize = 4096
+)
+
+// fifoWriter writes the current content of a file in a named pipe each
+// time a reader opens it
+type fifoWriter struct {
+	sync.Mutex
+
+	path    string
+	content string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (f *fifoWriter) get() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.content
+}
+
+func (f *fifoWriter) set(content string) {
+	f.Lock()
+	defer f.Unlock()
+	f.content = content
+}
+
+// stop stops serving the named pipe and waits till it is done
+func (f *fifoWriter) stop() {
+	f.cancel()
+	<-f.done
+}
+
+// serve writes the content for each reader till the context is done.
+// Opening the pipe for writing doesn't block, it fails while there is no
+// reader, so it can be stopped. After writing, it waits till the reader
+// closes the pipe, otherwise the reader would receive the content again.
+func (f *fifoWriter) serve(ctx context.Context) {
+	defer close(f.done)
+
+	closes, err := watchFIFOCloses(ctx, f.path)
+	if err != nil {
+		errorf("Couldn't watch named pipe '%s', not serving it: %v", f.path, err)
+		return
+	}
+	defer closes.Close()
+
+	for {
+		w, err := os.OpenFile(f.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+			// No reader yet
+			select {
+			case <-time.After(fifoPollInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err != nil {
+			errorf("Couldn't open named pipe '%s', not serving it anymore: %v", f.path, err)
+			return
+		}
+
+		closes.drain()
+		_, err = w.Write([]byte(f.get()))
+		if err != nil {
+			errorf("Couldn't write in named pipe '%s': %v", f.path, err)
+		}
+		w.Close()
+
+		if closes.wait() != nil {
+			// Context done
+			return
+		}
+	}
+}
+
+// fifoCloses receives inotify events when readers close a named pipe
+type fifoCloses struct {
+	*os.File
+}
+
+// watchFIFOCloses starts watching the closes of a named pipe, the watch
+// is closed when the context is done
+func watchFIFOCloses(ctx context.Context, path string) (*fifoCloses, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	// Removals are also received so the pipe is opened again and the
+	// error is reported
+	_, err = syscall.InotifyAddWatch(fd, path, syscall.IN_CLOSE_NOWRITE|syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	return &fifoCloses{f}, nil
+}
+
+// drain discards the closes of previous readers
+func (c *fifoCloses) drain() {
+	buf := make([]byte, inotifyBufferSize)
+	c.SetReadDeadline(time.Now())
+	for {
+		if _, err := c.Read(buf); err != nil {
+			break
+		}
+	}
+	c.SetReadDeadline(time.Time{})
+}
+
+// wait waits till a reader closes the pipe, it fails when the watch is
+// closed
+func (c *fifoCloses) wait() error {
+	_, err := c.Read(make([]byte, inotifyBufferSize))
+	return err
+}
+
+// makeFIFO creates a named pipe, or reuses it if it already exists
+func makeFIFO(path string, mode, parentMode os.FileMode) error {
+	err := parentDir(path, parentMode)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		err = syscall.Mkfifo(path, uint32(mode.Perm()))
+		if err != nil {
+			return fmt.Errorf("couldn't create named pipe '%s': %v", path, err)
+		}
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeNamedPipe == 0:
+		return fmt.Errorf("'%s' exists and it is not a named pipe", path)
+	}
+	// Mode passed to mkfifo is affected by umask
+	return os.Chmod(path, mode)
+}
+
+// serveFIFO serves the content of a file in a named pipe, it is created
+// and served the first time, and its content is replaced later
+func (p *pouch) serveFIFO(fc FileConfig, mode os.FileMode, content string) error {
+	if f, found := p.fifos[fc.Path]; found {
+		f.set(content)
+		return os.Chmod(fc.Path, mode)
+	}
+	err := makeFIFO(fc.Path, mode, fc.parentDirMode(mode))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fifoWriter{path: fc.Path, content: content, cancel: cancel, done: make(chan struct{})}
+	if p.fifos == nil {
+		p.fifos = make(map[string]*fifoWriter)
+	}
+	p.fifos[fc.Path] = f
+	go f.serve(ctx)
+	return nil
+}
+
+// stopRemovedFIFOs stops serving the named pipes of files that are not
+// configured anymore as named pipes
+func (p *pouch) stopRemovedFIFOs() {
+	for path, f := range p.fifos {
+		if fc, found := p.Files[path]; found && fc.FIFO {
+			continue
+		}
+		f.stop()
+		delete(p.fifos, path)
+	}
+}
+
+// stopFIFOs stops serving all the named pipes, they are served again if
+// their files are resolved again
+func (p *pouch) stopFIFOs() {
+	for path, f := range p.fifos {
+		f.stop()
+		delete(p.fifos, path)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFIFO(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("app", &api.Secret{Data: map[string]interface{}{"password": "secret"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:     filepath.Join(tmpdir, "app", "password"),
+		FIFO:     true,
+		Template: `{{ secret "app" "password" }}`,
+	}
+	assert.NoError(t, p.resolveFile(fc))
+
+	info, err := os.Lstat(fc.Path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.ModeNamedPipe|DefaultFileMode, info.Mode())
+	}
+
+	// Content is written for each reader
+	for i := 0; i < 2; i++ {
+		d, err := ioutil.ReadFile(fc.Path)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", string(d))
+	}
+
+	state.SetSecret("app", &api.Secret{Data: map[string]interface{}{"password": "other"}})
+	assert.NoError(t, p.resolveFile(fc))
+	d, err := ioutil.ReadFile(fc.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(d))
+
+	// Slow readers receive the content only once
+	r, err := os.Open(fc.Path)
+	if assert.NoError(t, err) {
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(r, buf)
+		assert.NoError(t, err)
+		time.Sleep(3 * fifoPollInterval)
+		rest, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "other", string(buf)+string(rest))
+		r.Close()
+	}
+
+	// Stopped pipes are not served anymore
+	writer := p.fifos[fc.Path]
+	p.stopFIFOs()
+	assert.Empty(t, p.fifos)
+	select {
+	case <-writer.done:
+	default:
+		t.Fatal("named pipe still served after stopping it")
+	}
+	r, err = os.OpenFile(fc.Path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if assert.NoError(t, err) {
+		time.Sleep(3 * fifoPollInterval)
+		d, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Empty(t, d)
+		r.Close()
+	}
+
+	// Regular files are not replaced
+	fc.Path = filepath.Join(tmpdir, "regular")
+	assert.NoError(t, ioutil.WriteFile(fc.Path, nil, 0600))
+	assert.Error(t, p.resolveFile(fc))
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-ini/ini"
+)
+
+// Formats rendered files can be required to have
+const (
+	JSONFormat = "json"
+	YAMLFormat = "yaml"
+	INIFormat  = "ini"
+)
+
+// parseFormat checks that content is valid in a format, and returns the
+// parsed value for formats that can be validated with schemas
+func parseFormat(format, content string) (interface{}, error) {
+	var value interface{}
+	switch format {
+	case JSONFormat:
+		err := json.Unmarshal([]byte(content), &value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+	case YAMLFormat:
+		err := yaml.Unmarshal([]byte(content), &value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %v", err)
+		}
+	case INIFormat:
+		_, err := ini.Load([]byte(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid INI: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format '%s', available: %s", format, formatNames())
+	}
+	return value, nil
+}
+
+// validateContent checks that the rendered content of a file has its
+// format, and matches its schema
+func validateContent(fc FileConfig, content string) error {
+	format := fc.Format
+	if format == "" && fc.Schema != "" {
+		format = JSONFormat
+	}
+	if format == "" {
+		return nil
+	}
+	value, err := parseFormat(format, content)
+	if err != nil {
+		return err
+	}
+	if fc.Schema == "" {
+		return nil
+	}
+	if format == INIFormat {
+		return fmt.Errorf("schemas cannot be used with %s files", format)
+	}
+	d, err := ioutil.ReadFile(fc.Schema)
+	if err != nil {
+		return fmt.Errorf("couldn't read schema: %v", err)
+	}
+	var schema map[string]interface{}
+	err = yaml.Unmarshal(d, &schema)
+	if err != nil {
+		return fmt.Errorf("incorrect schema in %s: %v", fc.Schema, err)
+	}
+	return validateSchema(schema, value, "$")
+}
+
+// schemaType returns the JSON Schema type of a value parsed from JSON
+func schemaType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// matchesType checks a value against the type keyword of a schema, that
+// can be a type or a list of types
+func matchesType(schemaTypes interface{}, value interface{}) bool {
+	var types []interface{}
+	switch t := schemaTypes.(type) {
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	actual := schemaType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSchema validates a value with the most common keywords of JSON
+// Schema: type, enum, const, required, properties, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum and
+// maximum. Errors refer to the failing value with its path from $.
+func validateSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if t, found := schema["type"]; found && !matchesType(t, value) {
+		return fmt.Errorf("%s: expected %v, found %s", path, t, schemaType(value))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: value not in %v", path, enum)
+		}
+	}
+	if c, found := schema["const"]; found && !reflect.DeepEqual(c, value) {
+		return fmt.Errorf("%s: expected %v", path, c)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, found := v[fmt.Sprint(r)]; !found {
+					return fmt.Errorf("%s: missing required property '%v'", path, r)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertyPath := path + "." + key
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				err := validateSchema(property, v[key], propertyPath)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: property not allowed", propertyPath)
+				}
+			case map[string]interface{}:
+				err := validateSchema(additional, v[key], propertyPath)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items", path, min)
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items", path, max)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if min, ok := schema["minLength"].(float64); ok && length < min {
+			return fmt.Errorf("%s: expected at least %v characters", path, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && length > max {
+			return fmt.Errorf("%s: expected at most %v characters", path, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%s: incorrect pattern in schema: %v", path, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: doesn't match %s", path, pattern)
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: expected at least %v", path, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s: expected at most %v", path, max)
+		}
+	}
+	return nil
+}
+
+// formatNames returns the formats that can be required, for errors
+func formatNames() string {
+	return strings.Join([]string{JSONFormat, YAMLFormat, INIFormat}, ", ")
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateContent(t *testing.T) {
+	cases := []struct {
+		format  string
+		content string
+		valid   bool
+	}{
+		{JSONFormat, `{"a": 1}`, true},
+		{JSONFormat, `{"a": 1`, false},
+		{YAMLFormat, "a:\n  b: 1\n", true},
+		{YAMLFormat, "a: [1\n", false},
+		{INIFormat, "[section]\nkey = value\n", true},
+		{INIFormat, "[section\nkey = value\n", false},
+		{"xml", "<a/>", false},
+	}
+	for _, c := range cases {
+		err := validateContent(FileConfig{Format: c.format}, c.content)
+		assert.Equal(t, c.valid, err == nil, c.content)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	schema := path.Join(tmpdir, "schema.yml")
+	ioutil.WriteFile(schema, []byte(`
+type: object
+required: [user, password]
+additionalProperties: false
+properties:
+  user:
+    type: string
+    pattern: "^[a-z]+$"
+  password:
+    type: string
+    minLength: 8
+  port:
+    type: integer
+    minimum: 1
+    maximum: 65535
+  hosts:
+    type: array
+    minItems: 1
+    items:
+      type: string
+      enum: [a, b]
+`), 0600)
+
+	cases := []struct {
+		content string
+		valid   bool
+	}{
+		{`{"user": "app", "password": "12345678", "port": 5432, "hosts": ["a", "b"]}`, true},
+		{`{"user": "app"}`, false},
+		{`{"user": "App", "password": "12345678"}`, false},
+		{`{"user": "app", "password": "1234"}`, false},
+		{`{"user": "app", "password": "12345678", "port": 5432.5}`, false},
+		{`{"user": "app", "password": "12345678", "port": 70000}`, false},
+		{`{"user": "app", "password": "12345678", "hosts": []}`, false},
+		{`{"user": "app", "password": "12345678", "hosts": ["c"]}`, false},
+		{`{"user": "app", "password": "12345678", "other": true}`, false},
+		{`["app"]`, false},
+	}
+	for _, c := range cases {
+		err := validateContent(FileConfig{Schema: schema}, c.content)
+		assert.Equal(t, c.valid, err == nil, c.content)
+	}
+
+	state := NewState("")
+	state.SetSecret("db", &api.Secret{Data: map[string]interface{}{"user": "app", "password": "1234"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "db.yml"),
+		Format:   YAMLFormat,
+		Schema:   schema,
+		Template: "user: {{ secret \"db\" \"user\" }}\npassword: \"{{ secret \"db\" \"password\" }}\"\n",
+	}
+	assert.Error(t, p.resolveFile(fc))
+	_, err = os.Stat(fc.Path)
+	assert.True(t, os.IsNotExist(err))
+
+	state.SetSecret("db", &api.Secret{Data: map[string]interface{}{"user": "app", "password": "12345678"}})
+	assert.NoError(t, p.resolveFile(fc))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package pouch
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -34,17 +35,33 @@ func (p *pouch) handleWrapped(path string) error {
 	if err != nil {
 		return err
 	}
-	if len(d) == 0 {
+	return p.unwrapSecretID(string(d))
+}
+
+func (p *pouch) unwrapSecretID(wrapped string) error {
+	wrapped = strings.TrimSpace(wrapped)
+	if len(wrapped) == 0 {
 		return isEmpty
 	}
-	err = p.Vault.UnwrapSecretID(strings.TrimSpace(string(d)))
+
+	// Secret IDs can be received in several channels at the same time,
+	// only the first one unwrapped is used
+	p.unwrapLock.Lock()
+	defer p.unwrapLock.Unlock()
+	if p.secretIDUnwrapped {
+		return fmt.Errorf("secret ID already obtained")
+	}
+	err := p.Vault.UnwrapSecretID(wrapped)
 	if err != nil {
 		return err
 	}
+	p.secretIDUnwrapped = true
 	return nil
 }
 
-func (p *pouch) Watch(path string) error {
+// Watch waits for a wrapped secret ID written in path, it returns once one
+// has been unwrapped or the context is done
+func (p *pouch) Watch(ctx context.Context, path string) error {
 	// If the file is here, we are done, try before watching
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		err = p.handleWrapped(path)
@@ -77,6 +94,8 @@ func (p *pouch) Watch(path string) error {
 			}
 		case err := <-watcher.Errors:
 			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 
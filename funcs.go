@@ -0,0 +1,202 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+)
+
+// sprigFuncMap contains general purpose functions available in file and
+// data templates, with the names and arguments of their equivalents in
+// the sprig library, or in Helm for YAML, so templates written for other
+// tools work as is
+var sprigFuncMap = template.FuncMap{
+	// Defaults and conditions
+	"default":  defaultValue,
+	"empty":    empty,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+
+	// Strings
+	"trim":       strings.TrimSpace,
+	"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"quote":      func(v ...interface{}) string { return quoteAll(`"%s"`, v) },
+	"squote":     func(v ...interface{}) string { return quoteAll(`'%s'`, v) },
+	"indent":     indent,
+	"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+	"toString":   toString,
+
+	// Encodings and checksums
+	"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":    b64dec,
+	"sha1sum":   func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+	"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+
+	// Data structures
+	"toJson":       toJSON,
+	"toPrettyJson": toPrettyJSON,
+	"fromJson":     fromJSON,
+	"toYaml":       toYAML,
+	"fromYaml":     fromYAML,
+	"list":         func(v ...interface{}) []interface{} { return v },
+	"dict":         dict,
+}
+
+// empty returns true if the value is the zero value of its type, or an
+// empty collection
+func empty(v interface{}) bool {
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return true
+	}
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// defaultValue returns the given value, or d if it is not set or empty,
+// to be used in pipelines as `.Value | default "foo"`
+func defaultValue(d interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return d
+	}
+	return given[0]
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, value := range v {
+		if !empty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func ternary(ifTrue, ifFalse interface{}, condition bool) interface{} {
+	if condition {
+		return ifTrue
+	}
+	return ifFalse
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func join(sep string, v interface{}) string {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return toString(v)
+	}
+	var parts []string
+	for i := 0; i < value.Len(); i++ {
+		parts = append(parts, toString(value.Index(i).Interface()))
+	}
+	return strings.Join(parts, sep)
+}
+
+func quoteAll(format string, v []interface{}) string {
+	var quoted []string
+	for _, value := range v {
+		if value != nil {
+			quoted = append(quoted, fmt.Sprintf(format, toString(value)))
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func b64dec(s string) (string, error) {
+	d, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	d, err := json.Marshal(v)
+	return string(d), err
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	d, err := json.MarshalIndent(v, "", "  ")
+	return string(d), err
+}
+
+func fromJSON(s string) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+func toYAML(v interface{}) (string, error) {
+	d, err := yaml.Marshal(v)
+	return strings.TrimSuffix(string(d), "\n"), err
+}
+
+func fromYAML(s string) (interface{}, error) {
+	var v interface{}
+	err := yaml.Unmarshal([]byte(s), &v)
+	return v, err
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict needs pairs of keys and values")
+	}
+	d := make(map[string]interface{})
+	for i := 0; i < len(pairs); i += 2 {
+		d[toString(pairs[i])] = pairs[i+1]
+	}
+	return d, nil
+}
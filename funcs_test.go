@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSprigFunctions(t *testing.T) {
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{`{{ .Missing | default "foo" }}`, "foo"},
+		{`{{ .Name | default "foo" }}`, "bar"},
+		{`{{ "" | default 0 }}`, "0"},
+		{`{{ coalesce "" .Missing .Name }}`, "bar"},
+		{`{{ ternary "yes" "no" (empty .List) }}`, "no"},
+		{`{{ "  foo " | trim | upper }}`, "FOO"},
+		{`{{ "foo.example.com" | trimSuffix ".example.com" }}`, "foo"},
+		{`{{ "a,b,c" | replace "," ";" }}`, "a;b;c"},
+		{`{{ .List | join "," }}`, "a,b"},
+		{`{{ "a b" | splitList " " | join "-" }}`, "a-b"},
+		{`{{ quote .Name 1 }}`, `"bar" "1"`},
+		{`{{ "a\nb" | indent 2 }}`, "  a\n  b"},
+		{`{{ "foo" | b64enc }}`, "Zm9v"},
+		{`{{ "Zm9v" | b64dec }}`, "foo"},
+		{`{{ "foo" | sha256sum }}`, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+		{`{{ dict "name" .Name "list" .List | toJson }}`, `{"list":["a","b"],"name":"bar"}`},
+		{`{{ (fromJson "{\"a\": \"b\"}").a }}`, "b"},
+		{`{{ list 1 "a" | toJson }}`, `[1,"a"]`},
+		{`{{ dict "name" .Name | toPrettyJson }}`, "{\n  \"name\": \"bar\"\n}"},
+		{`{{ dict "name" "a: b" "list" .List | toYaml }}`, "list:\n- a\n- b\nname: 'a: b'"},
+		{`{{ (fromYaml "a:\n  b: c").a.b }}`, "c"},
+	}
+
+	data := map[string]interface{}{
+		"Name": "bar",
+		"List": []string{"a", "b"},
+	}
+	e := &goTemplateEngine{}
+	for _, c := range cases {
+		content, err := e.Render("test", c.template, &RenderContext{Funcs: sprigFuncMap, Data: data})
+		if assert.NoError(t, err, c.template) {
+			assert.Equal(t, c.expected, content, c.template)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/fuse"
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+// Permissions of the files in the FUSE mount if not configured
+const DefaultFUSEMode = 0400
+
+// FUSEConfig defines an experimental FUSE mount where each key of the
+// secrets appears as a read-only file, generated when it is opened
+type FUSEConfig struct {
+	// Directory where the filesystem is mounted
+	MountPoint string `json:"mount_point,omitempty"`
+
+	// Names of the secrets exposed, patterns are accepted, all the
+	// secrets are exposed if not set
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Owner, group and permissions of the files, directories can be
+	// traversed by the ones that can read their files
+	Owner string   `json:"owner,omitempty"`
+	Group string   `json:"group,omitempty"`
+	Mode  FileMode `json:"mode,omitempty"`
+
+	// Allow access to other users than the one running pouch
+	AllowOther bool `json:"allow_other,omitempty"`
+}
+
+func (p *pouch) FUSE(c FUSEConfig) error {
+	if c.MountPoint == "" {
+		return fmt.Errorf("mount point of FUSE filesystem not set")
+	}
+	for _, pattern := range c.Secrets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("incorrect pattern '%s' in FUSE secrets: %v", pattern, err)
+		}
+	}
+	if c.Mode == 0 {
+		c.Mode = DefaultFUSEMode
+	}
+	uid, err := lookupID(c.Owner, lookupUID)
+	if err != nil {
+		return fmt.Errorf("unknown owner for FUSE filesystem: %v", err)
+	}
+	gid, err := lookupID(c.Group, lookupGID)
+	if err != nil {
+		return fmt.Errorf("unknown group for FUSE filesystem: %v", err)
+	}
+	if uid < 0 {
+		uid = os.Getuid()
+	}
+	if gid < 0 {
+		gid = os.Getgid()
+	}
+	p.fuseConfig = &c
+	p.fuseUID, p.fuseGID = uint32(uid), uint32(gid)
+	return nil
+}
+
+// startFUSE mounts the filesystem and serves it till the context is done
+func (p *pouch) startFUSE(ctx context.Context) error {
+	if p.fuseConfig == nil {
+		return nil
+	}
+	dir := p.fuseConfig.MountPoint
+	dev, err := fuse.Mount(dir, fuse.MountOptions{Name: "pouch", AllowOther: p.fuseConfig.AllowOther})
+	if err != nil {
+		return fmt.Errorf("couldn't mount FUSE filesystem: %v", err)
+	}
+	infof("Secrets mounted in %s", dir)
+	server := fuse.NewServer(&pouchFS{pouch: p, ctx: ctx}, dev)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := server.Serve()
+		if err != nil {
+			errorf("FUSE filesystem failed: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		err := fuse.Unmount(dir)
+		if err != nil {
+			errorf("Couldn't unmount %s: %v", dir, err)
+		}
+		<-done
+		dev.Close()
+	}()
+	return nil
+}
+
+// pouchFS exposes the secrets in the state as directories with a file
+// for each key
+type pouchFS struct {
+	pouch *pouch
+	ctx   context.Context
+}
+
+// run runs an operation in the main loop, so the state can be read
+func (fs *pouchFS) run(f func() error) error {
+	var err error
+	resp := fs.pouch.inMainLoop(fs.ctx, func() (interface{}, error) {
+		err = f()
+		return nil, nil
+	})
+	if resp.Error != "" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return err
+}
+
+// exposed returns the names of the secrets exposed in the filesystem
+func (fs *pouchFS) exposed() []string {
+	var names []string
+	for name := range fs.pouch.State.Secrets {
+		if len(fs.pouch.fuseConfig.Secrets) == 0 {
+			names = append(names, name)
+			continue
+		}
+		for _, pattern := range fs.pouch.fuseConfig.Secrets {
+			if matched, _ := path.Match(pattern, name); matched {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fs *pouchFS) node(name string, dir bool) fuse.Node {
+	mode := os.FileMode(fs.pouch.fuseConfig.Mode).Perm()
+	if dir {
+		// Directories can be traversed by whoever can read the files
+		mode |= (mode & 0444) >> 2
+	}
+	return fuse.Node{
+		Name: name,
+		Dir:  dir,
+		Mode: mode,
+		UID:  fs.pouch.fuseUID,
+		GID:  fs.pouch.fuseGID,
+	}
+}
+
+// stat returns the node of a path, and the secret and key of files
+func (fs *pouchFS) stat(p string) (node fuse.Node, secret, key string, err error) {
+	node = fs.node(path.Base(p), true)
+	if p == "" {
+		return node, "", "", nil
+	}
+	exposed := fs.exposed()
+	for _, name := range exposed {
+		if name == p {
+			node.ModTime = fs.pouch.State.Secrets[name].Timestamp
+			return node, "", "", nil
+		}
+		if strings.HasPrefix(name, p+"/") {
+			return node, "", "", nil
+		}
+	}
+	dir, key := path.Split(p)
+	secret = strings.TrimSuffix(dir, "/")
+	if stringInSlice(secret, exposed) {
+		s := fs.pouch.State.Secrets[secret]
+		if value, found := s.Data[key]; found {
+			node = fs.node(key, false)
+			node.Size = uint64(len(fmt.Sprint(value)))
+			node.ModTime = s.Timestamp
+			return node, secret, key, nil
+		}
+	}
+	return fuse.Node{}, "", "", os.ErrNotExist
+}
+
+func (fs *pouchFS) Stat(p string) (node fuse.Node, err error) {
+	err = fs.run(func() error {
+		node, _, _, err = fs.stat(p)
+		return err
+	})
+	return
+}
+
+func (fs *pouchFS) List(p string) (nodes []fuse.Node, err error) {
+	err = fs.run(func() error {
+		children := make(map[string]fuse.Node)
+		for _, name := range fs.exposed() {
+			if name == p {
+				for key := range fs.pouch.State.Secrets[name].Data {
+					children[key] = fs.node(key, false)
+				}
+				continue
+			}
+			rest := name
+			if p != "" {
+				if !strings.HasPrefix(name, p+"/") {
+					continue
+				}
+				rest = strings.TrimPrefix(name, p+"/")
+			}
+			child := strings.SplitN(rest, "/", 2)[0]
+			children[child] = fs.node(child, true)
+		}
+		for _, node := range children {
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	return
+}
+
+func (fs *pouchFS) Open(p string, caller fuse.Caller) (content []byte, err error) {
+	err = fs.run(func() error {
+		_, secret, key, err := fs.stat(p)
+		if err != nil {
+			return err
+		}
+		peer := peerCredentials{PID: int32(caller.PID), UID: caller.UID, GID: caller.GID}
+		details := map[string]interface{}{"key": key, "pid": peer.PID, "uid": peer.UID, "gid": peer.GID}
+		labels := metrics.Labels{"secret": secret}
+		// Consumers of the secret are also enforced if configured
+		if sc, found := fs.pouch.Secrets[secret]; found && sc.Consumers != nil {
+			allowed, err := sc.Consumers.allows(peer)
+			if err != nil {
+				errorf("Couldn't check consumers of secret '%s': %v", secret, err)
+			}
+			if !allowed {
+				fs.pouch.Metrics.Add(MetricSecretRequestsDenied, labels, 1)
+				fs.pouch.event(Event{
+					Type:    EventSecretAccessDenied,
+					Secret:  secret,
+					Message: fmt.Sprintf("Opening key '%s' of secret '%s' denied to %s", key, secret, peer),
+					Details: details,
+				})
+				return os.ErrPermission
+			}
+		}
+		content = []byte(fmt.Sprint(fs.pouch.State.Secrets[secret].Data[key]))
+		fs.pouch.Metrics.Add(MetricSecretRequests, labels, 1)
+		fs.pouch.event(Event{
+			Type:    EventSecretServed,
+			Secret:  secret,
+			Message: fmt.Sprintf("Key '%s' of secret '%s' opened by %s", key, secret, peer),
+			Details: details,
+		})
+		return nil
+	})
+	return
+}
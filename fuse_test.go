@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tuenti/pouch/pkg/fuse"
+)
+
+func TestFUSEFilesystem(t *testing.T) {
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"db":        {Name: "db", Data: SecretData{"user": "app", "password": "s3cr3t"}},
+		"certs/web": {Name: "certs/web", Data: SecretData{"key": "PRIVATE"}},
+		"hidden":    {Name: "hidden", Data: SecretData{"token": "abcdef"}},
+	}
+	secrets := map[string]SecretConfig{
+		"db": {Consumers: &SecretConsumers{Users: []string{strconv.Itoa(os.Getuid())}}},
+	}
+	p := NewPouch(state, nil, secrets, nil, nil).(*pouch)
+	assert.Error(t, p.FUSE(FUSEConfig{}))
+	assert.NoError(t, p.FUSE(FUSEConfig{MountPoint: "/run/secrets", Secrets: []string{"db", "certs/*"}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.controlRequests = make(chan controlRequest)
+	go func() {
+		for {
+			select {
+			case req := <-p.controlRequests:
+				p.runControl(req)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	fs := &pouchFS{pouch: p, ctx: ctx}
+
+	names := func(nodes []fuse.Node) map[string]bool {
+		m := make(map[string]bool)
+		for _, n := range nodes {
+			m[n.Name] = n.Dir
+		}
+		return m
+	}
+	nodes, err := fs.List("")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"db": true, "certs": true}, names(nodes))
+	nodes, err = fs.List("certs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"web": true}, names(nodes))
+	nodes, err = fs.List("db")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user": false, "password": false}, names(nodes))
+
+	node, err := fs.Stat("db/password")
+	if assert.NoError(t, err) {
+		assert.False(t, node.Dir)
+		assert.Equal(t, os.FileMode(DefaultFUSEMode), node.Mode)
+		assert.Equal(t, uint64(6), node.Size)
+	}
+	node, err = fs.Stat("certs")
+	if assert.NoError(t, err) {
+		assert.True(t, node.Dir)
+		assert.Equal(t, os.FileMode(0500), node.Mode)
+	}
+	_, err = fs.Stat("hidden/token")
+	assert.True(t, os.IsNotExist(err), "secrets not exposed shouldn't be found")
+	_, err = fs.Stat("db/unknown")
+	assert.True(t, os.IsNotExist(err), "unknown keys shouldn't be found")
+
+	caller := fuse.Caller{PID: 42, UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+	content, err := fs.Open("db/password", caller)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", string(content))
+	content, err = fs.Open("certs/web/key", fuse.Caller{PID: 42, UID: 12345})
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE", string(content))
+
+	// Consumers of the secret are enforced
+	_, err = fs.Open("db/password", fuse.Caller{PID: 42, UID: 12345, GID: 12345})
+	assert.True(t, os.IsPermission(err), "only consumers should open the secret")
+
+	var served, denied int
+	for _, e := range p.Events.Recent() {
+		switch e.Type {
+		case EventSecretServed:
+			served++
+			assert.Equal(t, int32(42), e.Details["pid"])
+		case EventSecretAccessDenied:
+			denied++
+		}
+	}
+	assert.Equal(t, 2, served)
+	assert.Equal(t, 1, denied)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/vault"
+)
+
+const (
+	ListMethod = "LIST"
+
+	// Secrets with URLs ending with this suffix are expanded to one
+	// secret per key found when listing their prefix
+	GlobSuffix = "/*"
+)
+
+func (c SecretConfig) IsGlob() bool {
+	return c.Provider() == VaultProvider && strings.HasSuffix(c.VaultURL, GlobSuffix)
+}
+
+// ListPath returns the path to list to expand a glob secret
+func (c SecretConfig) ListPath() string {
+	if c.ListURL != "" {
+		return c.ListURL
+	}
+	return strings.TrimSuffix(c.VaultURL, GlobSuffix)
+}
+
+// GlobSecretName returns the name of a secret obtained from expanding
+// a glob secret
+func GlobSecretName(name, key string) string {
+	return name + "/" + key
+}
+
+func (p *pouch) listKeys(c SecretConfig) ([]string, error) {
+	options := &vault.RequestOptions{Headers: c.Headers}
+	s, resp, err := p.Vault.Request(ListMethod, c.ListPath(), options)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// Vault replies with not found when there is nothing to list
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Data == nil {
+		return nil, nil
+	}
+	rawKeys, ok := s.Data["keys"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no keys found when listing %s", c.ListPath())
+	}
+	var keys []string
+	for _, k := range rawKeys {
+		key, ok := k.(string)
+		if !ok {
+			continue
+		}
+		// Skip subdirectories
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// expandSecrets replaces glob secrets by one secret for each key found
+// under their prefixes
+func (p *pouch) expandSecrets() error {
+	for name, c := range p.Secrets {
+		if !c.IsGlob() {
+			continue
+		}
+		keys, err := p.listKeys(c)
+		if err != nil {
+			return fmt.Errorf("couldn't list secrets for '%s': %v", name, err)
+		}
+		if p.secretGlobs == nil {
+			p.secretGlobs = make(map[string]SecretConfig)
+		}
+		p.secretGlobs[name] = c
+		delete(p.Secrets, name)
+
+		prefix := strings.TrimSuffix(c.VaultURL, GlobSuffix)
+		for _, key := range keys {
+			expanded := c
+			expanded.VaultURL = path.Join(prefix, key)
+			expanded.ListURL = ""
+			expanded.MetadataURL = ""
+			p.Secrets[GlobSecretName(name, key)] = expanded
+		}
+		debugf("Secret '%s' expanded to %d secrets", name, len(keys))
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tuenti/pouch/pkg/pouchapi"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	DefaultGRPCSocket     = "/run/pouch/grpc.sock"
+	DefaultGRPCSocketMode = 0600
+
+	// Events queued for each subscriber, events are dropped for slow
+	// subscribers
+	subscriberQueueSize = 100
+)
+
+// GRPCConfig defines the unix socket where the gRPC API is served to
+// local applications
+type GRPCConfig struct {
+	// Path of the unix socket, /run/pouch/grpc.sock by default
+	Socket string `json:"socket,omitempty"`
+
+	// Permissions of the socket, in octal, 0600 by default, access to
+	// the socket gives access to the content of all files
+	Mode string `json:"mode,omitempty"`
+}
+
+// subscriber receives the events matching a subscription
+type subscriber struct {
+	req    *pouchapi.SubscribeRequest
+	events chan Event
+}
+
+func (s *subscriber) matches(e Event) bool {
+	if len(s.req.Secrets) > 0 && !stringInSlice(e.Secret, s.req.Secrets) {
+		return false
+	}
+	if len(s.req.Files) > 0 && !stringInSlice(e.File, s.req.Files) {
+		return false
+	}
+	if len(s.req.Types) > 0 && !stringInSlice(e.Type, s.req.Types) {
+		return false
+	}
+	return true
+}
+
+// subscribers are the subscriptions to events
+type subscribers struct {
+	sync.Mutex
+	subscribers map[*subscriber]bool
+}
+
+func (s *subscribers) add(req *pouchapi.SubscribeRequest) *subscriber {
+	s.Lock()
+	defer s.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[*subscriber]bool)
+	}
+	sub := &subscriber{req: req, events: make(chan Event, subscriberQueueSize)}
+	s.subscribers[sub] = true
+	return sub
+}
+
+func (s *subscribers) remove(sub *subscriber) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.subscribers, sub)
+}
+
+func (s *subscribers) publish(e Event) {
+	s.Lock()
+	defer s.Unlock()
+	for sub := range s.subscribers {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			warnf("Subscriber too slow, event dropped: %s", e.Message)
+		}
+	}
+}
+
+func (p *pouch) GRPC(c GRPCConfig) error {
+	p.grpcSocket = c.Socket
+	if p.grpcSocket == "" {
+		p.grpcSocket = DefaultGRPCSocket
+	}
+	mode, err := parseSocketMode(c.Mode, DefaultGRPCSocketMode)
+	if err != nil {
+		return fmt.Errorf("incorrect mode of gRPC socket: %v", err)
+	}
+	p.grpcSocketMode = mode
+	return nil
+}
+
+// startGRPC serves the gRPC API till the context is done
+func (p *pouch) startGRPC(ctx context.Context) error {
+	if p.grpcSocket == "" {
+		return nil
+	}
+	l, err := listenUnix(ctx, p.grpcSocket, p.grpcSocketMode)
+	if err != nil {
+		return fmt.Errorf("couldn't listen in gRPC socket: %v", err)
+	}
+	s := grpc.NewServer()
+	pouchapi.RegisterServer(s, &grpcServer{pouch: p})
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+	go func() {
+		err := s.Serve(l)
+		if err != nil && ctx.Err() == nil {
+			errorf("gRPC server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// grpcServer implements the gRPC API
+type grpcServer struct {
+	pouch *pouch
+}
+
+func (s *grpcServer) GetFile(ctx context.Context, req *pouchapi.GetFileRequest) (*pouchapi.File, error) {
+	resp := s.pouch.inMainLoop(ctx, func() (interface{}, error) {
+		content, used, err := s.pouch.renderFile(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		return &pouchapi.File{
+			Path:    req.Path,
+			Content: []byte(content),
+			SHA256:  contentChecksum(content),
+			Secrets: sortedUsedSecrets(used),
+		}, nil
+	})
+	if resp.Error != "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s", resp.Error)
+	}
+	return resp.Result.(*pouchapi.File), nil
+}
+
+func (s *grpcServer) Subscribe(ctx context.Context, req *pouchapi.SubscribeRequest, send func(*pouchapi.Event) error) error {
+	sub := s.pouch.subscribers.add(req)
+	defer s.pouch.subscribers.remove(sub)
+	for {
+		select {
+		case e := <-sub.events:
+			err := send(&pouchapi.Event{
+				TimeUnixNano: e.Time.UnixNano(),
+				Type:         e.Type,
+				Secret:       e.Secret,
+				File:         e.File,
+				Notifier:     e.Notifier,
+				Message:      e.Message,
+			})
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
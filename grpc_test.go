@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tuenti/pouch/pkg/pouchapi"
+)
+
+func TestGRPC(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	v := &DummyVault{
+		T:             t,
+		ExpectedToken: "token",
+		Token:         "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/foo": &api.Secret{Data: map[string]interface{}{"password": "foopass"}},
+		},
+	}
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/secret/foo", HTTPMethod: "GET"},
+	}
+	filePath := path.Join(tmpdir, "foo")
+	files := []FileConfig{{Path: filePath, Template: `{{ secret "foo" "password" }}`, Served: true}}
+	p := NewPouch(NewState(""), v, secrets, files, nil).(*pouch)
+
+	socket := path.Join(tmpdir, "grpc.sock")
+	assert.NoError(t, p.GRPC(GRPCConfig{Socket: socket}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.controlRequests = make(chan controlRequest)
+	if !assert.NoError(t, p.startGRPC(ctx)) {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case req := <-p.controlRequests:
+				p.runControl(req)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	client := pouchapi.NewClient(socket)
+	events := make(chan *pouchapi.Event, 10)
+	go client.Subscribe(ctx, &pouchapi.SubscribeRequest{Files: []string{filePath}}, func(e *pouchapi.Event) {
+		events <- e
+	})
+	// Wait for the subscription to be registered
+	for i := 0; i < 100; i++ {
+		p.subscribers.Lock()
+		n := len(p.subscribers.subscribers)
+		p.subscribers.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = p.runControlCommand(ControlRefresh, []string{"foo"})
+	assert.NoError(t, err)
+	_, err = p.runControlCommand(ControlRerender, []string{filePath})
+	assert.NoError(t, err)
+
+	// Served files are never written
+	_, err = os.Stat(filePath)
+	assert.True(t, os.IsNotExist(err), "served file shouldn't be written")
+
+	select {
+	case e := <-events:
+		assert.Equal(t, EventFileWritten, e.Type)
+		assert.Equal(t, filePath, e.File)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+	}
+
+	f, err := client.GetFile(ctx, filePath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filePath, f.Path)
+		assert.Equal(t, "foopass", string(f.Content))
+		assert.Equal(t, contentChecksum("foopass"), f.SHA256)
+		assert.Equal(t, []string{"foo"}, f.Secrets)
+	}
+
+	_, err = client.GetFile(ctx, "/unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// healedFile returns the configuration of a file watched for self-healing
+// that writes the given path, it can be the path of a per-key file
+func (p *pouch) healedFile(path string) (FileConfig, bool) {
+	if fc, found := p.Files[path]; found && fc.SelfHeal && !fc.PerKey {
+		return fc, true
+	}
+	dir := filepath.Dir(path)
+	if fc, found := p.Files[dir]; found && fc.SelfHeal && fc.PerKey && p.keyFiles[dir][path] {
+		return fc, true
+	}
+	return FileConfig{}, false
+}
+
+// checkSelfHeal checks that self-healing is only enabled for local files
+func checkSelfHeal(files map[string]FileConfig) error {
+	for path, fc := range files {
+		if !fc.SelfHeal {
+			continue
+		}
+		if fc.Plugin != "" || len(fc.Hosts) > 0 || fc.FIFO {
+			return fmt.Errorf("self-healing is only supported for local files, not for '%s'", path)
+		}
+	}
+	return nil
+}
+
+// watchFiles watches the directories of local files with self-healing,
+// sending to tampered the paths of files with events
+func (p *pouch) watchFiles(ctx context.Context) error {
+	err := checkSelfHeal(p.Files)
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	for path, fc := range p.Files {
+		if !fc.SelfHeal {
+			continue
+		}
+		if fc.PerKey {
+			dirs[path] = true
+		} else {
+			dirs[filepath.Dir(path)] = true
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for dir := range dirs {
+		err := watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return fmt.Errorf("when adding watcher for %s: %v", dir, err)
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event := <-watcher.Events:
+				select {
+				case p.tampered <- event.Name:
+				case <-ctx.Done():
+					return
+				}
+			case err := <-watcher.Errors:
+				errorf("Error watching files: %v", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// healFile writes again a file if its content doesn't match the checksum
+// of the last content written, events caused by pouch writes are ignored
+// this way
+func (p *pouch) healFile(path string) {
+	fc, found := p.healedFile(path)
+	if !found {
+		return
+	}
+	expected := p.State.FileChecksums[path]
+	checksum, err := fileChecksum(path)
+	if err == nil && checksum == expected {
+		return
+	}
+	reason := "modified"
+	if os.IsNotExist(err) {
+		reason = "removed"
+	}
+	err = p.resolveFile(fc)
+	if err != nil {
+		errorf("Couldn't heal '%s', %s externally: %v", path, reason, err)
+		return
+	}
+	p.event(Event{
+		Type:    EventFileHealed,
+		File:    path,
+		Message: fmt.Sprintf("File '%s' %s externally, written again", path, reason),
+	})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Template: `{{ secret "foo" "password" }}`,
+		SelfHeal: true,
+	}
+	p := NewPouch(state, nil, nil, []FileConfig{fc}, nil).(*pouch)
+	assert.NoError(t, p.resolveFile(fc))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.tampered = make(chan string)
+	assert.NoError(t, p.watchFiles(ctx))
+
+	assert.NoError(t, ioutil.WriteFile(fc.Path, []byte("edited"), 0600))
+	select {
+	case tampered := <-p.tampered:
+		assert.Equal(t, fc.Path, tampered)
+	case <-time.After(time.Second):
+		t.Fatal("modification not detected")
+	}
+
+	p.healFile(fc.Path)
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo", string(d))
+
+	assert.NoError(t, os.Remove(fc.Path))
+	p.healFile(fc.Path)
+	d, _ = ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo", string(d))
+
+	events := p.Events.Recent()
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, EventFileHealed, events[2].Type)
+		assert.Contains(t, events[2].Message, "modified")
+		assert.Equal(t, EventFileHealed, events[4].Type)
+		assert.Contains(t, events[4].Message, "removed")
+	}
+}
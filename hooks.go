@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"text/template"
+	"time"
+)
+
+// Maximum time hooks can run if they don't set a timeout
+const DefaultHookTimeout = time.Minute
+
+// HookConfig is a command run before or after writing a file, it can be
+// set as a string with only the command
+type HookConfig struct {
+	// Command run with sh -c, it is a go template that can use the
+	// path, the mode, the owner and the group of the file
+	Command string `json:"command"`
+
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (h *HookConfig) UnmarshalJSON(d []byte) error {
+	var command string
+	if err := json.Unmarshal(d, &command); err == nil {
+		*h = HookConfig{Command: command}
+		return nil
+	}
+	type hookConfig HookConfig
+	var c hookConfig
+	err := json.Unmarshal(d, &c)
+	if err != nil {
+		return err
+	}
+	*h = HookConfig(c)
+	return nil
+}
+
+// hookData is what hook commands can use in their templates
+type hookData struct {
+	Path  string
+	Mode  string
+	Owner string
+	Group string
+}
+
+// runHook renders the command of a hook for a file and runs it
+func runHook(h *HookConfig, fc FileConfig) error {
+	timeout := DefaultHookTimeout
+	if h.Timeout != "" {
+		d, err := time.ParseDuration(h.Timeout)
+		if err != nil {
+			return fmt.Errorf("incorrect timeout for hook: %v", err)
+		}
+		timeout = d
+	}
+	t, err := template.New("hook").Funcs(sprigFuncMap).Funcs(dataFuncMap).Parse(h.Command)
+	if err != nil {
+		return fmt.Errorf("incorrect hook command: %v", err)
+	}
+	var command bytes.Buffer
+	err = t.Execute(&command, hookData{
+		Path:  fc.Path,
+		Mode:  fmt.Sprintf("%#o", fc.FileMode()),
+		Owner: fc.Owner,
+		Group: fc.Group,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't render hook command: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", command.String()).CombinedOutput()
+	if err != nil {
+		warnf("Hook '%s' failed: %s", command.String(), out)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookConfigUnmarshal(t *testing.T) {
+	var hooks []HookConfig
+	err := json.Unmarshal([]byte(`["true", {"command": "sleep 1", "timeout": "5s"}]`), &hooks)
+	assert.NoError(t, err)
+	assert.Equal(t, []HookConfig{
+		{Command: "true"},
+		{Command: "sleep 1", Timeout: "5s"},
+	}, hooks)
+}
+
+func TestWriteHooks(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	log := path.Join(tmpdir, "log")
+	fc := FileConfig{
+		Path:        path.Join(tmpdir, "foo"),
+		Mode:        0640,
+		Template:    `{{ secret "foo" "password" }}`,
+		BeforeWrite: &HookConfig{Command: `test ! -e {{ .Path }} && echo before {{ .Mode }} >> ` + log},
+		AfterWrite:  &HookConfig{Command: `cat {{ .Path }} >> ` + log},
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(log)
+	assert.Equal(t, "before 0640\nfoo", string(d))
+
+	// The file is not written if the hook before fails
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "bar"}})
+	assert.Error(t, p.resolveFile(fc))
+	d, _ = ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo", string(d))
+
+	fc.BeforeWrite = &HookConfig{Command: "exec sleep 5", Timeout: "100ms"}
+	assert.Error(t, p.resolveFile(fc))
+
+	// Failures after writing are only logged
+	fc.BeforeWrite = nil
+	fc.AfterWrite = &HookConfig{Command: "false"}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ = ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "bar", string(d))
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tuenti/pouch/pkg/systemd"
+)
+
+// JournalLogFormat sends messages natively to journald
+const JournalLogFormat = "journal"
+
+var journalPriorities = map[LogLevel]int{
+	LogDebug: systemd.JournalPriorityDebug,
+	LogInfo:  systemd.JournalPriorityInfo,
+	LogWarn:  systemd.JournalPriorityWarning,
+	LogError: systemd.JournalPriorityErr,
+}
+
+// journalSender sends messages to the journal
+type journalSender interface {
+	Send(message string, priority int, fields map[string]string) error
+}
+
+// journalLogger logs messages in the journal, with their fields as journal
+// fields, e.g. SECRET, FILE or UNIT, so they can be used to filter with
+// journalctl
+type journalLogger struct {
+	journal  journalSender
+	level    LogLevel
+	fallback Logger
+}
+
+func newJournalLogger(level LogLevel) Logger {
+	return &journalLogger{
+		journal:  &systemd.Journal{Identifier: "pouch"},
+		level:    level,
+		fallback: &writerLogger{w: os.Stderr, format: TextLogFormat, level: level},
+	}
+}
+
+func journalFieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	case int, int64, float64, bool:
+		return fmt.Sprint(v)
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(d)
+}
+
+func (l *journalLogger) Log(level LogLevel, message string, fields LogFields) {
+	if level < l.level {
+		return
+	}
+	journalFields := make(map[string]string, len(fields))
+	for name, value := range fields {
+		name = systemd.JournalFieldName(name)
+		if name == "" {
+			continue
+		}
+		journalFields[name] = journalFieldValue(value)
+	}
+	err := l.journal.Send(message, journalPriorities[level], journalFields)
+	if err != nil {
+		// Don't lose messages if the journal is not available
+		l.fallback.Log(level, message, fields)
+	}
+}
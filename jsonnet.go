@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	JsonnetTemplateEngine = "jsonnet"
+
+	DefaultJsonnetBinary  = "jsonnet"
+	DefaultJsonnetTimeout = time.Minute
+
+	// External variable containing the secrets
+	JsonnetSecretsVar = "secrets"
+)
+
+func init() {
+	RegisterTemplateEngine(JsonnetTemplateEngine, &JsonnetEngine{})
+}
+
+// JsonnetEngine renders files using the jsonnet command, secrets are
+// available in the `secrets` external variable, as an object with the
+// data of each secret under its name. Secrets are passed through the
+// environment so they are not visible in the command line.
+type JsonnetEngine struct {
+	Binary  string
+	Timeout time.Duration
+}
+
+func (e *JsonnetEngine) Render(name, source string, ctx *RenderContext) (string, error) {
+	secrets := make(map[string]SecretData)
+	for _, s := range ctx.Secrets {
+		data, err := ctx.Secret(s)
+		if err != nil {
+			return "", err
+		}
+		secrets[s] = data
+	}
+	secretsJSON, err := json.Marshal(secrets)
+	if err != nil {
+		return "", err
+	}
+
+	binary := e.Binary
+	if binary == "" {
+		binary = DefaultJsonnetBinary
+	}
+	binary, err = exec.LookPath(binary)
+	if err != nil {
+		return "", fmt.Errorf("jsonnet command not available: %v", err)
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultJsonnetTimeout
+	}
+	c, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := []string{"--ext-code", JsonnetSecretsVar}
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		// Template from file, allow imports relative to it
+		args = append(args, "-J", filepath.Dir(name))
+	}
+	args = append(args, "-")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(c, binary, args...)
+	cmd.Env = append(os.Environ(), JsonnetSecretsVar+"="+string(secretsJSON))
+	cmd.Stdin = strings.NewReader(source)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("jsonnet failed for %s: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
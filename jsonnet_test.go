@@ -0,0 +1,60 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func jsonnetTestContext() *RenderContext {
+	secrets := map[string]SecretData{
+		"db": {"user": "app", "password": "secret"},
+	}
+	return &RenderContext{
+		Secrets: []string{"db"},
+		Secret: func(name string) (SecretData, error) {
+			return secrets[name], nil
+		},
+	}
+}
+
+func TestJsonnetEngine(t *testing.T) {
+	if _, err := exec.LookPath(DefaultJsonnetBinary); err != nil {
+		t.Skip("jsonnet not available")
+	}
+	e := &JsonnetEngine{}
+	source := `local db = std.extVar("secrets").db; { user: db.user, password: db.password }`
+	content, err := e.Render("inline-template", source, jsonnetTestContext())
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"user": "app", "password": "secret"}`, content)
+	}
+
+	_, err = e.Render("inline-template", `{ user: `, jsonnetTestContext())
+	assert.Error(t, err)
+}
+
+func TestJsonnetEngineNotAvailable(t *testing.T) {
+	e := &JsonnetEngine{Binary: "pouch-test-missing-jsonnet"}
+	content, err := e.Render("inline-template", `{}`, jsonnetTestContext())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "jsonnet command not available")
+	}
+	assert.Empty(t, content)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Default template for the names of the files of per-key files
+const DefaultKeyFilename = "{{ .Key }}"
+
+// secretKey is the data available for the names of per-key files and
+// for templated paths
+type secretKey struct {
+	Secret string
+	Key    string
+}
+
+func renderKeyFilename(t *template.Template, secret, key string) (string, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, secretKey{Secret: secret, Key: key})
+	if err != nil {
+		return "", err
+	}
+	name := b.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, os.PathSeparator) {
+		return "", fmt.Errorf("incorrect filename '%s' for key '%s' of secret '%s'", name, key, secret)
+	}
+	return name, nil
+}
+
+// keyFileContents returns the paths of the files of each key of the
+// secrets of a per-key file, in the order they are written, and their
+// content
+func keyFileContents(fc FileConfig, ctx *RenderContext) ([]string, map[string]string, error) {
+	if len(fc.Secrets) == 0 {
+		return nil, nil, fmt.Errorf("per-key file '%s' needs a list of secrets", fc.Path)
+	}
+	filename := fc.Filename
+	if filename == "" {
+		filename = DefaultKeyFilename
+	}
+	t, err := template.New("filename").Parse(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("incorrect filename template for '%s': %v", fc.Path, err)
+	}
+
+	var paths []string
+	contents := make(map[string]string)
+	for _, name := range fc.Secrets {
+		data, err := ctx.Secret(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		var keys []string
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			filename, err := renderKeyFilename(t, name, key)
+			if err != nil {
+				return nil, nil, err
+			}
+			path := filepath.Join(fc.Path, filename)
+			if _, found := contents[path]; found {
+				return nil, nil, fmt.Errorf("more than one key written in '%s'", path)
+			}
+			content, err := decodeContent(fc.Encoding, fmt.Sprint(data[key]))
+			if err != nil {
+				return nil, nil, fmt.Errorf("couldn't decode key '%s' of secret '%s': %v", key, name, err)
+			}
+			paths = append(paths, path)
+			contents[path] = content
+		}
+	}
+	return paths, contents, nil
+}
+
+// resolveKeysDirectory writes each key of the secrets of a file in its own
+// file, in the directory of its path, as secret volumes in Kubernetes.
+// Files written for keys that don't exist anymore are removed.
+func (p *pouch) resolveKeysDirectory(fc FileConfig, ctx *RenderContext, used map[string]bool) error {
+	if fc.Plugin != "" || len(fc.Hosts) > 0 {
+		return fmt.Errorf("per-key file '%s' can only be written locally", fc.Path)
+	}
+	paths, contents, err := keyFileContents(fc, ctx)
+	if err != nil {
+		return err
+	}
+
+	switch fc.UpdateStrategy {
+	case "":
+		for _, path := range paths {
+			keyFile := fc
+			keyFile.Path = path
+			err = p.deliverFile(keyFile, contents[path], used)
+			if err != nil {
+				return err
+			}
+		}
+	case SymlinkUpdateStrategy:
+		err = p.swapKeysDirectory(fc, paths, contents, used)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown update strategy for '%s': %s", fc.Path, fc.UpdateStrategy)
+	}
+
+	written := make(map[string]bool)
+	for _, path := range paths {
+		written[path] = true
+	}
+	for path := range p.keyFiles[fc.Path] {
+		if written[path] {
+			continue
+		}
+		infof("Removing '%s', its key doesn't exist anymore", path)
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			errorf("Couldn't remove '%s': %v", path, err)
+			continue
+		}
+		p.State.DeleteManagedFile(path)
+		p.addForNotify(path, fc.Notify...)
+	}
+	if p.keyFiles == nil {
+		p.keyFiles = make(map[string]map[string]bool)
+	}
+	p.keyFiles[fc.Path] = written
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerKeyFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("app", &api.Secret{Data: map[string]interface{}{"user": "app", "password": "secret"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	dir := filepath.Join(tmpdir, "app")
+	fc := FileConfig{
+		Path:     dir,
+		PerKey:   true,
+		Filename: "{{ .Secret }}-{{ .Key }}",
+		Secrets:  []string{"app"},
+		Notify:   NotifyNames("app"),
+	}
+	assert.NoError(t, p.resolveFile(fc))
+
+	files, _ := ioutil.ReadDir(dir)
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "app-password", files[0].Name())
+		assert.Equal(t, "app-user", files[1].Name())
+	}
+	d, _ := ioutil.ReadFile(filepath.Join(dir, "app-password"))
+	assert.Equal(t, "secret", string(d))
+	assert.Len(t, p.pendingNotifiers[NotifyConfig{Notifier: "app"}], 2)
+	if assert.Len(t, state.Secrets["app"].FilesUsing, 1) {
+		assert.Equal(t, dir, state.Secrets["app"].FilesUsing[0].Path)
+	}
+
+	// Files of removed keys are removed
+	p.pendingNotifiers = nil
+	state.SetSecret("app", &api.Secret{Data: map[string]interface{}{"password": "secret"}})
+	assert.NoError(t, p.resolveFile(fc))
+	files, _ = ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+	assert.Equal(t, []string{filepath.Join(dir, "app-user")}, p.pendingNotifiers[NotifyConfig{Notifier: "app"}])
+
+	fc.Filename = "{{ .Secret }}/{{ .Key }}"
+	assert.Error(t, p.resolveFile(fc))
+}
+
+func TestPerKeySymlinkUpdate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("cert", &api.Secret{Data: map[string]interface{}{"certificate": "cert1", "private_key": "key1"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	dir := filepath.Join(tmpdir, "cert")
+	fc := FileConfig{
+		Path:           dir,
+		PerKey:         true,
+		UpdateStrategy: SymlinkUpdateStrategy,
+		Secrets:        []string{"cert"},
+		Notify:         NotifyNames("app"),
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	assert.Equal(t, []string{dir}, p.pendingNotifiers[NotifyConfig{Notifier: "app"}])
+
+	first, err := os.Readlink(filepath.Join(dir, dataLinkName))
+	assert.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(dir, "certificate"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dataLinkName, "certificate"), target)
+	d, _ := ioutil.ReadFile(filepath.Join(dir, "private_key"))
+	assert.Equal(t, "key1", string(d))
+
+	// Nothing changes if content is the same
+	p.pendingNotifiers = nil
+	assert.NoError(t, p.resolveFile(fc))
+	assert.Empty(t, p.pendingNotifiers)
+
+	state.SetSecret("cert", &api.Secret{Data: map[string]interface{}{"certificate": "cert2"}})
+	assert.NoError(t, p.resolveFile(fc))
+	second, err := os.Readlink(filepath.Join(dir, dataLinkName))
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	d, _ = ioutil.ReadFile(filepath.Join(dir, "certificate"))
+	assert.Equal(t, "cert2", string(d))
+
+	// Previous version and files of removed keys are removed
+	files, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{second, dataLinkName, "certificate"}, names)
+
+	// New versions that cannot be written are removed
+	state.SetSecret("cert", &api.Secret{Data: map[string]interface{}{"certificate": "cert3"}})
+	fc.Owner = "pouch-test-unknown-user"
+	assert.Error(t, p.resolveFile(fc))
+	files, _ = ioutil.ReadDir(dir)
+	names = nil
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{second, dataLinkName, "certificate"}, names)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/base64"
+	"text/template"
+
+	"github.com/tuenti/pouch/pkg/keystore"
+)
+
+// Functions to assemble keystores in templates, keystores are binary, so
+// they are returned in base64, to be used in files with base64 encoding
+var keystoreFuncMap = template.FuncMap{
+	"pkcs12": pkcs12Keystore,
+	"jks":    jksKeystore,
+}
+
+func pkcs12Keystore(alias, password, key string, certificates ...string) (string, error) {
+	k, err := keystore.Parse(key, certificates...)
+	if err != nil {
+		return "", err
+	}
+	k.Alias = alias
+	d, err := k.EncodePKCS12(password)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(d), nil
+}
+
+func jksKeystore(alias, password, key string, certificates ...string) (string, error) {
+	k, err := keystore.Parse(key, certificates...)
+	if err != nil {
+		return "", err
+	}
+	k.Alias = alias
+	d, err := k.EncodeJKS(password)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(d), nil
+}
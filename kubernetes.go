@@ -0,0 +1,245 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Files of the service account mounted in pods
+	kubernetesServiceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// Annotation set in pod templates by kubectl rollout restart
+	kubernetesRestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+// Resources of workloads that can be restarted
+var kubernetesWorkloads = map[string]string{
+	"deployment":  "deployments",
+	"statefulset": "statefulsets",
+	"daemonset":   "daemonsets",
+}
+
+type KubernetesNotifierConfig struct {
+	// URL of the API server, by default the one of the cluster where
+	// pouch is running
+	Host string `json:"host,omitempty"`
+
+	// File with the bearer token and with the CA of the API server, by
+	// default the ones of the service account
+	TokenFile string `json:"token_file,omitempty"`
+	CAFile    string `json:"ca_file,omitempty"`
+
+	// Namespace of the workload or the pods, by default the one of the
+	// service account
+	Namespace string `json:"namespace,omitempty"`
+
+	// Workload restarted as with kubectl rollout restart, in the form
+	// kind/name, e.g. deployment/web
+	Restart string `json:"restart,omitempty"`
+
+	// Label selector of pods deleted
+	DeletePods string `json:"delete_pods,omitempty"`
+
+	// Delete only pods running in the node of pouch, its name is read
+	// from the NODE_NAME environment variable, or the hostname is used
+	LocalNode bool `json:"local_node,omitempty"`
+}
+
+// KubernetesNotifier restarts workloads or deletes pods using the API of
+// Kubernetes, for clusters where pouch runs as a node agent
+type KubernetesNotifier struct {
+	Config KubernetesNotifierConfig
+}
+
+func (n *KubernetesNotifier) client() (*http.Client, error) {
+	caFile := n.Config.CAFile
+	if caFile == "" && n.Config.Host == "" {
+		caFile = kubernetesServiceAccountPath + "/ca.crt"
+	}
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func (n *KubernetesNotifier) host() (string, error) {
+	if n.Config.Host != "" {
+		return strings.TrimSuffix(n.Config.Host, "/"), nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return "", fmt.Errorf("host of the API server needed when not running in a cluster")
+	}
+	return "https://" + net.JoinHostPort(host, port), nil
+}
+
+func (n *KubernetesNotifier) namespace() (string, error) {
+	if n.Config.Namespace != "" {
+		return n.Config.Namespace, nil
+	}
+	d, err := ioutil.ReadFile(kubernetesServiceAccountPath + "/namespace")
+	if err != nil {
+		return "", fmt.Errorf("namespace needed when not running in a cluster")
+	}
+	return strings.TrimSpace(string(d)), nil
+}
+
+func localNodeName() (string, error) {
+	if name := os.Getenv("NODE_NAME"); name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// request does a request to the API server, the token is read on each
+// request as projected tokens are rotated
+func (n *KubernetesNotifier) request(ctx context.Context, method, path string, query url.Values, contentType, body string) error {
+	host, err := n.host()
+	if err != nil {
+		return err
+	}
+	client, err := n.client()
+	if err != nil {
+		return err
+	}
+	u := host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	tokenFile := n.Config.TokenFile
+	if tokenFile == "" && n.Config.Host == "" {
+		tokenFile = kubernetesServiceAccountPath + "/token"
+	}
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return fmt.Errorf("request to %s failed with status %d: %s", path, resp.StatusCode, status.Message)
+	}
+	return nil
+}
+
+func (n *KubernetesNotifier) restart(ctx context.Context, namespace string) (string, error) {
+	parts := strings.Split(n.Config.Restart, "/")
+	resource, found := kubernetesWorkloads[strings.ToLower(parts[0])]
+	if len(parts) != 2 || !found || parts[1] == "" {
+		return "", fmt.Errorf("incorrect workload %s, expected deployment, statefulset or daemonset/<name>", n.Config.Restart)
+	}
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						kubernetesRestartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("/apis/apps/v1/namespaces/%s/%s/%s", namespace, resource, parts[1])
+	err = n.request(ctx, http.MethodPatch, path, nil, "application/strategic-merge-patch+json", string(body))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Restarted %s in namespace %s", n.Config.Restart, namespace), nil
+}
+
+func (n *KubernetesNotifier) deletePods(ctx context.Context, namespace string) (string, error) {
+	query := url.Values{"labelSelector": {n.Config.DeletePods}}
+	if n.Config.LocalNode {
+		node, err := localNodeName()
+		if err != nil {
+			return "", err
+		}
+		query.Set("fieldSelector", "spec.nodeName="+node)
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/pods", namespace)
+	err := n.request(ctx, http.MethodDelete, path, query, "", "")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Deleted pods matching %s in namespace %s", n.Config.DeletePods, namespace), nil
+}
+
+func (n *KubernetesNotifier) Run(ctx context.Context) (string, error) {
+	namespace, err := n.namespace()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case n.Config.Restart != "" && n.Config.DeletePods == "":
+		if n.Config.LocalNode {
+			return "", fmt.Errorf("local_node can only be used to delete pods")
+		}
+		return n.restart(ctx, namespace)
+	case n.Config.DeletePods != "" && n.Config.Restart == "":
+		return n.deletePods(ctx, namespace)
+	}
+	return "", fmt.Errorf("one and only one of restart or delete_pods must be set")
+}
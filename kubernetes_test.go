@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+
+	var method, uri, contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		d, _ := ioutil.ReadAll(r.Body)
+		method, uri, contentType, body = r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), string(d)
+		if r.URL.Path == "/apis/apps/v1/namespaces/web/deployments/unknown" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"deployments.apps \"unknown\" not found"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	config := KubernetesNotifierConfig{
+		Host:      server.URL,
+		TokenFile: tokenFile,
+		Namespace: "web",
+		Restart:   "deployment/nginx",
+	}
+	n := &KubernetesNotifier{Config: config}
+	_, err = n.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPatch, method)
+	assert.Equal(t, "/apis/apps/v1/namespaces/web/deployments/nginx", uri)
+	assert.Equal(t, "application/strategic-merge-patch+json", contentType)
+	assert.Contains(t, body, kubernetesRestartedAtAnnotation)
+
+	n.Config.Restart = "deployment/unknown"
+	_, err = n.Run(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "not found")
+	}
+
+	n.Config.Restart = "job/backup"
+	_, err = n.Run(context.Background())
+	assert.Error(t, err)
+
+	os.Setenv("NODE_NAME", "node-1")
+	defer os.Unsetenv("NODE_NAME")
+	n.Config.Restart = ""
+	n.Config.DeletePods = "app=nginx"
+	n.Config.LocalNode = true
+	_, err = n.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodDelete, method)
+	assert.Equal(t, "/api/v1/namespaces/web/pods?fieldSelector=spec.nodeName%3Dnode-1&labelSelector=app%3Dnginx", uri)
+
+	n.Config.Restart = "deployment/nginx"
+	_, err = n.Run(context.Background())
+	assert.Error(t, err)
+}
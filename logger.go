@@ -0,0 +1,236 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/systemd"
+)
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+// Formats of log messages
+const (
+	TextLogFormat   = "text"
+	JSONLogFormat   = "json"
+	LogfmtLogFormat = "logfmt"
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	return logLevelNames[l]
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.ToLower(s) == name {
+			return level, nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level: %s", s)
+}
+
+// LogFields are structured data attached to log messages, as the secret
+// or the file they refer to
+type LogFields map[string]interface{}
+
+// Logger receives the messages logged by pouch, it can be replaced to
+// send them to other logging libraries
+type Logger interface {
+	Log(level LogLevel, message string, fields LogFields)
+}
+
+type LogConfig struct {
+	// Minimum level of messages logged: debug, info, warn or error,
+	// info by default
+	Level string `json:"level,omitempty"`
+
+	// Format of messages: text, json, logfmt or journal, journal when
+	// running as a systemd service and text otherwise by default
+	Format string `json:"format,omitempty"`
+}
+
+// writerLogger writes log messages with at least a level in a writer
+type writerLogger struct {
+	sync.Mutex
+
+	w      io.Writer
+	format string
+	level  LogLevel
+}
+
+// NewLogger creates a logger writing messages in the given format and
+// with at least the given level
+func NewLogger(w io.Writer, format string, level LogLevel) (Logger, error) {
+	switch format {
+	case "":
+		format = TextLogFormat
+	case TextLogFormat, JSONLogFormat, LogfmtLogFormat:
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+	return &writerLogger{w: w, format: format, level: level}, nil
+}
+
+// NewLoggerFromConfig creates a logger writing in stderr, or in the
+// journal when running as a systemd service and no format is set
+func NewLoggerFromConfig(c LogConfig) (Logger, error) {
+	level := LogInfo
+	if c.Level != "" {
+		var err error
+		level, err = ParseLogLevel(c.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Format == JournalLogFormat || (c.Format == "" && systemd.JournalStream()) {
+		return newJournalLogger(level), nil
+	}
+	return NewLogger(os.Stderr, c.Format, level)
+}
+
+func sortedFieldNames(fields LogFields) []string {
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func logfmtValue(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		d, err := json.Marshal(v)
+		if err != nil {
+			s = fmt.Sprint(v)
+		} else {
+			s = string(d)
+		}
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (l *writerLogger) Log(level LogLevel, message string, fields LogFields) {
+	if level < l.level {
+		return
+	}
+	now := time.Now()
+	var b bytes.Buffer
+	switch l.format {
+	case JSONLogFormat:
+		entry := make(map[string]interface{}, len(fields)+3)
+		for name, value := range fields {
+			entry[name] = value
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = message
+		d, err := json.Marshal(entry)
+		if err != nil {
+			d, _ = json.Marshal(map[string]string{"level": level.String(), "msg": message})
+		}
+		b.Write(d)
+	case LogfmtLogFormat:
+		fmt.Fprintf(&b, "time=%s level=%s msg=%s", now.Format(time.RFC3339Nano), level, logfmtValue(message))
+		for _, name := range sortedFieldNames(fields) {
+			fmt.Fprintf(&b, " %s=%s", name, logfmtValue(fields[name]))
+		}
+	default:
+		fmt.Fprintf(&b, "%s %s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), message)
+		if len(fields) > 0 {
+			d, _ := json.Marshal(fields)
+			fmt.Fprintf(&b, " %s", d)
+		}
+	}
+	b.WriteByte('\n')
+
+	l.Lock()
+	defer l.Unlock()
+	l.w.Write(b.Bytes())
+}
+
+// logWriter sends what is written to a logger, for libraries using the
+// standard log package
+type logWriter struct {
+	level LogLevel
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	logger.Log(w.level, redactSecrets(strings.TrimSuffix(string(p), "\n")), nil)
+	return len(p), nil
+}
+
+// NewLogWriter returns a writer that logs each write with the logger of
+// pouch, to be used as output of the standard log package
+func NewLogWriter(level LogLevel) io.Writer {
+	return logWriter{level: level}
+}
+
+var logger Logger = &writerLogger{w: os.Stderr, format: TextLogFormat, level: LogInfo}
+
+// SetLogger replaces the logger used by pouch
+func SetLogger(l Logger) {
+	logger = l
+}
+
+func logf(level LogLevel, fields LogFields, format string, args ...interface{}) {
+	logger.Log(level, redactSecrets(fmt.Sprintf(format, args...)), redactFields(fields))
+}
+
+func debugf(format string, args ...interface{}) {
+	logf(LogDebug, nil, format, args...)
+}
+
+func infof(format string, args ...interface{}) {
+	logf(LogInfo, nil, format, args...)
+}
+
+func warnf(format string, args ...interface{}) {
+	logf(LogWarn, nil, format, args...)
+}
+
+func errorf(format string, args ...interface{}) {
+	logf(LogError, nil, format, args...)
+}
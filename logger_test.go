@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	var b bytes.Buffer
+	l, err := NewLogger(&b, JSONLogFormat, LogInfo)
+	if !assert.NoError(t, err) {
+		return
+	}
+	l.Log(LogDebug, "hidden", nil)
+	l.Log(LogWarn, "Couldn't renew lease", LogFields{"secret": "db"})
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "Couldn't renew lease", entry["msg"])
+	assert.Equal(t, "db", entry["secret"])
+
+	b.Reset()
+	l, _ = NewLogger(&b, LogfmtLogFormat, LogDebug)
+	l.Log(LogDebug, "File written", LogFields{"file": "/etc/app.conf", "bytes": 10})
+	line := b.String()
+	assert.Contains(t, line, `level=debug msg="File written" bytes=10 file=/etc/app.conf`)
+
+	b.Reset()
+	l, _ = NewLogger(&b, "", LogError)
+	l.Log(LogWarn, "hidden", nil)
+	l.Log(LogError, "Status server failed", LogFields{"port": 8080})
+	assert.True(t, strings.HasSuffix(b.String(), ` ERROR Status server failed {"port":8080}`+"\n"), b.String())
+
+	_, err = NewLogger(&b, "xml", LogInfo)
+	assert.Error(t, err)
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := ParseLogLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, LogWarn, level)
+	_, err = ParseLogLevel("verbose")
+	assert.Error(t, err)
+}
+
+type recordingJournal struct {
+	messages []string
+	priority int
+	fields   map[string]string
+}
+
+func (j *recordingJournal) Send(message string, priority int, fields map[string]string) error {
+	j.messages = append(j.messages, message)
+	j.priority = priority
+	j.fields = fields
+	return nil
+}
+
+func TestJournalLogger(t *testing.T) {
+	journal := &recordingJournal{}
+	l := &journalLogger{journal: journal, level: LogInfo}
+	l.Log(LogDebug, "hidden", nil)
+	l.Log(LogWarn, "Notification to 'app' failed", LogFields{
+		"notifier": "app",
+		"unit":     "app.service",
+		"details":  map[string]interface{}{"success": false},
+	})
+	assert.Equal(t, []string{"Notification to 'app' failed"}, journal.messages)
+	assert.Equal(t, 4, journal.priority)
+	assert.Equal(t, map[string]string{
+		"NOTIFIER": "app",
+		"UNIT":     "app.service",
+		"DETAILS":  `{"success":false}`,
+	}, journal.fields)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+const (
+	MetricUp                    = "pouch_up"
+	MetricStatus                = "pouch_status"
+	MetricLastCycle             = "pouch_last_cycle_timestamp_seconds"
+	MetricSecrets               = "pouch_secrets"
+	MetricSecretLastUpdate      = "pouch_secret_last_update_timestamp_seconds"
+	MetricSecretNextUpdate      = "pouch_secret_next_update_timestamp_seconds"
+	MetricSecretUpdateErrors    = "pouch_secret_update_errors_total"
+	MetricSecretRotations       = "pouch_secret_rotations_total"
+	MetricSecretRotationAnomaly = "pouch_secret_rotation_anomaly"
+	MetricFileWrites            = "pouch_file_writes_total"
+	MetricFileWritesSkipped     = "pouch_file_writes_skipped_total"
+	MetricFileChecksFailed      = "pouch_file_checks_failed_total"
+	MetricNotifications         = "pouch_notifications_total"
+	MetricNotificationsFailed   = "pouch_notifications_failed_total"
+	MetricNotificationsSkipped  = "pouch_notifications_skipped_total"
+	MetricExpectationSuccess    = "pouch_expectation_success"
+	MetricSecretRequests        = "pouch_secret_requests_total"
+	MetricSecretRequestsDenied  = "pouch_secret_requests_denied_total"
+)
+
+// Interval between pushes of metrics to statsd if none is configured
+const DefaultStatsdInterval = 10 * time.Second
+
+type MetricsConfig struct {
+	// Path to a file where metrics are written in the format of
+	// the textfile collector of node_exporter
+	TextfilePath string `json:"textfile_path,omitempty"`
+
+	// Server where metrics are pushed with the statsd protocol
+	Statsd *StatsdConfig `json:"statsd,omitempty"`
+}
+
+type StatsdConfig struct {
+	// Address of the server, as host:port
+	Address string `json:"address"`
+
+	// Prefix added to the names of the metrics
+	Prefix string `json:"prefix,omitempty"`
+
+	// Send labels as DogStatsD tags
+	Tags bool `json:"tags,omitempty"`
+
+	// Interval between pushes, 10s by default
+	Interval string `json:"interval,omitempty"`
+}
+
+func newMetricsRegistry() *metrics.Registry {
+	r := metrics.NewRegistry()
+	r.Describe(MetricUp, metrics.Gauge, "Whether pouch is running.")
+	r.Describe(MetricStatus, metrics.Gauge, "Current status of pouch.")
+	r.Describe(MetricLastCycle, metrics.Gauge, "Time of the last update cycle.")
+	r.Describe(MetricSecrets, metrics.Gauge, "Number of secrets in state.")
+	r.Describe(MetricSecretLastUpdate, metrics.Gauge, "Time when the secret was last read.")
+	r.Describe(MetricSecretNextUpdate, metrics.Gauge, "Time when the secret will be read again.")
+	r.Describe(MetricSecretUpdateErrors, metrics.Counter, "Number of failed requests for a secret.")
+	r.Describe(MetricSecretRotations, metrics.Counter, "Number of times the values of a secret have changed.")
+	r.Describe(MetricSecretRotationAnomaly, metrics.Gauge, "Whether the secret is rotating much more often than usual.")
+	r.Describe(MetricFileWrites, metrics.Counter, "Number of times a file has been written.")
+	r.Describe(MetricFileWritesSkipped, metrics.Counter, "Number of times a file has not been written because its content didn't change.")
+	r.Describe(MetricFileChecksFailed, metrics.Counter, "Number of times the new content of a file has been rejected by its check command.")
+	r.Describe(MetricNotifications, metrics.Counter, "Number of notifications run.")
+	r.Describe(MetricNotificationsFailed, metrics.Counter, "Number of notifications failed.")
+	r.Describe(MetricNotificationsSkipped, metrics.Counter, "Number of notifications skipped because the files that triggered them didn't change.")
+	r.Describe(MetricExpectationSuccess, metrics.Gauge, "Whether the expectation was met in the last cycle.")
+	r.Describe(MetricSecretRequests, metrics.Counter, "Number of times the secret has been served by the secrets server.")
+	r.Describe(MetricSecretRequestsDenied, metrics.Counter, "Number of requests of the secret denied by the secrets server.")
+	return r
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// updateMetrics refreshes metrics obtained from state and writes them
+// in the textfile if configured
+func (p *pouch) updateMetrics() {
+	p.Metrics.Set(MetricUp, nil, 1)
+	p.Metrics.Set(MetricLastCycle, nil, timestamp(time.Now()))
+	p.Metrics.Set(MetricSecrets, nil, float64(len(p.State.Secrets)))
+
+	p.Metrics.Delete(MetricSecretLastUpdate, nil)
+	p.Metrics.Delete(MetricSecretNextUpdate, nil)
+	for name, s := range p.State.Secrets {
+		labels := metrics.Labels{"secret": name}
+		p.Metrics.Set(MetricSecretLastUpdate, labels, timestamp(s.Timestamp))
+		if ttu, known := s.TimeToUpdate(); known && !s.DisableAutoUpdate {
+			p.Metrics.Set(MetricSecretNextUpdate, labels, timestamp(ttu))
+		}
+	}
+
+	if p.MetricsTextfilePath != "" {
+		err := p.Metrics.WriteTextfile(p.MetricsTextfilePath)
+		if err != nil {
+			errorf("Couldn't write metrics to %s: %v", p.MetricsTextfilePath, err)
+		}
+	}
+}
+
+func (p *pouch) MetricsStatsd(c StatsdConfig) error {
+	if c.Address == "" {
+		return fmt.Errorf("address of statsd server needed")
+	}
+	p.statsdInterval = DefaultStatsdInterval
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return fmt.Errorf("incorrect statsd interval: %v", err)
+		}
+		p.statsdInterval = d
+	}
+	p.statsd = &metrics.StatsdPusher{Address: c.Address, Prefix: c.Prefix, Tags: c.Tags}
+	return nil
+}
+
+// startStatsd pushes metrics periodically to statsd till the context is
+// done
+func (p *pouch) startStatsd(ctx context.Context) {
+	if p.statsd == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.statsdInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.pushStatsd()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (p *pouch) pushStatsd() {
+	err := p.statsd.Push(p.Metrics)
+	if err != nil {
+		errorf("Couldn't push metrics to %s: %v", p.statsd.Address, err)
+	}
+}
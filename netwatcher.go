@@ -0,0 +1,252 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// Path of the endpoint accepting wrapped secret IDs
+	WrappedSecretIDEndpoint = "/v1/wrapped-secret-id"
+
+	// Wrapped secret IDs are small, bigger requests are rejected
+	maxWrappedSecretIDSize = 4096
+)
+
+// WrappedSecretIDListenerConfig configures network channels where pouch
+// accepts wrapped secret IDs, for provisioning flows where nothing can be
+// placed on disk before pouch starts
+type WrappedSecretIDListenerConfig struct {
+	// Address of an HTTPS endpoint accepting wrapped secret IDs
+	Listen   string `json:"listen,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// If set, clients of the HTTPS endpoint need a certificate signed
+	// by this CA
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+
+	// If set, clients of the HTTPS endpoint need to send the content of
+	// this file as bearer token
+	TokenFile string `json:"token_file,omitempty"`
+
+	// Path of a unix socket accepting wrapped secret IDs, it can be
+	// reached by streams forwarded with SSH
+	Socket string `json:"socket,omitempty"`
+}
+
+// netWatcher accepts wrapped secret IDs until one is unwrapped
+type netWatcher struct {
+	sync.Mutex
+
+	pouch *pouch
+	token string
+	done  chan struct{}
+}
+
+// unwrap unwraps a wrapped secret ID received from the network, only the
+// first one correctly unwrapped is used
+func (w *netWatcher) unwrap(wrapped string) error {
+	w.Lock()
+	defer w.Unlock()
+	select {
+	case <-w.done:
+		return fmt.Errorf("secret ID already obtained")
+	default:
+	}
+	err := w.pouch.unwrapSecretID(wrapped)
+	if err != nil {
+		return err
+	}
+	close(w.done)
+	return nil
+}
+
+func (w *netWatcher) authorized(r *http.Request) bool {
+	if w.token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) == 1
+}
+
+func (w *netWatcher) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WrappedSecretIDEndpoint {
+		http.NotFound(rw, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !w.authorized(r) {
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	d, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWrappedSecretIDSize))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = w.unwrap(string(d))
+	if err != nil {
+		errorf("Couldn't unwrap secret ID received from %s: %v", r.RemoteAddr, err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	infof("Secret ID received from %s", r.RemoteAddr)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// handleConn reads a wrapped secret ID from a stream, in a single line,
+// and replies with the result
+func (w *netWatcher) handleConn(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(io.LimitReader(conn, maxWrappedSecretIDSize)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+	err = w.unwrap(line)
+	if err != nil {
+		errorf("Couldn't unwrap secret ID received from socket: %v", err)
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+	infof("Secret ID received from socket")
+	fmt.Fprintf(conn, "ok\n")
+}
+
+func (w *netWatcher) serveSocket(ctx context.Context, l net.Listener, errs chan<- error) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-w.done:
+			case <-ctx.Done():
+			default:
+				errs <- err
+			}
+			return
+		}
+		go w.handleConn(conn)
+	}
+}
+
+func (c WrappedSecretIDListenerConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("certificate and key needed to listen on %s", c.Listen)
+	}
+	if c.ClientCAFile == "" && c.TokenFile == "" {
+		return nil, fmt.Errorf("client CA or token needed to listen on %s", c.Listen)
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// WatchListener waits for a wrapped secret ID received over the network
+// channels configured, it returns once one has been unwrapped or the
+// context is done, listeners are closed when it returns
+func (p *pouch) WatchListener(ctx context.Context, c WrappedSecretIDListenerConfig) error {
+	if c.Listen == "" && c.Socket == "" {
+		return fmt.Errorf("no address or socket to listen on")
+	}
+	w := &netWatcher{pouch: p, done: make(chan struct{})}
+	if c.TokenFile != "" {
+		d, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return err
+		}
+		w.token = strings.TrimSpace(string(d))
+		if w.token == "" {
+			// An empty token would authorize any client
+			return fmt.Errorf("token file %s is empty", c.TokenFile)
+		}
+	}
+
+	errs := make(chan error, 2)
+	if c.Listen != "" {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return err
+		}
+		l, err := tls.Listen("tcp", c.Listen, tlsConfig)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Handler: w}
+		defer server.Shutdown(context.Background())
+		go func() {
+			err := server.Serve(l)
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+	if c.Socket != "" {
+		os.Remove(c.Socket)
+		l, err := net.Listen("unix", c.Socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(c.Socket)
+		defer l.Close()
+		err = os.Chmod(c.Socket, 0600)
+		if err != nil {
+			return err
+		}
+		go w.serveSocket(ctx, l, errs)
+	}
+
+	select {
+	case <-w.done:
+		return nil
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetWatcherHTTP(t *testing.T) {
+	v := &DummyVault{T: t, WrappedSecretID: "wrapped", ExpectedSecretID: "secret"}
+	w := &netWatcher{pouch: &pouch{Vault: v}, token: "token", done: make(chan struct{})}
+
+	post := func(token, body string) int {
+		r := httptest.NewRequest("POST", WrappedSecretIDEndpoint, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		w.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("", "wrapped"))
+	assert.Equal(t, http.StatusUnauthorized, post("other", "wrapped"))
+	assert.Equal(t, http.StatusBadRequest, post("token", ""))
+	assert.Equal(t, http.StatusNoContent, post("token", "wrapped\n"))
+	assert.Equal(t, "secret", v.SecretID)
+
+	// Only the first one is used
+	assert.Equal(t, http.StatusBadRequest, post("token", "wrapped"))
+}
+
+func TestWatchListenerSocket(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	socket := filepath.Join(tmpdir, "wrapped.sock")
+
+	v := &DummyVault{T: t, WrappedSecretID: "wrapped", ExpectedSecretID: "secret"}
+	p := &pouch{Vault: v}
+
+	errs := make(chan error)
+	go func() {
+		errs <- p.WatchListener(context.Background(), WrappedSecretIDListenerConfig{Socket: socket})
+	}()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		conn, err = net.Dial("unix", socket)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("wrapped\n"))
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, "ok\n", reply)
+
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't finish")
+	}
+	assert.Equal(t, "secret", v.SecretID)
+
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWatchListenerCancel(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	socket := filepath.Join(tmpdir, "wrapped.sock")
+
+	v := &DummyVault{T: t, WrappedSecretID: "wrapped", ExpectedSecretID: "secret"}
+	p := &pouch{Vault: v}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- p.WatchListener(ctx, WrappedSecretIDListenerConfig{Socket: socket})
+	}()
+
+	for i := 0; i < 50; i++ {
+		_, err = os.Stat(socket)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Secret ID obtained by other channel
+	assert.NoError(t, p.unwrapSecretID("wrapped"))
+	assert.Error(t, p.unwrapSecretID("wrapped"))
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't finish")
+	}
+
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+	_, err = net.Dial("unix", socket)
+	assert.Error(t, err)
+}
+
+func TestWatchListenerEmptyToken(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	tokenFile := filepath.Join(tmpdir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte(" \n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pouch{Vault: &DummyVault{T: t}}
+	err = p.WatchListener(context.Background(), WrappedSecretIDListenerConfig{
+		Socket:    filepath.Join(tmpdir, "wrapped.sock"),
+		TokenFile: tokenFile,
+	})
+	assert.Error(t, err)
+}
@@ -19,15 +19,41 @@ package pouch
 import (
 	"context"
 	"fmt"
-	"log"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+	"github.com/tuenti/pouch/pkg/tracing"
 )
 
 const (
 	DefaultNotifyTimeout = 5 * time.Minute
+
+	DefaultNotifyRetryInterval = 5 * time.Second
+)
+
+// Actions that can be run on services
+const (
+	ServiceActionReload             = "reload"
+	ServiceActionRestart            = "restart"
+	ServiceActionTryRestart         = "try-restart"
+	ServiceActionReloadOrRestart    = "reload-or-restart"
+	ServiceActionTryReloadOrRestart = "try-reload-or-restart"
 )
 
+var serviceActions = []string{
+	ServiceActionReload,
+	ServiceActionRestart,
+	ServiceActionTryRestart,
+	ServiceActionReloadOrRestart,
+	ServiceActionTryReloadOrRestart,
+}
+
 type NotifierRunner interface {
 	Run(context.Context) (string, error)
 }
@@ -36,38 +62,179 @@ type ServiceNotifier struct {
 	Reloader
 
 	Service string
+
+	// Restart the service instead of reloading it
+	Restart bool
+
+	// Action run on the service, one of serviceActions, it has
+	// precedence over Restart, the service is reloaded by default
+	Action string
+
+	// Reload unit definitions before, service can be empty to
+	// only do this
+	DaemonReload bool
+
+	// Signal sent to the service instead of reloading it
+	Signal string
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignal obtains a signal from its name, with or without the SIG
+// prefix, or from its number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+	signal, found := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !found {
+		return 0, fmt.Errorf("unknown signal: %s", s)
+	}
+	return signal, nil
 }
 
 func (n *ServiceNotifier) Run(ctx context.Context) (string, error) {
-	err := n.Reload(ctx, n.Service)
-	return "", err
+	manager, isManager := n.Reloader.(UnitManager)
+	if (n.Restart || n.DaemonReload) && !isManager {
+		return "", fmt.Errorf("service manager doesn't support restarts or daemon reloads")
+	}
+	if n.DaemonReload {
+		err := manager.DaemonReload()
+		if err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case n.Service == "":
+		return "", nil
+	case n.Signal != "":
+		signaler, ok := n.Reloader.(UnitSignaler)
+		if !ok {
+			return "", fmt.Errorf("service manager doesn't support sending signals")
+		}
+		signal, err := parseSignal(n.Signal)
+		if err != nil {
+			return "", err
+		}
+		return "", signaler.Kill(n.Service, signal)
+	case n.Action != "":
+		if !stringInSlice(n.Action, serviceActions) {
+			return "", fmt.Errorf("unknown service action: %s", n.Action)
+		}
+		actioner, ok := n.Reloader.(UnitActioner)
+		if !ok {
+			return "", fmt.Errorf("service manager doesn't support action %s", n.Action)
+		}
+		return "", actioner.Action(ctx, n.Service, n.Action)
+	case n.Restart:
+		return "", manager.Restart(ctx, n.Service)
+	default:
+		return "", n.Reload(ctx, n.Service)
+	}
 }
 
 type CommandNotifier struct {
 	Command string
+
+	// Arguments of the command, if set the command is not run with
+	// a shell
+	Args []string
 }
 
 func (n *CommandNotifier) Run(ctx context.Context) (string, error) {
 	cmd := exec.CommandContext(ctx, "sh", "-c", n.Command)
+	if len(n.Args) > 0 {
+		cmd = exec.CommandContext(ctx, n.Command, n.Args...)
+	}
 	cmd.Stdin = nil
 	out, err := cmd.CombinedOutput()
 	return string(out), err
 }
 
-func (p *pouch) notifierRunner(config NotifierConfig) (NotifierRunner, error) {
+func (p *pouch) notifierRunner(name string, config NotifierConfig, files []string) (NotifierRunner, error) {
 	var runner NotifierRunner
 
 	count := 0
-	if config.Service != "" {
-		if p.Reloader == nil {
-			return nil, fmt.Errorf("service set for notifier, but not service reloader available")
+	if config.Service != "" || config.DaemonReload {
+		reloader, err := p.serviceReloader(config.ServiceManager)
+		if err != nil {
+			return nil, fmt.Errorf("service set for notifier: %v", err)
+		}
+		runner = &ServiceNotifier{
+			Reloader:     reloader,
+			Service:      config.Service,
+			Restart:      config.Restart,
+			Action:       config.Action,
+			DaemonReload: config.DaemonReload,
+			Signal:       config.Signal,
 		}
-		runner = &ServiceNotifier{Reloader: p.Reloader, Service: config.Service}
 		count++
 	}
 
 	if config.Command != "" {
-		runner = &CommandNotifier{Command: config.Command}
+		if config.Host != "" {
+			h, err := p.host(config.Host)
+			if err != nil {
+				return nil, err
+			}
+			if len(config.Args) > 0 {
+				return nil, fmt.Errorf("arguments cannot be used with commands run in remote hosts")
+			}
+			runner = &RemoteCommandNotifier{Host: h, Command: config.Command}
+		} else {
+			runner = &CommandNotifier{Command: config.Command, Args: config.Args}
+		}
+		count++
+	}
+
+	if config.Plugin != "" {
+		pl, err := p.plugin(config.Plugin)
+		if err != nil {
+			return nil, err
+		}
+		notifier, err := pl.Notifier()
+		if err != nil {
+			return nil, err
+		}
+		runner = &PluginNotifier{Notifier: notifier, Name: name, Files: files}
+		count++
+	}
+
+	if config.Process != nil {
+		runner = &ProcessNotifier{Config: *config.Process, Signal: config.Signal}
+		count++
+	}
+
+	if config.Docker != nil {
+		runner = &DockerNotifier{Config: *config.Docker, Signal: config.Signal}
+		count++
+	}
+
+	if config.Kubernetes != nil {
+		runner = &KubernetesNotifier{Config: *config.Kubernetes}
+		count++
+	}
+
+	if config.NATS != nil {
+		runner = &NATSNotifier{Config: *config.NATS, Event: newRotationEvent(name, files)}
+		count++
+	}
+
+	if config.Kafka != nil {
+		runner = &KafkaNotifier{Config: *config.Kafka, Event: newRotationEvent(name, files)}
+		count++
+	}
+
+	if config.Webhook != nil {
+		runner = &WebhookNotifier{Config: *config.Webhook, Event: newRotationEvent(name, files)}
 		count++
 	}
 
@@ -78,17 +245,183 @@ func (p *pouch) notifierRunner(config NotifierConfig) (NotifierRunner, error) {
 	return runner, nil
 }
 
-func (p *pouch) Notify(name string) {
+// notifyOrder sorts notifications so notifiers run after the ones they
+// depend on, and by name otherwise. Dependencies in cycles are ignored.
+func (p *pouch) notifyOrder(pending []NotifyConfig) []NotifyConfig {
+	remaining := make([]NotifyConfig, len(pending))
+	copy(remaining, pending)
+	sort.Slice(remaining, func(i, j int) bool {
+		if remaining[i].Notifier != remaining[j].Notifier {
+			return remaining[i].Notifier < remaining[j].Notifier
+		}
+		return fmt.Sprint(remaining[i]) < fmt.Sprint(remaining[j])
+	})
+
+	// waiting returns true if a notifier depends on others still pending
+	waiting := func(n NotifyConfig) bool {
+		for _, dependency := range p.Notifiers[n.Notifier].After {
+			for _, r := range remaining {
+				if r.Notifier == dependency && r.Notifier != n.Notifier {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	ordered := make([]NotifyConfig, 0, len(remaining))
+	for len(remaining) > 0 {
+		next := -1
+		for i, n := range remaining {
+			if !waiting(n) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			warnf("Cycle found in dependencies of notifiers, ignoring them")
+			return append(ordered, remaining...)
+		}
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return ordered
+}
+
+// withParameters returns the configuration of a notifier with the
+// parameters given by a file
+func (c NotifierConfig) withParameters(n NotifyConfig) NotifierConfig {
+	if n.Unit != "" {
+		c.Service = n.Unit
+	}
+	if n.Signal != "" {
+		c.Signal = n.Signal
+	}
+	if n.Action != "" {
+		c.Action = n.Action
+	}
+	if n.Timeout != "" {
+		c.Timeout = n.Timeout
+	}
+	return c
+}
+
+// requiredServices returns the services that must be active to run a
+// notifier, they can be required with skip_inactive, with the active
+// condition of the file, or with the active condition of the notifier
+func requiredServices(condition string, config NotifierConfig) ([]string, error) {
+	var services []string
+	if config.SkipInactive && config.Service != "" {
+		services = append(services, config.Service)
+	}
+	switch condition {
+	case "":
+	case NotifyConditionActive:
+		if config.Service == "" {
+			return nil, fmt.Errorf("condition '%s' can only be used with services", condition)
+		}
+		services = append(services, config.Service)
+	default:
+		return nil, fmt.Errorf("unknown condition '%s'", condition)
+	}
+	if config.When != nil && config.When.Active != "" {
+		services = append(services, config.When.Active)
+	}
+	return services, nil
+}
+
+// notifyConditions checks if the conditions to run a notifier, besides
+// its window, are met, with the reason if they are not
+func (p *pouch) notifyConditions(condition string, config NotifierConfig, files []string) (bool, string, error) {
+	if when := config.When; when != nil && len(when.Changed) > 0 && !anyFileMatches(when.Changed, files) {
+		return false, "none of the files changed", nil
+	}
+	services, err := requiredServices(condition, config)
+	if err != nil || len(services) == 0 {
+		return err == nil, "", err
+	}
+	reloader, err := p.serviceReloader(config.ServiceManager)
+	if err != nil {
+		return false, "", err
+	}
+	checker, ok := reloader.(ServiceChecker)
+	if !ok {
+		return false, "", fmt.Errorf("service manager cannot check if services are active")
+	}
+	for _, service := range services {
+		active, err := checker.IsActive(service)
+		if err != nil {
+			return false, "", err
+		}
+		if !active {
+			return false, fmt.Sprintf("service %s is not active", service), nil
+		}
+	}
+	return true, "", nil
+}
+
+// anyFileMatches returns true if any file matches any of the patterns
+func anyFileMatches(patterns, files []string) bool {
+	for _, file := range files {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, file); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notifyWindow returns when a notifier can run according to its
+// maintenance window, false if it cannot run in the foreseeable future
+func (p *pouch) notifyWindow(n NotifyConfig, now time.Time) (time.Time, bool) {
+	when := p.Notifiers[n.Notifier].When
+	if when == nil || when.Window == "" {
+		return now, true
+	}
+	window, err := parseCronSchedule(when.Window)
+	if err != nil {
+		warnf("Incorrect window for notifier '%s': %v", n.Notifier, err)
+		return now, true
+	}
+	return window.next(now)
+}
+
+// Notify runs a notifier, files are the files that triggered it. Failed
+// notifications are retried if configured, an error is only returned if
+// they are fatal, or when running once.
+func (p *pouch) Notify(n NotifyConfig, files []string) error {
+	name := n.Notifier
 	notifier, found := p.Notifiers[name]
 	if !found {
-		log.Printf("Couldn't find notifier for '%s'", name)
-		return
+		errorf("Couldn't find notifier for '%s'", name)
+		if p.once {
+			return fmt.Errorf("couldn't find notifier for '%s'", name)
+		}
+		return nil
 	}
+	notifier = notifier.withParameters(n)
 
-	runner, err := p.notifierRunner(notifier)
+	run, reason, err := p.notifyConditions(n.Condition, notifier, files)
 	if err != nil {
-		log.Printf("Couldn't configure notifier for '%s': %v", name, err)
-		return
+		errorf("Couldn't check conditions of notifier '%s': %v", name, err)
+		if notifier.Fatal || p.once {
+			return fmt.Errorf("couldn't check conditions of notifier '%s': %v", name, err)
+		}
+		return nil
+	}
+	if !run {
+		infof("Conditions of notifier '%s' not met (%s), skipping notification", name, reason)
+		return nil
+	}
+
+	runner, err := p.notifierRunner(name, notifier, files)
+	if err != nil {
+		errorf("Couldn't configure notifier for '%s': %v", name, err)
+		if notifier.Fatal || p.once {
+			return fmt.Errorf("couldn't configure notifier for '%s': %v", name, err)
+		}
+		return nil
 	}
 
 	timeout := DefaultNotifyTimeout
@@ -97,16 +430,69 @@ func (p *pouch) Notify(name string) {
 		if err == nil {
 			timeout = t
 		} else {
-			log.Printf("Incorrect timeout: %s", err)
+			warnf("Incorrect timeout: %s", err)
+		}
+	}
+	retryInterval := DefaultNotifyRetryInterval
+	if notifier.RetryInterval != "" {
+		t, err := time.ParseDuration(notifier.RetryInterval)
+		if err == nil {
+			retryInterval = t
+		} else {
+			warnf("Incorrect retry interval: %s", err)
 		}
 	}
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
 
-	out, err := runner.Run(ctx)
+	labels := metrics.Labels{"notifier": name}
+	p.Metrics.Add(MetricNotifications, labels, 1)
+
+	endSpan := p.startSpan("notify", tracing.SpanKindInternal, tracing.Attributes{"notifier": name, "files": len(files)})
+	var out string
+	for attempt := 0; attempt <= notifier.Retries; attempt++ {
+		if attempt > 0 {
+			warnf("Notification to '%s' failed: %s, retrying (%d/%d)", name, err, attempt, notifier.Retries)
+			time.Sleep(retryInterval)
+		}
+		p.span.SetAttribute("attempts", attempt+1)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		out, err = runner.Run(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	endSpan(err)
 	if err != nil {
-		log.Printf("Notification to '%s' failed: %s", name, err)
+		p.Metrics.Add(MetricNotificationsFailed, labels, 1)
+		p.event(Event{
+			Type:     EventNotification,
+			Notifier: name,
+			Message:  fmt.Sprintf("Notification to '%s' failed: %s", name, err),
+			Details:  notificationDetails(notifier, false),
+		})
 		if len(out) > 0 {
-			log.Println(string(out))
+			logf(LogWarn, LogFields{"notifier": name}, "%s", out)
 		}
+		if notifier.Fatal || p.once {
+			return fmt.Errorf("notification to '%s' failed: %v", name, err)
+		}
+		return nil
+	}
+	p.event(Event{
+		Type:     EventNotification,
+		Notifier: name,
+		Message:  fmt.Sprintf("Notification to '%s' done", name),
+		Details:  notificationDetails(notifier, true),
+	})
+	return nil
+}
+
+// notificationDetails returns the details of notification events, with
+// the unit notified, if any
+func notificationDetails(config NotifierConfig, success bool) map[string]interface{} {
+	details := map[string]interface{}{"success": success}
+	if config.Service != "" {
+		details["unit"] = config.Service
 	}
+	return details
 }
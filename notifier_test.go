@@ -0,0 +1,328 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingUnitManager struct {
+	fakeServices
+
+	reloaded []string
+	actions  []string
+	killed   map[string]syscall.Signal
+}
+
+func (m *recordingUnitManager) Reload(ctx context.Context, name string) error {
+	m.reloaded = append(m.reloaded, name)
+	return nil
+}
+
+func (m *recordingUnitManager) Action(ctx context.Context, name, action string) error {
+	m.actions = append(m.actions, action+" "+name)
+	return nil
+}
+
+func (m *recordingUnitManager) Kill(name string, signal syscall.Signal) error {
+	if m.killed == nil {
+		m.killed = make(map[string]syscall.Signal)
+	}
+	m.killed[name] = signal
+	return nil
+}
+
+func TestNotifyParameters(t *testing.T) {
+	manager := &recordingUnitManager{fakeServices: fakeServices{"nginx.service": true}}
+	p := &pouch{
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"nginx": {Service: "nginx.service"},
+		},
+	}
+
+	p.Notify(NotifyConfig{Notifier: "nginx"}, []string{"/foo"})
+	assert.Equal(t, []string{"nginx.service"}, manager.reloaded)
+
+	p.Notify(NotifyConfig{Notifier: "nginx", Signal: "SIGUSR1"}, []string{"/foo"})
+	assert.Equal(t, syscall.SIGUSR1, manager.killed["nginx.service"])
+
+	// Stopped units are not notified with the active condition
+	p.Notify(NotifyConfig{Notifier: "nginx", Unit: "other.service", Condition: NotifyConditionActive}, []string{"/foo"})
+	assert.Equal(t, []string{"nginx.service"}, manager.reloaded)
+
+	p.Notify(NotifyConfig{Notifier: "nginx", Unit: "nginx.service", Condition: NotifyConditionActive}, []string{"/foo"})
+	assert.Equal(t, []string{"nginx.service", "nginx.service"}, manager.reloaded)
+}
+
+func TestNotifyServiceActions(t *testing.T) {
+	manager := &recordingUnitManager{fakeServices: fakeServices{"nginx.service": true}}
+	p := &pouch{
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"nginx":   {Service: "nginx.service", Action: ServiceActionTryRestart},
+			"stopped": {Service: "stopped.service", SkipInactive: true},
+			"unknown": {Service: "nginx.service", Action: "stop"},
+		},
+	}
+
+	p.Notify(NotifyConfig{Notifier: "nginx"}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "nginx", Action: ServiceActionReloadOrRestart}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "unknown"}, []string{"/foo"})
+	assert.Equal(t, []string{"try-restart nginx.service", "reload-or-restart nginx.service"}, manager.actions)
+
+	p.Notify(NotifyConfig{Notifier: "stopped"}, []string{"/foo"})
+	assert.Empty(t, manager.reloaded)
+}
+
+func TestNotifyServiceManagers(t *testing.T) {
+	systemd := &recordingUnitManager{}
+	s6 := &recordingUnitManager{}
+	p := &pouch{
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: systemd,
+		Notifiers: map[string]NotifierConfig{
+			"nginx":   {Service: "nginx.service"},
+			"haproxy": {Service: "haproxy", ServiceManager: "s6"},
+			"unknown": {Service: "haproxy", ServiceManager: "runit"},
+		},
+	}
+	p.AddServiceReloader("systemd", systemd)
+	p.AddServiceReloader("s6", s6)
+
+	p.Notify(NotifyConfig{Notifier: "nginx"}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "haproxy"}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "unknown"}, []string{"/foo"})
+	assert.Equal(t, []string{"nginx.service"}, systemd.reloaded)
+	assert.Equal(t, []string{"haproxy"}, s6.reloaded)
+}
+
+func TestNotifyDebounce(t *testing.T) {
+	manager := &recordingUnitManager{}
+	p := &pouch{
+		State:    &PouchState{},
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"nginx": {Service: "nginx.service", Debounce: "50ms"},
+		},
+	}
+
+	p.addForNotify("/foo", NotifyConfig{Notifier: "nginx"})
+	assert.NoError(t, p.notifyPending())
+	p.addForNotify("/bar", NotifyConfig{Notifier: "nginx"})
+	assert.NoError(t, p.notifyPending())
+	assert.Empty(t, manager.reloaded)
+
+	next, found := p.nextNotification()
+	assert.True(t, found)
+	time.Sleep(time.Until(next))
+	assert.NoError(t, p.notifyPending())
+	assert.Equal(t, []string{"nginx.service"}, manager.reloaded)
+
+	_, found = p.nextNotification()
+	assert.False(t, found)
+}
+
+func TestNotifyOrder(t *testing.T) {
+	manager := &recordingUnitManager{}
+	p := &pouch{
+		State:    &PouchState{},
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"api":      {Service: "api", After: []string{"database"}},
+			"database": {Service: "database"},
+			"frontend": {Service: "frontend", After: []string{"api", "cache"}},
+			"cache":    {Service: "cache"},
+		},
+	}
+
+	for _, name := range []string{"frontend", "api", "cache", "database"} {
+		p.addForNotify("/foo", NotifyConfig{Notifier: name})
+	}
+	assert.NoError(t, p.notifyPending())
+	assert.Equal(t, []string{"cache", "database", "api", "frontend"}, manager.reloaded)
+
+	// Cycles don't prevent notifications
+	manager.reloaded = nil
+	p.Notifiers["database"] = NotifierConfig{Service: "database", After: []string{"frontend"}}
+	for _, name := range []string{"frontend", "api", "database"} {
+		p.addForNotify("/foo", NotifyConfig{Notifier: name})
+	}
+	assert.NoError(t, p.notifyPending())
+	assert.Len(t, manager.reloaded, 3, "all notifiers should run")
+}
+
+func TestNotifyUnchangedFiles(t *testing.T) {
+	manager := &recordingUnitManager{}
+	p := &pouch{
+		State:    &PouchState{},
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"nginx": {Service: "nginx.service"},
+		},
+	}
+	nginx := NotifyConfig{Notifier: "nginx"}
+
+	p.State.SetFileChecksum("/foo", "foo")
+	p.addForNotify("/foo", nginx)
+	assert.NoError(t, p.notifyPending())
+
+	// Content changed and went back before notifying
+	p.State.SetFileChecksum("/foo", "bar")
+	p.State.SetFileChecksum("/foo", "foo")
+	p.addForNotify("/foo", nginx)
+	assert.NoError(t, p.notifyPending())
+	assert.Equal(t, []string{"nginx.service"}, manager.reloaded)
+
+	p.State.SetFileChecksum("/foo", "bar")
+	p.addForNotify("/foo", nginx)
+	assert.NoError(t, p.notifyPending())
+	assert.Equal(t, []string{"nginx.service", "nginx.service"}, manager.reloaded)
+
+	// Files without checksums are always notified
+	p.addForNotify("/dir", nginx)
+	assert.NoError(t, p.notifyPending())
+	assert.Len(t, manager.reloaded, 3, "notifier should run")
+}
+
+func TestNotifierConditions(t *testing.T) {
+	manager := &recordingUnitManager{fakeServices: fakeServices{"nginx.service": true}}
+	p := &pouch{
+		State:    &PouchState{},
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"certs":   {Service: "certs", When: &NotifierConditions{Changed: []string{"/etc/ssl/*.pem"}}},
+			"active":  {Service: "active", When: &NotifierConditions{Active: "nginx.service"}},
+			"stopped": {Service: "stopped", When: &NotifierConditions{Active: "other.service"}},
+			"never":   {Service: "never", When: &NotifierConditions{Window: "0 0 30 2 *"}},
+		},
+	}
+
+	p.Notify(NotifyConfig{Notifier: "certs"}, []string{"/etc/ssl/app.key"})
+	p.Notify(NotifyConfig{Notifier: "certs"}, []string{"/etc/ssl/app.key", "/etc/ssl/app.pem"})
+	p.Notify(NotifyConfig{Notifier: "active"}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "stopped"}, []string{"/foo"})
+	assert.Equal(t, []string{"certs", "active"}, manager.reloaded)
+
+	// Notifiers out of their windows are kept pending
+	p.addForNotify("/foo", NotifyConfig{Notifier: "never"})
+	assert.NoError(t, p.notifyPending())
+	assert.Len(t, p.pendingNotifiers, 1)
+	_, found := p.nextNotification()
+	assert.False(t, found)
+}
+
+func TestNotifierActiveServices(t *testing.T) {
+	manager := &recordingUnitManager{fakeServices: fakeServices{"nginx.service": true}}
+	p := &pouch{
+		State:    &PouchState{},
+		Metrics:  newMetricsRegistry(),
+		Events:   NewEventLog(0),
+		Reloader: manager,
+		Notifiers: map[string]NotifierConfig{
+			"skip":      {Service: "other.service", SkipInactive: true},
+			"condition": {Service: "other.service"},
+			"when":      {Service: "nginx.service", When: &NotifierConditions{Active: "other.service"}},
+			"all":       {Service: "nginx.service", SkipInactive: true, When: &NotifierConditions{Active: "nginx.service"}},
+		},
+	}
+
+	// Notifications skipped for inactive services are not recorded,
+	// whatever the way of requiring them to be active
+	p.Notify(NotifyConfig{Notifier: "skip"}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "condition", Condition: NotifyConditionActive}, []string{"/foo"})
+	p.Notify(NotifyConfig{Notifier: "when"}, []string{"/foo"})
+	assert.Empty(t, manager.reloaded)
+	assert.Empty(t, p.Events.Recent())
+
+	p.Notify(NotifyConfig{Notifier: "all", Condition: NotifyConditionActive}, []string{"/foo"})
+	assert.Equal(t, []string{"nginx.service"}, manager.reloaded)
+
+	services, err := requiredServices(NotifyConditionActive, p.Notifiers["all"])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nginx.service", "nginx.service", "nginx.service"}, services)
+	_, err = requiredServices("running", p.Notifiers["all"])
+	assert.Error(t, err)
+	_, err = requiredServices(NotifyConditionActive, NotifierConfig{Command: "true"})
+	assert.Error(t, err)
+}
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"HUP":     syscall.SIGHUP,
+		"SIGUSR2": syscall.SIGUSR2,
+		"term":    syscall.SIGTERM,
+		"9":       syscall.SIGKILL,
+	} {
+		signal, err := parseSignal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, signal)
+	}
+	_, err := parseSignal("FOO")
+	assert.Error(t, err)
+}
+
+func TestCommandNotifierRetries(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Fails until it has been run three times
+	counter := path.Join(tmpdir, "counter")
+	script := `echo >> ` + counter + `; test $(wc -l < ` + counter + `) -ge 3`
+	p := &pouch{
+		Metrics: newMetricsRegistry(),
+		Events:  NewEventLog(0),
+		Notifiers: map[string]NotifierConfig{
+			"flaky":   {Command: "sh", Args: []string{"-c", script}, Retries: 2, RetryInterval: "10ms"},
+			"fatal":   {Command: "false", Fatal: true, Retries: 1, RetryInterval: "10ms"},
+			"logged":  {Command: "false"},
+			"timeout": {Command: "sleep", Args: []string{"5"}, Timeout: "50ms", Fatal: true},
+		},
+	}
+
+	assert.NoError(t, p.Notify(NotifyConfig{Notifier: "flaky"}, []string{"/foo"}))
+	d, _ := ioutil.ReadFile(counter)
+	assert.Equal(t, "\n\n\n", string(d))
+
+	assert.Error(t, p.Notify(NotifyConfig{Notifier: "fatal"}, []string{"/foo"}))
+	assert.NoError(t, p.Notify(NotifyConfig{Notifier: "logged"}, []string{"/foo"}))
+	assert.Error(t, p.Notify(NotifyConfig{Notifier: "timeout"}, []string{"/foo"}))
+}
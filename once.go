@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+// RunOnce logs in, reads all the secrets, writes all the files and runs
+// the notifiers of the files changed, and returns an error if any of
+// them fails. Nothing is kept running, so it can be used in cron jobs,
+// init containers and provisioning pipelines.
+func (p *pouch) RunOnce(ctx context.Context) (err error) {
+	defer recoverPanic(&err)
+	p.once = true
+
+	err = p.login()
+	if err != nil {
+		return err
+	}
+	err = p.loadSecretsAndFiles()
+	if err != nil {
+		return err
+	}
+	err = p.notifyAllPending(ctx)
+
+	p.updateMetrics()
+	p.writeProvenance()
+	saveErr := p.saveState()
+	if err == nil && saveErr != nil {
+		err = fmt.Errorf("couldn't save state: %v", saveErr)
+	}
+	return err
+}
+
+// notifyAllPending runs the pending notifiers without waiting for their
+// debounce periods, notifiers out of their maintenance windows are
+// skipped. All of them are run even if some fail, and the first error
+// is returned.
+func (p *pouch) notifyAllPending(ctx context.Context) error {
+	var due []NotifyConfig
+	now := time.Now()
+	for pending := range p.pendingNotifiers {
+		if window, found := p.notifyWindow(pending, now); !found || window.After(now) {
+			warnf("Notifier '%s' is out of its maintenance window, skipping it", pending.Notifier)
+			continue
+		}
+		due = append(due, pending)
+	}
+	var firstErr error
+	for _, pending := range p.notifyOrder(due) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		files := p.pendingNotifiers[pending]
+		delete(p.pendingNotifiers, pending)
+		delete(p.pendingSince, pending)
+		if p.filesUnchangedSinceNotified(pending, files) {
+			infof("Files notified by '%s' didn't change since last notification, skipping it", pending.Notifier)
+			p.Metrics.Add(MetricNotificationsSkipped, metrics.Labels{"notifier": pending.Notifier}, 1)
+			continue
+		}
+		err := p.Notify(pending, files)
+		p.recordNotifiedChecksums(pending, files)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
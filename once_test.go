@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunOnce(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	v := &DummyVault{
+		T:             t,
+		ExpectedToken: "token",
+		Token:         "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/foo": &api.Secret{Data: map[string]interface{}{"password": "newpassword"}},
+		},
+	}
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/secret/foo", HTTPMethod: "GET"},
+	}
+	filePath := path.Join(tmpdir, "foo")
+	notified := path.Join(tmpdir, "notified")
+	files := []FileConfig{{
+		Path:     filePath,
+		Template: `{{ secret "foo" "password" }}`,
+		Notify:   NotifyNames("touch"),
+	}}
+	notifiers := map[string]NotifierConfig{
+		"touch": {Command: "touch " + notified},
+	}
+
+	// Secrets in the state are read again
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "oldpassword"}})
+	p := NewPouch(state, v, secrets, files, notifiers).(*pouch)
+	assert.NoError(t, p.RunOnce(context.Background()))
+	d, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, "newpassword", string(d))
+	_, err = os.Stat(notified)
+	assert.NoError(t, err, "notifier should have been run")
+	assert.Equal(t, "newpassword", state.Secrets["foo"].Data["password"])
+
+	// Failed notifications are errors
+	os.Remove(filePath)
+	notifiers["touch"] = NotifierConfig{Command: "false"}
+	p = NewPouch(NewState(""), v, secrets, files, notifiers).(*pouch)
+	assert.Error(t, p.RunOnce(context.Background()))
+
+	// Unknown notifiers are errors
+	os.Remove(filePath)
+	files[0].Notify = NotifyNames("unknown")
+	p = NewPouch(NewState(""), v, secrets, files, notifiers).(*pouch)
+	assert.Error(t, p.RunOnce(context.Background()))
+
+	// Notifiers that cannot be configured are errors
+	os.Remove(filePath)
+	files[0].Notify = NotifyNames("remote")
+	notifiers["remote"] = NotifierConfig{Command: "true", Host: "unknown"}
+	p = NewPouch(NewState(""), v, secrets, files, notifiers).(*pouch)
+	assert.Error(t, p.RunOnce(context.Background()))
+	files[0].Notify = NotifyNames("touch")
+
+	// Failed writes are errors
+	os.Remove(filePath)
+	files[0].CheckCmd = "false"
+	p = NewPouch(NewState(""), v, secrets, files, nil).(*pouch)
+	assert.Error(t, p.RunOnce(context.Background()))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// Orphaned files are removed, with their backups
+	OrphanedFilesRemove = "remove"
+
+	// Orphaned files are only logged, as a dry run
+	OrphanedFilesReport = "report"
+)
+
+// OrphanedFiles sets what to do with files written by pouch that are not
+// in the configuration anymore
+func (p *pouch) OrphanedFiles(mode string) error {
+	switch mode {
+	case "", OrphanedFilesRemove:
+		p.reportOrphans = false
+	case OrphanedFilesReport:
+		p.reportOrphans = true
+	default:
+		return fmt.Errorf("unknown mode for orphaned files: %s", mode)
+	}
+	return nil
+}
+
+// orphanedFiles returns the files recorded as written by pouch that are
+// not managed anymore
+func (p *pouch) orphanedFiles() []string {
+	managed := make(map[string]bool)
+	for _, path := range p.managedFiles() {
+		managed[path] = true
+	}
+	var orphans []string
+	for path := range p.State.ManagedFiles {
+		if !managed[path] {
+			orphans = append(orphans, path)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// cleanOrphanedFiles removes files written by previous configurations,
+// so stale credentials don't linger, it must be called after resolving
+// all files
+func (p *pouch) cleanOrphanedFiles() {
+	for _, path := range p.orphanedFiles() {
+		if p.reportOrphans {
+			warnf("File '%s' is not managed anymore, it should be removed", path)
+			continue
+		}
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			errorf("Couldn't remove orphaned file '%s': %v", path, err)
+			continue
+		}
+		infof("Removed orphaned file '%s'", path)
+		p.State.DeleteManagedFile(path)
+
+		backups, _ := filepath.Glob(path + ".bak.*")
+		for _, backup := range backups {
+			err := os.Remove(backup)
+			if err != nil {
+				errorf("Couldn't remove backup of orphaned file '%s': %v", backup, err)
+			}
+		}
+	}
+}
+
+// checkUnmanaged fails if a file is going to overwrite a file that was
+// not written by pouch, to avoid overwriting files of other tools
+func (p *pouch) checkUnmanaged(fc FileConfig) error {
+	if fc.Force || p.adoptFiles || p.State.ManagedFiles[fc.Path] {
+		return nil
+	}
+	_, err := os.Lstat(fc.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("'%s' already exists and it was not written by pouch, set force to overwrite it", fc.Path)
+}
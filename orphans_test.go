@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrphanedFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	old := path.Join(tmpdir, "old")
+	for _, f := range []string{old, old + ".bak.1"} {
+		assert.NoError(t, ioutil.WriteFile(f, []byte("stale"), 0600))
+	}
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	state.AddManagedFile(old)
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "new"), Template: `{{ secret "foo" "password" }}`},
+	}
+	p := NewPouch(state, nil, nil, files, nil).(*pouch)
+	for _, fc := range p.Files {
+		assert.NoError(t, p.resolveFile(fc))
+	}
+	assert.Equal(t, []string{old}, p.orphanedFiles())
+
+	assert.NoError(t, p.OrphanedFiles(OrphanedFilesReport))
+	p.cleanOrphanedFiles()
+	_, err = os.Stat(old)
+	assert.NoError(t, err, "orphaned files are only reported")
+
+	assert.NoError(t, p.OrphanedFiles(OrphanedFilesRemove))
+	p.cleanOrphanedFiles()
+	for _, f := range []string{old, old + ".bak.1"} {
+		_, err = os.Stat(f)
+		assert.True(t, os.IsNotExist(err), f)
+	}
+	assert.Equal(t, map[string]bool{path.Join(tmpdir, "new"): true}, state.ManagedFiles)
+
+	assert.Error(t, p.OrphanedFiles("unknown"))
+}
+
+func TestUnmanagedFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{Path: path.Join(tmpdir, "foo"), Template: `{{ secret "foo" "password" }}`}
+
+	assert.NoError(t, ioutil.WriteFile(fc.Path, []byte("other"), 0600))
+	assert.Error(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "other", string(d))
+
+	// Files with the same content are adopted
+	assert.NoError(t, ioutil.WriteFile(fc.Path, []byte("foo"), 0600))
+	assert.NoError(t, p.resolveFile(fc))
+	assert.True(t, state.ManagedFiles[fc.Path])
+
+	fc.Path = path.Join(tmpdir, "bar")
+	fc.Force = true
+	assert.NoError(t, ioutil.WriteFile(fc.Path, []byte("other"), 0600))
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ = ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo", string(d))
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// lookupID resolves a user or group given by name or numeric id, -1 is
+// returned if it is not set, so it is not changed
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+func lookupUID(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGID(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// Ownership returns the ids of the owner and group configured for the
+// file, -1 for the ones not set
+func (fc FileConfig) Ownership() (uid, gid int, err error) {
+	uid, err = lookupID(fc.Owner, lookupUID)
+	if err != nil {
+		return -1, -1, fmt.Errorf("unknown owner for file '%s': %v", fc.Path, err)
+	}
+	gid, err = lookupID(fc.Group, lookupGID)
+	if err != nil {
+		return -1, -1, fmt.Errorf("unknown group for file '%s': %v", fc.Path, err)
+	}
+	return uid, gid, nil
+}
+
+// chownFile sets the owner and group of a written file, it is done after
+// every write so ownership is restored if it was changed
+func chownFile(fc FileConfig) error {
+	if fc.Owner == "" && fc.Group == "" {
+		return nil
+	}
+	uid, gid, err := fc.Ownership()
+	if err != nil {
+		return err
+	}
+	err = os.Chown(fc.Path, uid, gid)
+	if err != nil {
+		return fmt.Errorf("couldn't change ownership of '%s': %v", fc.Path, err)
+	}
+	return nil
+}
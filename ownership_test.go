@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileOwnership(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("current user unknown")
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Skip("current group unknown")
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	cases := []struct {
+		owner, group  string
+		expectedUID   int
+		expectedGID   int
+		expectedError bool
+	}{
+		{"", "", -1, -1, false},
+		{current.Uid, "", uid, -1, false},
+		{current.Username, group.Name, uid, gid, false},
+		{"", current.Gid, -1, gid, false},
+		{"pouch-nonexistent-user", "", -1, -1, true},
+		{"", "pouch-nonexistent-group", -1, -1, true},
+	}
+	for _, c := range cases {
+		fc := FileConfig{Path: "/tmp/foo", Owner: c.owner, Group: c.group}
+		u, g, err := fc.Ownership()
+		if c.expectedError {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, c.expectedUID, u)
+		assert.Equal(t, c.expectedGID, g)
+	}
+}
+
+func TestResolveFileOwnership(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := NewPouch(NewState(""), nil, nil, nil, nil).(*pouch)
+	filePath := path.Join(tmpdir, "foo")
+	fc := FileConfig{
+		Path:     filePath,
+		Template: "foo",
+		Owner:    strconv.Itoa(os.Getuid()),
+		Group:    strconv.Itoa(os.Getgid()),
+	}
+	err = p.resolveFile(fc)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(filePath)
+	if assert.NoError(t, err) {
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(os.Getuid()), stat.Uid)
+		assert.Equal(t, uint32(os.Getgid()), stat.Gid)
+	}
+
+	fc.Owner = "pouch-nonexistent-user"
+	assert.Error(t, p.resolveFile(fc))
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of the files with partial templates in templates directories
+const PartialTemplateExtension = ".tmpl"
+
+// AddTemplate adds a partial template that can be included in go
+// templates of files with the template action
+func (p *pouch) AddTemplate(name, source string) {
+	if p.partials == nil {
+		p.partials = make(map[string]string)
+	}
+	p.partials[name] = source
+}
+
+// LoadTemplatesDir reads the partial templates of a directory, named
+// after their files without extension
+func LoadTemplatesDir(dir string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+PartialTemplateExtension))
+	if err != nil {
+		return nil, err
+	}
+	templates := make(map[string]string)
+	for _, path := range paths {
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read template: %v", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), PartialTemplateExtension)
+		templates[name] = string(d)
+	}
+	return templates, nil
+}
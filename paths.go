@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// HasPathTemplate returns true if the path of the file is a template
+func (fc FileConfig) HasPathTemplate() bool {
+	return strings.Contains(fc.Path, "{{")
+}
+
+// renderPath renders the path of a file, templates can use the functions
+// available for secret data, and read values of secrets with the secret
+// function
+func (p *pouch) renderPath(fc FileConfig, data interface{}) (string, error) {
+	funcs := template.FuncMap{}
+	for name, f := range sprigFuncMap {
+		funcs[name] = f
+	}
+	for name, f := range dataFuncMap {
+		funcs[name] = f
+	}
+	funcs["secret"] = func(name, key string) (interface{}, error) {
+		secret, found := p.State.Secrets[name]
+		if !found {
+			return nil, fmt.Errorf("unknown secret: %s", name)
+		}
+		value, found := secret.Data[key]
+		if !found {
+			return nil, fmt.Errorf("unkown key in secret '%s': %s", name, key)
+		}
+		return value, nil
+	}
+	t, err := template.New("path").Funcs(funcs).Parse(fc.Path)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSpace(b.String())
+	if path == "" {
+		return "", fmt.Errorf("empty path rendered from '%s'", fc.Path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// addExpandedFile adds a file with its path rendered
+func (p *pouch) addExpandedFile(fc FileConfig, each *secretKey) error {
+	var data interface{}
+	if each != nil {
+		data = *each
+	}
+	path, err := p.renderPath(fc, data)
+	if err != nil {
+		return fmt.Errorf("couldn't render path '%s': %v", fc.Path, err)
+	}
+	if _, found := p.Files[path]; found {
+		return fmt.Errorf("more than one file rendered in '%s'", path)
+	}
+	expanded := fc
+	expanded.Path = path
+	expanded.ForEach = ""
+	expanded.forEach = each
+	if each != nil {
+		expanded.Secrets = append([]string{each.Secret}, fc.Secrets...)
+	}
+	p.Files[path] = expanded
+	return nil
+}
+
+// expandFiles renders templated paths of files, files with for_each are
+// expanded to one file for each secret obtained from their glob secret.
+// Paths are rendered once, after reading the secrets for the first time.
+func (p *pouch) expandFiles() error {
+	var templated []FileConfig
+	for path, fc := range p.Files {
+		if fc.ForEach == "" && !fc.HasPathTemplate() {
+			continue
+		}
+		templated = append(templated, fc)
+		delete(p.Files, path)
+	}
+	sort.Slice(templated, func(i, j int) bool { return templated[i].Path < templated[j].Path })
+
+	for _, fc := range templated {
+		if fc.ForEach == "" {
+			err := p.addExpandedFile(fc, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, found := p.secretGlobs[fc.ForEach]; !found {
+			return fmt.Errorf("file '%s' is expanded for '%s', that is not a glob secret", fc.Path, fc.ForEach)
+		}
+		prefix := GlobSecretName(fc.ForEach, "")
+		var names []string
+		for name := range p.Secrets {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err := p.addExpandedFile(fc, &secretKey{Secret: name, Key: strings.TrimPrefix(name, prefix)})
+			if err != nil {
+				return err
+			}
+		}
+		debugf("File '%s' expanded to %d files", fc.Path, len(names))
+	}
+	return nil
+}
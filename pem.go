@@ -0,0 +1,209 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Functions to manipulate PEM bundles in templates, as found in responses
+// of Vault PKI, before giving them to applications. Functions receiving
+// bundles accept several arguments, that are concatenated.
+var pemFuncMap = template.FuncMap{
+	"pemLeaf":         pemLeaf,
+	"pemChain":        pemChain,
+	"pemCertificates": pemCertificates,
+	"pemPrivateKey":   pemPrivateKey,
+	"pemOrderChain":   pemOrderChain,
+	"certFingerprint": certFingerprint,
+	"certExpiry":      certExpiry,
+}
+
+// pemBlocks decodes the PEM blocks of the given bundles
+func pemBlocks(bundles []string) []*pem.Block {
+	var blocks []*pem.Block
+	rest := []byte(strings.Join(bundles, "\n"))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return blocks
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func encodePEM(blocks []*pem.Block) string {
+	var b bytes.Buffer
+	for _, block := range blocks {
+		pem.Encode(&b, block)
+	}
+	return b.String()
+}
+
+func certificateBlocks(bundles []string) ([]*pem.Block, error) {
+	var certificates []*pem.Block
+	for _, block := range pemBlocks(bundles) {
+		if block.Type == "CERTIFICATE" {
+			certificates = append(certificates, block)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certificates, nil
+}
+
+// pemCertificates returns only the certificates of the bundles
+func pemCertificates(bundles ...string) (string, error) {
+	certificates, err := certificateBlocks(bundles)
+	if err != nil {
+		return "", err
+	}
+	return encodePEM(certificates), nil
+}
+
+// pemLeaf returns the first certificate of the bundles
+func pemLeaf(bundles ...string) (string, error) {
+	certificates, err := certificateBlocks(bundles)
+	if err != nil {
+		return "", err
+	}
+	return encodePEM(certificates[:1]), nil
+}
+
+// pemChain returns the certificates of the bundles after the first one,
+// that can be empty
+func pemChain(bundles ...string) (string, error) {
+	certificates, err := certificateBlocks(bundles)
+	if err != nil {
+		return "", err
+	}
+	return encodePEM(certificates[1:]), nil
+}
+
+// pemPrivateKey returns only the private key of the bundles
+func pemPrivateKey(bundles ...string) (string, error) {
+	for _, block := range pemBlocks(bundles) {
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return encodePEM([]*pem.Block{block}), nil
+		}
+	}
+	return "", fmt.Errorf("no private key found")
+}
+
+// pemOrderChain orders the certificates of the bundles from the leaf to
+// the root, following their issuers, certificates that are not part of
+// the chain of the leaf are discarded
+func pemOrderChain(bundles ...string) (string, error) {
+	blocks, err := certificateBlocks(bundles)
+	if err != nil {
+		return "", err
+	}
+	certificates := make([]*x509.Certificate, len(blocks))
+	for i, block := range blocks {
+		certificates[i], err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The leaf is the only certificate that doesn't issue others
+	leaf := -1
+	for i, c := range certificates {
+		issuer := false
+		for j, other := range certificates {
+			if i != j && !isSelfSigned(other) && bytes.Equal(other.RawIssuer, c.RawSubject) {
+				issuer = true
+				break
+			}
+		}
+		if issuer {
+			continue
+		}
+		if leaf >= 0 {
+			return "", fmt.Errorf("more than one leaf certificate found")
+		}
+		leaf = i
+	}
+	if leaf < 0 {
+		return "", fmt.Errorf("no leaf certificate found")
+	}
+
+	ordered := []*pem.Block{blocks[leaf]}
+	used := map[int]bool{leaf: true}
+	for current := certificates[leaf]; !isSelfSigned(current); {
+		next := -1
+		for i, c := range certificates {
+			if !used[i] && bytes.Equal(current.RawIssuer, c.RawSubject) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		ordered = append(ordered, blocks[next])
+		used[next] = true
+		current = certificates[next]
+	}
+	return encodePEM(ordered), nil
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject)
+}
+
+func parseFirstCertificate(bundle string) (*x509.Certificate, error) {
+	blocks, err := certificateBlocks([]string{bundle})
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(blocks[0].Bytes)
+}
+
+// certFingerprint returns the SHA256 fingerprint of the first certificate
+// of the bundle, in the format used by openssl
+func certFingerprint(bundle string) (string, error) {
+	c, err := parseFirstCertificate(bundle)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(c.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// certExpiry returns the expiration time of the first certificate of the
+// bundle
+func certExpiry(bundle string) (time.Time, error) {
+	c, err := parseFirstCertificate(bundle)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.NotAfter, nil
+}
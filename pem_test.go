@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+func newTestCertificate(t *testing.T, name string, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour).Truncate(time.Second),
+		IsCA:                  issuer == nil || name != "leaf",
+		BasicConstraintsValid: true,
+	}
+	parent, signer := template, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+}
+
+func TestPEMFunctions(t *testing.T) {
+	root := newTestCertificate(t, "root", nil)
+	intermediate := newTestCertificate(t, "intermediate", root)
+	leaf := newTestCertificate(t, "leaf", intermediate)
+	keyDER, _ := x509.MarshalECPrivateKey(leaf.key)
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	bundle := leaf.pem + keyPEM + intermediate.pem
+
+	content, err := pemLeaf(bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.pem, content)
+
+	content, err = pemChain(bundle, root.pem)
+	assert.NoError(t, err)
+	assert.Equal(t, intermediate.pem+root.pem, content)
+
+	content, err = pemCertificates(bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.pem+intermediate.pem, content)
+
+	content, err = pemPrivateKey(bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, keyPEM, content)
+	_, err = pemPrivateKey(leaf.pem)
+	assert.Error(t, err)
+
+	content, err = pemOrderChain(root.pem, leaf.pem, intermediate.pem)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.pem+intermediate.pem+root.pem, content)
+	_, err = pemOrderChain(root.pem, leaf.pem, newTestCertificate(t, "other", nil).pem)
+	assert.Error(t, err)
+
+	fingerprint, err := certFingerprint(bundle)
+	assert.NoError(t, err)
+	assert.Len(t, strings.Split(fingerprint, ":"), 32)
+
+	expiry, err := certExpiry(bundle)
+	assert.NoError(t, err)
+	assert.Equal(t, leaf.cert.NotAfter, expiry)
+
+	_, err = pemLeaf(keyPEM)
+	assert.Error(t, err)
+}
@@ -0,0 +1,361 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acme is a minimal client of the ACME protocol (RFC 8555), as
+// implemented by Let's Encrypt, to obtain certificates
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	LetsEncryptURL        = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusValid      = "valid"
+	StatusInvalid    = "invalid"
+
+	// Period to check again objects still being processed
+	PollPeriod = 2 * time.Second
+
+	nonceHeader   = "Replay-Nonce"
+	badNonceError = "urn:ietf:params:acme:error:badNonce"
+)
+
+// Problem is an error returned by the ACME server
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type Challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+type Authorization struct {
+	Identifier Identifier  `json:"identifier"`
+	Status     string      `json:"status"`
+	Challenges []Challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard"`
+}
+
+type Order struct {
+	URL string `json:"-"`
+
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Problem `json:"error,omitempty"`
+}
+
+// Client of an ACME server, requests are signed with the account key
+type Client struct {
+	DirectoryURL string
+	Key          *ecdsa.PrivateKey
+	HTTPClient   *http.Client
+
+	dir   *directory
+	nonce string
+	kid   string
+}
+
+func NewClient(directoryURL string, key *ecdsa.PrivateKey) *Client {
+	return &Client{
+		DirectoryURL: directoryURL,
+		Key:          key,
+		HTTPClient:   http.DefaultClient,
+	}
+}
+
+func (c *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if nonce := resp.Header.Get(nonceHeader); nonce != "" {
+		c.nonce = nonce
+	}
+	return resp, nil
+}
+
+func (c *Client) directory(ctx context.Context) (*directory, error) {
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, c.DirectoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't get ACME directory: %s", resp.Status)
+	}
+	var dir directory
+	err = json.NewDecoder(resp.Body).Decode(&dir)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect ACME directory: %v", err)
+	}
+	c.dir = &dir
+	return c.dir, nil
+}
+
+func (c *Client) getNonce(ctx context.Context) (string, error) {
+	if c.nonce != "" {
+		nonce := c.nonce
+		c.nonce = ""
+		return nonce, nil
+	}
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodHead, dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if c.nonce == "" {
+		return "", fmt.Errorf("no nonce received from ACME server")
+	}
+	return c.getNonce(ctx)
+}
+
+// post does a signed request, it is retried once if the nonce is rejected
+func (c *Client) post(ctx context.Context, url string, payload interface{}, accept string) (*http.Response, []byte, error) {
+	for retry := true; ; retry = false {
+		nonce, err := c.getNonce(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := signJWS(c.Key, c.kid, nonce, url, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := c.do(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode < 400 {
+			return resp, d, nil
+		}
+		problem := &Problem{Status: resp.StatusCode}
+		if json.Unmarshal(d, problem) != nil || problem.Type == "" {
+			return nil, nil, fmt.Errorf("ACME request to %s failed: %s", url, resp.Status)
+		}
+		if problem.Type == badNonceError && retry {
+			continue
+		}
+		return nil, nil, problem
+	}
+}
+
+func (c *Client) postJSON(ctx context.Context, url string, payload, result interface{}) (*http.Response, error) {
+	resp, d, err := c.post(ctx, url, payload, "")
+	if err != nil {
+		return nil, err
+	}
+	if result != nil {
+		err = json.Unmarshal(d, result)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect response from %s: %v", url, err)
+		}
+	}
+	return resp, nil
+}
+
+// Register creates the account of the key, or finds it if it already
+// exists, the terms of service of the server are agreed
+func (c *Client) Register(ctx context.Context, contact []string) error {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if len(contact) > 0 {
+		payload["contact"] = contact
+	}
+	resp, err := c.postJSON(ctx, dir.NewAccount, payload, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't register ACME account: %v", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("no account URL received from ACME server")
+	}
+	return nil
+}
+
+// NewOrder requests a certificate for the domains
+func (c *Client) NewOrder(ctx context.Context, domains []string) (*Order, error) {
+	dir, err := c.directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var identifiers []Identifier
+	for _, d := range domains {
+		identifiers = append(identifiers, Identifier{Type: "dns", Value: d})
+	}
+	var order Order
+	resp, err := c.postJSON(ctx, dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &order)
+	if err != nil {
+		return nil, err
+	}
+	order.URL = resp.Header.Get("Location")
+	return &order, nil
+}
+
+func (c *Client) Authorization(ctx context.Context, url string) (*Authorization, error) {
+	var authz Authorization
+	_, err := c.postJSON(ctx, url, nil, &authz)
+	return &authz, err
+}
+
+// Accept notifies the server that the challenge is ready to be validated
+func (c *Client) Accept(ctx context.Context, challenge Challenge) error {
+	_, err := c.postJSON(ctx, challenge.URL, struct{}{}, nil)
+	return err
+}
+
+func wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(PollPeriod):
+		return nil
+	}
+}
+
+// WaitAuthorization waits till the authorization is validated
+func (c *Client) WaitAuthorization(ctx context.Context, url string) error {
+	for {
+		authz, err := c.Authorization(ctx, url)
+		if err != nil {
+			return err
+		}
+		switch authz.Status {
+		case StatusValid:
+			return nil
+		case StatusPending, StatusProcessing:
+		default:
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("authorization for %s failed: %v", authz.Identifier.Value, ch.Error)
+				}
+			}
+			return fmt.Errorf("authorization for %s is %s", authz.Identifier.Value, authz.Status)
+		}
+		if err := wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Finalize sends the DER-encoded CSR for a ready order and waits till
+// the certificate is issued, it returns the URL of the certificate
+func (c *Client) Finalize(ctx context.Context, order *Order, csr []byte) (string, error) {
+	payload := map[string]string{"csr": encode(csr)}
+	_, err := c.postJSON(ctx, order.Finalize, payload, order)
+	if err != nil {
+		return "", err
+	}
+	for {
+		switch order.Status {
+		case StatusValid:
+			return order.Certificate, nil
+		case StatusPending, StatusReady, StatusProcessing:
+		default:
+			if order.Error != nil {
+				return "", fmt.Errorf("order failed: %v", order.Error)
+			}
+			return "", fmt.Errorf("order is %s", order.Status)
+		}
+		if err := wait(ctx); err != nil {
+			return "", err
+		}
+		_, err = c.postJSON(ctx, order.URL, nil, order)
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Certificate downloads the PEM-encoded certificate chain
+func (c *Client) Certificate(ctx context.Context, url string) ([]byte, error) {
+	_, d, err := c.post(ctx, url, nil, "application/pem-certificate-chain")
+	return d, err
+}
+
+// KeyAuthorization returns the key authorization for a challenge token
+func (c *Client) KeyAuthorization(token string) (string, error) {
+	thumbprint, err := Thumbprint(c.Key)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeACMEServer implements the minimum of an ACME server to issue a
+// certificate validating a single DNS-01 challenge
+type fakeACMEServer struct {
+	t       *testing.T
+	server  *httptest.Server
+	key     *ecdsa.PublicKey
+	nonce   int
+	authz   string
+	keyAuth string
+}
+
+func (s *fakeACMEServer) url(path string) string {
+	return s.server.URL + path
+}
+
+// verify checks the signature of a request and returns its payload
+func (s *fakeACMEServer) verify(r *http.Request) []byte {
+	var msg jws
+	assert.NoError(s.t, json.NewDecoder(r.Body).Decode(&msg))
+	protected, _ := base64.RawURLEncoding.DecodeString(msg.Protected)
+	var header struct {
+		jwsHeader
+		JWK *jsonWebKey `json:"jwk"`
+	}
+	json.Unmarshal(protected, &header)
+	assert.Equal(s.t, s.url(r.URL.Path), header.URL)
+	if header.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(header.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(header.JWK.Y)
+		s.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else {
+		assert.Equal(s.t, s.url("/account/1"), header.KID)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(msg.Signature)
+	digest := sha256.Sum256([]byte(msg.Protected + "." + msg.Payload))
+	r1 := new(big.Int).SetBytes(signature[:32])
+	s1 := new(big.Int).SetBytes(signature[32:])
+	assert.True(s.t, ecdsa.Verify(s.key, digest[:], r1, s1), "incorrect signature")
+	payload, _ := base64.RawURLEncoding.DecodeString(msg.Payload)
+	return payload
+}
+
+func (s *fakeACMEServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.nonce++
+	w.Header().Set(nonceHeader, fmt.Sprintf("nonce-%d", s.nonce))
+	if r.URL.Path == "/directory" {
+		json.NewEncoder(w).Encode(directory{
+			NewNonce:   s.url("/nonce"),
+			NewAccount: s.url("/account"),
+			NewOrder:   s.url("/order"),
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	payload := s.verify(r)
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", s.url("/account/1"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status": "valid"}`))
+	case "/order":
+		w.Header().Set("Location", s.url("/order/1"))
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Order{
+			Status:         StatusPending,
+			Authorizations: []string{s.url("/authz/1")},
+			Finalize:       s.url("/order/1/finalize"),
+		})
+	case "/authz/1":
+		thumbprint, _ := Thumbprint(&ecdsa.PrivateKey{PublicKey: *s.key})
+		s.keyAuth = "token." + thumbprint
+		status := s.authz
+		if status == "" {
+			status = StatusPending
+		}
+		json.NewEncoder(w).Encode(Authorization{
+			Identifier: Identifier{Type: "dns", Value: "example.com"},
+			Status:     status,
+			Challenges: []Challenge{
+				{Type: HTTP01, URL: s.url("/challenge/http"), Token: "token"},
+				{Type: DNS01, URL: s.url("/challenge/dns"), Token: "token"},
+			},
+		})
+	case "/challenge/dns":
+		s.authz = StatusValid
+		w.Write([]byte(`{"status": "processing"}`))
+	case "/order/1/finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if assert.NoError(s.t, err) {
+			assert.Equal(s.t, []string{"example.com"}, csr.DNSNames)
+		}
+		json.NewEncoder(w).Encode(Order{Status: StatusValid, Certificate: s.url("/cert/1")})
+	case "/cert/1":
+		assert.Equal(s.t, "application/pem-certificate-chain", r.Header.Get("Accept"))
+		w.Write([]byte("-----BEGIN CERTIFICATE-----\nMA==\n-----END CERTIFICATE-----\n"))
+	default:
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"type": "urn:ietf:params:acme:error:malformed", "detail": "not found"}`))
+	}
+}
+
+func TestObtainCertificate(t *testing.T) {
+	fake := &fakeACMEServer{t: t}
+	fake.server = httptest.NewServer(fake)
+	defer fake.server.Close()
+
+	tmpdir, err := ioutil.TempDir("", "pouch-acme-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	records := filepath.Join(tmpdir, "records")
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := NewClient(fake.url("/directory"), key)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = c.ObtainCertificate(ctx, []string{"example.com"}, &DNS01Solver{}, nil)
+	assert.Error(t, err, "account not registered")
+
+	assert.NoError(t, c.Register(ctx, []string{"mailto:admin@example.com"}))
+
+	certKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com"},
+	}, certKey)
+	solver := &DNS01Solver{
+		Command: "echo $ACME_ACTION $ACME_RECORD_NAME $ACME_RECORD_VALUE >> " + records,
+	}
+	chain, err := c.ObtainCertificate(ctx, []string{"example.com"}, solver, csr)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(chain)
+	if assert.NotNil(t, block) {
+		assert.Equal(t, "CERTIFICATE", block.Type)
+	}
+
+	name, value := DNS01Record("example.com", fake.keyAuth)
+	d, _ := ioutil.ReadFile(records)
+	assert.Equal(t, []string{
+		"present " + name + " " + value,
+		"cleanup " + name + " " + value,
+	}, strings.Split(strings.TrimSpace(string(d)), "\n"))
+}
+
+func TestHTTP01Solver(t *testing.T) {
+	s := &HTTP01Solver{Listen: "127.0.0.1:0"}
+	assert.NoError(t, s.Present("example.com", "token", "token.thumbprint"))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", HTTP01Prefix+"token", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "token.thumbprint", w.Body.String())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", HTTP01Prefix+"other", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, s.CleanUp("example.com", "token", "token.thumbprint"))
+	assert.Nil(t, s.server)
+}
+
+func TestDNS01Record(t *testing.T) {
+	name, value := DNS01Record("*.example.com", "token.thumbprint")
+	assert.Equal(t, "_acme-challenge.example.com.", name)
+	sum := sha256.Sum256([]byte("token.thumbprint"))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(sum[:]), value)
+}
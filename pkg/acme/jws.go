@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+var encode = base64.RawURLEncoding.EncodeToString
+
+type jsonWebKey struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwk returns the public part of an account key, only P-256 keys are
+// supported, fields are in the order required to calculate thumbprints
+func jwk(key *ecdsa.PrivateKey) (*jsonWebKey, error) {
+	if key.Curve.Params().Name != "P-256" {
+		return nil, fmt.Errorf("only P-256 account keys are supported")
+	}
+	return &jsonWebKey{
+		Crv: "P-256",
+		Kty: "EC",
+		X:   encode(padded(key.X, 32)),
+		Y:   encode(padded(key.Y, 32)),
+	}, nil
+}
+
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// Thumbprint returns the JWK thumbprint of the key as defined in RFC 7638
+func Thumbprint(key *ecdsa.PrivateKey) (string, error) {
+	k, err := jwk(key)
+	if err != nil {
+		return "", err
+	}
+	d, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(d)
+	return encode(sum[:]), nil
+}
+
+type jwsHeader struct {
+	Alg   string      `json:"alg"`
+	Nonce string      `json:"nonce"`
+	URL   string      `json:"url"`
+	JWK   *jsonWebKey `json:"jwk,omitempty"`
+	KID   string      `json:"kid,omitempty"`
+}
+
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// signJWS signs a request to url, the key is identified by the kid if
+// set, or sent as JWK otherwise. Nil payloads produce POST-as-GET requests.
+func signJWS(key *ecdsa.PrivateKey, kid, nonce, url string, payload interface{}) ([]byte, error) {
+	header := jwsHeader{Alg: "ES256", Nonce: nonce, URL: url, KID: kid}
+	if kid == "" {
+		k, err := jwk(key)
+		if err != nil {
+			return nil, err
+		}
+		header.JWK = k
+	}
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	var encodedPayload string
+	if payload != nil {
+		d, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = encode(d)
+	}
+	msg := encode(protected) + "." + encodedPayload
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(msg))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	signature := append(padded(r, 32), padded(s, 32)...)
+	return json.Marshal(jws{
+		Protected: encode(protected),
+		Payload:   encodedPayload,
+		Signature: encode(signature),
+	})
+}
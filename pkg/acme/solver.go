@@ -0,0 +1,221 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package acme
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	HTTP01 = "http-01"
+	DNS01  = "dns-01"
+
+	HTTP01Prefix = "/.well-known/acme-challenge/"
+	DNS01Prefix  = "_acme-challenge."
+)
+
+// Solver prepares the validation of challenges of a type
+type Solver interface {
+	Type() string
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token, keyAuth string) error
+}
+
+// HTTP01Solver serves key authorizations over HTTP while challenges are
+// being validated
+type HTTP01Solver struct {
+	sync.Mutex
+
+	// Address to listen on, it must be reachable in port 80 of the
+	// domains
+	Listen string
+
+	tokens map[string]string
+	server *http.Server
+}
+
+func (s *HTTP01Solver) Type() string {
+	return HTTP01
+}
+
+func (s *HTTP01Solver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	keyAuth, found := s.tokens[strings.TrimPrefix(r.URL.Path, HTTP01Prefix)]
+	s.Unlock()
+	if !found || !strings.HasPrefix(r.URL.Path, HTTP01Prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write([]byte(keyAuth))
+}
+
+func (s *HTTP01Solver) Present(domain, token, keyAuth string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]string)
+	}
+	s.tokens[token] = keyAuth
+	if s.server != nil {
+		return nil
+	}
+	l, err := net.Listen("tcp", s.Listen)
+	if err != nil {
+		return fmt.Errorf("couldn't listen for HTTP-01 challenges: %v", err)
+	}
+	s.server = &http.Server{Handler: s}
+	go func(server *http.Server) {
+		err := server.Serve(l)
+		if err != http.ErrServerClosed {
+			log.Printf("HTTP-01 challenges server failed: %v", err)
+		}
+	}(s.server)
+	return nil
+}
+
+func (s *HTTP01Solver) CleanUp(domain, token, keyAuth string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.tokens, token)
+	if len(s.tokens) > 0 || s.server == nil {
+		return nil
+	}
+	err := s.server.Shutdown(context.Background())
+	s.server = nil
+	return err
+}
+
+// DNS01Solver runs a command to create and remove the TXT records of the
+// challenges, it is run in a shell with the ACME_ACTION (present or
+// cleanup), ACME_DOMAIN, ACME_RECORD_NAME and ACME_RECORD_VALUE
+// environment variables. The command is expected to return once the
+// record is visible to the ACME server.
+type DNS01Solver struct {
+	Command string
+}
+
+func (s *DNS01Solver) Type() string {
+	return DNS01
+}
+
+// DNS01Record returns the name and value of the TXT record for a challenge
+func DNS01Record(domain, keyAuth string) (name, value string) {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return DNS01Prefix + strings.TrimPrefix(domain, "*.") + ".", encode(sum[:])
+}
+
+func (s *DNS01Solver) run(action, domain, keyAuth string) error {
+	name, value := DNS01Record(domain, keyAuth)
+	cmd := exec.Command("sh", "-c", s.Command)
+	cmd.Env = append(os.Environ(),
+		"ACME_ACTION="+action,
+		"ACME_DOMAIN="+domain,
+		"ACME_RECORD_NAME="+name,
+		"ACME_RECORD_VALUE="+value,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("DNS-01 command failed on %s for %s: %v: %s", action, domain, err, out)
+	}
+	return nil
+}
+
+func (s *DNS01Solver) Present(domain, token, keyAuth string) error {
+	return s.run("present", domain, keyAuth)
+}
+
+func (s *DNS01Solver) CleanUp(domain, token, keyAuth string) error {
+	return s.run("cleanup", domain, keyAuth)
+}
+
+// ObtainCertificate does the whole flow to obtain a certificate for the
+// domains, validating them with the solver. The CSR must be DER-encoded
+// and include the domains, the PEM-encoded chain is returned.
+func (c *Client) ObtainCertificate(ctx context.Context, domains []string, solver Solver, csr []byte) ([]byte, error) {
+	if c.kid == "" {
+		return nil, fmt.Errorf("ACME account not registered")
+	}
+	order, err := c.NewOrder(ctx, domains)
+	if err != nil {
+		return nil, err
+	}
+	for _, url := range order.Authorizations {
+		err := c.authorize(ctx, url, solver)
+		if err != nil {
+			return nil, err
+		}
+	}
+	certURL, err := c.Finalize(ctx, order, csr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Certificate(ctx, certURL)
+}
+
+func (c *Client) authorize(ctx context.Context, url string, solver Solver) error {
+	authz, err := c.Authorization(ctx, url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == StatusValid {
+		return nil
+	}
+	var challenge *Challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == solver.Type() {
+			challenge = &authz.Challenges[i]
+			break
+		}
+	}
+	domain := authz.Identifier.Value
+	if authz.Wildcard {
+		domain = "*." + domain
+	}
+	if challenge == nil {
+		return fmt.Errorf("no %s challenge offered for %s", solver.Type(), domain)
+	}
+
+	keyAuth, err := c.KeyAuthorization(challenge.Token)
+	if err != nil {
+		return err
+	}
+	err = solver.Present(domain, challenge.Token, keyAuth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := solver.CleanUp(domain, challenge.Token, keyAuth)
+		if err != nil {
+			log.Printf("Couldn't clean up %s challenge for %s: %v", solver.Type(), domain, err)
+		}
+	}()
+	err = c.Accept(ctx, *challenge)
+	if err != nil {
+		return err
+	}
+	return c.WaitAuthorization(ctx, url)
+}
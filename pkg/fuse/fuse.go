@@ -0,0 +1,523 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fuse implements a minimal read-only FUSE filesystem, speaking
+// the protocol of the kernel directly
+package fuse
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Operations of the FUSE protocol handled
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opOpen        = 14
+	opRead        = 15
+	opStatfs      = 17
+	opRelease     = 18
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+)
+
+const (
+	// Version of the protocol implemented
+	protocolMajor = 7
+	protocolMinor = 26
+
+	// Maximum size of writes, requests are read in buffers big enough
+	// for them, as the kernel requires
+	maxWrite   = 128 * 1024
+	bufferSize = maxWrite + 4096
+
+	inHeaderSize  = 40
+	outHeaderSize = 16
+	attrSize      = 88
+
+	// Content of open files is read directly from the server, so it is
+	// never kept in the page cache
+	fopenDirectIO = 1 << 0
+
+	rootNodeID = 1
+
+	// Time the kernel can cache names and attributes, short as secrets
+	// can change at any time
+	validity = time.Second
+)
+
+var byteOrder binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	// FUSE messages use the native byte order
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		byteOrder = binary.BigEndian
+	}
+}
+
+// Node describes a file or a directory
+type Node struct {
+	Name    string
+	Dir     bool
+	Mode    os.FileMode
+	UID     uint32
+	GID     uint32
+	Size    uint64
+	ModTime time.Time
+}
+
+// Caller identifies the process doing an operation
+type Caller struct {
+	PID uint32
+	UID uint32
+	GID uint32
+}
+
+// FS is a read-only filesystem, paths are relative to its root, that is
+// the empty path. Errors satisfying os.IsNotExist or os.IsPermission are
+// returned to the caller as such, other errors as I/O errors.
+type FS interface {
+	// Stat returns the attributes of a file or directory
+	Stat(path string) (Node, error)
+
+	// List returns the nodes in a directory
+	List(path string) ([]Node, error)
+
+	// Open returns the content of a file, it is generated each time
+	// the file is opened
+	Open(path string, caller Caller) ([]byte, error)
+}
+
+// Server serves a filesystem to the kernel
+type Server struct {
+	fs  FS
+	dev io.ReadWriter
+
+	// Inodes are assigned to paths when they are looked up, and kept
+	// while the filesystem is mounted
+	mutex     sync.Mutex
+	paths     map[uint64]string
+	inodes    map[string]uint64
+	nextInode uint64
+
+	// Content of open files
+	handles    map[uint64][]byte
+	nextHandle uint64
+
+	writeMutex sync.Mutex
+}
+
+// NewServer returns a server of a filesystem, whose requests are read
+// from a device, as returned by Mount
+func NewServer(fs FS, dev io.ReadWriter) *Server {
+	return &Server{
+		fs:        fs,
+		dev:       dev,
+		paths:     map[uint64]string{rootNodeID: ""},
+		inodes:    map[string]uint64{"": rootNodeID},
+		nextInode: rootNodeID + 1,
+		handles:   make(map[uint64][]byte),
+	}
+}
+
+type request struct {
+	opcode uint32
+	unique uint64
+	nodeID uint64
+	caller Caller
+	body   []byte
+}
+
+// Serve handles requests till the filesystem is unmounted
+func (s *Server) Serve() error {
+	for {
+		buf := make([]byte, bufferSize)
+		n, err := s.dev.Read(buf)
+		switch err {
+		case nil:
+		case syscall.EINTR, syscall.EAGAIN, syscall.ENOENT:
+			// Interrupted, or request interrupted before read
+			continue
+		case io.EOF, syscall.ENODEV:
+			return nil
+		default:
+			return err
+		}
+		if n < inHeaderSize {
+			continue
+		}
+		req := request{
+			opcode: byteOrder.Uint32(buf[4:]),
+			unique: byteOrder.Uint64(buf[8:]),
+			nodeID: byteOrder.Uint64(buf[16:]),
+			caller: Caller{
+				UID: byteOrder.Uint32(buf[24:]),
+				GID: byteOrder.Uint32(buf[28:]),
+				PID: byteOrder.Uint32(buf[32:]),
+			},
+			body: buf[inHeaderSize:n],
+		}
+		switch req.opcode {
+		case opInit:
+			s.init(req)
+		case opDestroy:
+			s.reply(req, 0, nil)
+			return nil
+		case opForget, opBatchForget, opInterrupt:
+			// No reply expected
+		default:
+			go s.handle(req)
+		}
+	}
+}
+
+func (s *Server) handle(req request) {
+	switch req.opcode {
+	case opLookup:
+		s.lookup(req)
+	case opGetattr:
+		s.getattr(req)
+	case opOpen:
+		s.open(req)
+	case opRead:
+		s.read(req)
+	case opRelease:
+		s.release(req)
+	case opOpendir:
+		s.opendir(req)
+	case opReaddir:
+		s.readdir(req)
+	case opStatfs:
+		s.statfs(req)
+	case opFlush, opReleasedir:
+		s.reply(req, 0, nil)
+	default:
+		s.reply(req, syscall.ENOSYS, nil)
+	}
+}
+
+// reply writes the response to a request, with an error or with data
+func (s *Server) reply(req request, errno syscall.Errno, data []byte) {
+	out := make([]byte, outHeaderSize+len(data))
+	byteOrder.PutUint32(out[0:], uint32(len(out)))
+	byteOrder.PutUint32(out[4:], uint32(-int32(errno)))
+	byteOrder.PutUint64(out[8:], req.unique)
+	copy(out[outHeaderSize:], data)
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	// Errors are ignored, they happen when the request was interrupted
+	s.dev.Write(out)
+}
+
+func errno(err error) syscall.Errno {
+	if e, ok := err.(syscall.Errno); ok {
+		return e
+	}
+	switch {
+	case os.IsNotExist(err):
+		return syscall.ENOENT
+	case os.IsPermission(err):
+		return syscall.EACCES
+	default:
+		return syscall.EIO
+	}
+}
+
+func (s *Server) path(nodeID uint64) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path, found := s.paths[nodeID]
+	return path, found
+}
+
+func (s *Server) inode(path string) uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ino, found := s.inodes[path]; found {
+		return ino
+	}
+	ino := s.nextInode
+	s.nextInode++
+	s.inodes[path] = ino
+	s.paths[ino] = path
+	return ino
+}
+
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func (s *Server) stat(req request) (string, Node, syscall.Errno) {
+	path, found := s.path(req.nodeID)
+	if !found {
+		return "", Node{}, syscall.ENOENT
+	}
+	node, err := s.fs.Stat(path)
+	if err != nil {
+		return "", Node{}, errno(err)
+	}
+	return path, node, 0
+}
+
+func (s *Server) init(req request) {
+	if len(req.body) < 16 {
+		s.reply(req, syscall.EIO, nil)
+		return
+	}
+	major := byteOrder.Uint32(req.body[0:])
+	minor := byteOrder.Uint32(req.body[4:])
+	maxReadahead := byteOrder.Uint32(req.body[8:])
+	if major != protocolMajor {
+		s.reply(req, syscall.EPROTO, nil)
+		return
+	}
+	if minor > protocolMinor {
+		minor = protocolMinor
+	}
+	out := make([]byte, 64)
+	byteOrder.PutUint32(out[0:], protocolMajor)
+	byteOrder.PutUint32(out[4:], minor)
+	byteOrder.PutUint32(out[8:], maxReadahead)
+	byteOrder.PutUint16(out[16:], 12) // max_background
+	byteOrder.PutUint16(out[18:], 9)  // congestion_threshold
+	byteOrder.PutUint32(out[20:], maxWrite)
+	s.reply(req, 0, out)
+}
+
+func putAttr(b []byte, ino uint64, node Node) {
+	mode := uint32(node.Mode.Perm())
+	nlink := uint32(1)
+	if node.Dir {
+		mode |= syscall.S_IFDIR
+		nlink = 2
+	} else {
+		mode |= syscall.S_IFREG
+	}
+	t := uint64(node.ModTime.Unix())
+	nsec := uint32(node.ModTime.Nanosecond())
+	if node.ModTime.IsZero() {
+		t, nsec = 0, 0
+	}
+	byteOrder.PutUint64(b[0:], ino)
+	byteOrder.PutUint64(b[8:], node.Size)
+	byteOrder.PutUint64(b[16:], (node.Size+511)/512)
+	for i := 0; i < 3; i++ {
+		byteOrder.PutUint64(b[24+8*i:], t)
+		byteOrder.PutUint32(b[48+4*i:], nsec)
+	}
+	byteOrder.PutUint32(b[60:], mode)
+	byteOrder.PutUint32(b[64:], nlink)
+	byteOrder.PutUint32(b[68:], node.UID)
+	byteOrder.PutUint32(b[72:], node.GID)
+	byteOrder.PutUint32(b[80:], 4096) // blksize
+}
+
+func (s *Server) lookup(req request) {
+	parent, found := s.path(req.nodeID)
+	if !found {
+		s.reply(req, syscall.ENOENT, nil)
+		return
+	}
+	name := strings.TrimRight(string(req.body), "\x00")
+	path := joinPath(parent, name)
+	node, err := s.fs.Stat(path)
+	if err != nil {
+		s.reply(req, errno(err), nil)
+		return
+	}
+	ino := s.inode(path)
+	out := make([]byte, 40+attrSize)
+	byteOrder.PutUint64(out[0:], ino)
+	byteOrder.PutUint64(out[16:], uint64(validity/time.Second))
+	byteOrder.PutUint64(out[24:], uint64(validity/time.Second))
+	putAttr(out[40:], ino, node)
+	s.reply(req, 0, out)
+}
+
+func (s *Server) getattr(req request) {
+	_, node, e := s.stat(req)
+	if e != 0 {
+		s.reply(req, e, nil)
+		return
+	}
+	out := make([]byte, 16+attrSize)
+	byteOrder.PutUint64(out[0:], uint64(validity/time.Second))
+	putAttr(out[16:], req.nodeID, node)
+	s.reply(req, 0, out)
+}
+
+func (s *Server) open(req request) {
+	if len(req.body) < 4 {
+		s.reply(req, syscall.EIO, nil)
+		return
+	}
+	flags := byteOrder.Uint32(req.body[0:])
+	if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+		s.reply(req, syscall.EROFS, nil)
+		return
+	}
+	path, node, e := s.stat(req)
+	if e != 0 {
+		s.reply(req, e, nil)
+		return
+	}
+	if node.Dir {
+		s.reply(req, syscall.EISDIR, nil)
+		return
+	}
+	content, err := s.fs.Open(path, req.caller)
+	if err != nil {
+		s.reply(req, errno(err), nil)
+		return
+	}
+	s.mutex.Lock()
+	s.nextHandle++
+	fh := s.nextHandle
+	s.handles[fh] = content
+	s.mutex.Unlock()
+
+	out := make([]byte, 16)
+	byteOrder.PutUint64(out[0:], fh)
+	byteOrder.PutUint32(out[8:], fopenDirectIO)
+	s.reply(req, 0, out)
+}
+
+func (s *Server) read(req request) {
+	if len(req.body) < 24 {
+		s.reply(req, syscall.EIO, nil)
+		return
+	}
+	fh := byteOrder.Uint64(req.body[0:])
+	offset := byteOrder.Uint64(req.body[8:])
+	size := uint64(byteOrder.Uint32(req.body[16:]))
+	s.mutex.Lock()
+	content, found := s.handles[fh]
+	s.mutex.Unlock()
+	if !found {
+		s.reply(req, syscall.EBADF, nil)
+		return
+	}
+	if offset >= uint64(len(content)) {
+		s.reply(req, 0, nil)
+		return
+	}
+	end := offset + size
+	if end > uint64(len(content)) {
+		end = uint64(len(content))
+	}
+	s.reply(req, 0, content[offset:end])
+}
+
+func (s *Server) release(req request) {
+	if len(req.body) >= 8 {
+		fh := byteOrder.Uint64(req.body[0:])
+		s.mutex.Lock()
+		// Content is overwritten so it doesn't stay in memory
+		for i := range s.handles[fh] {
+			s.handles[fh][i] = 0
+		}
+		delete(s.handles, fh)
+		s.mutex.Unlock()
+	}
+	s.reply(req, 0, nil)
+}
+
+func (s *Server) opendir(req request) {
+	_, node, e := s.stat(req)
+	if e != 0 {
+		s.reply(req, e, nil)
+		return
+	}
+	if !node.Dir {
+		s.reply(req, syscall.ENOTDIR, nil)
+		return
+	}
+	s.reply(req, 0, make([]byte, 16))
+}
+
+func (s *Server) readdir(req request) {
+	if len(req.body) < 24 {
+		s.reply(req, syscall.EIO, nil)
+		return
+	}
+	offset := byteOrder.Uint64(req.body[8:])
+	size := int(byteOrder.Uint32(req.body[16:]))
+	path, found := s.path(req.nodeID)
+	if !found {
+		s.reply(req, syscall.ENOENT, nil)
+		return
+	}
+	nodes, err := s.fs.List(path)
+	if err != nil {
+		s.reply(req, errno(err), nil)
+		return
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	entries := append([]Node{{Name: ".", Dir: true}, {Name: "..", Dir: true}}, nodes...)
+
+	var out []byte
+	for i := int(offset); i < len(entries); i++ {
+		node := entries[i]
+		ino := req.nodeID
+		if i >= 2 {
+			ino = s.inode(joinPath(path, node.Name))
+		}
+		entryType := uint32(syscall.DT_REG)
+		if node.Dir {
+			entryType = syscall.DT_DIR
+		}
+		// Entries are aligned to 8 bytes
+		length := (24 + len(node.Name) + 7) &^ 7
+		if len(out)+length > size {
+			break
+		}
+		entry := make([]byte, length)
+		byteOrder.PutUint64(entry[0:], ino)
+		byteOrder.PutUint64(entry[8:], uint64(i+1))
+		byteOrder.PutUint32(entry[16:], uint32(len(node.Name)))
+		byteOrder.PutUint32(entry[20:], entryType)
+		copy(entry[24:], node.Name)
+		out = append(out, entry...)
+	}
+	s.reply(req, 0, out)
+}
+
+func (s *Server) statfs(req request) {
+	out := make([]byte, 80)
+	byteOrder.PutUint32(out[40:], 4096) // bsize
+	byteOrder.PutUint32(out[44:], 255)  // namelen
+	byteOrder.PutUint32(out[48:], 4096) // frsize
+	s.reply(req, 0, out)
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fuse
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dummyFS struct {
+	opened []Caller
+}
+
+func (fs *dummyFS) Stat(path string) (Node, error) {
+	switch path {
+	case "", "db":
+		return Node{Dir: true, Mode: 0500}, nil
+	case "db/password":
+		return Node{Mode: 0400, Size: 6}, nil
+	case "forbidden":
+		return Node{Mode: 0400}, nil
+	}
+	return Node{}, os.ErrNotExist
+}
+
+func (fs *dummyFS) List(path string) ([]Node, error) {
+	if path == "" {
+		return []Node{{Name: "forbidden"}, {Name: "db", Dir: true}}, nil
+	}
+	return []Node{{Name: "password"}}, nil
+}
+
+func (fs *dummyFS) Open(path string, caller Caller) ([]byte, error) {
+	if path == "forbidden" {
+		return nil, os.ErrPermission
+	}
+	fs.opened = append(fs.opened, caller)
+	return []byte("s3cr3t"), nil
+}
+
+// dummyDevice receives requests and sends replies through channels, as
+// the kernel does through the FUSE device
+type dummyDevice struct {
+	requests chan []byte
+	replies  chan []byte
+}
+
+func (d *dummyDevice) Read(b []byte) (int, error) {
+	req, ok := <-d.requests
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(b, req), nil
+}
+
+func (d *dummyDevice) Write(b []byte) (int, error) {
+	d.replies <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+var unique uint64
+
+// call sends a request and returns the error and data of its reply
+func (d *dummyDevice) call(t *testing.T, opcode uint32, nodeID uint64, body []byte) (syscall.Errno, []byte) {
+	unique++
+	req := make([]byte, inHeaderSize+len(body))
+	byteOrder.PutUint32(req[0:], uint32(len(req)))
+	byteOrder.PutUint32(req[4:], opcode)
+	byteOrder.PutUint64(req[8:], unique)
+	byteOrder.PutUint64(req[16:], nodeID)
+	byteOrder.PutUint32(req[24:], 1000)
+	byteOrder.PutUint32(req[28:], 100)
+	byteOrder.PutUint32(req[32:], 42)
+	copy(req[inHeaderSize:], body)
+	d.requests <- req
+	select {
+	case reply := <-d.replies:
+		assert.Equal(t, uint32(len(reply)), byteOrder.Uint32(reply[0:]))
+		assert.Equal(t, unique, byteOrder.Uint64(reply[8:]))
+		return syscall.Errno(-int32(byteOrder.Uint32(reply[4:]))), reply[outHeaderSize:]
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reply for operation %d", opcode)
+	}
+	return 0, nil
+}
+
+func readIn(fh, offset uint64, size uint32) []byte {
+	b := make([]byte, 40)
+	byteOrder.PutUint64(b[0:], fh)
+	byteOrder.PutUint64(b[8:], offset)
+	byteOrder.PutUint32(b[16:], size)
+	return b
+}
+
+func TestServer(t *testing.T) {
+	fs := &dummyFS{}
+	dev := &dummyDevice{requests: make(chan []byte), replies: make(chan []byte)}
+	done := make(chan error)
+	go func() { done <- NewServer(fs, dev).Serve() }()
+
+	initIn := make([]byte, 16)
+	byteOrder.PutUint32(initIn[0:], 7)
+	byteOrder.PutUint32(initIn[4:], 31)
+	byteOrder.PutUint32(initIn[8:], 65536)
+	errno, out := dev.call(t, opInit, 0, initIn)
+	if assert.Equal(t, syscall.Errno(0), errno) && assert.Len(t, out, 64) {
+		assert.Equal(t, uint32(7), byteOrder.Uint32(out[0:]))
+		assert.Equal(t, uint32(protocolMinor), byteOrder.Uint32(out[4:]))
+		assert.Equal(t, uint32(maxWrite), byteOrder.Uint32(out[20:]))
+	}
+
+	errno, _ = dev.call(t, opLookup, rootNodeID, []byte("unknown\x00"))
+	assert.Equal(t, syscall.ENOENT, errno)
+
+	errno, out = dev.call(t, opLookup, rootNodeID, []byte("db\x00"))
+	if !assert.Equal(t, syscall.Errno(0), errno) || !assert.Len(t, out, 40+attrSize) {
+		return
+	}
+	dbNode := byteOrder.Uint64(out[0:])
+	assert.Equal(t, uint32(syscall.S_IFDIR|0500), byteOrder.Uint32(out[40+60:]))
+
+	errno, out = dev.call(t, opLookup, dbNode, []byte("password\x00"))
+	if !assert.Equal(t, syscall.Errno(0), errno) {
+		return
+	}
+	passwordNode := byteOrder.Uint64(out[0:])
+	assert.Equal(t, uint64(6), byteOrder.Uint64(out[40+8:]))
+	assert.Equal(t, uint32(syscall.S_IFREG|0400), byteOrder.Uint32(out[40+60:]))
+
+	errno, out = dev.call(t, opGetattr, passwordNode, make([]byte, 16))
+	if assert.Equal(t, syscall.Errno(0), errno) && assert.Len(t, out, 16+attrSize) {
+		assert.Equal(t, passwordNode, byteOrder.Uint64(out[16:]))
+	}
+
+	// Files can only be opened for reading
+	errno, _ = dev.call(t, opOpen, passwordNode, []byte{byte(syscall.O_WRONLY), 0, 0, 0, 0, 0, 0, 0})
+	assert.Equal(t, syscall.EROFS, errno)
+
+	errno, out = dev.call(t, opOpen, passwordNode, make([]byte, 8))
+	if !assert.Equal(t, syscall.Errno(0), errno) {
+		return
+	}
+	fh := byteOrder.Uint64(out[0:])
+	assert.Equal(t, uint32(fopenDirectIO), byteOrder.Uint32(out[8:]))
+	assert.Equal(t, []Caller{{PID: 42, UID: 1000, GID: 100}}, fs.opened)
+
+	errno, out = dev.call(t, opRead, passwordNode, readIn(fh, 0, 4))
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, "s3cr", string(out))
+	errno, out = dev.call(t, opRead, passwordNode, readIn(fh, 4, 4096))
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Equal(t, "3t", string(out))
+	errno, out = dev.call(t, opRead, passwordNode, readIn(fh, 6, 4096))
+	assert.Equal(t, syscall.Errno(0), errno)
+	assert.Empty(t, out)
+
+	errno, _ = dev.call(t, opRelease, passwordNode, readIn(fh, 0, 0))
+	assert.Equal(t, syscall.Errno(0), errno)
+	errno, _ = dev.call(t, opRead, passwordNode, readIn(fh, 0, 4096))
+	assert.Equal(t, syscall.EBADF, errno)
+
+	errno, out = dev.call(t, opLookup, rootNodeID, []byte("forbidden\x00"))
+	if assert.Equal(t, syscall.Errno(0), errno) {
+		errno, _ = dev.call(t, opOpen, byteOrder.Uint64(out[0:]), make([]byte, 8))
+		assert.Equal(t, syscall.EACCES, errno)
+	}
+
+	errno, _ = dev.call(t, opOpendir, passwordNode, make([]byte, 8))
+	assert.Equal(t, syscall.ENOTDIR, errno)
+	errno, _ = dev.call(t, opOpendir, rootNodeID, make([]byte, 8))
+	assert.Equal(t, syscall.Errno(0), errno)
+
+	errno, out = dev.call(t, opReaddir, rootNodeID, readIn(0, 0, 4096))
+	if assert.Equal(t, syscall.Errno(0), errno) {
+		var names []string
+		for len(out) > 0 {
+			length := int(byteOrder.Uint32(out[16:]))
+			names = append(names, string(out[24:24+length]))
+			out = out[(24+length+7)&^7:]
+		}
+		assert.Equal(t, []string{".", "..", "db", "forbidden"}, names)
+	}
+	// Entries are continued from the offset of the last one read
+	errno, out = dev.call(t, opReaddir, rootNodeID, readIn(0, 3, 4096))
+	if assert.Equal(t, syscall.Errno(0), errno) && assert.Len(t, out, 40) {
+		assert.Equal(t, "forbidden", string(out[24:33]))
+		assert.Equal(t, uint64(4), byteOrder.Uint64(out[8:]))
+	}
+
+	// Operations not implemented, like symlink
+	errno, _ = dev.call(t, 6, rootNodeID, nil)
+	assert.Equal(t, syscall.ENOSYS, errno)
+
+	errno, _ = dev.call(t, opDestroy, 0, nil)
+	assert.Equal(t, syscall.Errno(0), errno)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not stopped")
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fuse
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+const devicePath = "/dev/fuse"
+
+// fusermount returns the path of the fusermount helper, it is called
+// fusermount3 in systems with libfuse 3
+func fusermount() string {
+	for _, name := range []string{"fusermount", "fusermount3"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return "fusermount"
+}
+
+// MountOptions are the options of a mounted filesystem
+type MountOptions struct {
+	// Name of the filesystem, shown as its source and subtype
+	Name string
+
+	// Allow access to other users than the one mounting it, it needs
+	// user_allow_other in /etc/fuse.conf if not mounted by root
+	AllowOther bool
+}
+
+// Device is the connection with the kernel of a mounted filesystem
+type Device struct {
+	fd int
+}
+
+func (d *Device) Read(b []byte) (int, error) {
+	n, err := syscall.Read(d.fd, b)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (d *Device) Write(b []byte) (int, error) {
+	n, err := syscall.Write(d.fd, b)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (d *Device) Close() error {
+	return syscall.Close(d.fd)
+}
+
+// Mount mounts a read-only filesystem in a directory, and returns the
+// device where its requests are received. It is mounted directly when
+// running as root, and with fusermount otherwise.
+func Mount(dir string, opts MountOptions) (*Device, error) {
+	if opts.Name == "" {
+		opts.Name = "fuse"
+	}
+	if os.Geteuid() == 0 {
+		return mountDirect(dir, opts)
+	}
+	return mountFusermount(dir, opts)
+}
+
+func mountDirect(dir string, opts MountOptions) (*Device, error) {
+	fd, err := syscall.Open(devicePath, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %v", devicePath, err)
+	}
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions",
+		fd, os.Getuid(), os.Getgid())
+	if opts.AllowOther {
+		data += ",allow_other"
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY)
+	err = syscall.Mount(opts.Name, dir, "fuse."+opts.Name, flags, data)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("couldn't mount %s: %v", dir, err)
+	}
+	return &Device{fd: fd}, nil
+}
+
+// mountFusermount mounts the filesystem with the setuid fusermount
+// helper, that passes the opened device through a unix socket
+func mountFusermount(dir string, opts MountOptions) (*Device, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	local := os.NewFile(uintptr(fds[0]), "fusermount")
+	defer local.Close()
+	remote := os.NewFile(uintptr(fds[1]), "fusermount")
+
+	options := []string{"ro", "nosuid", "nodev", "default_permissions", "fsname=" + opts.Name, "subtype=" + opts.Name}
+	if opts.AllowOther {
+		options = append(options, "allow_other")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(fusermount(), "-o", strings.Join(options, ","), "--", dir)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	remote.Close()
+	if err != nil {
+		return nil, fmt.Errorf("fusermount failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	buf := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := syscall.Recvmsg(fds[0], buf, oob, 0)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't receive device from fusermount: %v", err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return nil, fmt.Errorf("couldn't receive device from fusermount: %v", err)
+	}
+	received, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(received) == 0 {
+		return nil, fmt.Errorf("couldn't receive device from fusermount: %v", err)
+	}
+	return &Device{fd: received[0]}, nil
+}
+
+// Unmount unmounts a filesystem, lazily if it is busy
+func Unmount(dir string) error {
+	if os.Geteuid() == 0 {
+		return syscall.Unmount(dir, syscall.MNT_DETACH)
+	}
+	out, err := exec.Command(fusermount(), "-u", "-z", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("fusermount failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
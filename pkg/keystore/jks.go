@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	jksMagic           = 0xfeedfeed
+	jksVersion         = 2
+	jksPrivateKeyEntry = 1
+
+	// Default alias of entries in JKS keystores
+	DefaultAlias = "pouch"
+)
+
+// Identifier of the proprietary algorithm used to protect keys in JKS
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksPassword encodes passwords as Java does when protecting keys and
+// keystores, with two bytes per character
+func jksPassword(password string) []byte {
+	return bmpString(password)
+}
+
+// protectKey encrypts a private key as done by the key protector of JKS,
+// xoring it with a stream of SHA-1 digests of the password and a salt
+func protectKey(pkcs8 []byte, password []byte) ([]byte, error) {
+	salt, err := randomBytes(sha1.Size)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(pkcs8))
+	digest := salt
+	for i := 0; i < len(pkcs8); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(pkcs8); j++ {
+			encrypted[i+j] = pkcs8[i+j] ^ digest[j]
+		}
+	}
+	check := sha1.Sum(append(append([]byte{}, password...), pkcs8...))
+
+	protected := append(append(salt, encrypted...), check[:]...)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidJKSKeyProtector,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: protected,
+	})
+}
+
+// writeUTF writes a string as Java's DataOutput.writeUTF
+func writeUTF(b *bytes.Buffer, s string) error {
+	if len(s) > 0xffff {
+		return fmt.Errorf("string too long")
+	}
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+	return nil
+}
+
+// EncodeJKS encodes the keystore in Java KeyStore format, the key and the
+// keystore are protected with the same password
+func (k *Keystore) EncodeJKS(password string) ([]byte, error) {
+	encodedPassword := jksPassword(password)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	protectedKey, err := protectKey(pkcs8, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	// Java lowercases aliases
+	alias := strings.ToLower(k.Alias)
+	if alias == "" {
+		alias = DefaultAlias
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&b, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+
+	binary.Write(&b, binary.BigEndian, uint32(jksPrivateKeyEntry))
+	err = writeUTF(&b, alias)
+	if err != nil {
+		return nil, err
+	}
+	binary.Write(&b, binary.BigEndian, k.date().UnixNano()/1e6)
+	binary.Write(&b, binary.BigEndian, uint32(len(protectedKey)))
+	b.Write(protectedKey)
+	binary.Write(&b, binary.BigEndian, uint32(len(k.Certificates)))
+	for _, cert := range k.Certificates {
+		writeUTF(&b, "X.509")
+		binary.Write(&b, binary.BigEndian, uint32(len(cert.Raw)))
+		b.Write(cert.Raw)
+	}
+
+	// Integrity of the keystore is checked with a digest of the password,
+	// a fixed string, and the content
+	h := sha1.New()
+	h.Write(encodedPassword)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(b.Bytes())
+	b.Write(h.Sum(nil))
+	return b.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keystore assembles private keys and certificates in keystore
+// formats for services that cannot read PEM files, such as Java ones.
+package keystore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Keystore contains a private key with its certificate chain
+type Keystore struct {
+	Key crypto.PrivateKey
+
+	// Certificates of the chain, the one of the key first
+	Certificates []*x509.Certificate
+
+	// Alias of the entry, used in JKS and as friendly name in PKCS#12
+	Alias string
+
+	// Creation time of the entry, current time if not set
+	Date time.Time
+}
+
+// Parse reads a private key and certificates from PEM, certificates can be
+// split in several arguments, e.g. for the certificate and its CA
+func Parse(keyPEM string, certificatesPEM ...string) (*Keystore, error) {
+	k := &Keystore{}
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	key, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	k.Key = key
+
+	for _, certs := range certificatesPEM {
+		rest := []byte(certs)
+		for {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			k.Certificates = append(k.Certificates, cert)
+		}
+	}
+	if len(k.Certificates) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return k, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse private key: %v", err)
+	}
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+func (k *Keystore) date() time.Time {
+	if k.Date.IsZero() {
+		return time.Now()
+	}
+	return k.Date
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyPEMs(t *testing.T) (keyPEM, certPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return
+}
+
+func TestParse(t *testing.T) {
+	keyPEM, certPEM := testKeyPEMs(t)
+	k, err := Parse(keyPEM, certPEM+certPEM, certPEM)
+	assert.NoError(t, err)
+	assert.Len(t, k.Certificates, 3)
+
+	_, err = Parse(keyPEM)
+	assert.Error(t, err)
+	_, err = Parse(certPEM, certPEM)
+	assert.Error(t, err)
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	keyPEM, certPEM := testKeyPEMs(t)
+	k, err := Parse(keyPEM, certPEM)
+	if !assert.NoError(t, err) {
+		return
+	}
+	k.Alias = "test"
+	d, err := k.EncodePKCS12("changeit")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var pfx pfxPDU
+	_, err = asn1.Unmarshal(d, &pfx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 3, pfx.Version)
+	var authSafe []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe)
+	assert.NoError(t, err)
+
+	password := pkcs12Password("changeit")
+	macKey := pkcs12KDF(password, pfx.MacData.MacSalt, kdfMACKey, pfx.MacData.Iterations, kdfHashSize)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafe)
+	assert.Equal(t, mac.Sum(nil), pfx.MacData.Mac.Digest)
+
+	var contents []contentInfo
+	_, err = asn1.Unmarshal(authSafe, &contents)
+	if !assert.NoError(t, err) || !assert.Len(t, contents, 2) {
+		return
+	}
+	var keyBagsDER []byte
+	_, err = asn1.Unmarshal(contents[1].Content.Bytes, &keyBagsDER)
+	assert.NoError(t, err)
+	var keyBags []safeBag
+	_, err = asn1.Unmarshal(keyBagsDER, &keyBags)
+	if !assert.NoError(t, err) || !assert.Len(t, keyBags, 1) {
+		return
+	}
+	var encrypted encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(keyBags[0].Value.Bytes, &encrypted)
+	assert.NoError(t, err)
+	var params pbeParams
+	_, err = asn1.Unmarshal(encrypted.Algorithm.Parameters.FullBytes, &params)
+	assert.NoError(t, err)
+
+	block, _ := des.NewTripleDESCipher(pkcs12KDF(password, params.Salt, kdfEncryptionKey, params.Iterations, 24))
+	iv := pkcs12KDF(password, params.Salt, kdfIV, params.Iterations, des.BlockSize)
+	data := encrypted.EncryptedData
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	data = data[:len(data)-int(data[len(data)-1])]
+	expected, _ := x509.MarshalPKCS8PrivateKey(k.Key)
+	assert.Equal(t, expected, data)
+}
+
+func TestEncodeJKS(t *testing.T) {
+	keyPEM, certPEM := testKeyPEMs(t)
+	k, err := Parse(keyPEM, certPEM)
+	if !assert.NoError(t, err) {
+		return
+	}
+	k.Alias = "Test"
+	d, err := k.EncodeJKS("changeit")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	password := jksPassword("changeit")
+	content, digest := d[:len(d)-sha1.Size], d[len(d)-sha1.Size:]
+	h := sha1.New()
+	h.Write(password)
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(content)
+	assert.Equal(t, h.Sum(nil), digest)
+
+	r := bytes.NewReader(content)
+	var header struct{ Magic, Version, Count, Tag uint32 }
+	binary.Read(r, binary.BigEndian, &header)
+	assert.Equal(t, uint32(jksMagic), header.Magic)
+	assert.Equal(t, uint32(1), header.Count)
+	var aliasLen uint16
+	binary.Read(r, binary.BigEndian, &aliasLen)
+	alias := make([]byte, aliasLen)
+	r.Read(alias)
+	assert.Equal(t, "test", string(alias))
+	var date int64
+	var keyLen uint32
+	binary.Read(r, binary.BigEndian, &date)
+	binary.Read(r, binary.BigEndian, &keyLen)
+	protected := make([]byte, keyLen)
+	r.Read(protected)
+
+	var encrypted encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(protected, &encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, oidJKSKeyProtector, encrypted.Algorithm.Algorithm)
+	data := encrypted.EncryptedData
+	salt, check := data[:sha1.Size], data[len(data)-sha1.Size:]
+	plain := data[sha1.Size : len(data)-sha1.Size]
+	key := make([]byte, len(plain))
+	stream := salt
+	for i := 0; i < len(plain); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, password...), stream...))
+		stream = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plain); j++ {
+			key[i+j] = plain[i+j] ^ stream[j]
+		}
+	}
+	expected, _ := x509.MarshalPKCS8PrivateKey(k.Key)
+	assert.Equal(t, expected, key)
+	expectedCheck := sha1.Sum(append(append([]byte{}, password...), expected...))
+	assert.Equal(t, expectedCheck[:], check)
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"unicode/utf16"
+)
+
+const (
+	pkcs12Iterations = 2048
+	pkcs12SaltSize   = 8
+
+	// Parameters of the key derivation function of PKCS#12 with SHA-1
+	kdfHashSize  = 20
+	kdfBlockSize = 64
+
+	// Purposes of derived keys
+	kdfEncryptionKey = 1
+	kdfIV            = 2
+	kdfMACKey        = 3
+)
+
+var (
+	oidData                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidShroudedKeyBag          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHA3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                    = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// bmpString encodes a string as UTF-16 big endian, as PKCS#12 passwords
+// and friendly names
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+// fill repeats data till the given length
+func fill(data []byte, size int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	b := make([]byte, size)
+	for i := 0; i < size; i += len(data) {
+		copy(b[i:], data)
+	}
+	return b
+}
+
+// pkcs12KDF derives keys from passwords as defined in RFC 7292, Appendix B
+func pkcs12KDF(password, salt []byte, id byte, iterations, size int) []byte {
+	d := bytes.Repeat([]byte{id}, kdfBlockSize)
+	roundUp := func(n int) int { return (n + kdfBlockSize - 1) / kdfBlockSize * kdfBlockSize }
+	i := append(fill(salt, roundUp(len(salt))), fill(password, roundUp(len(password)))...)
+
+	var result []byte
+	for len(result) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(i)
+		a := h.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		result = append(result, a...)
+
+		// Each block of I is incremented by B + 1
+		b := fill(a, kdfBlockSize)
+		for j := 0; j < len(i); j += kdfBlockSize {
+			carry := 1
+			for k := kdfBlockSize - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return result[:size]
+}
+
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func pkcs12Password(password string) []byte {
+	return append(bmpString(password), 0, 0)
+}
+
+// encryptKey encrypts a private key in PKCS#8 with 3DES, what is widely
+// supported, also by old Java versions
+func encryptKey(pkcs8 []byte, password []byte) (*encryptedPrivateKeyInfo, error) {
+	salt, err := randomBytes(pkcs12SaltSize)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	key := pkcs12KDF(password, salt, kdfEncryptionKey, pkcs12Iterations, 24)
+	iv := pkcs12KDF(password, salt, kdfIV, pkcs12Iterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := des.BlockSize - len(pkcs8)%des.BlockSize
+	data := append(append([]byte{}, pkcs8...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return &encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBEWithSHA3KeyTripleDES,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: data,
+	}, nil
+}
+
+// explicit wraps DER content in an explicit context-specific tag 0
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func attribute(id asn1.ObjectIdentifier, value asn1.RawValue) (pkcs12Attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{
+		ID:    id,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der},
+	}, nil
+}
+
+// dataContent builds a content info with data
+func dataContent(v interface{}) (contentInfo, error) {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	octets, err := asn1.Marshal(der)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}, nil
+}
+
+// EncodePKCS12 encodes the keystore in PKCS#12 format, protected with
+// a password. Certificates are not encrypted.
+func (k *Keystore) EncodePKCS12(password string) ([]byte, error) {
+	encodedPassword := pkcs12Password(password)
+	localKeyID := sha1.Sum(k.Certificates[0].Raw)
+
+	attributes := []pkcs12Attribute{}
+	a, err := attribute(oidLocalKeyID, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagOctetString, Bytes: localKeyID[:]})
+	if err != nil {
+		return nil, err
+	}
+	attributes = append(attributes, a)
+	if k.Alias != "" {
+		a, err := attribute(oidFriendlyName, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpString(k.Alias)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, a)
+	}
+
+	var certBags []safeBag
+	for i, cert := range k.Certificates {
+		der, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicit(der)}
+		if i == 0 {
+			bag.Attributes = attributes
+		}
+		certBags = append(certBags, bag)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := encryptKey(pkcs8, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(*encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{ID: oidShroudedKeyBag, Value: explicit(der), Attributes: attributes}}
+
+	var authenticatedSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		ci, err := dataContent(bags)
+		if err != nil {
+			return nil, err
+		}
+		authenticatedSafe = append(authenticatedSafe, ci)
+	}
+	authSafeDER, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := dataContent(asn1.RawValue{FullBytes: authSafeDER})
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt, err := randomBytes(pkcs12SaltSize)
+	if err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(encodedPassword, macSalt, kdfMACKey, pkcs12Iterations, kdfHashSize)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authSafeDER)
+
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: authSafe,
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
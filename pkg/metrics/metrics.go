@@ -0,0 +1,221 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+
+	DefaultTextfileMode = os.FileMode(0644)
+)
+
+// labelValueEscaper escapes label values as the Prometheus text format
+// expects, other characters are written as they are
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var keys []string
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, labelValueEscaper.Replace(l[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+type family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples map[string]*Sample
+}
+
+// Registry keeps the last value of a set of metrics, values are
+// identified by the name of the metric and its labels.
+type Registry struct {
+	sync.Mutex
+
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Describe registers a metric, metrics must be described before
+// setting any value on them.
+func (r *Registry) Describe(name, metricType, help string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, found := r.families[name]; found {
+		return
+	}
+	r.families[name] = &family{
+		Name:    name,
+		Help:    help,
+		Type:    metricType,
+		Samples: make(map[string]*Sample),
+	}
+}
+
+func (r *Registry) sample(name string, labels Labels) *Sample {
+	f, found := r.families[name]
+	if !found {
+		panic(fmt.Sprintf("metric %s not described", name))
+	}
+	key := labels.String()
+	s, found := f.Samples[key]
+	if !found {
+		s = &Sample{Labels: labels}
+		f.Samples[key] = s
+	}
+	return s
+}
+
+func (r *Registry) Set(name string, labels Labels, value float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.sample(name, labels).Value = value
+}
+
+func (r *Registry) Add(name string, labels Labels, delta float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.sample(name, labels).Value += delta
+}
+
+// Delete removes all the samples of a metric matching the given labels.
+func (r *Registry) Delete(name string, labels Labels) {
+	r.Lock()
+	defer r.Unlock()
+	f, found := r.families[name]
+	if !found {
+		return
+	}
+	for key, s := range f.Samples {
+		match := true
+		for k, v := range labels {
+			if s.Labels[k] != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			delete(f.Samples, key)
+		}
+	}
+}
+
+func (r *Registry) sortedFamilies() []*family {
+	var names []string
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var families []*family
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	return families
+}
+
+func (f *family) sortedSamples() []*Sample {
+	var keys []string
+	for key := range f.Samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var samples []*Sample
+	for _, key := range keys {
+		samples = append(samples, f.Samples[key])
+	}
+	return samples
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var written int64
+	b := bufio.NewWriter(w)
+	for _, f := range r.sortedFamilies() {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		n, _ := fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.Name, f.Help, f.Name, f.Type)
+		written += int64(n)
+		for _, s := range f.sortedSamples() {
+			n, _ := fmt.Fprintf(b, "%s%s %s\n", f.Name, s.Labels, strconv.FormatFloat(s.Value, 'g', -1, 64))
+			written += int64(n)
+		}
+	}
+	return written, b.Flush()
+}
+
+// WriteTextfile writes the metrics in a file that can be read by the
+// textfile collector of node_exporter. File is atomically replaced so
+// the collector never reads partial content.
+func (r *Registry) WriteTextfile(path string) error {
+	dir := filepath.Dir(path)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = r.WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), DefaultTextfileMode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const expectedExposition = `# HELP test_errors_total Test counter.
+# TYPE test_errors_total counter
+test_errors_total{secret="bar"} 1
+test_errors_total{secret="foo"} 2
+# HELP test_up Test gauge.
+# TYPE test_up gauge
+test_up 1
+`
+
+func newTestRegistry() *Registry {
+	r := NewRegistry()
+	r.Describe("test_up", Gauge, "Test gauge.")
+	r.Describe("test_errors_total", Counter, "Test counter.")
+	r.Describe("test_unused", Gauge, "Test metric without samples.")
+	r.Set("test_up", nil, 1)
+	r.Add("test_errors_total", Labels{"secret": "foo"}, 1)
+	r.Add("test_errors_total", Labels{"secret": "foo"}, 1)
+	r.Add("test_errors_total", Labels{"secret": "bar"}, 1)
+	return r
+}
+
+func TestWriteTo(t *testing.T) {
+	var b bytes.Buffer
+	_, err := newTestRegistry().WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expectedExposition, b.String())
+}
+
+func TestLabelsString(t *testing.T) {
+	cases := []struct {
+		labels   Labels
+		expected string
+	}{
+		{nil, ""},
+		{Labels{"secret": "foo", "file": "/etc/foo"}, `{file="/etc/foo",secret="foo"}`},
+		{Labels{"file": `C:\foo`}, `{file="C:\\foo"}`},
+		{Labels{"file": `"foo"`}, `{file="\"foo\""}`},
+		{Labels{"file": "foo\nbar"}, `{file="foo\nbar"}`},
+		{Labels{"file": "/etc/ñ\tfoo"}, "{file=\"/etc/ñ\tfoo\"}"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.labels.String())
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r := newTestRegistry()
+	r.Delete("test_errors_total", Labels{"secret": "foo"})
+
+	var b bytes.Buffer
+	r.WriteTo(&b)
+	assert.NotContains(t, b.String(), `secret="foo"`)
+	assert.Contains(t, b.String(), `secret="bar"`)
+}
+
+func TestWriteTextfile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-metrics-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := path.Join(tmpdir, "pouch.prom")
+	err = newTestRegistry().WriteTextfile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expectedExposition, string(d))
+
+	files, _ := ioutil.ReadDir(tmpdir)
+	assert.Equal(t, 1, len(files), "Temporary files should be removed")
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum size of the packets sent, to avoid fragmentation
+const statsdMaxPacketSize = 1432
+
+var (
+	statsdInvalidName = regexp.MustCompile(`[^a-zA-Z0-9_\-]+`)
+	statsdInvalidTag  = regexp.MustCompile(`[,|#\s]+`)
+)
+
+// StatsdPusher sends the metrics of a registry to a statsd server. Gauges
+// are sent with their values, and counters with their increments since
+// the last push.
+type StatsdPusher struct {
+	// Address of the server, as host:port
+	Address string
+
+	// Prefix added to the names of the metrics
+	Prefix string
+
+	// Send labels as DogStatsD tags, otherwise their values are
+	// appended to the names of the metrics
+	Tags bool
+
+	// Values of counters in the last push
+	last map[string]float64
+}
+
+func (p *StatsdPusher) name(f *family, s *Sample) string {
+	name := p.Prefix + f.Name
+	if p.Tags || len(s.Labels) == 0 {
+		return name
+	}
+	var keys []string
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name += "." + statsdInvalidName.ReplaceAllString(strings.Trim(s.Labels[k], "/"), "_")
+	}
+	return name
+}
+
+func (p *StatsdPusher) tags(s *Sample) string {
+	if !p.Tags || len(s.Labels) == 0 {
+		return ""
+	}
+	var tags []string
+	for k, v := range s.Labels {
+		tags = append(tags, k+":"+statsdInvalidTag.ReplaceAllString(v, "_"))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// lines returns the metrics of a registry in the statsd protocol
+func (p *StatsdPusher) lines(r *Registry) []string {
+	r.Lock()
+	defer r.Unlock()
+
+	if p.last == nil {
+		p.last = make(map[string]float64)
+	}
+	var lines []string
+	for _, f := range r.sortedFamilies() {
+		for _, s := range f.sortedSamples() {
+			value, kind := s.Value, "g"
+			if f.Type == Counter {
+				key := f.Name + s.Labels.String()
+				value, kind = s.Value-p.last[key], "c"
+				p.last[key] = s.Value
+				if value == 0 {
+					continue
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s:%s|%s%s",
+				p.name(f, s), strconv.FormatFloat(value, 'f', -1, 64), kind, p.tags(s)))
+		}
+	}
+	return lines
+}
+
+// WriteTo writes the metrics of a registry, in packets of limited size
+func (p *StatsdPusher) WriteTo(w io.Writer, r *Registry) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := w.Write(packet.Bytes())
+		packet.Reset()
+		return err
+	}
+	for _, line := range p.lines(r) {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdMaxPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
+
+// Push sends the metrics of a registry to the server
+func (p *StatsdPusher) Push(r *Registry) error {
+	conn, err := net.Dial("udp", p.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return p.WriteTo(conn, r)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdPusher(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("pouch_up", Gauge, "Up.")
+	r.Describe("pouch_file_writes_total", Counter, "Writes.")
+	r.Set("pouch_up", nil, 1)
+	r.Add("pouch_file_writes_total", Labels{"file": "/etc/foo.conf"}, 2)
+
+	p := &StatsdPusher{Prefix: "node1."}
+	var b bytes.Buffer
+	assert.NoError(t, p.WriteTo(&b, r))
+	assert.Equal(t, "node1.pouch_file_writes_total.etc_foo_conf:2|c\nnode1.pouch_up:1|g", b.String())
+
+	// Counters are sent as increments
+	r.Add("pouch_file_writes_total", Labels{"file": "/etc/foo.conf"}, 1)
+	p.Tags = true
+	b.Reset()
+	assert.NoError(t, p.WriteTo(&b, r))
+	assert.Equal(t, "node1.pouch_file_writes_total:1|c|#file:/etc/foo.conf\nnode1.pouch_up:1|g", b.String())
+
+	b.Reset()
+	assert.NoError(t, p.WriteTo(&b, r))
+	assert.Equal(t, "node1.pouch_up:1|g", b.String())
+}
+
+func TestStatsdPush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := NewRegistry()
+	r.Describe("pouch_secrets", Gauge, "Secrets.")
+	for i := 0; i < 100; i++ {
+		r.Set("pouch_secrets", Labels{"secret": strings.Repeat("x", 20) + string(rune('a'+i%26)) + string(rune('a'+i/26))}, 1)
+	}
+	p := &StatsdPusher{Address: conn.LocalAddr().String()}
+	assert.NoError(t, p.Push(r))
+
+	// Metrics are split in several packets
+	lines := 0
+	buf := make([]byte, 2*statsdMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for lines < 100 {
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, n <= statsdMaxPacketSize, "packet too big")
+		lines += len(strings.Split(string(buf[:n]), "\n"))
+	}
+	assert.Equal(t, 100, lines)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nats implements a minimal NATS client able to publish messages
+package nats
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultPort = "4222"
+
+	// Schemes of server URLs, tls forces a TLS connection
+	Scheme    = "nats"
+	TLSScheme = "tls"
+)
+
+type serverInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+}
+
+type connectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// Publisher publishes messages in a NATS server
+type Publisher struct {
+	// Server URL, credentials can be included as user information,
+	// a single user is used as token
+	URL string
+
+	// Name of the client, reported to the server
+	Name string
+
+	// TLS configuration for servers requiring it
+	TLSConfig *tls.Config
+}
+
+// Publish connects with the server, publishes a message and waits for
+// the server to confirm it has been processed
+func (p *Publisher) Publish(ctx context.Context, subject string, payload []byte) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid subject '%s'", subject)
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != Scheme && u.Scheme != TLSScheme {
+		return fmt.Errorf("unsupported scheme in NATS URL: %s", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), DefaultPort)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("couldn't read server info: %v", err)
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected message from server: %s", strings.TrimSpace(line))
+	}
+	var info serverInfo
+	err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if err != nil {
+		return fmt.Errorf("couldn't parse server info: %v", err)
+	}
+
+	if info.TLSRequired || u.Scheme == TLSScheme {
+		config := p.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		config = config.Clone()
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+		r = bufio.NewReader(conn)
+	}
+
+	options := connectOptions{Name: p.Name, Lang: "go", Version: "0.1.0"}
+	if u.User != nil {
+		if password, isSet := u.User.Password(); isSet {
+			options.User = u.User.Username()
+			options.Pass = password
+		} else {
+			options.Token = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	fmt.Fprintf(w, "PUB %s %d\r\n", subject, len(payload))
+	w.Write(payload)
+	w.WriteString("\r\nPING\r\n")
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("couldn't read reply from server: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer accepts a connection and records what the client sends,
+// replying with the given reply to the ping
+func testServer(t *testing.T, reply string) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"tls_required\":false}\r\n")
+		r := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			line = strings.TrimSpace(line)
+			lines = append(lines, line)
+			if line == "PING" {
+				fmt.Fprint(conn, reply)
+				break
+			}
+		}
+		received <- lines
+	}()
+	return "nats://" + l.Addr().String(), received
+}
+
+func TestPublish(t *testing.T) {
+	url, received := testServer(t, "PONG\r\n")
+	url = strings.Replace(url, "nats://", "nats://user:pass@", 1)
+	p := &Publisher{URL: url, Name: "test"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := p.Publish(ctx, "pouch.rotation", []byte("hello"))
+	assert.NoError(t, err)
+
+	lines := <-received
+	if assert.Len(t, lines, 4) {
+		assert.Contains(t, lines[0], `"user":"user","pass":"pass"`)
+		assert.Equal(t, "PUB pouch.rotation 5", lines[1])
+		assert.Equal(t, "hello", lines[2])
+		assert.Equal(t, "PING", lines[3])
+	}
+}
+
+func TestPublishError(t *testing.T) {
+	url, _ := testServer(t, "-ERR 'Authorization Violation'\r\n")
+	p := &Publisher{URL: url}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := p.Publish(ctx, "pouch.rotation", []byte("hello"))
+	assert.Error(t, err)
+
+	err = p.Publish(ctx, "invalid subject", nil)
+	assert.Error(t, err)
+}
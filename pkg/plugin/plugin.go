@@ -0,0 +1,221 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin allows to extend pouch with secret backends, notifiers
+// and outputs implemented in separate binaries. Plugin binaries call
+// Serve with the implementations they provide, pouch runs them and
+// communicates with them over RPC.
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"sync"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+const (
+	SecretBackendPlugin = "secret_backend"
+	NotifierPlugin      = "notifier"
+	OutputPlugin        = "output"
+)
+
+// Handshake must be the same in pouch and plugins, the protocol version
+// needs to be increased on incompatible changes of the interfaces.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "POUCH_PLUGIN",
+	MagicCookieValue: "db4cd5c5-4a34-4d2b-9a5f-0d7b8c1e8f2a",
+}
+
+type SecretRequest struct {
+	Method  string
+	Path    string
+	Data    map[string]interface{}
+	Headers map[string]string
+}
+
+type Secret struct {
+	Data          map[string]interface{}
+	LeaseID       string
+	LeaseDuration int
+	Renewable     bool
+}
+
+// SecretBackend obtains secrets from sources not supported by pouch
+type SecretBackend interface {
+	Read(*SecretRequest) (*Secret, error)
+}
+
+type NotifyRequest struct {
+	// Name of the notifier in pouch configuration
+	Name string
+
+	// Files that triggered the notification
+	Files []string
+}
+
+// Notifier is notified when files change
+type Notifier interface {
+	Notify(*NotifyRequest) (output string, err error)
+}
+
+type OutputRequest struct {
+	Path    string
+	Mode    uint32
+	Content []byte
+}
+
+// Output delivers rendered files to targets other than the local filesystem
+type Output interface {
+	Write(*OutputRequest) error
+}
+
+// Serve is called by plugin binaries to serve their implementations,
+// implementations not provided by the plugin can be nil.
+func Serve(backend SecretBackend, notifier Notifier, output Output) {
+	plugins := make(map[string]plugin.Plugin)
+	if backend != nil {
+		plugins[SecretBackendPlugin] = &secretBackendPlugin{impl: backend}
+	}
+	if notifier != nil {
+		plugins[NotifierPlugin] = &notifierPlugin{impl: notifier}
+	}
+	if output != nil {
+		plugins[OutputPlugin] = &outputPlugin{impl: output}
+	}
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugins,
+	})
+}
+
+type Config struct {
+	// Path to the plugin binary
+	Path string `json:"path,omitempty"`
+
+	// Arguments for the plugin binary
+	Args []string `json:"args,omitempty"`
+
+	// Optional hex-encoded SHA256 checksum of the binary, if set
+	// the plugin is not run if it doesn't match
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Plugin is a plugin binary, it is started the first time it is used,
+// and started again if it exits.
+type Plugin struct {
+	sync.Mutex
+
+	Name   string
+	Config Config
+
+	client *plugin.Client
+}
+
+func New(name string, c Config) *Plugin {
+	return &Plugin{Name: name, Config: c}
+}
+
+func (p *Plugin) clientConfig() (*plugin.ClientConfig, error) {
+	if p.Config.Path == "" {
+		return nil, fmt.Errorf("path for plugin '%s' not set", p.Name)
+	}
+	config := &plugin.ClientConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]plugin.Plugin{
+			SecretBackendPlugin: &secretBackendPlugin{},
+			NotifierPlugin:      &notifierPlugin{},
+			OutputPlugin:        &outputPlugin{},
+		},
+		Cmd:     exec.Command(p.Config.Path, p.Config.Args...),
+		Managed: true,
+	}
+	if p.Config.Checksum != "" {
+		checksum, err := hex.DecodeString(p.Config.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect checksum for plugin '%s': %v", p.Name, err)
+		}
+		config.SecureConfig = &plugin.SecureConfig{
+			Checksum: checksum,
+			Hash:     sha256.New(),
+		}
+	}
+	return config, nil
+}
+
+func (p *Plugin) dispense(kind string) (interface{}, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.client != nil && p.client.Exited() {
+		p.client.Kill()
+		p.client = nil
+	}
+	if p.client == nil {
+		config, err := p.clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		p.client = plugin.NewClient(config)
+	}
+
+	rpcClient, err := p.client.Client()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start plugin '%s': %v", p.Name, err)
+	}
+	impl, err := rpcClient.Dispense(kind)
+	if err != nil {
+		return nil, fmt.Errorf("plugin '%s' doesn't implement %s: %v", p.Name, kind, err)
+	}
+	return impl, nil
+}
+
+func (p *Plugin) SecretBackend() (SecretBackend, error) {
+	impl, err := p.dispense(SecretBackendPlugin)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(SecretBackend), nil
+}
+
+func (p *Plugin) Notifier() (Notifier, error) {
+	impl, err := p.dispense(NotifierPlugin)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(Notifier), nil
+}
+
+func (p *Plugin) Output() (Output, error) {
+	impl, err := p.dispense(OutputPlugin)
+	if err != nil {
+		return nil, err
+	}
+	return impl.(Output), nil
+}
+
+func (p *Plugin) Kill() {
+	p.Lock()
+	defer p.Unlock()
+	if p.client != nil {
+		p.client.Kill()
+		p.client = nil
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPlugin struct {
+	written []OutputRequest
+}
+
+func (p *testPlugin) Read(r *SecretRequest) (*Secret, error) {
+	switch r.Path {
+	case "/error":
+		return nil, fmt.Errorf("not found")
+	case "/empty":
+		return nil, nil
+	}
+	return &Secret{
+		Data: map[string]interface{}{
+			"path":   r.Path,
+			"method": r.Method,
+			"count":  r.Data["count"],
+		},
+		LeaseDuration: 100,
+	}, nil
+}
+
+func (p *testPlugin) Notify(r *NotifyRequest) (string, error) {
+	return "notified " + r.Name, nil
+}
+
+func (p *testPlugin) Write(r *OutputRequest) error {
+	p.written = append(p.written, *r)
+	return nil
+}
+
+func testDispense(t *testing.T, impl *testPlugin, kind string) interface{} {
+	client, _ := plugin.TestPluginRPCConn(t, map[string]plugin.Plugin{
+		SecretBackendPlugin: &secretBackendPlugin{impl: impl},
+		NotifierPlugin:      &notifierPlugin{impl: impl},
+		OutputPlugin:        &outputPlugin{impl: impl},
+	})
+	raw, err := client.Dispense(kind)
+	assert.NoError(t, err)
+	return raw
+}
+
+func TestSecretBackendPlugin(t *testing.T) {
+	backend := testDispense(t, &testPlugin{}, SecretBackendPlugin).(SecretBackend)
+
+	s, err := backend.Read(&SecretRequest{
+		Method: "POST",
+		Path:   "/foo",
+		Data:   map[string]interface{}{"count": 3},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, s.LeaseDuration)
+	assert.Equal(t, "/foo", s.Data["path"])
+	assert.Equal(t, "POST", s.Data["method"])
+	assert.Equal(t, json.Number("3"), s.Data["count"])
+
+	_, err = backend.Read(&SecretRequest{Path: "/error"})
+	assert.Error(t, err)
+
+	_, err = backend.Read(&SecretRequest{Path: "/empty"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no secret found in /empty")
+	}
+}
+
+func TestNotifierPlugin(t *testing.T) {
+	notifier := testDispense(t, &testPlugin{}, NotifierPlugin).(Notifier)
+
+	out, err := notifier.Notify(&NotifyRequest{Name: "foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, "notified foo", out)
+}
+
+func TestOutputPlugin(t *testing.T) {
+	impl := &testPlugin{}
+	output := testDispense(t, impl, OutputPlugin).(Output)
+
+	err := output.Write(&OutputRequest{Path: "/foo", Mode: 0600, Content: []byte("bar")})
+	assert.NoError(t, err)
+	assert.Equal(t, []OutputRequest{{Path: "/foo", Mode: 0600, Content: []byte("bar")}}, impl.written)
+}
+
+func TestPluginWithoutPath(t *testing.T) {
+	p := New("foo", Config{})
+	_, err := p.SecretBackend()
+	assert.Error(t, err)
+}
@@ -0,0 +1,202 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/rpc"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// Arbitrary data is passed JSON-encoded, so there is no need to register
+// in gob every type that could be found in secrets
+
+func encodeData(data map[string]interface{}) ([]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return json.Marshal(data)
+}
+
+func decodeData(d []byte) (map[string]interface{}, error) {
+	if d == nil {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(d))
+	decoder.UseNumber()
+	err := decoder.Decode(&data)
+	return data, err
+}
+
+// RPCSecretRequest and RPCSecret are only used in the wire, they need
+// to be exported so net/rpc can use them as arguments
+
+type RPCSecretRequest struct {
+	Method  string
+	Path    string
+	Data    []byte
+	Headers map[string]string
+}
+
+type RPCSecret struct {
+	Data          []byte
+	LeaseID       string
+	LeaseDuration int
+	Renewable     bool
+}
+
+type secretBackendPlugin struct {
+	impl SecretBackend
+}
+
+func (p *secretBackendPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &secretBackendServer{impl: p.impl}, nil
+}
+
+func (p *secretBackendPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &secretBackendClient{client: c}, nil
+}
+
+type secretBackendServer struct {
+	impl SecretBackend
+}
+
+func (s *secretBackendServer) Read(args RPCSecretRequest, reply *RPCSecret) error {
+	data, err := decodeData(args.Data)
+	if err != nil {
+		return err
+	}
+	secret, err := s.impl.Read(&SecretRequest{
+		Method:  args.Method,
+		Path:    args.Path,
+		Data:    data,
+		Headers: args.Headers,
+	})
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no secret found in %s", args.Path)
+	}
+	encoded, err := encodeData(secret.Data)
+	if err != nil {
+		return err
+	}
+	*reply = RPCSecret{
+		Data:          encoded,
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: secret.LeaseDuration,
+		Renewable:     secret.Renewable,
+	}
+	return nil
+}
+
+type secretBackendClient struct {
+	client *rpc.Client
+}
+
+func (c *secretBackendClient) Read(r *SecretRequest) (*Secret, error) {
+	encoded, err := encodeData(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	args := RPCSecretRequest{
+		Method:  r.Method,
+		Path:    r.Path,
+		Data:    encoded,
+		Headers: r.Headers,
+	}
+	var reply RPCSecret
+	err = c.client.Call("Plugin.Read", args, &reply)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeData(reply.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &Secret{
+		Data:          data,
+		LeaseID:       reply.LeaseID,
+		LeaseDuration: reply.LeaseDuration,
+		Renewable:     reply.Renewable,
+	}, nil
+}
+
+type notifierPlugin struct {
+	impl Notifier
+}
+
+func (p *notifierPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &notifierServer{impl: p.impl}, nil
+}
+
+func (p *notifierPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &notifierClient{client: c}, nil
+}
+
+type notifierServer struct {
+	impl Notifier
+}
+
+func (s *notifierServer) Notify(args NotifyRequest, reply *string) error {
+	out, err := s.impl.Notify(&args)
+	*reply = out
+	return err
+}
+
+type notifierClient struct {
+	client *rpc.Client
+}
+
+func (c *notifierClient) Notify(r *NotifyRequest) (string, error) {
+	var out string
+	err := c.client.Call("Plugin.Notify", *r, &out)
+	return out, err
+}
+
+type outputPlugin struct {
+	impl Output
+}
+
+func (p *outputPlugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &outputServer{impl: p.impl}, nil
+}
+
+func (p *outputPlugin) Client(b *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &outputClient{client: c}, nil
+}
+
+type outputServer struct {
+	impl Output
+}
+
+func (s *outputServer) Write(args OutputRequest, reply *struct{}) error {
+	return s.impl.Write(&args)
+}
+
+type outputClient struct {
+	client *rpc.Client
+}
+
+func (c *outputClient) Write(r *OutputRequest) error {
+	return c.client.Call("Plugin.Write", *r, &struct{}{})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pouchapi defines the gRPC API served by pouch in a unix socket,
+// so local applications can obtain rendered files and subscribe to updates
+// without reading them from disk, and a client for it
+package pouchapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	netcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	ServiceName = "pouch.Pouch"
+
+	getFileMethod   = "/pouch.Pouch/GetFile"
+	subscribeMethod = "/pouch.Pouch/Subscribe"
+)
+
+// Server implements the API
+type Server interface {
+	GetFile(ctx context.Context, req *GetFileRequest) (*File, error)
+
+	// Subscribe sends events with send till the context is done
+	Subscribe(ctx context.Context, req *SubscribeRequest, send func(*Event) error) error
+}
+
+var subscribeStream = grpc.StreamDesc{
+	StreamName:    "Subscribe",
+	ServerStreams: true,
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "GetFile",
+		Handler: func(srv interface{}, ctx netcontext.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var req GetFileRequest
+			if err := dec(&req); err != nil {
+				return nil, err
+			}
+			return srv.(Server).GetFile(ctx, &req)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    subscribeStream.StreamName,
+		ServerStreams: subscribeStream.ServerStreams,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var req SubscribeRequest
+			if err := stream.RecvMsg(&req); err != nil {
+				return err
+			}
+			return srv.(Server).Subscribe(stream.Context(), &req, func(e *Event) error {
+				return stream.SendMsg(e)
+			})
+		},
+	}},
+	Metadata: "pouch.proto",
+}
+
+// RegisterServer registers the implementation of the API in a gRPC server
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// Client connects to the API served in a unix socket
+type Client struct {
+	Socket string
+}
+
+func NewClient(socket string) *Client {
+	return &Client{Socket: strings.TrimPrefix(socket, "unix://")}
+}
+
+func (c *Client) dial(ctx context.Context) (*grpc.ClientConn, error) {
+	conn, err := grpc.DialContext(ctx, c.Socket,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to pouch in %s: %v", c.Socket, err)
+	}
+	return conn, nil
+}
+
+// GetFile obtains the current content of a file
+func (c *Client) GetFile(ctx context.Context, path string) (*File, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var file File
+	err = grpc.Invoke(ctx, getFileMethod, &GetFileRequest{Path: path}, &file, conn)
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Subscribe calls f with the events matching the request till the context
+// is done or the connection fails
+func (c *Client) Subscribe(ctx context.Context, req *SubscribeRequest, f func(*Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stream, err := grpc.NewClientStream(ctx, &subscribeStream, conn, subscribeMethod)
+	if err != nil {
+		return err
+	}
+	err = stream.SendMsg(req)
+	if err != nil {
+		return err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+	for {
+		var e Event
+		err = stream.RecvMsg(&e)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		f(&e)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouchapi
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Messages of the API of pouch, as defined in pouch.proto
+
+type GetFileRequest struct {
+	Path string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+}
+
+func (m *GetFileRequest) Reset()         { *m = GetFileRequest{} }
+func (m *GetFileRequest) String() string { return proto.CompactTextString(m) }
+func (*GetFileRequest) ProtoMessage()    {}
+
+type File struct {
+	Path    string   `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Content []byte   `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
+	SHA256  string   `protobuf:"bytes,3,opt,name=sha256" json:"sha256,omitempty"`
+	Secrets []string `protobuf:"bytes,4,rep,name=secrets" json:"secrets,omitempty"`
+}
+
+func (m *File) Reset()         { *m = File{} }
+func (m *File) String() string { return proto.CompactTextString(m) }
+func (*File) ProtoMessage()    {}
+
+type SubscribeRequest struct {
+	Secrets []string `protobuf:"bytes,1,rep,name=secrets" json:"secrets,omitempty"`
+	Files   []string `protobuf:"bytes,2,rep,name=files" json:"files,omitempty"`
+	Types   []string `protobuf:"bytes,3,rep,name=types" json:"types,omitempty"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+
+type Event struct {
+	TimeUnixNano int64  `protobuf:"varint,1,opt,name=time_unix_nano,json=timeUnixNano" json:"time_unix_nano,omitempty"`
+	Type         string `protobuf:"bytes,2,opt,name=type" json:"type,omitempty"`
+	Secret       string `protobuf:"bytes,3,opt,name=secret" json:"secret,omitempty"`
+	File         string `protobuf:"bytes,4,opt,name=file" json:"file,omitempty"`
+	Notifier     string `protobuf:"bytes,5,opt,name=notifier" json:"notifier,omitempty"`
+	Message      string `protobuf:"bytes,6,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
@@ -0,0 +1,292 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pouchtest provides an in-memory Vault, so code embedding pouch
+// can be tested without running Vault
+package pouchtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// Token obtained on login by default
+	DefaultToken = "pouchtest-token"
+
+	// Method used to inject failures on renewals, with the lease ID as path
+	RenewMethod = "RENEW"
+)
+
+// Request is a request received by the Vault
+type Request struct {
+	Method  string
+	Path    string
+	Options *vault.RequestOptions
+}
+
+type failure struct {
+	statusCode int
+	times      int
+}
+
+type response struct {
+	data map[string]interface{}
+	ttl  time.Duration
+}
+
+// Vault is an in-memory implementation of vault.Vault, it can be used
+// concurrently. Responses are programmed with SetSecret and SetResponse,
+// and failures can be injected with Fail.
+type Vault struct {
+	sync.Mutex
+
+	// Token obtained on login, DefaultToken if not set
+	LoginToken string
+
+	token     string
+	loginErr  error
+	secretIDs map[string]string
+
+	responses map[string]response
+	secrets   map[string]*api.Secret
+	failures  map[string]*failure
+	leases    map[string]string
+	requests  []Request
+	nextLease int
+
+	subscribers []chan vault.Event
+}
+
+// NewVault creates an empty in-memory Vault
+func NewVault() *Vault {
+	return &Vault{
+		secretIDs: make(map[string]string),
+		responses: make(map[string]response),
+		secrets:   make(map[string]*api.Secret),
+		failures:  make(map[string]*failure),
+		leases:    make(map[string]string),
+	}
+}
+
+func key(method, path string) string {
+	if method == "" {
+		method = http.MethodGet
+	}
+	return method + " " + path
+}
+
+// SetSecret sets the data returned when reading the path, a new lease with
+// the given TTL is created on each read, no lease is created if it is zero
+func (v *Vault) SetSecret(path string, data map[string]interface{}, ttl time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	v.responses[key(http.MethodGet, path)] = response{data: data, ttl: ttl}
+}
+
+// SetResponse sets the secret returned for requests with a method and
+// a path, as it is
+func (v *Vault) SetResponse(method, path string, s *api.Secret) {
+	v.Lock()
+	defer v.Unlock()
+	v.secrets[key(method, path)] = s
+}
+
+// DeleteSecret removes the responses for a path, requests to it fail as
+// not found
+func (v *Vault) DeleteSecret(method, path string) {
+	v.Lock()
+	defer v.Unlock()
+	delete(v.responses, key(method, path))
+	delete(v.secrets, key(method, path))
+}
+
+// Fail makes the next requests with a method and a path fail the given
+// number of times, or always if times is negative. Failures have a response
+// with the status code, or no response if it is zero, as connection errors.
+func (v *Vault) Fail(method, path string, statusCode int, times int) {
+	v.Lock()
+	defer v.Unlock()
+	v.failures[key(method, path)] = &failure{statusCode: statusCode, times: times}
+}
+
+// FailLogin makes logins fail with the error, or succeed again if it is nil
+func (v *Vault) FailLogin(err error) {
+	v.Lock()
+	defer v.Unlock()
+	v.loginErr = err
+}
+
+// SetWrappedSecretID makes a wrapped secret ID available for unwrapping
+func (v *Vault) SetWrappedSecretID(wrapped, secretID string) {
+	v.Lock()
+	defer v.Unlock()
+	v.secretIDs[wrapped] = secretID
+}
+
+// Requests returns the requests received, including renewals
+func (v *Vault) Requests() []Request {
+	v.Lock()
+	defer v.Unlock()
+	requests := make([]Request, len(v.requests))
+	copy(requests, v.requests)
+	return requests
+}
+
+// Publish sends an event to the current subscribers, it blocks till
+// they receive it
+func (v *Vault) Publish(e vault.Event) {
+	v.Lock()
+	subscribers := v.subscribers
+	v.Unlock()
+	for _, s := range subscribers {
+		s <- e
+	}
+}
+
+func (v *Vault) Login() error {
+	v.Lock()
+	defer v.Unlock()
+	if v.loginErr != nil {
+		return v.loginErr
+	}
+	if v.token == "" {
+		v.token = v.LoginToken
+		if v.token == "" {
+			v.token = DefaultToken
+		}
+	}
+	return nil
+}
+
+func (v *Vault) UnwrapSecretID(token string) error {
+	v.Lock()
+	defer v.Unlock()
+	if _, found := v.secretIDs[token]; !found {
+		return fmt.Errorf("wrapping token is not valid or does not exist")
+	}
+	delete(v.secretIDs, token)
+	return nil
+}
+
+func (v *Vault) GetToken() string {
+	v.Lock()
+	defer v.Unlock()
+	return v.token
+}
+
+func (v *Vault) SetToken(token string) {
+	v.Lock()
+	defer v.Unlock()
+	v.token = token
+}
+
+// injectedFailure returns the error and the response of a programmed
+// failure, if any
+func (v *Vault) injectedFailure(k string) (*api.Response, error) {
+	f, found := v.failures[k]
+	if !found || f.times == 0 {
+		return nil, nil
+	}
+	if f.times > 0 {
+		f.times--
+	}
+	err := fmt.Errorf("injected failure for %s", k)
+	if f.statusCode == 0 {
+		return nil, err
+	}
+	return &api.Response{Response: &http.Response{StatusCode: f.statusCode}}, err
+}
+
+func errorResponse(statusCode int, format string, args ...interface{}) (*api.Secret, *api.Response, error) {
+	resp := &api.Response{Response: &http.Response{StatusCode: statusCode}}
+	return nil, resp, fmt.Errorf(format, args...)
+}
+
+func (v *Vault) Request(method, urlPath string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	v.Lock()
+	defer v.Unlock()
+	v.requests = append(v.requests, Request{Method: method, Path: urlPath, Options: options})
+	if v.token == "" {
+		return errorResponse(http.StatusForbidden, "permission denied, not logged in")
+	}
+	k := key(method, urlPath)
+	if resp, err := v.injectedFailure(k); err != nil {
+		return nil, resp, err
+	}
+	if s, found := v.secrets[k]; found {
+		return s, nil, nil
+	}
+	r, found := v.responses[k]
+	if !found {
+		return errorResponse(http.StatusNotFound, "no secret found for %s", k)
+	}
+	data := make(map[string]interface{}, len(r.data))
+	for name, value := range r.data {
+		data[name] = value
+	}
+	s := &api.Secret{Data: data}
+	if r.ttl > 0 {
+		v.nextLease++
+		s.LeaseID = fmt.Sprintf("%s/%d", urlPath, v.nextLease)
+		s.LeaseDuration = int(r.ttl / time.Second)
+		s.Renewable = true
+		v.leases[s.LeaseID] = urlPath
+	}
+	return s, nil, nil
+}
+
+// Renew extends leases of secrets set with SetSecret
+func (v *Vault) Renew(leaseID string, increment int) (*api.Secret, error) {
+	v.Lock()
+	defer v.Unlock()
+	v.requests = append(v.requests, Request{Method: RenewMethod, Path: leaseID})
+	if _, err := v.injectedFailure(key(RenewMethod, leaseID)); err != nil {
+		return nil, err
+	}
+	if _, found := v.leases[leaseID]; !found {
+		return nil, fmt.Errorf("lease not found or lease is not renewable")
+	}
+	return &api.Secret{LeaseID: leaseID, LeaseDuration: increment, Renewable: true}, nil
+}
+
+// Subscribe returns a channel receiving the events sent with Publish, till
+// the context is done
+func (v *Vault) Subscribe(ctx context.Context, eventType string) (<-chan vault.Event, error) {
+	events := make(chan vault.Event)
+	v.Lock()
+	v.subscribers = append(v.subscribers, events)
+	v.Unlock()
+	go func() {
+		<-ctx.Done()
+		v.Lock()
+		defer v.Unlock()
+		for i, s := range v.subscribers {
+			if s == events {
+				v.subscribers = append(v.subscribers[:i], v.subscribers[i+1:]...)
+				break
+			}
+		}
+	}()
+	return events, nil
+}
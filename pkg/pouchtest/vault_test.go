@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouchtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// Vault can be used wherever a vault.Vault is expected
+var _ vault.Vault = &Vault{}
+
+func TestVaultRequests(t *testing.T) {
+	v := NewVault()
+	v.SetSecret("/v1/secret/foo", map[string]interface{}{"password": "secret"}, time.Hour)
+	v.SetResponse("POST", "/v1/pki/issue/foo", &api.Secret{Data: map[string]interface{}{"certificate": "cert"}})
+
+	_, resp, err := v.Request("GET", "/v1/secret/foo", nil)
+	assert.Error(t, err, "requests without login should fail")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	v.FailLogin(fmt.Errorf("login failed"))
+	assert.Error(t, v.Login())
+	v.FailLogin(nil)
+	assert.NoError(t, v.Login())
+	assert.Equal(t, DefaultToken, v.GetToken())
+
+	s, _, err := v.Request("GET", "/v1/secret/foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", s.Data["password"])
+	assert.Equal(t, 3600, s.LeaseDuration)
+	assert.True(t, s.Renewable)
+
+	renewed, err := v.Renew(s.LeaseID, 600)
+	assert.NoError(t, err)
+	assert.Equal(t, 600, renewed.LeaseDuration)
+	_, err = v.Renew("unknown", 600)
+	assert.Error(t, err)
+
+	s, _, err = v.Request("POST", "/v1/pki/issue/foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "cert", s.Data["certificate"])
+
+	_, resp, err = v.Request("GET", "/v1/secret/unknown", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	v.Fail("GET", "/v1/secret/foo", http.StatusServiceUnavailable, 1)
+	_, resp, err = v.Request("GET", "/v1/secret/foo", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_, _, err = v.Request("GET", "/v1/secret/foo", nil)
+	assert.NoError(t, err, "failure should be injected only once")
+
+	v.Fail("GET", "/v1/secret/foo", 0, -1)
+	for i := 0; i < 3; i++ {
+		_, resp, err = v.Request("GET", "/v1/secret/foo", nil)
+		assert.Error(t, err)
+		assert.Nil(t, resp)
+	}
+
+	v.Fail(RenewMethod, renewed.LeaseID, 0, 1)
+	_, err = v.Renew(renewed.LeaseID, 600)
+	assert.Error(t, err)
+
+	assert.Len(t, v.Requests(), 12)
+}
+
+func TestVaultUnwrapAndEvents(t *testing.T) {
+	v := NewVault()
+	v.SetWrappedSecretID("wrapped", "secret-id")
+	assert.NoError(t, v.UnwrapSecretID("wrapped"))
+	assert.Error(t, v.UnwrapSecretID("wrapped"), "wrapped secret IDs can be used once")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := v.Subscribe(ctx, "kv*")
+	assert.NoError(t, err)
+	go v.Publish(vault.Event{Path: "secret/data/foo"})
+	select {
+	case e := <-events:
+		assert.Equal(t, "secret/data/foo", e.Path)
+	case <-time.After(time.Second):
+		t.Fatal("event not received")
+	}
+}
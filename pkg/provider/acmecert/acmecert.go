@@ -0,0 +1,269 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package acmecert provides certificates obtained with the ACME protocol,
+// as from Let's Encrypt, so they are rendered and rotated as any other
+// secret
+package acmecert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/acme"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "acme"
+
+	DefaultAccountKeyPath = "/var/lib/pouch/acme-account.key"
+	DefaultTimeout        = 10 * time.Minute
+)
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	// Directory of the ACME server, Let's Encrypt by default
+	DirectoryURL string `json:"directory_url,omitempty"`
+
+	// Contact email for the account
+	Email string `json:"email,omitempty"`
+
+	// Path to the account key, it is created if it doesn't exist
+	AccountKeyPath string `json:"account_key_path,omitempty"`
+
+	// Terms of service of the ACME server need to be explicitly agreed
+	AgreeTerms bool `json:"agree_terms,omitempty"`
+
+	// Address to serve HTTP-01 challenges
+	HTTP01Listen string `json:"http01_listen,omitempty"`
+
+	// Command to create and remove the TXT records of DNS-01 challenges
+	DNS01Command string `json:"dns01_command,omitempty"`
+
+	// Maximum time to obtain a certificate, 10 minutes by default
+	Timeout string `json:"timeout,omitempty"`
+}
+
+type acmeProvider struct {
+	sync.Mutex
+
+	config  Config
+	timeout time.Duration
+	solver  acme.Solver
+	client  *acme.Client
+}
+
+// New creates an ACME certificates provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", ProviderName, err)
+		}
+	}
+	if !c.AgreeTerms {
+		return nil, fmt.Errorf("terms of service of the ACME server need to be agreed")
+	}
+	if c.DirectoryURL == "" {
+		c.DirectoryURL = acme.LetsEncryptURL
+	}
+	if c.AccountKeyPath == "" {
+		c.AccountKeyPath = DefaultAccountKeyPath
+	}
+
+	p := &acmeProvider{config: c, timeout: DefaultTimeout}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect timeout: %v", err)
+		}
+		p.timeout = d
+	}
+	switch {
+	case c.DNS01Command != "":
+		p.solver = &acme.DNS01Solver{Command: c.DNS01Command}
+	case c.HTTP01Listen != "":
+		p.solver = &acme.HTTP01Solver{Listen: c.HTTP01Listen}
+	default:
+		return nil, fmt.Errorf("address for HTTP-01 challenges or command for DNS-01 challenges needed")
+	}
+	return p, nil
+}
+
+// accountKey loads the account key, or creates it if it doesn't exist
+func accountKey(path string) (*ecdsa.PrivateKey, error) {
+	d, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(d)
+		if block == nil {
+			return nil, fmt.Errorf("no key found in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Login registers the ACME account, or finds it if it already exists
+func (p *acmeProvider) Login() error {
+	p.Lock()
+	defer p.Unlock()
+	return p.login()
+}
+
+func (p *acmeProvider) login() error {
+	key, err := accountKey(p.config.AccountKeyPath)
+	if err != nil {
+		return fmt.Errorf("couldn't load ACME account key: %v", err)
+	}
+	client := acme.NewClient(p.config.DirectoryURL, key)
+	var contact []string
+	if p.config.Email != "" {
+		contact = []string{"mailto:" + p.config.Email}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	err = client.Register(ctx, contact)
+	if err != nil {
+		return err
+	}
+	p.client = client
+	return nil
+}
+
+// Request obtains a new certificate for the comma-separated list of
+// domains in the path, with a new key
+func (p *acmeProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	var domains []string
+	for _, d := range strings.Split(path, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, badRequest(), fmt.Errorf("domains needed")
+	}
+	if p.client == nil {
+		err := p.login()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	chain, err := p.client.ObtainCertificate(ctx, domains, p.solver, csr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't obtain certificate for %s: %v", strings.Join(domains, ", "), err)
+	}
+	data, err := secretData(chain, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Certificates are updated using their validity
+	return &api.Secret{Data: data}, nil, nil
+}
+
+// secretData splits the chain in the certificate and its issuers, keys
+// are named as in the PKI secrets engine of Vault
+func secretData(chain []byte, key *ecdsa.PrivateKey) (map[string]interface{}, error) {
+	var certs []string
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		certs = append(certs, string(pem.EncodeToMemory(block)))
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates received")
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"certificate": certs[0],
+		"ca_chain":    strings.Join(certs[1:], ""),
+		"fullchain":   strings.Join(certs, ""),
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func (p *acmeProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
+
+func badRequest() *api.Response {
+	return &api.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}}
+}
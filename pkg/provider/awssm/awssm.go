@@ -0,0 +1,200 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package awssm provides secrets from AWS Secrets Manager, secrets are
+// referenced as awssm://<name or ARN>. Importing this package registers
+// the provider.
+package awssm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/provider/internal/awsjson"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "awssm"
+
+	// Secrets read are reused during this time, so secrets using the
+	// same AWS secret don't need to read it again
+	CacheTTL = time.Minute
+
+	// Keys for secrets that are not JSON objects
+	ValueKey  = "value"
+	BinaryKey = "binary"
+)
+
+var service = awsjson.Service{
+	Name:         "secretsmanager",
+	APIVersion:   "2017-10-17",
+	JSONVersion:  "1.1",
+	TargetPrefix: "secretsmanager",
+}
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	awsjson.Config
+}
+
+type getSecretValueInput struct {
+	_ struct{} `type:"structure"`
+
+	SecretId     *string `type:"string" required:"true"`
+	VersionId    *string `type:"string"`
+	VersionStage *string `type:"string"`
+}
+
+type getSecretValueOutput struct {
+	_ struct{} `type:"structure"`
+
+	ARN          *string `type:"string"`
+	Name         *string `type:"string"`
+	SecretBinary []byte  `type:"blob"`
+	SecretString *string `type:"string"`
+	VersionId    *string `type:"string"`
+}
+
+type cachedSecret struct {
+	secret *api.Secret
+	time   time.Time
+}
+
+type secretsManager struct {
+	client          *awsjson.Client
+	refreshInterval time.Duration
+
+	cacheLock sync.Mutex
+	cache     map[string]cachedSecret
+}
+
+// New creates an AWS Secrets Manager provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	err := awsjson.ParseConfig(ProviderName, config, &c)
+	if err != nil {
+		return nil, err
+	}
+	refreshInterval, err := c.RefreshPeriod()
+	if err != nil {
+		return nil, err
+	}
+	client, err := awsjson.New(c.Config, service)
+	if err != nil {
+		return nil, err
+	}
+	return &secretsManager{
+		client:          client,
+		refreshInterval: refreshInterval,
+		cache:           make(map[string]cachedSecret),
+	}, nil
+}
+
+func (m *secretsManager) Login() error {
+	return nil
+}
+
+// Request reads a secret, the path is its name or ARN, optionally with
+// version_id or version_stage query parameters
+func (m *secretsManager) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	m.cacheLock.Lock()
+	cached, found := m.cache[path]
+	m.cacheLock.Unlock()
+	if found && time.Since(cached.time) < CacheTTL {
+		return cached.secret, nil, nil
+	}
+
+	input, err := parsePath(path)
+	if err != nil {
+		return nil, awsjson.ErrorResponse(http.StatusBadRequest), err
+	}
+	output := &getSecretValueOutput{}
+	resp, err := m.client.Call("GetSecretValue", input, output)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	// Secrets don't expire, but they are read again after the
+	// refresh interval
+	secret := &api.Secret{
+		Data:          secretData(output),
+		LeaseDuration: int(m.refreshInterval / time.Second),
+	}
+
+	m.cacheLock.Lock()
+	m.cache[path] = cachedSecret{secret: secret, time: time.Now()}
+	m.cacheLock.Unlock()
+	return secret, nil, nil
+}
+
+func (m *secretsManager) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
+
+func parsePath(path string) (*getSecretValueInput, error) {
+	// Not parsed as URL, as ARNs would be confused with schemes
+	parts := strings.SplitN(path, "?", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("secret ID needed")
+	}
+	input := &getSecretValueInput{SecretId: aws.String(parts[0])}
+	if len(parts) == 1 {
+		return input, nil
+	}
+	query, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if v := query.Get("version_id"); v != "" {
+		input.VersionId = aws.String(v)
+	}
+	if v := query.Get("version_stage"); v != "" {
+		input.VersionStage = aws.String(v)
+	}
+	return input, nil
+}
+
+// secretData returns the keys of secrets stored as JSON objects, other
+// secrets are returned in a single key
+func secretData(output *getSecretValueOutput) map[string]interface{} {
+	if output.SecretString == nil {
+		return map[string]interface{}{
+			BinaryKey: base64.StdEncoding.EncodeToString(output.SecretBinary),
+		}
+	}
+	value := *output.SecretString
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return map[string]interface{}{ValueKey: value}
+	}
+	return data
+}
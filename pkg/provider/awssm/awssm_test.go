@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awssm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256")
+
+		var input map[string]string
+		d, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(d, &input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch input["SecretId"] {
+		case "app":
+			assert.Equal(t, "AWSCURRENT", input["VersionStage"])
+			w.Write([]byte(`{"Name": "app", "SecretString": "{\"user\": \"foo\", \"port\": 5432}"}`))
+		case "plain":
+			w.Write([]byte(`{"Name": "plain", "SecretString": "password"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	config, _ := json.Marshal(map[string]string{
+		"region":           "eu-west-1",
+		"endpoint":         server.URL,
+		"refresh_interval": "10m",
+	})
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := provider.Request("", "app?version_stage=AWSCURRENT", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", s.Data["user"])
+	assert.Equal(t, json.Number("5432"), s.Data["port"])
+	assert.Equal(t, 600, s.LeaseDuration)
+
+	// Cached
+	_, _, err = provider.Request("", "app?version_stage=AWSCURRENT", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	s, _, err = provider.Request("", "plain", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "password", s.Data[ValueKey])
+
+	_, resp, err := provider.Request("", "unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
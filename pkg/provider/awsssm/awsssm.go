@@ -0,0 +1,223 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package awsssm provides secrets stored as parameters in AWS Systems
+// Manager Parameter Store
+package awsssm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/provider/internal/awsjson"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "ssm"
+
+	// Key for secrets obtained from a single parameter
+	ValueKey = "value"
+)
+
+var service = awsjson.Service{
+	Name:         "ssm",
+	APIVersion:   "2014-11-06",
+	JSONVersion:  "1.1",
+	TargetPrefix: "AmazonSSM",
+}
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	awsjson.Config
+}
+
+type parameter struct {
+	_ struct{} `type:"structure"`
+
+	Name    *string `type:"string"`
+	Type    *string `type:"string"`
+	Value   *string `type:"string"`
+	Version *int64  `type:"long"`
+}
+
+type getParameterInput struct {
+	_ struct{} `type:"structure"`
+
+	Name           *string `type:"string" required:"true"`
+	WithDecryption *bool   `type:"boolean"`
+}
+
+type getParameterOutput struct {
+	_ struct{} `type:"structure"`
+
+	Parameter *parameter `type:"structure"`
+}
+
+type getParametersByPathInput struct {
+	_ struct{} `type:"structure"`
+
+	Path           *string `type:"string" required:"true"`
+	Recursive      *bool   `type:"boolean"`
+	WithDecryption *bool   `type:"boolean"`
+	NextToken      *string `type:"string"`
+}
+
+type getParametersByPathOutput struct {
+	_ struct{} `type:"structure"`
+
+	Parameters []*parameter `type:"list"`
+	NextToken  *string      `type:"string"`
+}
+
+type parameterStore struct {
+	client          *awsjson.Client
+	refreshInterval time.Duration
+}
+
+// New creates an AWS Systems Manager Parameter Store provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	err := awsjson.ParseConfig(ProviderName, config, &c)
+	if err != nil {
+		return nil, err
+	}
+	refreshInterval, err := c.RefreshPeriod()
+	if err != nil {
+		return nil, err
+	}
+	client, err := awsjson.New(c.Config, service)
+	if err != nil {
+		return nil, err
+	}
+	return &parameterStore{
+		client:          client,
+		refreshInterval: refreshInterval,
+	}, nil
+}
+
+func (s *parameterStore) Login() error {
+	return nil
+}
+
+// Request reads a parameter, or all the parameters under a path if it
+// ends with a slash. SecureString parameters are decrypted.
+func (s *parameterStore) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	name, recursive, err := parsePath(path)
+	if err != nil {
+		return nil, awsjson.ErrorResponse(http.StatusBadRequest), err
+	}
+
+	var data map[string]interface{}
+	var resp *api.Response
+	if strings.HasSuffix(name, "/") {
+		data, resp, err = s.getParametersByPath(name, recursive)
+	} else {
+		data, resp, err = s.getParameter(name)
+	}
+	if err != nil {
+		return nil, resp, err
+	}
+
+	// Parameters don't expire, but they are read again after the
+	// refresh interval
+	return &api.Secret{
+		Data:          data,
+		LeaseDuration: int(s.refreshInterval / time.Second),
+	}, nil, nil
+}
+
+func (s *parameterStore) getParameter(name string) (map[string]interface{}, *api.Response, error) {
+	input := &getParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	}
+	output := &getParameterOutput{}
+	resp, err := s.client.Call("GetParameter", input, output)
+	if err != nil {
+		return nil, resp, err
+	}
+	if output.Parameter == nil {
+		return nil, nil, fmt.Errorf("parameter %s not found", name)
+	}
+	return map[string]interface{}{
+		ValueKey: aws.StringValue(output.Parameter.Value),
+	}, nil, nil
+}
+
+// getParametersByPath reads all parameters under a path, they are
+// returned in a single map, indexed by their names relative to the path
+func (s *parameterStore) getParametersByPath(path string, recursive bool) (map[string]interface{}, *api.Response, error) {
+	data := make(map[string]interface{})
+	input := &getParametersByPathInput{
+		Path:           aws.String(strings.TrimSuffix(path, "/")),
+		Recursive:      aws.Bool(recursive),
+		WithDecryption: aws.Bool(true),
+	}
+	for {
+		output := &getParametersByPathOutput{}
+		resp, err := s.client.Call("GetParametersByPath", input, output)
+		if err != nil {
+			return nil, resp, err
+		}
+		for _, p := range output.Parameters {
+			key := strings.TrimPrefix(aws.StringValue(p.Name), path)
+			data[key] = aws.StringValue(p.Value)
+		}
+		if aws.StringValue(output.NextToken) == "" {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+	return data, nil, nil
+}
+
+func (s *parameterStore) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
+
+// parsePath obtains the name of the parameter and if it should be read
+// recursively, paths are read recursively unless recursive=false is set
+func parsePath(path string) (name string, recursive bool, err error) {
+	parts := strings.SplitN(path, "?", 2)
+	name = parts[0]
+	if name == "" || name == "/" {
+		return "", false, fmt.Errorf("parameter name needed")
+	}
+	recursive = true
+	if len(parts) == 1 {
+		return name, recursive, nil
+	}
+	query, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return "", false, err
+	}
+	if query.Get("recursive") == "false" {
+		recursive = false
+	}
+	return name, recursive, nil
+}
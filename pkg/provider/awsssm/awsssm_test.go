@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsssm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		d, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(d, &input)
+		assert.Equal(t, true, input["WithDecryption"])
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetParameter":
+			if input["Name"] != "/app/password" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type": "ParameterNotFound", "message": "not found"}`))
+				return
+			}
+			w.Write([]byte(`{"Parameter": {"Name": "/app/password", "Type": "SecureString", "Value": "secret"}}`))
+		case "AmazonSSM.GetParametersByPath":
+			assert.Equal(t, "/app", input["Path"])
+			assert.Equal(t, true, input["Recursive"])
+			if input["NextToken"] == nil {
+				w.Write([]byte(`{"Parameters": [{"Name": "/app/password", "Value": "secret"}], "NextToken": "next"}`))
+				return
+			}
+			w.Write([]byte(`{"Parameters": [{"Name": "/app/db/user", "Value": "foo"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	config, _ := json.Marshal(map[string]string{
+		"region":   "eu-west-1",
+		"endpoint": server.URL,
+	})
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := provider.Request("", "/app/password", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", s.Data[ValueKey])
+	assert.Equal(t, 3600, s.LeaseDuration)
+
+	s, _, err = provider.Request("", "/app/", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "secret", "db/user": "foo"}, s.Data)
+
+	_, resp, err := provider.Request("", "/app/unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
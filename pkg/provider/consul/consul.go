@@ -0,0 +1,285 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package consul provides values stored in the KV store of Consul, so
+// configuration can be mixed with secrets in the same templates, and the
+// healthy instances of services registered in Consul
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "consul"
+
+	// Provider of the healthy instances of services
+	ServiceProviderName = "consul-service"
+
+	// Key for values obtained from a single key
+	ValueKey = "value"
+
+	// Key for the list of instances of a service
+	InstancesKey = "instances"
+
+	DefaultRefreshInterval = time.Hour
+
+	// Maximum time blocking queries wait for changes
+	WatchWaitTime = 5 * time.Minute
+)
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+	pouch.RegisterSecretProvider(ServiceProviderName, NewService)
+}
+
+type Config struct {
+	// Address of Consul, by default the one in CONSUL_HTTP_ADDR or
+	// the local agent
+	Address string `json:"address,omitempty"`
+
+	// ACL token, by default the one in CONSUL_HTTP_TOKEN
+	Token string `json:"token,omitempty"`
+
+	Datacenter string `json:"datacenter,omitempty"`
+
+	// Interval to read values again if they haven't changed, one hour
+	// by default
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+type consulProvider struct {
+	kv              *consul.KV
+	health          *consul.Health
+	services        bool
+	refreshInterval time.Duration
+
+	// Indexes of the last responses, for blocking queries
+	indexesLock sync.Mutex
+	indexes     map[string]uint64
+}
+
+// New creates a Consul KV provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	return newProvider(ProviderName, config)
+}
+
+// NewService creates a provider of the healthy instances of services
+func NewService(config json.RawMessage) (pouch.SecretProvider, error) {
+	return newProvider(ServiceProviderName, config)
+}
+
+func newProvider(name string, config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", name, err)
+		}
+	}
+	refreshInterval := DefaultRefreshInterval
+	if c.RefreshInterval != "" {
+		d, err := time.ParseDuration(c.RefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect refresh interval: %v", err)
+		}
+		refreshInterval = d
+	}
+
+	consulConfig := consul.DefaultConfig()
+	if c.Address != "" {
+		consulConfig.Address = c.Address
+	}
+	if c.Token != "" {
+		consulConfig.Token = c.Token
+	}
+	if c.Datacenter != "" {
+		consulConfig.Datacenter = c.Datacenter
+	}
+	client, err := consul.NewClient(consulConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &consulProvider{
+		kv:              client.KV(),
+		health:          client.Health(),
+		services:        name == ServiceProviderName,
+		refreshInterval: refreshInterval,
+		indexes:         make(map[string]uint64),
+	}, nil
+}
+
+func (p *consulProvider) Login() error {
+	return nil
+}
+
+// queryService reads the healthy instances of a service, the path is
+// its name, optionally prefixed by a tag and a dot, and followed by an
+// at sign and a datacenter, as in consul-template, e.g. primary.db@dc1
+func (p *consulProvider) queryService(path string, q *consul.QueryOptions) (map[string]interface{}, *consul.QueryMeta, error) {
+	name, tag := path, ""
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if q == nil {
+			q = &consul.QueryOptions{}
+		}
+		q.Datacenter = name[i+1:]
+		name = name[:i]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		tag, name = name[:i], name[i+1:]
+	}
+	entries, meta, err := p.health.Service(name, tag, true, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	instances := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		tags := make([]interface{}, 0, len(entry.Service.Tags))
+		for _, tag := range entry.Service.Tags {
+			tags = append(tags, tag)
+		}
+		instances = append(instances, map[string]interface{}{
+			"ID":      entry.Service.ID,
+			"Name":    entry.Service.Service,
+			"Node":    entry.Node.Node,
+			"Address": address,
+			"Port":    entry.Service.Port,
+			"Tags":    tags,
+		})
+	}
+	// Services without healthy instances are not an error, they may
+	// appear later
+	return map[string]interface{}{InstancesKey: instances}, meta, nil
+}
+
+// query reads a key, or all the keys under a prefix if the path ends
+// with a slash, or the instances of a service for service providers
+func (p *consulProvider) query(path string, q *consul.QueryOptions) (map[string]interface{}, *consul.QueryMeta, error) {
+	if p.services {
+		return p.queryService(path, q)
+	}
+	if strings.HasSuffix(path, "/") {
+		pairs, meta, err := p.kv.List(path, q)
+		if err != nil {
+			return nil, nil, err
+		}
+		data := make(map[string]interface{})
+		for _, pair := range pairs {
+			key := strings.TrimPrefix(pair.Key, path)
+			if key == "" || strings.HasSuffix(key, "/") {
+				continue
+			}
+			data[key] = string(pair.Value)
+		}
+		return data, meta, nil
+	}
+
+	pair, meta, err := p.kv.Get(path, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pair == nil {
+		return nil, meta, nil
+	}
+	return map[string]interface{}{ValueKey: string(pair.Value)}, meta, nil
+}
+
+// Request reads a key, with its value in the value key, or all the keys
+// under a prefix if the path ends with a slash, with their names relative
+// to the prefix. Service providers return the instances of the service in
+// the instances key.
+func (p *consulProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	path = strings.TrimPrefix(path, "/")
+	data, meta, err := p.query(path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		resp := &api.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		return nil, resp, fmt.Errorf("key %s not found in Consul", path)
+	}
+
+	p.indexesLock.Lock()
+	p.indexes[path] = meta.LastIndex
+	p.indexesLock.Unlock()
+
+	// Values are watched, but they are also read again after the
+	// refresh interval
+	return &api.Secret{
+		Data:          data,
+		LeaseDuration: int(p.refreshInterval / time.Second),
+	}, nil, nil
+}
+
+type queryResult struct {
+	meta *consul.QueryMeta
+	err  error
+}
+
+// Watch waits for changes in the path using blocking queries
+func (p *consulProvider) Watch(ctx context.Context, path string) error {
+	path = strings.TrimPrefix(path, "/")
+	p.indexesLock.Lock()
+	index := p.indexes[path]
+	p.indexesLock.Unlock()
+
+	for {
+		// The API client doesn't support contexts, queries are left
+		// running when the context is done, they end after the wait time
+		result := make(chan queryResult, 1)
+		go func() {
+			_, meta, err := p.query(path, &consul.QueryOptions{WaitIndex: index, WaitTime: WatchWaitTime})
+			result <- queryResult{meta: meta, err: err}
+		}()
+
+		var r queryResult
+		select {
+		case r = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if index == 0 {
+			index = r.meta.LastIndex
+			continue
+		}
+		if r.meta.LastIndex != index {
+			return nil
+		}
+	}
+}
+
+func (p *consulProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consul
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKV struct {
+	sync.Mutex
+
+	index  uint64
+	values map[string]string
+	change chan struct{}
+}
+
+func (kv *fakeKV) set(key, value string) {
+	kv.Lock()
+	kv.index++
+	kv.values[key] = value
+	kv.Unlock()
+	close(kv.change)
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.Lock()
+	if r.URL.Query().Get("index") == fmt.Sprint(kv.index) {
+		// Blocking query
+		change := kv.change
+		kv.Unlock()
+		<-change
+		kv.Lock()
+	}
+	defer kv.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	var pairs []map[string]interface{}
+	for k, v := range kv.values {
+		_, recurse := r.URL.Query()["recurse"]
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			pairs = append(pairs, map[string]interface{}{
+				"Key":         k,
+				"Value":       base64.StdEncoding.EncodeToString([]byte(v)),
+				"ModifyIndex": kv.index,
+			})
+		}
+	}
+	w.Header().Set("X-Consul-Index", fmt.Sprint(kv.index))
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func TestRequestAndWatch(t *testing.T) {
+	kv := &fakeKV{
+		index: 10,
+		values: map[string]string{
+			"app/db/host": "db.example.com",
+			"app/db/port": "5432",
+			"other":       "foo",
+		},
+		change: make(chan struct{}),
+	}
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	config, _ := json.Marshal(Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := provider.Request("", "app/db/host", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "db.example.com", s.Data[ValueKey])
+	assert.Equal(t, 3600, s.LeaseDuration)
+
+	s, _, err = provider.Request("", "app/", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"db/host": "db.example.com", "db/port": "5432"}, s.Data)
+
+	_, resp, err := provider.Request("", "unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	watcher := provider.(*consulProvider)
+	changed := make(chan error)
+	go func() {
+		changed <- watcher.Watch(context.Background(), "app/db/host")
+	}()
+	select {
+	case <-changed:
+		t.Fatal("watch returned without changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	kv.set("app/db/host", "db2.example.com")
+	select {
+	case err := <-changed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, watcher.Watch(ctx, "app/db/host"))
+}
+
+func TestServiceRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" || r.URL.Query().Get("tag") != "primary" || r.URL.Query().Get("dc") != "dc1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "10")
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{
+				"Node":    map[string]interface{}{"Node": "node1", "Address": "10.0.0.1"},
+				"Service": map[string]interface{}{"ID": "web1", "Service": "web", "Port": 8080, "Tags": []string{"primary"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	config, _ := json.Marshal(Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	provider, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := provider.Request("", "primary.web@dc1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"ID":      "web1",
+		"Name":    "web",
+		"Node":    "node1",
+		"Address": "10.0.0.1",
+		"Port":    8080,
+		"Tags":    []interface{}{"primary"},
+	}}, s.Data[InstancesKey])
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package etcd provides values stored in etcd v3, so runtime configuration
+// can be mixed with secrets in the same templates
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "etcd"
+
+	// Key for values obtained from a single key
+	ValueKey = "value"
+
+	DefaultEndpoint        = "127.0.0.1:2379"
+	DefaultRefreshInterval = time.Hour
+
+	RequestTimeout = 30 * time.Second
+)
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	// Endpoints of etcd, the local one by default
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// TLS configuration, if the CA or the client certificate are set,
+	// connections use TLS
+	CAFile   string `json:"ca_file,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+
+	// Interval to read values again if they haven't changed, one hour
+	// by default
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+type etcdProvider struct {
+	// Number of watches started, first to be aligned for atomic operations
+	watches uint64
+
+	client          *clientv3.Client
+	refreshInterval time.Duration
+
+	// Revisions of the last responses, to watch for changes after them
+	revisionsLock sync.Mutex
+	revisions     map[string]int64
+}
+
+type watchKey struct{}
+
+// New creates an etcd v3 provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", ProviderName, err)
+		}
+	}
+	refreshInterval := DefaultRefreshInterval
+	if c.RefreshInterval != "" {
+		d, err := time.ParseDuration(c.RefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect refresh interval: %v", err)
+		}
+		refreshInterval = d
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := c.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{DefaultEndpoint}
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: endpoints,
+		Username:  c.Username,
+		Password:  c.Password,
+		TLS:       tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &etcdProvider{
+		client:          client,
+		refreshInterval: refreshInterval,
+		revisions:       make(map[string]int64),
+	}, nil
+}
+
+func (p *etcdProvider) Login() error {
+	return nil
+}
+
+func keyOptions(key string) []clientv3.OpOption {
+	if strings.HasSuffix(key, "/") {
+		return []clientv3.OpOption{clientv3.WithPrefix()}
+	}
+	return nil
+}
+
+// Request reads a key, with its value in the value key, or all the keys
+// under a prefix if the path ends with a slash, with their names relative
+// to the prefix
+func (p *etcdProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+	resp, err := p.client.Get(ctx, path, keyOptions(path)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data := make(map[string]interface{})
+	for _, kv := range resp.Kvs {
+		if !strings.HasSuffix(path, "/") {
+			data[ValueKey] = string(kv.Value)
+			continue
+		}
+		key := strings.TrimPrefix(string(kv.Key), path)
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		data[key] = string(kv.Value)
+	}
+	if len(data) == 0 {
+		resp := &api.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+		return nil, resp, fmt.Errorf("key %s not found in etcd", path)
+	}
+
+	p.revisionsLock.Lock()
+	p.revisions[path] = resp.Header.Revision
+	p.revisionsLock.Unlock()
+
+	// Values are watched, but they are also read again after the
+	// refresh interval
+	return &api.Secret{
+		Data:          data,
+		LeaseDuration: int(p.refreshInterval / time.Second),
+	}, nil, nil
+}
+
+// Watch waits for changes in the path after the last revision read
+func (p *etcdProvider) Watch(ctx context.Context, path string) error {
+	p.revisionsLock.Lock()
+	revision := p.revisions[path]
+	p.revisionsLock.Unlock()
+
+	// Watch streams of the client are shared by contexts with the same
+	// description, each watch uses its own context so streams of
+	// finished watches are not reused
+	id := atomic.AddUint64(&p.watches, 1)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, watchKey{}, fmt.Sprint(id)))
+	defer cancel()
+	opts := keyOptions(path)
+	if revision > 0 {
+		opts = append(opts, clientv3.WithRev(revision+1))
+	}
+	for resp := range p.client.Watch(ctx, path, opts...) {
+		if resp.CompactRevision > 0 {
+			// Revisions after the last one read have been compacted,
+			// changes could have been lost, so read it again
+			return nil
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if len(resp.Events) > 0 {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// Watches are closed by the server when revisions have been
+	// compacted, read it again in case it changed
+	return nil
+}
+
+func (p *etcdProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/stretchr/testify/assert"
+	netcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+type fakeEtcd struct {
+	pb.KVServer
+	sync.Mutex
+
+	revision int64
+	values   map[string]string
+	change   chan *mvccpb.KeyValue
+}
+
+func (e *fakeEtcd) set(key, value string) {
+	e.Lock()
+	e.revision++
+	e.values[key] = value
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: e.revision}
+	e.Unlock()
+	e.change <- kv
+}
+
+func (e *fakeEtcd) Range(ctx netcontext.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	e.Lock()
+	defer e.Unlock()
+	resp := &pb.RangeResponse{Header: &pb.ResponseHeader{Revision: e.revision}}
+	var keys []string
+	for k := range e.values {
+		key := []byte(k)
+		if bytes.Equal(key, r.Key) || (len(r.RangeEnd) > 0 && bytes.Compare(key, r.Key) >= 0 && bytes.Compare(key, r.RangeEnd) < 0) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(e.values[k])})
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (e *fakeEtcd) Watch(stream pb.Watch_WatchServer) error {
+	r, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	create := r.GetCreateRequest()
+	e.Lock()
+	header := &pb.ResponseHeader{Revision: e.revision}
+	e.Unlock()
+	if create.StartRevision != header.Revision+1 {
+		// Only watches from the current revision are supported
+		err = stream.Send(&pb.WatchResponse{Header: header, Created: true})
+		if err != nil {
+			return err
+		}
+		err = stream.Send(&pb.WatchResponse{Header: header, Canceled: true, CompactRevision: header.Revision})
+		if err != nil {
+			return err
+		}
+		<-stream.Context().Done()
+		return nil
+	}
+	err = stream.Send(&pb.WatchResponse{Header: header, Created: true})
+	if err != nil {
+		return err
+	}
+	select {
+	case kv := <-e.change:
+		err = stream.Send(&pb.WatchResponse{
+			Header: &pb.ResponseHeader{Revision: kv.ModRevision},
+			Events: []*mvccpb.Event{{Type: mvccpb.PUT, Kv: kv}},
+		})
+		if err != nil {
+			return err
+		}
+	case <-stream.Context().Done():
+		return nil
+	}
+	// Wait for the client to cancel
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestRequestAndWatch(t *testing.T) {
+	e := &fakeEtcd{
+		revision: 10,
+		values: map[string]string{
+			"/app/db/host": "db.example.com",
+			"/app/db/port": "5432",
+			"/other":       "foo",
+		},
+		change: make(chan *mvccpb.KeyValue),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterKVServer(s, e)
+	pb.RegisterWatchServer(s, e)
+	go s.Serve(l)
+	defer s.Stop()
+
+	config, _ := json.Marshal(Config{Endpoints: []string{l.Addr().String()}})
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, _, err := provider.Request("", "/app/db/host", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "db.example.com", secret.Data[ValueKey])
+	assert.Equal(t, 3600, secret.LeaseDuration)
+
+	secret, _, err = provider.Request("", "/app/", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"db/host": "db.example.com", "db/port": "5432"}, secret.Data)
+
+	_, resp, err := provider.Request("", "/unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	watcher := provider.(*etcdProvider)
+	changed := make(chan error)
+	go func() {
+		changed <- watcher.Watch(context.Background(), "/app/db/host")
+	}()
+	select {
+	case <-changed:
+		t.Fatal("watch returned without changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	e.set("/app/db/host", "db2.example.com")
+	select {
+	case err := <-changed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+
+	// Changes after the revision read may have been lost
+	assert.NoError(t, watcher.Watch(context.Background(), "/app/db/host"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, watcher.Watch(ctx, "/app/"))
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package awsjson builds clients for AWS services using the JSON protocol,
+// for services not included in the vendored SDK
+package awsjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+	"github.com/hashicorp/vault/api"
+)
+
+const DefaultRefreshInterval = time.Hour
+
+// Config is the common configuration of providers using AWS services
+type Config struct {
+	// AWS region, taken from the environment if not set
+	Region string `json:"region,omitempty"`
+
+	// Alternative endpoint, e.g. for VPC endpoints
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Interval to read secrets again, one hour by default
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+// ParseConfig parses the configuration of a provider, config can be
+// any struct embedding Config
+func ParseConfig(provider string, raw json.RawMessage, config interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(raw, config)
+	if err != nil {
+		return fmt.Errorf("incorrect configuration for %s: %v", provider, err)
+	}
+	return nil
+}
+
+// RefreshPeriod returns the interval to read secrets again
+func (c Config) RefreshPeriod() (time.Duration, error) {
+	if c.RefreshInterval == "" {
+		return DefaultRefreshInterval, nil
+	}
+	d, err := time.ParseDuration(c.RefreshInterval)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect refresh interval: %v", err)
+	}
+	return d, nil
+}
+
+// Service describes an AWS service using the JSON protocol
+type Service struct {
+	Name         string
+	APIVersion   string
+	JSONVersion  string
+	TargetPrefix string
+}
+
+type Client struct {
+	*client.Client
+}
+
+// New creates a client for a service, credentials are obtained as usual
+// in AWS SDKs, from the environment, shared configuration or instance
+// roles
+func New(c Config, s Service) (*Client, error) {
+	awsConfig := aws.Config{}
+	if c.Region != "" {
+		awsConfig.Region = aws.String(c.Region)
+	}
+	if c.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(c.Endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cc := sess.ClientConfig(s.Name)
+	cl := client.New(
+		*cc.Config,
+		metadata.ClientInfo{
+			ServiceName:   s.Name,
+			SigningName:   cc.SigningName,
+			SigningRegion: cc.SigningRegion,
+			Endpoint:      cc.Endpoint,
+			APIVersion:    s.APIVersion,
+			JSONVersion:   s.JSONVersion,
+			TargetPrefix:  s.TargetPrefix,
+		},
+		cc.Handlers,
+	)
+	cl.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	cl.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	cl.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	cl.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	cl.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+	return &Client{Client: cl}, nil
+}
+
+// Call calls an operation, input and output must be structs tagged as
+// the ones in the SDK. If the call fails, the returned response contains
+// the status code of the failure, if any.
+func (c *Client) Call(operation string, input, output interface{}) (*api.Response, error) {
+	op := &request.Operation{
+		Name:       operation,
+		HTTPMethod: http.MethodPost,
+		HTTPPath:   "/",
+	}
+	err := c.NewRequest(op, input, output).Send()
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok {
+			return ErrorResponse(reqErr.StatusCode()), err
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ErrorResponse builds a response with a status code, so pouch can decide
+// if requests should be retried
+func ErrorResponse(statusCode int) *api.Response {
+	return &api.Response{Response: &http.Response{StatusCode: statusCode}}
+}
@@ -0,0 +1,357 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package kubernetes provides Secrets read from the API of Kubernetes, so
+// values managed in Kubernetes can be used in the same templates as values
+// from Vault
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "kubernetes"
+
+	DefaultRefreshInterval = time.Hour
+
+	// Files of the service account mounted in pods
+	ServiceAccountPath   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	DefaultTokenFile     = ServiceAccountPath + "/token"
+	DefaultCAFile        = ServiceAccountPath + "/ca.crt"
+	DefaultNamespaceFile = ServiceAccountPath + "/namespace"
+
+	// Maximum time watches wait for changes before starting again
+	WatchTimeout = 5 * time.Minute
+
+	RequestTimeout = 30 * time.Second
+)
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	// URL of the API server, by default the one of the cluster where
+	// pouch is running
+	Host string `json:"host,omitempty"`
+
+	// File with the bearer token, by default the one of the service account
+	TokenFile string `json:"token_file,omitempty"`
+
+	// File with the CA of the API server, by default the one of the
+	// service account
+	CAFile string `json:"ca_file,omitempty"`
+
+	// Namespace of secrets referenced only by their names, by default
+	// the one of the service account
+	Namespace string `json:"namespace,omitempty"`
+
+	// Interval to read secrets again if they haven't changed, one hour
+	// by default
+	RefreshInterval string `json:"refresh_interval,omitempty"`
+}
+
+type secretObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubernetesProvider struct {
+	host            string
+	tokenFile       string
+	namespace       string
+	refreshInterval time.Duration
+	client          *http.Client
+
+	// Resource versions of the last secrets read, to watch for changes
+	versionsLock sync.Mutex
+	versions     map[string]string
+}
+
+// New creates a Kubernetes Secrets provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", ProviderName, err)
+		}
+	}
+	refreshInterval := DefaultRefreshInterval
+	if c.RefreshInterval != "" {
+		d, err := time.ParseDuration(c.RefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect refresh interval: %v", err)
+		}
+		refreshInterval = d
+	}
+
+	host := c.Host
+	if host == "" {
+		serviceHost, servicePort := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if serviceHost == "" || servicePort == "" {
+			return nil, fmt.Errorf("host of the API server needed when not running in a cluster")
+		}
+		host = "https://" + net.JoinHostPort(serviceHost, servicePort)
+	}
+	tokenFile := c.TokenFile
+	if tokenFile == "" && c.Host == "" {
+		tokenFile = DefaultTokenFile
+	}
+	caFile := c.CAFile
+	if caFile == "" && c.Host == "" {
+		caFile = DefaultCAFile
+	}
+	namespace := c.Namespace
+	if namespace == "" {
+		d, err := ioutil.ReadFile(DefaultNamespaceFile)
+		if err == nil {
+			namespace = strings.TrimSpace(string(d))
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &kubernetesProvider{
+		host:            strings.TrimSuffix(host, "/"),
+		tokenFile:       tokenFile,
+		namespace:       namespace,
+		refreshInterval: refreshInterval,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		versions: make(map[string]string),
+	}, nil
+}
+
+func (p *kubernetesProvider) Login() error {
+	return nil
+}
+
+// secretRef returns the namespace and the name of the secret in a path,
+// in the form namespace/name, or just name for the default namespace
+func (p *kubernetesProvider) secretRef(path string) (namespace, name string, err error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		if p.namespace == "" {
+			return "", "", fmt.Errorf("namespace needed for secret %s", path)
+		}
+		return p.namespace, parts[0], nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("incorrect secret reference %s, expected <namespace>/<name>", path)
+}
+
+// get does a request to the API server, the token is read on each request
+// as projected tokens are rotated
+func (p *kubernetesProvider) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := p.host + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.tokenFile != "" {
+		token, err := ioutil.ReadFile(p.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp, fmt.Errorf("request to %s failed with status %d: %s", path, resp.StatusCode, status.Message)
+	}
+	return resp, nil
+}
+
+// decodeData decodes the values of a secret, that are base64-encoded
+func decodeData(s *secretObject) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	for k, v := range s.Data {
+		d, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect value for key %s: %v", k, err)
+		}
+		data[k] = string(d)
+	}
+	return data, nil
+}
+
+// Request reads a secret, referenced as namespace/name, or just by its name
+// if it is in the default namespace, with its values decoded
+func (p *kubernetesProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	namespace, name, err := p.secretRef(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+	resp, err := p.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name), nil)
+	if err != nil {
+		if resp != nil {
+			return nil, &api.Response{Response: resp}, err
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var s secretObject
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't decode secret %s/%s: %v", namespace, name, err)
+	}
+	data, err := decodeData(&s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.versionsLock.Lock()
+	p.versions[namespace+"/"+name] = s.Metadata.ResourceVersion
+	p.versionsLock.Unlock()
+
+	// Secrets are watched, but they are also read again after the
+	// refresh interval
+	return &api.Secret{
+		Data:          data,
+		LeaseDuration: int(p.refreshInterval / time.Second),
+	}, nil, nil
+}
+
+// Watch waits for changes in the secret using the watch API
+func (p *kubernetesProvider) Watch(ctx context.Context, path string) error {
+	namespace, name, err := p.secretRef(path)
+	if err != nil {
+		return err
+	}
+	p.versionsLock.Lock()
+	version := p.versions[namespace+"/"+name]
+	p.versionsLock.Unlock()
+
+	for {
+		query := url.Values{
+			"watch":          {"true"},
+			"fieldSelector":  {"metadata.name=" + name},
+			"timeoutSeconds": {fmt.Sprint(int(WatchTimeout / time.Second))},
+		}
+		if version != "" {
+			query.Set("resourceVersion", version)
+		}
+		resp, err := p.get(ctx, fmt.Sprintf("/api/v1/namespaces/%s/secrets", namespace), query)
+		if err != nil {
+			return err
+		}
+		changed, err := watchEvents(resp, &version)
+		if err != nil || changed {
+			return err
+		}
+	}
+}
+
+// watchEvents reads events of a watch till the secret changes or the watch
+// ends, the version is updated with the events received
+func watchEvents(resp *http.Response, version *string) (bool, error) {
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		err := decoder.Decode(&event)
+		if err != nil {
+			// Watches are closed by the server after the timeout
+			return false, nil
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var s secretObject
+			err = json.Unmarshal(event.Object, &s)
+			if err != nil {
+				return false, err
+			}
+			if *version == "" {
+				// Without version, watches start with the
+				// current state of the secret
+				*version = s.Metadata.ResourceVersion
+				continue
+			}
+			if s.Metadata.ResourceVersion != *version {
+				return true, nil
+			}
+		case "ERROR":
+			// Versions can be too old to be watched, the secret is
+			// read again in that case
+			return true, nil
+		}
+	}
+}
+
+func (p *kubernetesProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
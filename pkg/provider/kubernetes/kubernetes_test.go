@@ -0,0 +1,176 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAPI struct {
+	sync.Mutex
+
+	version int
+	data    map[string]string
+	change  chan struct{}
+}
+
+func (a *fakeAPI) set(key, value string) {
+	a.Lock()
+	a.version++
+	a.data[key] = value
+	a.Unlock()
+	close(a.change)
+}
+
+func (a *fakeAPI) object() map[string]interface{} {
+	data := make(map[string]string)
+	for k, v := range a.data {
+		data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return map[string]interface{}{
+		"metadata": map[string]string{
+			"name":            "app",
+			"namespace":       "default",
+			"resourceVersion": fmt.Sprint(a.version),
+		},
+		"data": data,
+	}
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/v1/namespaces/default/secrets/app":
+		a.Lock()
+		defer a.Unlock()
+		json.NewEncoder(w).Encode(a.object())
+	case "/api/v1/namespaces/default/secrets":
+		if r.URL.Query().Get("fieldSelector") != "metadata.name=app" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.Lock()
+		change := a.change
+		a.Unlock()
+		w.(http.Flusher).Flush()
+		select {
+		case <-change:
+		case <-r.Context().Done():
+			return
+		}
+		a.Lock()
+		defer a.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"type":   "MODIFIED",
+			"object": a.object(),
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "not found"})
+	}
+}
+
+func TestRequestAndWatch(t *testing.T) {
+	a := &fakeAPI{
+		version: 10,
+		data:    map[string]string{"password": "secret"},
+		change:  make(chan struct{}),
+	}
+	server := httptest.NewServer(a)
+	defer server.Close()
+
+	config, _ := json.Marshal(Config{Host: server.URL, Namespace: "default"})
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := provider.Request("", "app", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	tokenFile := path.Join(tmpdir, "token")
+	err = ioutil.WriteFile(tokenFile, []byte("token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ = json.Marshal(Config{Host: server.URL, Namespace: "default", TokenFile: tokenFile})
+	provider, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"app", "default/app"} {
+		s, _, err := provider.Request("", p, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"password": "secret"}, s.Data)
+		assert.Equal(t, 3600, s.LeaseDuration)
+	}
+
+	_, resp, err = provider.Request("", "default/unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	_, _, err = provider.Request("", "a/b/c", nil)
+	assert.Error(t, err)
+
+	watcher := provider.(*kubernetesProvider)
+	changed := make(chan error)
+	go func() {
+		changed <- watcher.Watch(context.Background(), "app")
+	}()
+	select {
+	case <-changed:
+		t.Fatal("watch returned without changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.set("password", "other")
+	select {
+	case err := <-changed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, watcher.Watch(ctx, "app"))
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package static provides secrets defined in the configuration or in a
+// local JSON file, so configurations can be run without Vault during
+// development
+package static
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/vault/api"
+)
+
+const ProviderName = "static"
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	// Data of the secrets, indexed by their names
+	Secrets map[string]map[string]interface{} `json:"secrets,omitempty"`
+
+	// Path to a JSON file with an object with the data of the secrets
+	// indexed by their names, it takes precedence over the secrets
+	// defined in the configuration
+	File string `json:"file,omitempty"`
+}
+
+type staticProvider struct {
+	secrets map[string]map[string]interface{}
+	file    string
+}
+
+// New creates a provider of static secrets
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := decode(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", ProviderName, err)
+		}
+	}
+	p := &staticProvider{secrets: c.Secrets, file: c.File}
+	if p.file != "" {
+		// Fail early if the file is not correct
+		_, err := p.readFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// decode keeps numbers as json.Number, as they are found in secrets from Vault
+func decode(d []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(d))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (p *staticProvider) Login() error {
+	return nil
+}
+
+func (p *staticProvider) readFile() (map[string]map[string]interface{}, error) {
+	d, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return nil, err
+	}
+	var secrets map[string]map[string]interface{}
+	err = decode(d, &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect secrets in %s: %v", p.file, err)
+	}
+	return secrets, nil
+}
+
+// Request returns the data of the secret with the name in the path, the
+// file is read on each request, so it can be modified while running
+func (p *staticProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	name := strings.Trim(path, "/")
+	if p.file != "" {
+		secrets, err := p.readFile()
+		if err != nil {
+			return nil, nil, err
+		}
+		if data, found := secrets[name]; found {
+			return &api.Secret{Data: data}, nil, nil
+		}
+	}
+	if data, found := p.secrets[name]; found {
+		return &api.Secret{Data: data}, nil, nil
+	}
+	resp := &api.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	return nil, resp, fmt.Errorf("static secret %s not found", name)
+}
+
+// Watch waits for changes in the file, if any. Its directory is watched,
+// so the file can be replaced by editors.
+func (p *staticProvider) Watch(ctx context.Context, path string) error {
+	if p.file == "" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	file := filepath.Clean(p.file)
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				return nil
+			}
+		case err := <-watcher.Errors:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *staticProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestAndWatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	file := filepath.Join(tmpdir, "secrets.json")
+	err = ioutil.WriteFile(file, []byte(`{"db": {"password": "fromfile", "ttl": 60}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := []byte(`{
+		"secrets": {
+			"db": {"password": "inline"},
+			"api": {"token": "inline"}
+		},
+		"file": "` + file + `"
+	}`)
+	provider, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _, err := provider.Request("", "db", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "fromfile", s.Data["password"])
+	assert.Equal(t, json.Number("60"), s.Data["ttl"])
+
+	s, _, err = provider.Request("", "/api", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "inline", s.Data["token"])
+
+	_, resp, err := provider.Request("", "unknown", nil)
+	assert.Error(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	watcher := provider.(*staticProvider)
+	changed := make(chan error)
+	go func() {
+		changed <- watcher.Watch(context.Background(), "db")
+	}()
+	select {
+	case <-changed:
+		t.Fatal("watch returned without changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = ioutil.WriteFile(file, []byte(`{"db": {"password": "changed"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-changed:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("change not detected")
+	}
+	s, _, err = provider.Request("", "db", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", s.Data["password"])
+
+	_, err = New([]byte(`{"file": "` + filepath.Join(tmpdir, "unknown.json") + `"}`))
+	assert.Error(t, err)
+}
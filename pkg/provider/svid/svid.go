@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package svid provides SPIFFE SVIDs obtained from the Workload API as
+// secrets, so they can be rendered in files and rotated as any other
+// secret
+package svid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/spiffe"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	ProviderName = "spiffe"
+
+	X509Path = "x509"
+	JWTPath  = "jwt"
+
+	RequestTimeout = 30 * time.Second
+)
+
+func init() {
+	pouch.RegisterSecretProvider(ProviderName, New)
+}
+
+type Config struct {
+	// Path to the socket of the Workload API, by default the one in
+	// SPIFFE_ENDPOINT_SOCKET or the default one of SPIRE agents
+	Socket string `json:"socket,omitempty"`
+}
+
+type svidProvider struct {
+	client *spiffe.Client
+}
+
+// New creates a SPIFFE SVID provider
+func New(config json.RawMessage) (pouch.SecretProvider, error) {
+	var c Config
+	if len(config) > 0 {
+		err := json.Unmarshal(config, &c)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect configuration for %s: %v", ProviderName, err)
+		}
+	}
+	return &svidProvider{client: spiffe.NewClient(c.Socket)}, nil
+}
+
+func (p *svidProvider) Login() error {
+	return nil
+}
+
+// Request obtains an X.509 SVID with the x509 path, or a JWT SVID with the
+// jwt path, with the audience and optionally the SPIFFE ID as query
+// parameters
+func (p *svidProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+
+	parts := strings.SplitN(path, "?", 2)
+	var query url.Values
+	if len(parts) == 2 {
+		var err error
+		query, err = url.ParseQuery(parts[1])
+		if err != nil {
+			return nil, badRequest(), err
+		}
+	}
+	switch parts[0] {
+	case X509Path:
+		return p.x509SVID(ctx)
+	case JWTPath:
+		return p.jwtSVID(ctx, query)
+	}
+	return nil, badRequest(), fmt.Errorf("unknown SVID type '%s'", parts[0])
+}
+
+// x509SVID returns the certificate chain, key and bundle of the X.509 SVID,
+// pouch updates it using the validity of the certificate
+func (p *svidProvider) x509SVID(ctx context.Context) (*api.Secret, *api.Response, error) {
+	svid, err := p.client.FetchX509SVID(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	certs, key, bundle, _, err := svid.PEM()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"spiffe_id":   svid.SpiffeID,
+			"certificate": string(certs),
+			"private_key": string(key),
+			"bundle":      string(bundle),
+		},
+	}, nil, nil
+}
+
+func (p *svidProvider) jwtSVID(ctx context.Context, query url.Values) (*api.Secret, *api.Response, error) {
+	audience := query["audience"]
+	if len(audience) == 0 {
+		return nil, badRequest(), fmt.Errorf("audience needed for JWT SVIDs")
+	}
+	svid, err := p.client.FetchJWTSVID(ctx, audience, query.Get("spiffe_id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	expiration, err := svid.Expiration()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &api.Secret{
+		Data: map[string]interface{}{
+			"spiffe_id": svid.SpiffeID,
+			"token":     svid.SVID,
+		},
+		LeaseDuration: int(time.Until(expiration) / time.Second),
+	}, nil, nil
+}
+
+func (p *svidProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("secrets from %s cannot be renewed", ProviderName)
+}
+
+func badRequest() *api.Response {
+	return &api.Response{Response: &http.Response{StatusCode: http.StatusBadRequest}}
+}
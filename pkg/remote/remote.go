@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package remote delivers files and runs commands in remote hosts over
+// SSH, files are copied using the SCP protocol
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultPort    = "22"
+	DefaultTimeout = 30 * time.Second
+)
+
+type Config struct {
+	// Address of the host, port 22 is used if not specified
+	Address string `json:"address,omitempty"`
+
+	User string `json:"user,omitempty"`
+
+	// Path to the private key used to authenticate
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+
+	// Path to a known_hosts file to verify the host key, hashed
+	// entries are not supported
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+
+	// Timeout to connect
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Host is a remote host, a new connection is opened for each operation
+type Host struct {
+	Name    string
+	Address string
+
+	config *ssh.ClientConfig
+}
+
+func New(name string, c Config) (*Host, error) {
+	if c.Address == "" {
+		return nil, fmt.Errorf("address needed for host '%s'", name)
+	}
+	address := c.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, DefaultPort)
+	}
+
+	timeout := DefaultTimeout
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect timeout for host '%s': %v", name, err)
+		}
+		timeout = d
+	}
+
+	if c.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("private key needed for host '%s'", name)
+	}
+	d, err := ioutil.ReadFile(c.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect private key for host '%s': %v", name, err)
+	}
+
+	if c.KnownHostsFile == "" {
+		return nil, fmt.Errorf("known hosts file needed for host '%s'", name)
+	}
+	hostKeys, err := loadKnownHosts(c.KnownHostsFile, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Host{
+		Name:    name,
+		Address: address,
+		config: &ssh.ClientConfig{
+			User:            c.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback(hostKeys),
+			Timeout:         timeout,
+		},
+	}, nil
+}
+
+// loadKnownHosts returns the keys for the address found in a known_hosts
+// file
+func loadKnownHosts(file, address string) ([]ssh.PublicKey, error) {
+	d, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	host, port, _ := net.SplitHostPort(address)
+	names := []string{"[" + host + "]:" + port}
+	if port == DefaultPort {
+		names = append(names, host)
+	}
+
+	var keys []ssh.PublicKey
+	for len(d) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(d)
+		if err != nil {
+			break
+		}
+		d = rest
+		if marker != "" {
+			continue
+		}
+		for _, h := range hosts {
+			for _, name := range names {
+				if h == name {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no known host keys found for %s in %s", address, file)
+	}
+	return keys, nil
+}
+
+func hostKeyCallback(keys []ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown host key %s for %s", ssh.FingerprintSHA256(key), hostname)
+	}
+}
+
+// session runs f in a new session, it is interrupted when the context
+// is done
+func (h *Host) session(ctx context.Context, f func(*ssh.Session) error) error {
+	client, err := ssh.Dial("tcp", h.Address, h.config)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to '%s': %v", h.Name, err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(session)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// Closing the connection interrupts the session
+		client.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Copy writes a file in the host using the SCP protocol, the directory
+// of the file must exist
+func (h *Host) Copy(ctx context.Context, filePath string, mode os.FileMode, content []byte) error {
+	return h.session(ctx, func(s *ssh.Session) error {
+		stdin, err := s.StdinPipe()
+		if err != nil {
+			return err
+		}
+		var stdout, stderr bytes.Buffer
+		s.Stdout = &stdout
+		s.Stderr = &stderr
+		err = s.Start("scp -t " + shellQuote(filePath))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdin, "C%04o %d %s\n", mode.Perm(), len(content), path.Base(filePath))
+		stdin.Write(content)
+		stdin.Write([]byte{0})
+		stdin.Close()
+		err = s.Wait()
+		if err != nil {
+			return fmt.Errorf("couldn't copy %s to '%s': %v: %s", filePath, h.Name, err, scpError(stdout.Bytes(), stderr.Bytes()))
+		}
+		return nil
+	})
+}
+
+// scpError extracts error messages from SCP replies
+func scpError(stdout, stderr []byte) string {
+	msg := strings.TrimSpace(string(stderr))
+	for _, b := range [][]byte{{1}, {2}} {
+		if i := bytes.Index(stdout, b); i >= 0 {
+			msg = strings.TrimSpace(string(stdout[i+1:]))
+		}
+	}
+	return msg
+}
+
+// Run runs a command in the host and returns its output
+func (h *Host) Run(ctx context.Context, command string) (string, error) {
+	var out []byte
+	err := h.session(ctx, func(s *ssh.Session) error {
+		var err error
+		out, err = s.CombinedOutput(command)
+		return err
+	})
+	return string(out), err
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+type copiedFile struct {
+	path    string
+	header  string
+	content string
+}
+
+// fakeSSHServer accepts sessions running scp sinks, or any other command,
+// whose output is the command itself
+type fakeSSHServer struct {
+	sync.Mutex
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	copied   []copiedFile
+}
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, ssh.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+func (s *fakeSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSSHServer) handle(conn net.Conn) {
+	// The configuration is modified when used, so use a copy
+	config := *s.config
+	_, channels, requests, err := ssh.NewServerConn(conn, &config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				command := string(req.Payload[4:])
+				if strings.HasPrefix(command, "scp -t ") {
+					s.scpSink(command, channel)
+				} else {
+					fmt.Fprintf(channel, "ran %s", command)
+				}
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, 0)
+				channel.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func (s *fakeSSHServer) scpSink(command string, channel ssh.Channel) {
+	r := bufio.NewReader(channel)
+	header, _ := r.ReadString('\n')
+	var mode, size int
+	var name string
+	fmt.Sscanf(header, "C%o %d %s", &mode, &size, &name)
+	content := make([]byte, size)
+	io.ReadFull(r, content)
+	r.ReadByte()
+
+	s.Lock()
+	s.copied = append(s.copied, copiedFile{
+		path:    strings.TrimPrefix(command, "scp -t "),
+		header:  strings.TrimSpace(header),
+		content: string(content),
+	})
+	s.Unlock()
+}
+
+func TestHost(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-remote-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	clientKey, clientSigner := newKey(t)
+	_, hostSigner := newKey(t)
+
+	server := &fakeSSHServer{config: &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "pouch" && string(key.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unauthorized")
+		},
+	}}
+	server.config.AddHostKey(hostSigner)
+	server.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.listener.Close()
+	go server.serve()
+
+	der, _ := x509.MarshalECPrivateKey(clientKey)
+	keyFile := filepath.Join(tmpdir, "id_ecdsa")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	knownHosts := filepath.Join(tmpdir, "known_hosts")
+	ioutil.WriteFile(knownHosts, []byte(fmt.Sprintf("[%s]:%s %s", host, port, ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))), 0600)
+
+	h, err := New("test", Config{
+		Address:        server.listener.Addr().String(),
+		User:           "pouch",
+		PrivateKeyFile: keyFile,
+		KnownHostsFile: knownHosts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	err = h.Copy(ctx, "/etc/app/secret's.conf", 0640, []byte("password"))
+	assert.NoError(t, err)
+	if assert.Len(t, server.copied, 1) {
+		assert.Equal(t, copiedFile{
+			path:    `'/etc/app/secret'\''s.conf'`,
+			header:  "C0640 8 secret's.conf",
+			content: "password",
+		}, server.copied[0])
+	}
+
+	out, err := h.Run(ctx, "systemctl reload app")
+	assert.NoError(t, err)
+	assert.Equal(t, "ran systemctl reload app", out)
+
+	// Unknown host key
+	_, otherSigner := newKey(t)
+	ioutil.WriteFile(knownHosts, []byte(fmt.Sprintf("[%s]:%s %s", host, port, ssh.MarshalAuthorizedKey(otherSigner.PublicKey()))), 0600)
+	h, err = New("test", Config{
+		Address:        server.listener.Addr().String(),
+		User:           "pouch",
+		PrivateKeyFile: keyFile,
+		KnownHostsFile: knownHosts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = h.Run(ctx, "true")
+	assert.Error(t, err)
+
+	_, err = New("test", Config{
+		Address:        "unknown.example.com",
+		PrivateKeyFile: keyFile,
+		KnownHostsFile: knownHosts,
+	})
+	assert.Error(t, err)
+}
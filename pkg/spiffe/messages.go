@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Messages of the SPIFFE Workload API, only the fields used by pouch are
+// defined, unknown fields are ignored when decoding
+
+type X509SVIDRequest struct{}
+
+func (m *X509SVIDRequest) Reset()         { *m = X509SVIDRequest{} }
+func (m *X509SVIDRequest) String() string { return proto.CompactTextString(m) }
+func (*X509SVIDRequest) ProtoMessage()    {}
+
+type X509SVID struct {
+	SpiffeID    string `protobuf:"bytes,1,opt,name=spiffe_id,json=spiffeId" json:"spiffe_id,omitempty"`
+	X509SVID    []byte `protobuf:"bytes,2,opt,name=x509_svid,json=x509Svid,proto3" json:"x509_svid,omitempty"`
+	X509SVIDKey []byte `protobuf:"bytes,3,opt,name=x509_svid_key,json=x509SvidKey,proto3" json:"x509_svid_key,omitempty"`
+	Bundle      []byte `protobuf:"bytes,4,opt,name=bundle,proto3" json:"bundle,omitempty"`
+}
+
+func (m *X509SVID) Reset()         { *m = X509SVID{} }
+func (m *X509SVID) String() string { return proto.CompactTextString(m) }
+func (*X509SVID) ProtoMessage()    {}
+
+type X509SVIDResponse struct {
+	SVIDs []*X509SVID `protobuf:"bytes,1,rep,name=svids" json:"svids,omitempty"`
+}
+
+func (m *X509SVIDResponse) Reset()         { *m = X509SVIDResponse{} }
+func (m *X509SVIDResponse) String() string { return proto.CompactTextString(m) }
+func (*X509SVIDResponse) ProtoMessage()    {}
+
+type JWTSVIDRequest struct {
+	Audience []string `protobuf:"bytes,1,rep,name=audience" json:"audience,omitempty"`
+	SpiffeID string   `protobuf:"bytes,2,opt,name=spiffe_id,json=spiffeId" json:"spiffe_id,omitempty"`
+}
+
+func (m *JWTSVIDRequest) Reset()         { *m = JWTSVIDRequest{} }
+func (m *JWTSVIDRequest) String() string { return proto.CompactTextString(m) }
+func (*JWTSVIDRequest) ProtoMessage()    {}
+
+type JWTSVID struct {
+	SpiffeID string `protobuf:"bytes,1,opt,name=spiffe_id,json=spiffeId" json:"spiffe_id,omitempty"`
+	SVID     string `protobuf:"bytes,2,opt,name=svid" json:"svid,omitempty"`
+}
+
+func (m *JWTSVID) Reset()         { *m = JWTSVID{} }
+func (m *JWTSVID) String() string { return proto.CompactTextString(m) }
+func (*JWTSVID) ProtoMessage()    {}
+
+type JWTSVIDResponse struct {
+	SVIDs []*JWTSVID `protobuf:"bytes,1,rep,name=svids" json:"svids,omitempty"`
+}
+
+func (m *JWTSVIDResponse) Reset()         { *m = JWTSVIDResponse{} }
+func (m *JWTSVIDResponse) String() string { return proto.CompactTextString(m) }
+func (*JWTSVIDResponse) ProtoMessage()    {}
@@ -0,0 +1,183 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package spiffe is a client of the SPIFFE Workload API, as served by
+// SPIRE agents, to obtain X.509 and JWT SVIDs of the workload
+package spiffe
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Default path of the socket, it can be overridden with the
+	// environment variable
+	DefaultSocket = "/tmp/spire-agent/public/api.sock"
+	SocketEnv     = "SPIFFE_ENDPOINT_SOCKET"
+
+	// Header required by the Workload API in all requests
+	headerKey = "workload.spiffe.io"
+
+	fetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+	fetchJWTSVIDMethod  = "/SpiffeWorkloadAPI/FetchJWTSVID"
+)
+
+type Client struct {
+	Socket string
+}
+
+// NewClient creates a client for the Workload API in the socket, if it
+// is empty the socket is taken from the environment or the default one
+func NewClient(socket string) *Client {
+	if socket == "" {
+		socket = os.Getenv(SocketEnv)
+	}
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	return &Client{Socket: strings.TrimPrefix(socket, "unix://")}
+}
+
+func (c *Client) dial(ctx context.Context) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, c.Socket,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+}
+
+func withHeader(ctx context.Context) context.Context {
+	return metadata.NewOutgoingContext(ctx, metadata.Pairs(headerKey, "true"))
+}
+
+var x509SVIDStream = &grpc.StreamDesc{
+	StreamName:    "FetchX509SVID",
+	ServerStreams: true,
+}
+
+// FetchX509SVID obtains the current X.509 SVID of the workload, the first
+// one if the workload has several identities
+func (c *Client) FetchX509SVID(ctx context.Context) (*X509SVID, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to workload API in %s: %v", c.Socket, err)
+	}
+	defer conn.Close()
+
+	stream, err := grpc.NewClientStream(withHeader(ctx), x509SVIDStream, conn, fetchX509SVIDMethod)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.SendMsg(&X509SVIDRequest{})
+	if err != nil {
+		return nil, err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+	var resp X509SVIDResponse
+	err = stream.RecvMsg(&resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.SVIDs) == 0 {
+		return nil, fmt.Errorf("no X.509 SVIDs received")
+	}
+	return resp.SVIDs[0], nil
+}
+
+// FetchJWTSVID obtains a JWT SVID for the audience, spiffeID can be used
+// to select the identity if the workload has several ones
+func (c *Client) FetchJWTSVID(ctx context.Context, audience []string, spiffeID string) (*JWTSVID, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to workload API in %s: %v", c.Socket, err)
+	}
+	defer conn.Close()
+
+	req := &JWTSVIDRequest{Audience: audience, SpiffeID: spiffeID}
+	var resp JWTSVIDResponse
+	err = grpc.Invoke(withHeader(ctx), fetchJWTSVIDMethod, req, &resp, conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.SVIDs) == 0 {
+		return nil, fmt.Errorf("no JWT SVIDs received")
+	}
+	return resp.SVIDs[0], nil
+}
+
+// PEM returns the certificate chain, the key and the trust bundle of an
+// X.509 SVID encoded in PEM, and the expiration of the certificate
+func (s *X509SVID) PEM() (certs, key, bundle []byte, expiration time.Time, err error) {
+	chain, err := x509.ParseCertificates(s.X509SVID)
+	if err != nil {
+		return nil, nil, nil, expiration, fmt.Errorf("incorrect certificates in SVID: %v", err)
+	}
+	if len(chain) == 0 {
+		return nil, nil, nil, expiration, fmt.Errorf("no certificates in SVID")
+	}
+	roots, err := x509.ParseCertificates(s.Bundle)
+	if err != nil {
+		return nil, nil, nil, expiration, fmt.Errorf("incorrect certificates in bundle: %v", err)
+	}
+	for _, c := range chain {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	for _, c := range roots {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	key = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: s.X509SVIDKey})
+	return certs, key, bundle, chain[0].NotAfter, nil
+}
+
+// Expiration returns the expiration time of a JWT SVID, the token is not
+// verified
+func (s *JWTSVID) Expiration() (time.Time, error) {
+	parts := strings.Split(s.SVID, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("incorrect JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("incorrect JWT payload: %v", err)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("incorrect JWT claims: %v", err)
+	}
+	return time.Unix(claims.Exp, 0), nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	netcontext "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type workloadAPI interface{}
+
+func checkHeader(ctx netcontext.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if len(md[headerKey]) == 0 || md[headerKey][0] != "true" {
+		return fmt.Errorf("security header missing")
+	}
+	return nil
+}
+
+func serveWorkloadAPI(t *testing.T, socket string, x509SVID *X509SVID, jwt string) *grpc.Server {
+	s := grpc.NewServer()
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "SpiffeWorkloadAPI",
+		HandlerType: (*workloadAPI)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "FetchJWTSVID",
+			Handler: func(srv interface{}, ctx netcontext.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := checkHeader(ctx); err != nil {
+					return nil, err
+				}
+				var req JWTSVIDRequest
+				if err := dec(&req); err != nil {
+					return nil, err
+				}
+				assert.Equal(t, []string{"vault"}, req.Audience)
+				return &JWTSVIDResponse{SVIDs: []*JWTSVID{{SpiffeID: "spiffe://example.org/pouch", SVID: jwt}}}, nil
+			},
+		}},
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "FetchX509SVID",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				if err := checkHeader(stream.Context()); err != nil {
+					return err
+				}
+				var req X509SVIDRequest
+				if err := stream.RecvMsg(&req); err != nil {
+					return err
+				}
+				return stream.SendMsg(&X509SVIDResponse{SVIDs: []*X509SVID{x509SVID}})
+			},
+		}},
+	}, struct{}{})
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return s
+}
+
+func newCertificate(t *testing.T, notAfter time.Time) (cert, key []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pouch"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	cert, err = x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestWorkloadAPI(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-spiffe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	socket := filepath.Join(tmpdir, "api.sock")
+
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert, key := newCertificate(t, notAfter)
+	exp := time.Now().Add(5 * time.Minute).Unix()
+	jwt := "e30." + base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp))) + ".sig"
+
+	s := serveWorkloadAPI(t, socket, &X509SVID{
+		SpiffeID:    "spiffe://example.org/pouch",
+		X509SVID:    cert,
+		X509SVIDKey: key,
+		Bundle:      cert,
+	}, jwt)
+	defer s.Stop()
+
+	ctx, cancel := netcontext.WithTimeout(netcontext.Background(), 5*time.Second)
+	defer cancel()
+	c := NewClient("unix://" + socket)
+
+	x509SVID, err := c.FetchX509SVID(ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "spiffe://example.org/pouch", x509SVID.SpiffeID)
+		certs, keyPEM, bundle, expiration, err := x509SVID.PEM()
+		assert.NoError(t, err)
+		assert.Equal(t, notAfter.UTC(), expiration.UTC())
+		assert.Equal(t, certs, bundle)
+		block, _ := pem.Decode(keyPEM)
+		if assert.NotNil(t, block) {
+			assert.Equal(t, key, block.Bytes)
+		}
+	}
+
+	jwtSVID, err := c.FetchJWTSVID(ctx, []string{"vault"}, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, jwt, jwtSVID.SVID)
+		expiration, err := jwtSVID.Expiration()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, expiration.Unix())
+	}
+}
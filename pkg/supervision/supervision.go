@@ -0,0 +1,228 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supervision reloads services managed by init and supervision
+// systems other than systemd, as the ones used in Alpine containers or
+// BSD-style hosts
+package supervision
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	S6          = "s6"
+	Runit       = "runit"
+	Supervisord = "supervisord"
+	OpenRC      = "openrc"
+
+	// Scan directory of s6-overlay
+	DefaultS6ScanDir = "/run/service"
+)
+
+type Config struct {
+	// Init or supervision system, one of s6, runit, supervisord or openrc
+	Type string `json:"type"`
+
+	// Directory with the services supervised by s6 or runit
+	ScanDir string `json:"scan_dir,omitempty"`
+
+	// Configuration file used by supervisorctl
+	ConfigFile string `json:"config_file,omitempty"`
+}
+
+// Names of the signals as used by the tools of each system
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "HUP",
+	syscall.SIGINT:  "INT",
+	syscall.SIGQUIT: "QUIT",
+	syscall.SIGKILL: "KILL",
+	syscall.SIGTERM: "TERM",
+	syscall.SIGUSR1: "USR1",
+	syscall.SIGUSR2: "USR2",
+	syscall.SIGALRM: "ALRM",
+}
+
+var s6Signals = map[string]string{
+	"HUP": "-h", "INT": "-i", "QUIT": "-q", "KILL": "-k",
+	"TERM": "-t", "USR1": "-1", "USR2": "-2", "ALRM": "-a",
+}
+
+var runitSignals = map[string]string{
+	"HUP": "hup", "INT": "interrupt", "QUIT": "quit", "KILL": "kill",
+	"TERM": "term", "USR1": "1", "USR2": "2", "ALRM": "alarm",
+}
+
+type runFunc func(ctx context.Context, env []string, name string, args ...string) (string, error)
+
+func run(ctx context.Context, env []string, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// Manager manages services with the command line tools of a supervision
+// system
+type Manager struct {
+	config Config
+	run    runFunc
+}
+
+// New creates a manager for the supervision system of the configuration
+func New(c Config) (*Manager, error) {
+	switch c.Type {
+	case S6:
+		if c.ScanDir == "" {
+			c.ScanDir = DefaultS6ScanDir
+		}
+	case Runit, Supervisord, OpenRC:
+	default:
+		return nil, fmt.Errorf("unknown service manager: %s", c.Type)
+	}
+	return &Manager{config: c, run: run}, nil
+}
+
+// s6Dir returns the service directory of a service supervised by s6
+func (m *Manager) s6Dir(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(m.config.ScanDir, name)
+}
+
+func (m *Manager) runitEnv() []string {
+	if m.config.ScanDir == "" {
+		return nil
+	}
+	return []string{"SVDIR=" + m.config.ScanDir}
+}
+
+func (m *Manager) supervisorctl(ctx context.Context, args ...string) (string, error) {
+	if m.config.ConfigFile != "" {
+		args = append([]string{"-c", m.config.ConfigFile}, args...)
+	}
+	return m.run(ctx, nil, "supervisorctl", args...)
+}
+
+// Reload reloads a service, services of s6, runit and supervisord are
+// sent a HUP signal
+func (m *Manager) Reload(ctx context.Context, name string) error {
+	var err error
+	switch m.config.Type {
+	case S6:
+		_, err = m.run(ctx, nil, "s6-svc", "-h", m.s6Dir(name))
+	case Runit:
+		_, err = m.run(ctx, m.runitEnv(), "sv", "reload", name)
+	case Supervisord:
+		_, err = m.supervisorctl(ctx, "signal", "HUP", name)
+	case OpenRC:
+		_, err = m.run(ctx, nil, "rc-service", name, "reload")
+	}
+	return err
+}
+
+func (m *Manager) Restart(ctx context.Context, name string) error {
+	var err error
+	switch m.config.Type {
+	case S6:
+		_, err = m.run(ctx, nil, "s6-svc", "-r", m.s6Dir(name))
+	case Runit:
+		_, err = m.run(ctx, m.runitEnv(), "sv", "restart", name)
+	case Supervisord:
+		_, err = m.supervisorctl(ctx, "restart", name)
+	case OpenRC:
+		_, err = m.run(ctx, nil, "rc-service", name, "restart")
+	}
+	return err
+}
+
+// DaemonReload applies changes in the definitions of services, runsvdir
+// scans its directory periodically, so nothing is needed for runit
+func (m *Manager) DaemonReload() error {
+	ctx := context.Background()
+	var err error
+	switch m.config.Type {
+	case S6:
+		_, err = m.run(ctx, nil, "s6-svscanctl", "-a", m.config.ScanDir)
+	case Supervisord:
+		_, err = m.supervisorctl(ctx, "update")
+	case OpenRC:
+		err = fmt.Errorf("openrc doesn't support reloading service definitions")
+	}
+	return err
+}
+
+// IsActive checks if a service is running
+func (m *Manager) IsActive(name string) (bool, error) {
+	ctx := context.Background()
+	switch m.config.Type {
+	case S6:
+		out, err := m.run(ctx, nil, "s6-svstat", "-o", "up", m.s6Dir(name))
+		if err != nil {
+			return false, err
+		}
+		return strings.TrimSpace(out) == "true", nil
+	case Runit:
+		out, err := m.run(ctx, m.runitEnv(), "sv", "status", name)
+		if err != nil {
+			return false, err
+		}
+		return strings.HasPrefix(out, "run:"), nil
+	case Supervisord:
+		// supervisorctl status fails for services that are not
+		// running, so only its output is checked
+		out, _ := m.supervisorctl(ctx, "status", name)
+		fields := strings.Fields(out)
+		return len(fields) > 1 && fields[1] == "RUNNING", nil
+	case OpenRC:
+		_, err := m.run(ctx, nil, "rc-service", name, "status")
+		return err == nil, nil
+	}
+	return false, nil
+}
+
+// Kill sends a signal to a service
+func (m *Manager) Kill(name string, signal syscall.Signal) error {
+	signalName, found := signalNames[signal]
+	if !found {
+		return fmt.Errorf("signal %d not supported by %s", signal, m.config.Type)
+	}
+	ctx := context.Background()
+	var err error
+	switch m.config.Type {
+	case S6:
+		_, err = m.run(ctx, nil, "s6-svc", s6Signals[signalName], m.s6Dir(name))
+	case Runit:
+		_, err = m.run(ctx, m.runitEnv(), "sv", runitSignals[signalName], name)
+	case Supervisord:
+		_, err = m.supervisorctl(ctx, "signal", signalName, name)
+	case OpenRC:
+		err = fmt.Errorf("openrc doesn't support sending signals to services")
+	}
+	return err
+}
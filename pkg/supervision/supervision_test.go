@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supervision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingRunner struct {
+	commands []string
+	outputs  map[string]string
+}
+
+func (r *recordingRunner) run(ctx context.Context, env []string, name string, args ...string) (string, error) {
+	command := strings.Join(append(append(env, name), args...), " ")
+	r.commands = append(r.commands, command)
+	out, found := r.outputs[command]
+	if !found {
+		return "", nil
+	}
+	if out == "error" {
+		return "", fmt.Errorf("%s failed", name)
+	}
+	return out, nil
+}
+
+func newTestManager(t *testing.T, c Config, outputs map[string]string) (*Manager, *recordingRunner) {
+	m, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recordingRunner{outputs: outputs}
+	m.run = r.run
+	return m, r
+}
+
+func TestManagers(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		config   Config
+		outputs  map[string]string
+		expected []string
+	}{
+		{
+			config:  Config{Type: S6},
+			outputs: map[string]string{"s6-svstat -o up /run/service/nginx": "true\n"},
+			expected: []string{
+				"s6-svc -h /run/service/nginx",
+				"s6-svc -r /run/service/nginx",
+				"s6-svc -2 /run/service/nginx",
+				"s6-svstat -o up /run/service/nginx",
+			},
+		},
+		{
+			config:  Config{Type: Runit, ScanDir: "/etc/service"},
+			outputs: map[string]string{"SVDIR=/etc/service sv status nginx": "run: nginx: (pid 42) 10s\n"},
+			expected: []string{
+				"SVDIR=/etc/service sv reload nginx",
+				"SVDIR=/etc/service sv restart nginx",
+				"SVDIR=/etc/service sv 2 nginx",
+				"SVDIR=/etc/service sv status nginx",
+			},
+		},
+		{
+			config:  Config{Type: Supervisord, ConfigFile: "/etc/supervisord.conf"},
+			outputs: map[string]string{"supervisorctl -c /etc/supervisord.conf status nginx": "nginx  RUNNING   pid 42, uptime 0:01:00\n"},
+			expected: []string{
+				"supervisorctl -c /etc/supervisord.conf signal HUP nginx",
+				"supervisorctl -c /etc/supervisord.conf restart nginx",
+				"supervisorctl -c /etc/supervisord.conf signal USR2 nginx",
+				"supervisorctl -c /etc/supervisord.conf status nginx",
+			},
+		},
+	}
+	for _, c := range cases {
+		m, r := newTestManager(t, c.config, c.outputs)
+		assert.NoError(t, m.Reload(ctx, "nginx"), c.config.Type)
+		assert.NoError(t, m.Restart(ctx, "nginx"), c.config.Type)
+		assert.NoError(t, m.Kill("nginx", syscall.SIGUSR2), c.config.Type)
+		active, err := m.IsActive("nginx")
+		assert.NoError(t, err, c.config.Type)
+		assert.True(t, active, c.config.Type)
+		assert.Equal(t, c.expected, r.commands, c.config.Type)
+	}
+}
+
+func TestOpenRC(t *testing.T) {
+	m, r := newTestManager(t, Config{Type: OpenRC}, map[string]string{"rc-service stopped status": "error"})
+	assert.NoError(t, m.Reload(context.Background(), "nginx"))
+	assert.Equal(t, []string{"rc-service nginx reload"}, r.commands)
+
+	active, err := m.IsActive("stopped")
+	assert.NoError(t, err)
+	assert.False(t, active)
+
+	assert.Error(t, m.Kill("nginx", syscall.SIGHUP))
+	assert.Error(t, m.DaemonReload())
+
+	_, err = New(Config{Type: "upstart"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// JournalSocket is the socket where journald receives native messages
+const JournalSocket = "/run/systemd/journal/socket"
+
+// Priorities of journal messages, as syslog levels
+const (
+	JournalPriorityErr     = 3
+	JournalPriorityWarning = 4
+	JournalPriorityInfo    = 6
+	JournalPriorityDebug   = 7
+)
+
+var journalInvalidField = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// JournalFieldName converts a name to a valid journal field name, fields
+// can only contain uppercase letters, digits and underscores, and cannot
+// start with an underscore, that is reserved for trusted fields
+func JournalFieldName(name string) string {
+	name = journalInvalidField.ReplaceAllString(strings.ToUpper(name), "_")
+	return strings.TrimLeft(name, "_")
+}
+
+// JournalStream returns true if the standard error is connected to the
+// journal, as it happens when running as a systemd service
+func JournalStream() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var dev, ino uint64
+	if _, err := fmt.Sscanf(stream, "%d:%d", &dev, &ino); err != nil {
+		return false
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &stat); err != nil {
+		return false
+	}
+	return uint64(stat.Dev) == dev && stat.Ino == ino
+}
+
+// appendJournalField encodes a field in the native journal protocol,
+// values with new lines are encoded with their size
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// Journal sends messages to journald with the native protocol
+type Journal struct {
+	// Path of the socket, JournalSocket by default
+	Socket string
+
+	// Identifier of the messages, as the name of the program
+	Identifier string
+}
+
+func (j *Journal) socket() string {
+	if j.Socket == "" {
+		return JournalSocket
+	}
+	return j.Socket
+}
+
+// Send sends a message to the journal, with the given priority and
+// additional fields, whose names must be valid
+func (j *Journal) Send(message string, priority int, fields map[string]string) error {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", message)
+	appendJournalField(&b, "PRIORITY", fmt.Sprint(priority))
+	if j.Identifier != "" {
+		appendJournalField(&b, "SYSLOG_IDENTIFIER", j.Identifier)
+	}
+	for name, value := range fields {
+		appendJournalField(&b, name, value)
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.socket(), Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(b.Bytes())
+	if err == nil {
+		return nil
+	}
+	if opErr, ok := err.(*net.OpError); !ok || !isMessageTooLong(opErr.Err) {
+		return err
+	}
+
+	// Messages too big for a datagram are sent in a file descriptor
+	f, err := ioutil.TempFile("/dev/shm", "pouch-journal")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if _, err = f.Write(b.Bytes()); err != nil {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix([]byte{}, syscall.UnixRights(int(f.Fd())), nil)
+	return err
+}
+
+func isMessageTooLong(err error) bool {
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalFieldName(t *testing.T) {
+	assert.Equal(t, "SECRET", JournalFieldName("secret"))
+	assert.Equal(t, "VAULT_PATH", JournalFieldName("vault.path"))
+	assert.Equal(t, "EVENT", JournalFieldName("_event"))
+}
+
+func TestJournalSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	j := &Journal{Socket: socket, Identifier: "pouch"}
+	err = j.Send("Secret 'db' read", JournalPriorityInfo, map[string]string{"SECRET": "db"})
+	assert.NoError(t, err)
+	b := make([]byte, 4096)
+	n, err := conn.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "MESSAGE=Secret 'db' read\nPRIORITY=6\nSYSLOG_IDENTIFIER=pouch\nSECRET=db\n", string(b[:n]))
+
+	err = j.Send("two\nlines", JournalPriorityErr, nil)
+	assert.NoError(t, err)
+	n, err = conn.Read(b)
+	assert.NoError(t, err)
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(9))
+	expected.WriteString("two\nlines\nPRIORITY=3\nSYSLOG_IDENTIFIER=pouch\n")
+	assert.Equal(t, expected.String(), string(b[:n]))
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"syscall"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/coreos/go-systemd/dbus"
@@ -33,7 +34,14 @@ type SystemD interface {
 	Close()
 
 	NotifyReady() error
+	NotifyDegraded(string) error
+	NotifyNotReady(string) error
 	Reload(context.Context, string) error
+	Restart(context.Context, string) error
+	Action(context.Context, string, string) error
+	DaemonReload() error
+	IsActive(string) (bool, error)
+	Kill(string, syscall.Signal) error
 }
 
 type SystemdConfigurer interface {
@@ -85,18 +93,34 @@ func (s *systemd) CanNotify() bool {
 	return true
 }
 
-func (s *systemd) NotifyReady() error {
-	sent, err := daemon.SdNotify(false, "READY=1")
+func (s *systemd) notify(what, state string) error {
+	sent, err := daemon.SdNotify(false, state)
 	if err != nil {
-		return fmt.Errorf("couldn't notify ready: %v", err)
+		return fmt.Errorf("couldn't notify %s: %v", what, err)
 	}
 	if !sent {
-		return fmt.Errorf("ready notification to systemd was not sent")
+		return fmt.Errorf("%s notification to systemd was not sent", what)
 	}
 	return nil
 }
 
-func (s *systemd) Reload(ctx context.Context, name string) error {
+func (s *systemd) NotifyReady() error {
+	return s.notify("ready", "READY=1\nSTATUS=Ready")
+}
+
+// Systemd doesn't have a way to revert readiness, so status is used to
+// report problems after being ready
+func (s *systemd) NotifyDegraded(reason string) error {
+	return s.notify("degraded", "STATUS=Degraded: "+reason)
+}
+
+func (s *systemd) NotifyNotReady(reason string) error {
+	return s.notify("not ready", "STATUS=Not ready: "+reason)
+}
+
+type unitJob func(c *dbus.Conn, name, mode string, result chan<- string) (int, error)
+
+func (s *systemd) runJob(ctx context.Context, what string, job unitJob, name string) error {
 	c, err := dbus.New()
 	if err != nil {
 		return err
@@ -104,7 +128,7 @@ func (s *systemd) Reload(ctx context.Context, name string) error {
 	defer c.Close()
 
 	result := make(chan string, 1)
-	_, err = c.ReloadOrRestartUnit(name, "replace", result)
+	_, err = job(c, name, "replace", result)
 	if err != nil {
 		return err
 	}
@@ -113,8 +137,70 @@ func (s *systemd) Reload(ctx context.Context, name string) error {
 		return ctx.Err()
 	case r := <-result:
 		if r != "done" {
-			return fmt.Errorf("reload job for %s is not done (found: %s)", name, r)
+			return fmt.Errorf("%s job for %s is not done (found: %s)", what, name, r)
 		}
 	}
 	return nil
 }
+
+func (s *systemd) Reload(ctx context.Context, name string) error {
+	return s.runJob(ctx, "reload", (*dbus.Conn).ReloadOrRestartUnit, name)
+}
+
+func (s *systemd) Restart(ctx context.Context, name string) error {
+	return s.runJob(ctx, "restart", (*dbus.Conn).RestartUnit, name)
+}
+
+// Jobs of the actions that can be run on units, named as in systemctl
+var actionJobs = map[string]unitJob{
+	"reload":                (*dbus.Conn).ReloadUnit,
+	"restart":               (*dbus.Conn).RestartUnit,
+	"try-restart":           (*dbus.Conn).TryRestartUnit,
+	"reload-or-restart":     (*dbus.Conn).ReloadOrRestartUnit,
+	"try-reload-or-restart": (*dbus.Conn).ReloadOrTryRestartUnit,
+}
+
+// Action runs an action on a unit, as systemctl does
+func (s *systemd) Action(ctx context.Context, name, action string) error {
+	job, found := actionJobs[action]
+	if !found {
+		return fmt.Errorf("unknown action for %s: %s", name, action)
+	}
+	return s.runJob(ctx, action, job, name)
+}
+
+// DaemonReload reloads unit files, needed after modifying them
+func (s *systemd) DaemonReload() error {
+	c, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Reload()
+}
+
+// Kill sends a signal to the processes of a unit
+func (s *systemd) Kill(name string, signal syscall.Signal) error {
+	c, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.KillUnit(name, int32(signal))
+	return nil
+}
+
+// IsActive checks if a unit is active
+func (s *systemd) IsActive(name string) (bool, error) {
+	c, err := dbus.New()
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+	p, err := c.GetUnitProperty(name, "ActiveState")
+	if err != nil {
+		return false, err
+	}
+	state, _ := p.Value.Value().(string)
+	return state == "active", nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records spans of operations and exports them to an
+// OpenTelemetry collector with the OTLP/HTTP protocol, in JSON encoding.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Path of the traces endpoint of OTLP/HTTP collectors
+	TracesPath = "/v1/traces"
+
+	// Maximum number of spans kept waiting to be exported, older spans
+	// are dropped when reached
+	maxPendingSpans = 2048
+)
+
+type SpanKind int
+
+// Kinds of spans, as defined by OpenTelemetry
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// Status codes of spans, as defined by OpenTelemetry
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+// Attributes describe the operation of a span
+type Attributes map[string]interface{}
+
+// Span is an operation being traced
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     SpanKind
+	start    time.Time
+	end      time.Time
+	attrs    Attributes
+	err      string
+}
+
+// SetAttribute adds an attribute to the span, it does nothing on nil spans
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(Attributes)
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span, setting its status as failed if err is not nil
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.tracer.record(s)
+}
+
+// Tracer creates spans and exports them to a collector. A nil tracer
+// creates nil spans, so code can be instrumented unconditionally.
+type Tracer struct {
+	// Base URL of the collector, e.g. http://localhost:4318
+	Endpoint string
+
+	// Headers added to export requests, e.g. for authentication
+	Headers map[string]string
+
+	// Name of the traced service
+	ServiceName string
+
+	// Transformation applied to error messages and string attributes
+	// before exporting them
+	Filter func(string) string
+
+	sync.Mutex
+	pending []*Span
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start starts a span, child of parent if it is not nil
+func (t *Tracer) Start(parent *Span, name string, kind SpanKind, attrs Attributes) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		tracer: t,
+		spanID: randomID(8),
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	return s
+}
+
+func (t *Tracer) record(s *Span) {
+	t.Lock()
+	defer t.Unlock()
+	if len(t.pending) >= maxPendingSpans {
+		t.pending = t.pending[1:]
+	}
+	t.pending = append(t.pending, s)
+}
+
+func (t *Tracer) filter(s string) string {
+	if t.Filter == nil {
+		return s
+	}
+	return t.Filter(s)
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func (t *Tracer) attributes(attrs Attributes) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attrs))
+	for key, value := range attrs {
+		var v otlpValue
+		switch value := value.(type) {
+		case string:
+			v = otlpValue{"stringValue": t.filter(value)}
+		case bool:
+			v = otlpValue{"boolValue": value}
+		case int:
+			v = otlpValue{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = otlpValue{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = otlpValue{"doubleValue": value}
+		default:
+			v = otlpValue{"stringValue": t.filter(fmt.Sprint(value))}
+		}
+		result = append(result, otlpAttribute{Key: key, Value: v})
+	}
+	return result
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// payload returns the spans in an OTLP export request
+func (t *Tracer) payload(spans []*Span) map[string]interface{} {
+	var otlpSpans []map[string]interface{}
+	for _, s := range spans {
+		status := map[string]interface{}{"code": statusUnset}
+		if s.err != "" {
+			status = map[string]interface{}{"code": statusError, "message": t.filter(s.err)}
+		}
+		span := map[string]interface{}{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": unixNano(s.start),
+			"endTimeUnixNano":   unixNano(s.end),
+			"attributes":        t.attributes(s.attrs),
+			"status":            status,
+		}
+		if s.parentID != "" {
+			span["parentSpanId"] = s.parentID
+		}
+		otlpSpans = append(otlpSpans, span)
+	}
+	resource := t.attributes(Attributes{"service.name": t.ServiceName})
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": t.ServiceName},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+// Export sends the finished spans to the collector
+func (t *Tracer) Export(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	d, err := json.Marshal(t.payload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.Endpoint+TracesPath, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%d spans rejected with status %d: %s", len(spans), resp.StatusCode, out)
+	}
+	return nil
+}
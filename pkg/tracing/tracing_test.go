@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start(nil, "noop", SpanKindInternal, nil)
+	assert.Nil(t, span)
+	span.SetAttribute("foo", "bar")
+	span.End(nil)
+	assert.NoError(t, tracer.Export(context.Background()))
+}
+
+func TestExport(t *testing.T) {
+	var received map[string]interface{}
+	var path, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	tracer := &Tracer{
+		Endpoint:    server.URL,
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		ServiceName: "pouch",
+		Filter:      func(s string) string { return strings.Replace(s, "s3cr3t", "[REDACTED]", -1) },
+	}
+	parent := tracer.Start(nil, "secret.update", SpanKindInternal, Attributes{"secret": "db"})
+	child := tracer.Start(parent, "vault.request", SpanKindClient, Attributes{"vault.path": "secret/db"})
+	child.SetAttribute("http.status_code", 200)
+	child.End(errors.New("unexpected s3cr3t"))
+	parent.End(nil)
+
+	assert.NoError(t, tracer.Export(context.Background()))
+	assert.Equal(t, TracesPath, path)
+	assert.Equal(t, "Bearer token", auth)
+
+	d, _ := json.Marshal(received)
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []struct {
+					Key   string
+					Value map[string]interface{}
+				}
+			}
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string
+					Kind         int
+					Attributes   []struct {
+						Key   string
+						Value map[string]interface{}
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	if !assert.NoError(t, json.Unmarshal(d, &request)) || !assert.Len(t, request.ResourceSpans, 1) {
+		return
+	}
+	rs := request.ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "pouch", rs.Resource.Attributes[0].Value["stringValue"])
+	spans := rs.ScopeSpans[0].Spans
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	assert.Equal(t, "vault.request", spans[0].Name)
+	assert.Equal(t, int(SpanKindClient), spans[0].Kind)
+	assert.Len(t, spans[0].TraceID, 32)
+	assert.Len(t, spans[0].SpanID, 16)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "", spans[1].ParentSpanID)
+	assert.Equal(t, statusError, spans[0].Status.Code)
+	assert.Equal(t, "unexpected [REDACTED]", spans[0].Status.Message)
+	assert.Equal(t, statusUnset, spans[1].Status.Code)
+	for _, a := range spans[0].Attributes {
+		if a.Key == "http.status_code" {
+			assert.Equal(t, "200", a.Value["intValue"])
+		}
+	}
+
+	// Exported spans are not sent again
+	received = nil
+	assert.NoError(t, tracer.Export(context.Background()))
+	assert.Nil(t, received)
+}
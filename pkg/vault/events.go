@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/websocket"
+)
+
+const (
+	EventsSubscribeURL = "/v1/sys/events/subscribe/"
+)
+
+// Event is a notification received from Vault, available since Vault 1.13
+type Event struct {
+	ID        string
+	EventType string
+
+	// Path of the request that generated the event, and path where the
+	// affected data can be read
+	Path     string
+	DataPath string
+
+	Operation string
+}
+
+type rawEvent struct {
+	Data struct {
+		EventType string `json:"event_type"`
+		Event     struct {
+			ID       string `json:"id"`
+			Metadata struct {
+				Path      string `json:"path"`
+				DataPath  string `json:"data_path"`
+				Operation string `json:"operation"`
+			} `json:"metadata"`
+		} `json:"event"`
+	} `json:"data"`
+}
+
+func parseEvent(d []byte) (Event, error) {
+	var raw rawEvent
+	err := json.Unmarshal(d, &raw)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:        raw.Data.Event.ID,
+		EventType: raw.Data.EventType,
+		Path:      raw.Data.Event.Metadata.Path,
+		DataPath:  raw.Data.Event.Metadata.DataPath,
+		Operation: raw.Data.Event.Metadata.Operation,
+	}, nil
+}
+
+// Subscribe subscribes to Vault events of a type, that can contain
+// wildcards. Events are sent to the returned channel till the context
+// is done or the connection is lost, then the channel is closed.
+func (v *vaultApi) Subscribe(ctx context.Context, eventType string) (<-chan Event, error) {
+	config, err := v.newConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Websockets cannot be used over HTTP/2
+	transport := config.HttpClient.Transport.(*http.Transport)
+	transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+
+	address := strings.TrimSuffix(config.Address, "/")
+	eventsURL := address + EventsSubscribeURL + eventType + "?json=true"
+	header := make(http.Header)
+	if v.Token != "" {
+		header.Set(TokenHeader, v.Token)
+	}
+	conn, err := websocket.Dial(ctx, config.HttpClient, eventsURL, header)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		defer close(done)
+		for {
+			_, d, err := conn.ReadMessage()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Vault events subscription failed: %v", err)
+				}
+				return
+			}
+			e, err := parseEvent(d)
+			if err != nil {
+				log.Printf("Couldn't parse Vault event: %v", err)
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
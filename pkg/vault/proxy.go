@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ProxyConfig struct {
+	// Proxy URL, http, https and socks5 schemes are supported
+	URL string `json:"url,omitempty"`
+
+	// Comma-separated list of hosts, domains, IPs or CIDRs that
+	// shouldn't be accessed through the proxy
+	NoProxy string `json:"no_proxy,omitempty"`
+
+	// Connect directly, ignoring proxy environment variables
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+type proxyFunc func(*http.Request) (*url.URL, error)
+
+// Proxy returns the function to be used by the HTTP transport to select
+// the proxy for each request. If nothing is configured, the proxy is
+// obtained from HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func (c *ProxyConfig) Proxy() (proxyFunc, error) {
+	if c == nil {
+		return http.ProxyFromEnvironment, nil
+	}
+	if c.Disabled {
+		return nil, nil
+	}
+	if c.URL == "" {
+		return nil, fmt.Errorf("proxy URL required")
+	}
+	proxyURL, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect proxy URL: %v", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy scheme not supported: %s", proxyURL.Scheme)
+	}
+
+	noProxy := parseNoProxy(c.NoProxy)
+	return func(r *http.Request) (*url.URL, error) {
+		if noProxy.match(r.URL) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+type noProxyList []string
+
+func parseNoProxy(s string) noProxyList {
+	var l noProxyList
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func (l noProxyList) match(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, e := range l {
+		if e == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(e); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort, err := net.SplitHostPort(e)
+		if err != nil {
+			entryHost, entryPort = e, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		// Domains match themselves and any subdomain
+		domain := strings.TrimPrefix(entryHost, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestNoProxyMatch(t *testing.T) {
+	cases := []struct {
+		noProxy string
+		url     string
+		match   bool
+	}{
+		{"", "http://vault:8200", false},
+		{"*", "http://vault:8200", true},
+		{"vault", "http://vault:8200", true},
+		{"VAULT", "http://vault:8200", true},
+		{"vault", "http://other:8200", false},
+
+		// Domains match themselves and their subdomains
+		{"example.com", "https://example.com", true},
+		{"example.com", "https://vault.example.com", true},
+		{".example.com", "https://vault.example.com", true},
+		{".example.com", "https://example.com", true},
+		{"example.com", "https://badexample.com", false},
+		{"foo, example.com ,bar", "https://vault.example.com", true},
+
+		// Ports, with defaults by scheme
+		{"vault:8200", "http://vault:8200", true},
+		{"vault:8200", "http://vault:8201", false},
+		{"vault:443", "https://vault", true},
+		{"vault:443", "http://vault", false},
+		{"vault:80", "http://vault", true},
+
+		// IPs and CIDRs
+		{"10.0.0.1", "http://10.0.0.1:8200", true},
+		{"10.0.0.1", "http://10.0.0.2:8200", false},
+		{"10.0.0.1:8200", "http://10.0.0.1:8200", true},
+		{"10.0.0.1:8200", "http://10.0.0.1:8300", false},
+		{"10.0.0.0/8", "http://10.1.2.3:8200", true},
+		{"10.0.0.0/8", "http://192.168.1.1:8200", false},
+		{"10.0.0.0/8", "http://vault:8200", false},
+		{"::1", "http://[::1]:8200", true},
+		{"[::1]:8200", "http://[::1]:8200", true},
+		{"fd00::/8", "http://[fd00::1]:8200", true},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := parseNoProxy(c.noProxy).match(u); match != c.match {
+			t.Errorf("no_proxy '%s' with %s: expected match %v, found %v", c.noProxy, c.url, c.match, match)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	request := func(rawurl string) *http.Request {
+		r, err := http.NewRequest("GET", rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	c := &ProxyConfig{URL: "http://proxy:3128", NoProxy: "localhost"}
+	proxy, err := c.Proxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := proxy(request("https://vault:8200/v1/secret/foo"))
+	if err != nil || u == nil || u.String() != "http://proxy:3128" {
+		t.Fatalf("expected proxy, found %v (%v)", u, err)
+	}
+	u, err = proxy(request("http://localhost:8200/v1/secret/foo"))
+	if err != nil || u != nil {
+		t.Fatalf("expected direct connection, found %v (%v)", u, err)
+	}
+
+	c = &ProxyConfig{URL: "http://proxy:3128", Disabled: true}
+	proxy, err = c.Proxy()
+	if err != nil || proxy != nil {
+		t.Fatalf("expected no proxy function when disabled, found error %v", err)
+	}
+
+	for _, c := range []*ProxyConfig{{}, {URL: "ftp://proxy"}, {URL: "%"}} {
+		if _, err := c.Proxy(); err == nil {
+			t.Errorf("expected error with proxy URL '%s'", c.URL)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/spiffe"
+)
+
+const (
+	DefaultSPIFFEAuthMount    = "jwt"
+	DefaultSPIFFEAuthAudience = "vault"
+
+	spiffeLoginTimeout = 30 * time.Second
+)
+
+// SPIFFEAuthConfig configures login with JWT SVIDs obtained from the
+// SPIFFE Workload API, using the JWT auth method of Vault
+type SPIFFEAuthConfig struct {
+	// Path to the socket of the Workload API
+	Socket string `json:"socket,omitempty"`
+
+	// Role of the JWT auth method
+	Role string `json:"role,omitempty"`
+
+	// Path where the JWT auth method is mounted, jwt by default
+	Mount string `json:"mount,omitempty"`
+
+	// Audience of the JWT SVID, vault by default
+	Audience string `json:"audience,omitempty"`
+
+	// SPIFFE ID to use if the workload has several identities
+	SpiffeID string `json:"spiffe_id,omitempty"`
+}
+
+func (c *SPIFFEAuthConfig) loginURL() string {
+	mount := c.Mount
+	if mount == "" {
+		mount = DefaultSPIFFEAuthMount
+	}
+	return fmt.Sprintf("/v1/auth/%s/login", mount)
+}
+
+// spiffeLogin obtains a token using a JWT SVID
+func (v *vaultApi) spiffeLogin() error {
+	audience := v.spiffe.Audience
+	if audience == "" {
+		audience = DefaultSPIFFEAuthAudience
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeLoginTimeout)
+	defer cancel()
+	svid, err := spiffe.NewClient(v.spiffe.Socket).FetchJWTSVID(ctx, []string{audience}, v.spiffe.SpiffeID)
+	if err != nil {
+		return fmt.Errorf("couldn't obtain JWT SVID: %v", err)
+	}
+
+	data := map[string]interface{}{"jwt": svid.SVID}
+	if v.spiffe.Role != "" {
+		data["role"] = v.spiffe.Role
+	}
+	s, _, err := v.Request(http.MethodPost, v.spiffe.loginURL(), &RequestOptions{Data: data})
+	if err != nil {
+		return err
+	}
+	if s == nil || s.Auth == nil {
+		return fmt.Errorf("no token obtained with JWT SVID of %s", svid.SpiffeID)
+	}
+	v.Token = s.Auth.ClientToken
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-rootcerts"
+)
+
+type TLSConfig struct {
+	CACert             string `json:"ca_cert,omitempty"`
+	CAPath             string `json:"ca_path,omitempty"`
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"`
+	TLSServerName      string `json:"tls_server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+func (c *TLSConfig) IsSet() bool {
+	return c.CACert != "" || c.CAPath != "" ||
+		c.ClientCert != "" || c.ClientKey != "" ||
+		c.TLSServerName != "" || c.InsecureSkipVerify
+}
+
+// tlsLoader keeps a TLS configuration built from files, it is rebuilt
+// when any of these files change, so CA bundles and client certificates
+// can be rotated without restarting.
+type tlsLoader struct {
+	sync.Mutex
+
+	config TLSConfig
+
+	current *tls.Config
+	mtimes  map[string]time.Time
+}
+
+func newTLSLoader(c TLSConfig) *tlsLoader {
+	return &tlsLoader{config: c}
+}
+
+func (l *tlsLoader) files() []string {
+	var files []string
+	for _, f := range []string{l.config.CACert, l.config.CAPath, l.config.ClientCert, l.config.ClientKey} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (l *tlsLoader) changed() (bool, map[string]time.Time) {
+	mtimes := make(map[string]time.Time)
+	changed := l.current == nil
+	for _, f := range l.files() {
+		info, err := os.Stat(f)
+		if err != nil {
+			// Let the loader report the error
+			return true, nil
+		}
+		mtimes[f] = info.ModTime()
+		if !l.mtimes[f].Equal(info.ModTime()) {
+			changed = true
+		}
+	}
+	return changed, mtimes
+}
+
+func (l *tlsLoader) load() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         l.config.TLSServerName,
+		InsecureSkipVerify: l.config.InsecureSkipVerify,
+	}
+
+	rootConfig := &rootcerts.Config{
+		CAFile: l.config.CACert,
+		CAPath: l.config.CAPath,
+	}
+	if err := rootcerts.ConfigureTLS(config, rootConfig); err != nil {
+		return nil, fmt.Errorf("couldn't load CA certificates: %v", err)
+	}
+
+	switch {
+	case l.config.ClientCert != "" && l.config.ClientKey != "":
+		cert, err := tls.LoadX509KeyPair(l.config.ClientCert, l.config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case l.config.ClientCert != "" || l.config.ClientKey != "":
+		return nil, fmt.Errorf("both client cert and client key must be provided")
+	}
+
+	return config, nil
+}
+
+// TLSClientConfig returns the current TLS configuration, reloading it if
+// any of its files changed since the last time it was loaded.
+func (l *tlsLoader) TLSClientConfig() (*tls.Config, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	changed, mtimes := l.changed()
+	if !changed {
+		return l.current, nil
+	}
+
+	config, err := l.load()
+	if err != nil {
+		if l.current != nil {
+			log.Printf("Couldn't reload TLS configuration, using previous one: %v", err)
+			return l.current, nil
+		}
+		return nil, err
+	}
+	if l.current != nil {
+		log.Println("TLS configuration for Vault reloaded")
+	}
+	l.current = config
+	l.mtimes = mtimes
+	return l.current, nil
+}
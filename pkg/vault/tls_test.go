@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCACert(t *testing.T, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writeCACert writes a CA file with a modification time in the future,
+// so it is seen as changed even if written in the same second
+func writeCACert(t *testing.T, path string, d []byte, mtime time.Time) {
+	if err := ioutil.WriteFile(path, d, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certPool(t *testing.T, d []byte) *x509.CertPool {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(d) {
+		t.Fatal("couldn't parse certificate")
+	}
+	return pool
+}
+
+func TestTLSLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := path.Join(dir, "ca.pem")
+
+	first := testCACert(t, "first")
+	writeCACert(t, caFile, first, time.Now())
+
+	l := newTLSLoader(TLSConfig{CACert: caFile})
+	config, err := l.TLSClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.RootCAs.Equal(certPool(t, first)) {
+		t.Fatal("expected first CA certificate")
+	}
+
+	unchanged, err := l.TLSClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged != config {
+		t.Fatal("configuration reloaded without changes")
+	}
+
+	second := testCACert(t, "second")
+	writeCACert(t, caFile, second, time.Now().Add(time.Minute))
+	reloaded, err := l.TLSClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.RootCAs.Equal(certPool(t, second)) {
+		t.Fatal("expected second CA certificate after reload")
+	}
+
+	// Previous configuration is kept if the new one cannot be loaded
+	writeCACert(t, caFile, []byte("not a certificate"), time.Now().Add(2*time.Minute))
+	kept, err := l.TLSClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error when reload fails: %v", err)
+	}
+	if kept != reloaded {
+		t.Fatal("expected previous configuration when reload fails")
+	}
+}
+
+func TestTLSLoaderInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := path.Join(dir, "ca.pem")
+	writeCACert(t, caFile, []byte("not a certificate"), time.Now())
+
+	_, err = newTLSLoader(TLSConfig{CACert: caFile}).TLSClientConfig()
+	if err == nil {
+		t.Fatal("expected error with invalid CA certificate")
+	}
+
+	certFile := path.Join(dir, "cert.pem")
+	writeCACert(t, certFile, testCACert(t, "client"), time.Now())
+	_, err = newTLSLoader(TLSConfig{ClientCert: certFile}).TLSClientConfig()
+	if err == nil || !strings.Contains(err.Error(), "both client cert and client key") {
+		t.Fatalf("expected error with client cert without key, found %v", err)
+	}
+}
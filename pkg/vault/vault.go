@@ -17,10 +17,13 @@ limitations under the License.
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -40,6 +43,14 @@ const (
 
 	SysHealthURL = "/v1/sys/health"
 
+	LeaseRenewURL  = "/v1/sys/leases/renew"
+	LeaseRevokeURL = "/v1/sys/leases/revoke"
+
+	// Addresses with this scheme are paths to unix sockets, HTTP requests
+	// through these sockets are done using the fake host address
+	UnixSocketScheme      = "unix://"
+	UnixSocketHTTPAddress = "http://localhost"
+
 	AuthAppRoleURL  = "/v1/sys/auth/approle"
 	AppRoleLoginURL = "/v1/auth/approle/login"
 	AppRoleURL      = "/v1/auth/approle/role"
@@ -49,6 +60,9 @@ type RequestOptions struct {
 	WrapTTL string
 
 	Data map[string]interface{}
+
+	// Additional headers to send with the request
+	Headers map[string]string
 }
 
 type Vault interface {
@@ -56,6 +70,9 @@ type Vault interface {
 	Request(method, urlPath string, options *RequestOptions) (*api.Secret, *api.Response, error)
 	UnwrapSecretID(token string) error
 	GetToken() string
+	SetToken(token string)
+	Subscribe(ctx context.Context, eventType string) (<-chan Event, error)
+	Renew(leaseID string, increment int) (*api.Secret, error)
 }
 
 type Config struct {
@@ -63,6 +80,13 @@ type Config struct {
 	RoleID   string `json:"role_id,omitempty"`
 	SecretID string `json:"secret_id,omitempty"`
 	Token    string `json:"token,omitempty"`
+
+	TLSConfig
+
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+
+	// Login using SPIFFE workload identity
+	SPIFFE *SPIFFEAuthConfig `json:"spiffe,omitempty"`
 }
 
 type vaultApi struct {
@@ -70,18 +94,44 @@ type vaultApi struct {
 	RoleID   string
 	SecretID string
 	Token    string
+
+	tls    *tlsLoader
+	proxy  *ProxyConfig
+	spiffe *SPIFFEAuthConfig
 }
 
 func New(c Config) Vault {
-	return &vaultApi{
+	v := &vaultApi{
 		Address:  c.Address,
 		RoleID:   c.RoleID,
 		SecretID: c.SecretID,
 		Token:    c.Token,
+		proxy:    c.Proxy,
+		spiffe:   c.SPIFFE,
+	}
+	if c.TLSConfig.IsSet() {
+		v.tls = newTLSLoader(c.TLSConfig)
 	}
+	return v
 }
 
 func (v *vaultApi) getClient() (*api.Client, error) {
+	c, _, err := v.getClientWithConfig()
+	return c, err
+}
+
+func (v *vaultApi) getClientWithConfig() (*api.Client, *api.Config, error) {
+	config, err := v.newConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := api.NewClient(config)
+	return c, config, err
+}
+
+// newConfig returns the configuration for the API client, with a
+// transport not yet configured for HTTP/2
+func (v *vaultApi) newConfig() (*api.Config, error) {
 	config := api.DefaultConfig()
 	if err := config.ReadEnvironment(); err != nil {
 		return nil, fmt.Errorf("couldn't read config from environment: %v", err)
@@ -89,7 +139,40 @@ func (v *vaultApi) getClient() (*api.Client, error) {
 	if v.Address != "" {
 		config.Address = v.Address
 	}
-	return api.NewClient(config)
+	transport := config.HttpClient.Transport.(*http.Transport)
+	if v.tls != nil {
+		tlsConfig, err := v.tls.TLSClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		// Transport can modify its TLS config, give it its own copy
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	if socketPath, isUnix := unixSocketPath(config.Address); isUnix {
+		// Connections to unix sockets are always done directly
+		config.Address = UnixSocketHTTPAddress
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		}
+	} else {
+		proxy, err := v.proxy.Proxy()
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+	return config, nil
+}
+
+// unixSocketPath returns the path of the socket if the address is
+// of the form unix:///path/to/socket
+func unixSocketPath(address string) (string, bool) {
+	if !strings.HasPrefix(address, UnixSocketScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(address, UnixSocketScheme), true
 }
 
 // A token is considered invalid if we receive 400 status codes
@@ -193,6 +276,14 @@ func (v *vaultApi) Login() error {
 		go v.autoRenewToken()
 		return nil
 	}
+	if v.spiffe != nil {
+		err := v.spiffeLogin()
+		if err != nil {
+			return err
+		}
+		go v.autoRenewToken()
+		return nil
+	}
 	if v.RoleID == "" {
 		return fmt.Errorf("role ID needed")
 	}
@@ -237,8 +328,29 @@ func (v *vaultApi) UnwrapSecretID(token string) error {
 	return nil
 }
 
+// rawRequestWithHeaders does a request adding custom headers, the API
+// client doesn't provide a way to set them
+func rawRequestWithHeaders(config *api.Config, r *api.Request, headers map[string]string) (*api.Response, error) {
+	req, err := r.ToHTTP()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	httpResp, err := config.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := &api.Response{Response: httpResp}
+	if err := resp.Error(); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
 func (v *vaultApi) Request(method, urlPath string, options *RequestOptions) (*api.Secret, *api.Response, error) {
-	c, err := v.getClient()
+	c, config, err := v.getClientWithConfig()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -260,7 +372,12 @@ func (v *vaultApi) Request(method, urlPath string, options *RequestOptions) (*ap
 		}
 	}
 
-	resp, err := c.RawRequest(r)
+	var resp *api.Response
+	if options != nil && len(options.Headers) > 0 {
+		resp, err = rawRequestWithHeaders(config, r, options.Headers)
+	} else {
+		resp, err = c.RawRequest(r)
+	}
 	if err != nil {
 		return nil, resp, err
 	}
@@ -273,6 +390,29 @@ func (v *vaultApi) Request(method, urlPath string, options *RequestOptions) (*ap
 	return s, resp, err
 }
 
+// Renew extends the lease of a secret, increment is in seconds, if zero
+// the default increment is used
+func (v *vaultApi) Renew(leaseID string, increment int) (*api.Secret, error) {
+	data := map[string]interface{}{"lease_id": leaseID}
+	if increment > 0 {
+		data["increment"] = increment
+	}
+	s, _, err := v.Request(http.MethodPut, LeaseRenewURL, &RequestOptions{Data: data})
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("empty response when renewing lease")
+	}
+	return s, nil
+}
+
 func (v *vaultApi) GetToken() string {
 	return v.Token
 }
+
+// SetToken replaces the token used in requests, e.g. by a token obtained
+// by another instance
+func (v *vaultApi) SetToken(token string) {
+	v.Token = token
+}
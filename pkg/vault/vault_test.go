@@ -18,8 +18,11 @@ package vault
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	nethttp "net/http"
 	"net/http/httptest"
+	"os"
 	"path"
 	"testing"
 
@@ -179,6 +182,63 @@ func TestRequestWithData(t *testing.T) {
 	}
 }
 
+func TestUnixSocketPath(t *testing.T) {
+	cases := []struct {
+		address string
+		path    string
+		isUnix  bool
+	}{
+		{"unix:///run/vault/vault.sock", "/run/vault/vault.sock", true},
+		{"unix://vault.sock", "vault.sock", true},
+		{"http://127.0.0.1:8200", "", false},
+		{"https://vault.example.com", "", false},
+	}
+	for _, c := range cases {
+		path, isUnix := unixSocketPath(c.address)
+		if path != c.path || isUnix != c.isUnix {
+			t.Errorf("address %s: expected (%s, %v), found (%s, %v)", c.address, c.path, c.isUnix, path, isUnix)
+		}
+	}
+}
+
+func TestRequestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-vault-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "vault.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get(TokenHeader) != "token" {
+			w.WriteHeader(nethttp.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/foo" {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"foo": "bar"}}`))
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	v := New(Config{Address: UnixSocketScheme + socket, Token: "token"})
+	s, _, err := v.Request("GET", "/v1/secret/foo", nil)
+	if err != nil {
+		t.Fatalf("couldn't read secret through unix socket: %v", err)
+	}
+	if s == nil || s.Data["foo"] != "bar" {
+		t.Fatalf("unexpected secret: %+v", s)
+	}
+}
+
 func TestTokenRenovation(t *testing.T) {
 	core, _, token := test.NewTestCoreAppRole(t)
 	ln, address := http.TestServer(t, core)
@@ -0,0 +1,251 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package websocket implements a minimal websocket client, enough to
+// consume streams of messages, as described in RFC 6455.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+const (
+	ContinuationMessage = 0x0
+	TextMessage         = 0x1
+	BinaryMessage       = 0x2
+	CloseMessage        = 0x8
+	PingMessage         = 0x9
+	PongMessage         = 0xa
+
+	// Messages bigger than this are rejected
+	MaxMessageSize = 16 * 1024 * 1024
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// HandshakeError is returned when the server doesn't accept the upgrade
+type HandshakeError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket handshake failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+type Conn struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+
+	// Clients must mask the frames they send, servers must not
+	client bool
+
+	writeLock sync.Mutex
+}
+
+func newConn(rwc io.ReadWriteCloser, client bool) *Conn {
+	return &Conn{rwc: rwc, r: bufio.NewReader(rwc), client: client}
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	io.WriteString(h, key+acceptGUID)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Dial opens a websocket connection with an http or https URL. The client
+// must not use HTTP/2, as upgrades are not possible with it.
+func Dial(ctx context.Context, client *http.Client, url string, header http.Header) (*Conn, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &HandshakeError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upgraded connection is not writable")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		rwc.Close()
+		return nil, fmt.Errorf("incorrect accept key in websocket handshake")
+	}
+	return newConn(rwc, true), nil
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err = io.ReadFull(c.r, l[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err = io.ReadFull(c.r, l[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(l[:])
+	}
+	if length > MaxMessageSize {
+		err = fmt.Errorf("websocket frame too big: %d bytes", length)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// ReadMessage returns the next data message, control messages are
+// handled while reading. io.EOF is returned when the connection is
+// closed by the other side.
+func (c *Conn) ReadMessage() (opcode byte, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			c.WriteMessage(CloseMessage, payload)
+			return 0, nil, io.EOF
+		case ContinuationMessage:
+			if opcode == 0 {
+				return 0, nil, fmt.Errorf("unexpected continuation frame")
+			}
+		default:
+			if opcode != 0 {
+				return 0, nil, fmt.Errorf("unexpected data frame in fragmented message")
+			}
+			opcode = op
+		}
+		if len(data)+len(payload) > MaxMessageSize {
+			return 0, nil, fmt.Errorf("websocket message too big")
+		}
+		data = append(data, payload...)
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+// WriteMessage sends a message in a single frame
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	length := len(data)
+	switch {
+	case length < 126:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+
+	payload := data
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		payload = make([]byte, length)
+		for i := range data {
+			payload[i] = data[i] ^ mask[i%4]
+		}
+	}
+	_, err := c.rwc.Write(append(frame, payload...))
+	return err
+}
+
+// Close sends a close message and closes the connection
+func (c *Conn) Close() error {
+	c.WriteMessage(CloseMessage, []byte{0x03, 0xe8})
+	return c.rwc.Close()
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(t *testing.T, handler func(*Conn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+		c := newConn(conn, false)
+		defer c.Close()
+		handler(c)
+	}))
+}
+
+func TestReadMessages(t *testing.T) {
+	pong := make(chan string, 1)
+	server := testServer(t, func(c *Conn) {
+		c.WriteMessage(TextMessage, []byte("hello"))
+		c.WriteMessage(PingMessage, []byte("ping"))
+		_, _, payload, _ := c.readFrame()
+		pong <- string(payload)
+
+		// Fragmented message
+		c.rwc.Write([]byte{TextMessage, 3, 'f', 'o', 'o'})
+		c.rwc.Write([]byte{0x80 | ContinuationMessage, 3, 'b', 'a', 'r'})
+	})
+	defer server.Close()
+
+	c, err := Dial(context.Background(), server.Client(), server.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	opcode, data, err := c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(TextMessage), opcode)
+	assert.Equal(t, "hello", string(data))
+
+	_, data, err = c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "foobar", string(data))
+	assert.Equal(t, "ping", <-pong)
+
+	_, _, err = c.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWriteMessages(t *testing.T) {
+	received := make(chan string, 1)
+	server := testServer(t, func(c *Conn) {
+		_, data, err := c.ReadMessage()
+		if err == nil {
+			received <- string(data)
+		}
+	})
+	defer server.Close()
+
+	c, err := Dial(context.Background(), server.Client(), server.URL, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	long := make([]byte, 70000)
+	for i := range long {
+		long[i] = 'a'
+	}
+	assert.NoError(t, c.WriteMessage(TextMessage, long))
+	assert.Equal(t, string(long), <-received)
+}
+
+func TestHandshakeError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := Dial(context.Background(), server.Client(), server.URL, nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusNotFound, err.(*HandshakeError).StatusCode)
+	}
+}
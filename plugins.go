@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tuenti/pouch/pkg/plugin"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func (p *pouch) AddPlugin(pl *plugin.Plugin) {
+	if p.plugins == nil {
+		p.plugins = make(map[string]*plugin.Plugin)
+	}
+	p.plugins[pl.Name] = pl
+}
+
+func (p *pouch) plugin(name string) (*plugin.Plugin, error) {
+	pl, found := p.plugins[name]
+	if !found {
+		return nil, fmt.Errorf("unknown plugin: %s", name)
+	}
+	return pl, nil
+}
+
+// pluginSecret reads a secret using a secret backend plugin
+func (p *pouch) pluginSecret(c SecretConfig, data map[string]interface{}) (*api.Secret, error) {
+	pl, err := p.plugin(c.Plugin)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := pl.SecretBackend()
+	if err != nil {
+		return nil, err
+	}
+	s, err := backend.Read(&plugin.SecretRequest{
+		Method:  c.HTTPMethod,
+		Path:    c.VaultURL,
+		Data:    data,
+		Headers: c.Headers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &api.Secret{
+		Data:          s.Data,
+		LeaseID:       s.LeaseID,
+		LeaseDuration: s.LeaseDuration,
+		Renewable:     s.Renewable,
+	}, nil
+}
+
+// pluginOutput delivers the content of a file using an output plugin
+func (p *pouch) pluginOutput(fc FileConfig, mode uint32, content string) error {
+	pl, err := p.plugin(fc.Plugin)
+	if err != nil {
+		return err
+	}
+	output, err := pl.Output()
+	if err != nil {
+		return err
+	}
+	return output.Write(&plugin.OutputRequest{
+		Path:    fc.Path,
+		Mode:    mode,
+		Content: []byte(content),
+	})
+}
+
+type PluginNotifier struct {
+	Notifier plugin.Notifier
+
+	Name  string
+	Files []string
+}
+
+func (n *PluginNotifier) Run(ctx context.Context) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := n.Notifier.Notify(&plugin.NotifyRequest{Name: n.Name, Files: n.Files})
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
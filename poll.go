@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/vault"
+)
+
+// MetadataPath returns the path where the metadata of a KV version 2
+// secret can be read
+func (c SecretConfig) MetadataPath() string {
+	if c.MetadataURL != "" {
+		return c.MetadataURL
+	}
+	return strings.Replace(c.VaultURL, "/data/", "/metadata/", 1)
+}
+
+// PollPeriod returns the period to check the version of the secret, zero
+// if it is not polled
+func (c SecretConfig) PollPeriod() (time.Duration, error) {
+	if c.PollInterval == "" {
+		return 0, nil
+	}
+	if c.Provider() != VaultProvider {
+		return 0, fmt.Errorf("only secrets from Vault can be polled")
+	}
+	d, err := time.ParseDuration(c.PollInterval)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("poll interval must be positive")
+	}
+	return d, nil
+}
+
+// schedulePoll sets when the version of a secret has to be checked, it
+// is not checked anymore if it is not polled
+func (p *pouch) schedulePoll(name string) error {
+	period, err := p.Secrets[name].PollPeriod()
+	if err != nil {
+		delete(p.polls, name)
+		return fmt.Errorf("incorrect poll interval for secret '%s': %v", name, err)
+	}
+	if period == 0 {
+		delete(p.polls, name)
+		return nil
+	}
+	if p.polls == nil {
+		p.polls = make(map[string]time.Time)
+	}
+	p.polls[name] = time.Now().Add(period)
+	return nil
+}
+
+// nextPoll returns the secret whose version has to be checked first
+func (p *pouch) nextPoll() (name string, next time.Time) {
+	for n, t := range p.polls {
+		if name == "" || t.Before(next) {
+			name = n
+			next = t
+		}
+	}
+	return
+}
+
+// pollSecret checks if the current version of a secret is different
+// to the one in the state
+func (p *pouch) pollSecret(name string) (changed bool, err error) {
+	c := p.Secrets[name]
+	options := &vault.RequestOptions{Headers: c.Headers}
+	s, _, err := p.Vault.Request(http.MethodGet, c.MetadataPath(), options)
+	if err != nil {
+		return false, err
+	}
+	if s == nil || s.Data == nil {
+		return false, fmt.Errorf("no metadata found in %s", c.MetadataPath())
+	}
+	version, ok := toInt(s.Data["current_version"])
+	if !ok {
+		return false, fmt.Errorf("no version found in metadata of %s", c.MetadataPath())
+	}
+	state, found := p.State.Secrets[name]
+	if !found {
+		return true, nil
+	}
+	return state.Version != version, nil
+}
@@ -20,14 +20,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
+	"github.com/tuenti/pouch/pkg/metrics"
+	"github.com/tuenti/pouch/pkg/plugin"
+	"github.com/tuenti/pouch/pkg/remote"
+	"github.com/tuenti/pouch/pkg/tracing"
 	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
 )
 
 const (
@@ -36,20 +46,72 @@ const (
 )
 
 type Pouch interface {
+	Login() error
+	TakeOver() error
 	Run(context.Context) error
-	Watch(path string) error
+	RunOnce(context.Context) error
+	DryRun(w io.Writer) error
+	Watch(ctx context.Context, path string) error
+	WatchListener(ctx context.Context, c WrappedSecretIDListenerConfig) error
 	AddStatusNotifier(StatusNotifier)
 	ServiceReloader(Reloader)
+	AddServiceReloader(name string, r Reloader)
+	MetricsTextfile(path string)
+	MetricsStatsd(c StatsdConfig) error
+	Tracing(c TracingConfig) error
+	StatusListener(c StatusConfig)
+	ReplicateState(c ReplicationConfig) error
+	ProvenanceFile(path string)
+	AddPlugin(*plugin.Plugin)
+	AddHost(*remote.Host)
+	VaultEvents(eventType string)
+	AddTemplateFunction(name string, f interface{})
+	AddTemplate(name, source string)
+	AddSecretProvider(name string, provider SecretProvider)
+	AddExpectation(name string, c ExpectationConfig)
+	AddAlert(name string, c AlertConfig) error
+	AuditLog(c AuditConfig) error
+	GRPC(c GRPCConfig) error
+	SecretsServer(c SecretsServerConfig) error
+	FUSE(c FUSEConfig) error
+	ControlSocket(c ControlConfig) error
+	ConfigPath(path string)
+	Exec(c ExecConfig)
+	ShredOnExit()
+	OrphanedFiles(mode string) error
+	Render(path string) (string, error)
 }
 
 type StatusNotifier interface {
 	NotifyReady() error
+	NotifyDegraded(reason string) error
+	NotifyNotReady(reason string) error
 }
 
 type Reloader interface {
 	Reload(context.Context, string) error
 }
 
+// UnitManager is a service manager that can restart services and apply
+// changes in their definitions
+type UnitManager interface {
+	Reloader
+
+	Restart(context.Context, string) error
+	DaemonReload() error
+}
+
+// UnitActioner is a service manager that can run specific actions on
+// services, as reload, restart or try-restart
+type UnitActioner interface {
+	Action(ctx context.Context, name, action string) error
+}
+
+// UnitSignaler is a service manager that can send signals to services
+type UnitSignaler interface {
+	Kill(name string, signal syscall.Signal) error
+}
+
 type pouch struct {
 	State *PouchState
 
@@ -57,45 +119,175 @@ type pouch struct {
 	Secrets   map[string]SecretConfig
 	Files     map[string]FileConfig
 	Notifiers map[string]NotifierConfig
+
+	// Default reloader of services, and reloaders that can be selected
+	// by notifiers, by name
 	Reloader  Reloader
+	Reloaders map[string]Reloader
+
+	Metrics             *metrics.Registry
+	MetricsTextfilePath string
+
+	statsd         *metrics.StatsdPusher
+	statsdInterval time.Duration
+
+	// Spans of the operations, exported to OpenTelemetry, and the span
+	// of the operation in progress
+	tracer          *tracing.Tracer
+	tracingInterval time.Duration
+	span            *tracing.Span
+
+	Events *EventLog
 
 	statusNotifiers  []StatusNotifier
-	pendingNotifiers map[string]bool
+	// Pending notifiers, with the files that triggered them and when
+	// they were triggered first
+	pendingNotifiers map[NotifyConfig][]string
+	pendingSince     map[NotifyConfig]time.Time
+
+	// Checksums of the files that triggered notifiers when they were
+	// last run
+	notifiedChecksums map[NotifyConfig]map[string]string
+
+	statusLock    sync.Mutex
+	status        Status
+	statusMessage string
+	statusServer  *StatusServer
+	dashboard     *dashboard
+
+	// Named pipes served, by path
+	fifos map[string]*fifoWriter
+
+	// Files written for each key of per-key files, by directory
+	keyFiles map[string]map[string]bool
+
+	// Provenance of the files written, and path where it is recorded
+	provenance     map[string]*FileProvenance
+	provenancePath string
+
+	loggedIn bool
+
+	// Serializes unwrapping of secret IDs received in different channels
+	unwrapLock        sync.Mutex
+	secretIDUnwrapped bool
+
+	// Glob secrets, as they were configured before expanding them
+	secretGlobs map[string]SecretConfig
+
+	plugins map[string]*plugin.Plugin
+
+	// Sends the state to standby instances in other hosts
+	replicator *stateReplicator
+
+	// Conditions checked after each cycle, and the reasons of the
+	// ones currently failing
+	expectations        map[string]ExpectationConfig
+	expectationFailures map[string]string
+
+	// Alerts about problems rotating secrets, consecutive failures to read
+	// each secret and the last one, and versions of secrets reported as
+	// close to expire
+	alerts         map[string]AlertConfig
+	secretFailures map[string]int
+	secretErrors   map[string]secretError
+	leasesExpiring map[string]time.Time
+
+	// Append-only record of secrets read, files written and notifications
+	auditLog *auditLog
+
+	// Socket where commands are received, the commands waiting to be
+	// run in the main loop, and the Pouchfile reloaded on request
+	controlSocket     string
+	controlSocketMode os.FileMode
+	controlRequests   chan controlRequest
+	configPath        string
+
+	// Socket where the gRPC API is served, and subscriptions to events
+	grpcSocket     string
+	grpcSocketMode os.FileMode
+	subscribers    subscribers
+
+	// Socket where secrets are served to local applications
+	secretsServerSocket     string
+	secretsServerSocketMode os.FileMode
+	secretsServerTimeout    time.Duration
+
+	// FUSE filesystem where secrets are exposed
+	fuseConfig *FUSEConfig
+	fuseUID    uint32
+	fuseGID    uint32
+
+	// Remote hosts where files can be pushed
+	hosts map[string]*remote.Host
+
+	// Type of Vault events to subscribe to, subscription is disabled
+	// if empty
+	vaultEventType string
+
+	// Paths of secrets to be read again
+	refresh chan string
+
+	// Names of secrets changed in providers watching them
+	changed chan string
+
+	// Paths of files modified or removed externally
+	tampered chan string
+
+	// Context where watchers of secrets and files are started, and
+	// function to stop them, so they are restarted on reloads
+	watchParent context.Context
+	cancelWatch context.CancelFunc
+
+	// Next time to check the version of polled secrets
+	polls map[string]time.Time
+
+	// Additional functions for file templates
+	templateFuncs template.FuncMap
+
+	// Partial templates that file templates can include
+	partials map[string]string
+
+	// Secret providers other than Vault
+	providers map[string]SecretProvider
+
+	// Command run with secrets in its environment, in exec mode
+	exec *execProcess
+
+	// If files and state are overwritten and removed when stopping
+	shredOnExit bool
+
+	// Run once and exit, any failure is an error
+	once bool
+
+	// Only show the changes in files, nothing is written
+	dryRun bool
+
+	// If orphaned files are only reported instead of removed
+	reportOrphans bool
+
+	// If existing files not recorded in the state can be overwritten,
+	// for states of versions that didn't record them
+	adoptFiles bool
 }
 
-func getFileContent(fc FileConfig, data interface{}, secretFunc interface{}) (string, error) {
-	if fc.Template != "" && fc.TemplateFile != "" {
-		return "", fmt.Errorf("inline template and template file specified")
-	}
-	var t *template.Template
-	funcMap := template.FuncMap{
-		"secret": secretFunc,
+func getFileContent(fc FileConfig, ctx *RenderContext) (string, error) {
+	engine, err := getTemplateEngine(fc.Engine)
+	if err != nil {
+		return "", err
 	}
-	var err error
+	name, source, err := templateSource(fc)
 	switch {
-	case fc.Template != "":
-		t, err = template.New("inline-template").Funcs(funcMap).Parse(fc.Template)
-		if err != nil {
-			return "", err
-		}
-	case fc.TemplateFile != "":
-		d, err := ioutil.ReadFile(fc.TemplateFile)
-		if err != nil {
-			return "", err
-		}
-		t, err = template.New(fc.TemplateFile).Funcs(funcMap).Parse(string(d))
-		if err != nil {
-			return "", err
-		}
+	case err == nil:
+	case fc.Template == "" && fc.TemplateFile == "" && templateOptional(engine):
+		name = fc.Path
 	default:
-		return "", fmt.Errorf("no content defined for file %s", fc.Path)
+		return "", err
 	}
-	var b bytes.Buffer
-	err = t.Execute(&b, data)
+	content, err := engine.Render(name, source, ctx)
 	if err != nil {
 		return "", err
 	}
-	return b.String(), nil
+	return decodeContent(fc.Encoding, content)
 }
 
 func dirMode(mode os.FileMode) os.FileMode {
@@ -110,8 +302,28 @@ func dirMode(mode os.FileMode) os.FileMode {
 }
 
 var dataFuncMap = template.FuncMap{
-	"env":      os.Getenv,
-	"hostname": os.Hostname,
+	"env":          os.Getenv,
+	"hostname":     os.Hostname,
+	"fqdn":         fqdn,
+	"file":         readFile,
+	"uuid":         uuid,
+	"randomString": randomString,
+	"now":          time.Now,
+	"timestamp":    formatNow,
+	"unixTime":     func() int64 { return time.Now().Unix() },
+	"ipAddress":    ipAddress,
+	"ipAddresses":  ipAddresses,
+	"lookupIP":     lookupIP,
+}
+
+// readFile reads the content of a file, to be used in data templates,
+// e.g. to send a CSR to be signed
+func readFile(path string) (string, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
 }
 
 func resolveData(data map[string]interface{}) map[string]interface{} {
@@ -122,7 +334,7 @@ func resolveData(data map[string]interface{}) map[string]interface{} {
 			if !ok {
 				return d, nil
 			}
-			t, err := template.New("secret-data").Funcs(dataFuncMap).Parse(v)
+			t, err := template.New("secret-data").Funcs(sprigFuncMap).Funcs(dataFuncMap).Parse(v)
 			if err != nil {
 				return d, err
 			}
@@ -134,7 +346,7 @@ func resolveData(data map[string]interface{}) map[string]interface{} {
 			return b.String(), nil
 		}()
 		if err != nil {
-			log.Printf("When resolving data template '%s' for '%s': %v", d, k, err)
+			warnf("When resolving data template '%s' for '%s': %v", d, k, err)
 		}
 		result[k] = resolved
 	}
@@ -142,9 +354,36 @@ func resolveData(data map[string]interface{}) map[string]interface{} {
 }
 
 func (p *pouch) resolveSecret(name string, c SecretConfig) (retry bool, err error) {
-	options := &vault.RequestOptions{Data: resolveData(c.Data)}
-	s, resp, err := p.Vault.Request(c.HTTPMethod, c.VaultURL, options)
+	if c.Plugin != "" {
+		endSpan := p.startSpan("plugin.secret", tracing.SpanKindClient, tracing.Attributes{"secret": name, "plugin": c.Plugin})
+		s, err := p.pluginSecret(c, resolveData(c.Data))
+		endSpan(err)
+		if err != nil {
+			// Errors from plugins are unknown, so keep trying
+			p.Metrics.Add(MetricSecretUpdateErrors, metrics.Labels{"secret": name}, 1)
+			return true, err
+		}
+		p.updateSecret(name, s)
+		return false, nil
+	}
+
+	provider, path, err := p.provider(c)
+	if err != nil {
+		return false, err
+	}
+	options := &vault.RequestOptions{Data: resolveData(c.Data), Headers: c.Headers}
+	endSpan := p.startSpan("vault.request", tracing.SpanKindClient, tracing.Attributes{
+		"secret":      name,
+		"http.method": c.HTTPMethod,
+		"vault.path":  path,
+	})
+	s, resp, err := provider.Request(c.HTTPMethod, path, options)
+	if resp != nil {
+		p.span.SetAttribute("http.status_code", resp.StatusCode)
+	}
+	endSpan(err)
 	if err != nil {
+		p.Metrics.Add(MetricSecretUpdateErrors, metrics.Labels{"secret": name}, 1)
 		switch {
 		case resp == nil:
 			// Retry if there was a connection error and no response
@@ -160,83 +399,429 @@ func (p *pouch) resolveSecret(name string, c SecretConfig) (retry bool, err erro
 			return false, err
 		}
 	}
+	p.updateSecret(name, s)
+	return false, nil
+}
+
+// updateSecret stores a secret in the state, recording what changed
+func (p *pouch) updateSecret(name string, s *api.Secret) {
+	var oldData SecretData
+	old, known := p.State.Secrets[name]
+	if known {
+		oldData = old.Data
+	}
 	p.State.SetSecret(name, s)
-	err = p.State.Save()
+	diff := diffSecretData(name, oldData, p.State.Secrets[name].Data)
+	e := Event{
+		Type:    EventSecretUpdated,
+		Secret:  name,
+		Message: fmt.Sprintf("Secret '%s' read", name),
+		Details: map[string]interface{}{"changes": diff},
+	}
+	if known {
+		e.Message = fmt.Sprintf("Secret '%s' updated, %s", name, diff)
+	}
+	p.event(e)
+	if known && diff.Changed() {
+		p.recordRotation(name)
+	}
+
+	err := p.saveState()
 	if err != nil {
-		log.Printf("Couldn't save state: %s", err)
+		errorf("Couldn't save state: %s", err)
 	}
-	return false, nil
 }
 
-func (p *pouch) resolveFile(fc FileConfig) error {
+// FileMode returns the mode of the file, with the permissions added by
+// the read shortcuts
+func (fc FileConfig) FileMode() os.FileMode {
 	mode := os.FileMode(fc.Mode)
 	if mode == 0 {
 		mode = DefaultFileMode
 	}
-	dir := path.Dir(fc.Path)
-	err := os.MkdirAll(dir, dirMode(mode))
+	if fc.GroupReadable {
+		mode |= 0040
+	}
+	if fc.WorldReadable {
+		mode |= 0004
+	}
+	return mode
+}
+
+// parentDirMode returns the mode for the parent directories of a file,
+// zero if they must not be created
+func (fc FileConfig) parentDirMode(mode os.FileMode) os.FileMode {
+	switch {
+	case fc.CreateDirs != nil && !*fc.CreateDirs:
+		return 0
+	case fc.DirMode != 0:
+		return os.FileMode(fc.DirMode)
+	}
+	return dirMode(mode)
+}
+
+// parentDir ensures that the parent directory of a file exists, creating
+// it with parentMode, if parentMode is zero it must exist
+func parentDir(filePath string, parentMode os.FileMode) error {
+	dir := path.Dir(filePath)
+	if parentMode != 0 {
+		return os.MkdirAll(dir, parentMode)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("directory of '%s' not available and it is not created: %v", filePath, err)
+	}
+	return nil
+}
+
+// writeFile writes the content of a file, creating its parent directories
+// with parentMode, if parentMode is zero they must exist
+func writeFile(filePath string, mode, parentMode os.FileMode, content string) error {
+	err := parentDir(filePath, parentMode)
 	if err != nil {
 		return err
 	}
 
-	secretFunc := func(name, key string) (interface{}, error) {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, mode)
+	if err != nil {
+		return fmt.Errorf("couldn't open %s file to be written: %s", filePath, err)
+	}
+	defer file.Close()
+
+	_, err = file.Write([]byte(content))
+	if err != nil {
+		return fmt.Errorf("couldn't write secret in '%s': %s", filePath, err)
+	}
+
+	// Ensure file contents have been committed to disk
+	err = file.Sync()
+	if err != nil {
+		return fmt.Errorf("not able to commit the file '%s' to disk: %s", filePath, err)
+	}
+	return nil
+}
+
+// contentUnchanged checks if a file already has the rendered content, local
+// files are compared with their content on disk, files delivered elsewhere
+// with the checksum of their last delivery
+func (p *pouch) contentUnchanged(fc FileConfig, content string) bool {
+	if fc.Plugin != "" || len(fc.Hosts) > 0 || fc.Served {
+		checksum, found := p.State.FileChecksums[fc.Path]
+		return found && checksum == contentChecksum(content)
+	}
+	if fc.FIFO {
+		// Reading from the pipe would block
+		f, found := p.fifos[fc.Path]
+		return found && f.get() == content
+	}
+	current, err := ioutil.ReadFile(fc.Path)
+	return err == nil && string(current) == content
+}
+
+// fileFuncMaps contains the functions available in file templates, besides
+// the ones to read secrets and the configured template functions
+var fileFuncMaps = []template.FuncMap{sprigFuncMap, cryptFuncMap, keystoreFuncMap, pemFuncMap}
+
+// renderContext returns the context to render a file, secrets used are
+// registered in used
+func (p *pouch) renderContext(fc FileConfig, used map[string]bool) *RenderContext {
+	secretData := func(name string) (SecretData, error) {
 		secret, found := p.State.Secrets[name]
 		if !found {
 			return nil, fmt.Errorf("unknown secret: %s", name)
 		}
-		value, found := secret.Data[key]
+		secret.RegisterUsage(fc.Path, fc.Priority)
+		used[name] = true
+		return secret.Data, nil
+	}
+	secretFunc := func(name, key string) (interface{}, error) {
+		data, err := secretData(name)
+		if err != nil {
+			return nil, err
+		}
+		value, found := data[key]
 		if !found {
 			return nil, fmt.Errorf("unkown key in secret '%s': %s", name, key)
 		}
-		secret.RegisterUsage(fc.Path, fc.Priority)
 		return value, nil
 	}
 
-	content, err := getFileContent(fc, nil, secretFunc)
+	funcs := template.FuncMap{}
+	for _, funcMap := range fileFuncMaps {
+		for name, f := range funcMap {
+			funcs[name] = f
+		}
+	}
+	for name, f := range p.templateFuncs {
+		funcs[name] = f
+	}
+	funcs["secret"] = secretFunc
+	funcs["secretMeta"] = func(name, field string) (interface{}, error) {
+		_, err := secretData(name)
+		if err != nil {
+			return nil, err
+		}
+		value, found := p.State.Secrets[name].Metadata()[field]
+		if !found {
+			return nil, fmt.Errorf("unknown metadata of secret '%s': %s", name, field)
+		}
+		return value, nil
+	}
+	funcs["secretOrDefault"] = func(name, key string, d interface{}) interface{} {
+		data, err := secretData(name)
+		if err != nil {
+			return d
+		}
+		value, found := data[key]
+		if !found {
+			return d
+		}
+		return value
+	}
+	ctx := &RenderContext{
+		Funcs:    funcs,
+		Partials: p.partials,
+		Secret:   secretData,
+		Secrets:  fc.Secrets,
+		URLs:     make(map[string]string),
+		Strict:   fc.Strict,
+	}
+	for name, c := range p.Secrets {
+		ctx.URLs[name] = c.VaultURL
+	}
+	if fc.forEach != nil {
+		ctx.Data = *fc.forEach
+	}
+	if len(ctx.Secrets) == 0 {
+		for name := range p.State.Secrets {
+			ctx.Secrets = append(ctx.Secrets, name)
+		}
+		sort.Strings(ctx.Secrets)
+	}
+	return ctx
+}
+
+func (p *pouch) resolveFile(fc FileConfig) (err error) {
+	endSpan := p.startSpan("file.update", tracing.SpanKindInternal, tracing.Attributes{"file": fc.Path})
+	defer func() { endSpan(err) }()
+
+	used := make(map[string]bool)
+	ctx := p.renderContext(fc, used)
+	if fc.PerKey {
+		return p.resolveKeysDirectory(fc, ctx, used)
+	}
+	endRender := p.startSpan("file.render", tracing.SpanKindInternal, tracing.Attributes{"file": fc.Path})
+	content, err := getFileContent(fc, ctx)
+	endRender(err)
 	if err != nil {
 		return err
 	}
+	endWrite := p.startSpan("file.write", tracing.SpanKindInternal, tracing.Attributes{"file": fc.Path, "bytes": len(content)})
+	err = p.deliverFile(fc, content, used)
+	endWrite(err)
+	return err
+}
 
-	file, err := os.OpenFile(fc.Path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, mode)
+// deliverFile writes the rendered content of a file, or delivers it to
+// its destination, used are the secrets used to render it
+func (p *pouch) deliverFile(fc FileConfig, content string, used map[string]bool) error {
+	mode := fc.FileMode()
+	if fc.Plugin != "" && len(fc.Hosts) > 0 {
+		return fmt.Errorf("file '%s' cannot be delivered both with a plugin and to hosts", fc.Path)
+	}
+	if fc.FIFO && (fc.Plugin != "" || len(fc.Hosts) > 0) {
+		return fmt.Errorf("named pipe '%s' can only be served locally", fc.Path)
+	}
+	if fc.Served && (fc.FIFO || fc.Plugin != "" || len(fc.Hosts) > 0) {
+		return fmt.Errorf("file '%s' served through the gRPC API cannot be delivered otherwise", fc.Path)
+	}
+	if fc.CheckCmd != "" && (fc.FIFO || fc.Served || fc.Plugin != "" || len(fc.Hosts) > 0) {
+		return fmt.Errorf("file '%s' can only be checked if it is written locally", fc.Path)
+	}
+	err := validateContent(fc, content)
 	if err != nil {
-		return fmt.Errorf("couldn't open %s file to be written: %s", fc.Path, err)
+		return fmt.Errorf("rendered content of '%s' is not valid: %v", fc.Path, err)
+	}
+	if p.contentUnchanged(fc, content) {
+		// Avoid notifying services when renewals produce the same content
+		infof("Content of '%s' didn't change, not written", fc.Path)
+		p.Metrics.Add(MetricFileWritesSkipped, metrics.Labels{"file": fc.Path}, 1)
+		if fc.Plugin == "" && len(fc.Hosts) == 0 && !fc.Served {
+			// Files with the expected content can be adopted
+			p.State.AddManagedFile(fc.Path)
+			p.State.SetFileChecksum(fc.Path, content)
+			return chownFile(fc)
+		}
+		return nil
 	}
-	defer file.Close()
 
-	bytesWritten, err := file.Write([]byte(content))
-	if err != nil {
-		return fmt.Errorf("couldn't write secret in '%s': %s", fc.Path, err)
+	if fc.Plugin == "" && len(fc.Hosts) == 0 && !fc.Served {
+		err = p.checkUnmanaged(fc)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case fc.Plugin != "":
+		err = p.pluginOutput(fc, uint32(mode), content)
+		if err != nil {
+			return fmt.Errorf("couldn't deliver '%s' with plugin '%s': %v", fc.Path, fc.Plugin, err)
+		}
+		p.State.SetFileChecksum(fc.Path, content)
+	case len(fc.Hosts) > 0:
+		err = p.pushFile(fc, mode, content)
+		if err != nil {
+			return err
+		}
+		p.State.SetFileChecksum(fc.Path, content)
+	case fc.Served:
+		// Never written, only served when requested
+		p.State.SetFileChecksum(fc.Path, content)
+	case fc.FIFO:
+		err = p.serveFIFO(fc, mode, content)
+		if err != nil {
+			return err
+		}
+		p.State.AddManagedFile(fc.Path)
+		err = chownFile(fc)
+		if err != nil {
+			return err
+		}
+	default:
+		checkedPath := ""
+		if fc.CheckCmd != "" {
+			checkedPath, err = p.checkFile(fc, mode, content)
+			if err != nil {
+				return err
+			}
+		}
+		if fc.BeforeWrite != nil {
+			err = runHook(fc.BeforeWrite, fc)
+			if err != nil {
+				os.Remove(checkedPath)
+				return fmt.Errorf("hook before writing '%s' failed, file not written: %v", fc.Path, err)
+			}
+		}
+		err = backupFile(fc.Path, fc.Backups)
+		if err != nil {
+			os.Remove(checkedPath)
+			return fmt.Errorf("couldn't backup '%s': %v", fc.Path, err)
+		}
+		if checkedPath != "" {
+			err = os.Rename(checkedPath, fc.Path)
+			if err != nil {
+				os.Remove(checkedPath)
+				return fmt.Errorf("couldn't replace '%s': %v", fc.Path, err)
+			}
+		} else {
+			err = writeFile(fc.Path, mode, fc.parentDirMode(mode), content)
+			if err != nil {
+				return err
+			}
+		}
+		p.State.AddManagedFile(fc.Path)
+		p.State.SetFileChecksum(fc.Path, content)
+		err = chownFile(fc)
+		if err != nil {
+			return err
+		}
+		if fc.AfterWrite != nil {
+			// The file is already written, failures are only logged
+			err = runHook(fc.AfterWrite, fc)
+			if err != nil {
+				warnf("Hook after writing '%s' failed: %v", fc.Path, err)
+			}
+		}
 	}
 
-	// Ensure file contents have been committed to disk
-	err = file.Sync()
-	if err != nil {
-		return fmt.Errorf("not able to commit the file '%s' to disk: %s", fc.Path, err)
+	usedNames := sortedUsedSecrets(used)
+	message := fmt.Sprintf("Written %d bytes into %s", len(content), fc.Path)
+	if fc.Served {
+		message = fmt.Sprintf("Rendered %d bytes of %s, served through the gRPC API", len(content), fc.Path)
 	}
+	p.event(Event{
+		Type:    EventFileWritten,
+		File:    fc.Path,
+		Message: message,
+		Details: map[string]interface{}{
+			"sha256":  contentChecksum(content),
+			"secrets": usedNames,
+		},
+	})
+	p.Metrics.Add(MetricFileWrites, metrics.Labels{"file": fc.Path}, 1)
+	p.recordProvenance(fc.Path, content, usedNames)
 
-	log.Printf("Written %d bytes into %s", bytesWritten, fc.Path)
+	p.addForNotify(fc.Path, fc.Notify...)
+	return nil
+}
+
+// sortedUsedSecrets returns the names of the secrets used by a file
+func sortedUsedSecrets(used map[string]bool) []string {
+	var names []string
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateSecretAndFiles reads a secret, retrying while possible, and
+// updates the files using it
+func (p *pouch) updateSecretAndFiles(name string) (err error) {
+	endSpan := p.startSpan("secret.update", tracing.SpanKindInternal, tracing.Attributes{"secret": name})
+	defer func() { endSpan(err) }()
 
-	p.addForNotify(fc.Notify...)
+	if p.Secrets[name].RenewLease && p.renewSecret(name) {
+		return nil
+	}
+
+	for retry := true; retry; {
+		retry, err = p.resolveSecret(name, p.Secrets[name])
+		if err != nil {
+			p.secretUpdateFailed(name, err)
+			if retry {
+				p.updateStatus()
+				p.checkLeases(time.Now())
+				<-time.After(SecretRetryPeriod)
+			} else {
+				return err
+			}
+		}
+	}
+	delete(p.secretFailures, name)
+	for _, f := range p.State.Secrets[name].FilesUsing {
+		debugf("Updating file '%s'", f.Path)
+		err = p.resolveFile(p.Files[f.Path])
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (p *pouch) Run(ctx context.Context) error {
-	err := p.Vault.Login()
+// loadSecretsAndFiles reads the configured secrets that are not in the
+// state and writes the configured files, cleaning files and secrets that
+// are not configured anymore
+func (p *pouch) loadSecretsAndFiles() error {
+	err := p.expandSecrets()
 	if err != nil {
 		return err
 	}
-	p.State.Token = p.Vault.GetToken()
-	err = p.State.Save()
+
+	err = p.discoverSecrets()
 	if err != nil {
-		log.Printf("Couldn't save state: %s", err)
+		return err
 	}
 
 	for name, c := range p.Secrets {
-		if s, found := p.State.Secrets[name]; found {
+		s, found := p.State.Secrets[name]
+		if found {
 			// Clean files using this secret, we'll process templates in case
 			// someone has changed
 			s.FilesUsing = nil
-		} else {
+		}
+		// In one-shot mode secrets are always read, as they won't be
+		// updated later
+		if !found || p.once {
 			_, err = p.resolveSecret(name, c)
 			if err != nil {
 				return err
@@ -250,95 +835,430 @@ func (p *pouch) Run(ctx context.Context) error {
 		}
 	}
 
+	err = p.expandFiles()
+	if err != nil {
+		return err
+	}
+
 	for _, fc := range p.Files {
 		err := p.resolveFile(fc)
 		if err != nil {
 			return err
 		}
 	}
+	p.cleanOrphanedFiles()
+
+	for name := range p.Secrets {
+		err := p.schedulePoll(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverPanic converts a panic into an error, panic values and stacks
+// could contain secret values so they are only logged redacted
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		errorf("Panic: %v\n%s", r, debug.Stack())
+		*err = fmt.Errorf("panic: %s", redactSecrets(fmt.Sprint(r)))
+	}
+}
+
+// login logs in and keeps the token in the state
+func (p *pouch) login() error {
+	err := p.Login()
+	if err != nil {
+		return err
+	}
+	p.State.Token = p.Vault.GetToken()
+	secretRedactor.AddValues(p.State.Token)
+	err = p.saveState()
+	if err != nil {
+		errorf("Couldn't save state: %s", err)
+	}
+
+	// States with secrets but without managed files were written by
+	// versions that didn't record them
+	p.adoptFiles = len(p.State.Secrets) > 0 && p.State.ManagedFiles == nil
+	return nil
+}
+
+func (p *pouch) Run(ctx context.Context) (err error) {
+	defer recoverPanic(&err)
+	defer p.stopFIFOs()
+
+	// Commands can be received from the status server
+	p.controlRequests = make(chan controlRequest)
+	p.startStatusServer()
+
+	err = p.login()
+	if err != nil {
+		return err
+	}
+
+	err = p.loadSecretsAndFiles()
+	if err != nil {
+		return err
+	}
+
+	if p.exec != nil {
+		err = p.startExec()
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.vaultEventType != "" {
+		subscriptionCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		p.refresh = make(chan string)
+		go p.subscribeVaultEvents(subscriptionCtx)
+	}
+
+	replicationCtx, cancelReplication := context.WithCancel(ctx)
+	defer cancelReplication()
+	p.startReplication(replicationCtx)
+
+	statsdCtx, cancelStatsd := context.WithCancel(ctx)
+	defer cancelStatsd()
+	p.startStatsd(statsdCtx)
+
+	tracingCtx, cancelTracing := context.WithCancel(ctx)
+	defer cancelTracing()
+	p.startTracing(tracingCtx)
+
+	controlCtx, cancelControl := context.WithCancel(ctx)
+	defer cancelControl()
+	err = p.startControlSocket(controlCtx)
+	if err != nil {
+		return err
+	}
+	err = p.startGRPC(controlCtx)
+	if err != nil {
+		return err
+	}
+	err = p.startSecretsServer(controlCtx)
+	if err != nil {
+		return err
+	}
+	err = p.startFUSE(controlCtx)
+	if err != nil {
+		return err
+	}
 
-	p.NotifyReady()
+	p.changed = make(chan string)
+	p.tampered = make(chan string)
+	err = p.startWatchers(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.cancelWatch()
 
 	for {
-		p.notifyPending()
+		p.updateStatus()
+		err = p.notifyPending()
+		if err != nil {
+			p.stopExec()
+			return err
+		}
+		err = p.updateExec()
+		if err != nil {
+			p.stopExec()
+			return err
+		}
+		if p.checkExpectations() {
+			p.updateStatus()
+		}
+		p.checkLeases(time.Now())
 
-		err = p.State.Save()
+		err = p.saveState()
 		if err != nil {
-			log.Printf("Couldn't save state: %s", err)
+			errorf("Couldn't save state: %s", err)
 		}
 
+		p.updateMetrics()
+		p.updateDashboard()
+		p.writeProvenance()
+
 		var nextUpdate <-chan time.Time
 		s, ttu := p.State.NextUpdate()
 		if s != nil {
 			nextUpdate = time.After(time.Until(ttu))
 		} else {
-			log.Printf("No secret to update")
+			debugf("No secret to update")
+		}
+
+		var nextPoll <-chan time.Time
+		polled, pollTime := p.nextPoll()
+		if polled != "" {
+			nextPoll = time.After(time.Until(pollTime))
+		}
+
+		var nextNotification <-chan time.Time
+		if notifyTime, found := p.nextNotification(); found {
+			nextNotification = time.After(time.Until(notifyTime))
 		}
 
 		select {
 		case <-nextUpdate:
-			log.Printf("Updating secret '%s'", s.Name)
-			for retry := true; retry; {
-				retry, err = p.resolveSecret(s.Name, p.Secrets[s.Name])
+			infof("Updating secret '%s'", s.Name)
+			err = p.updateSecretAndFiles(s.Name)
+			if err != nil {
+				return err
+			}
+		case <-nextPoll:
+			changed, err := p.pollSecret(polled)
+			if err := p.schedulePoll(polled); err != nil {
+				errorf("Secret '%s' won't be polled anymore: %v", polled, err)
+			}
+			if err != nil {
+				warnf("Couldn't check version of secret '%s': %v", polled, err)
+				break
+			}
+			if changed {
+				infof("Secret '%s' has a new version, updating it", polled)
+				err = p.updateSecretAndFiles(polled)
 				if err != nil {
-					if retry {
-						log.Println(err)
-						<-time.After(SecretRetryPeriod)
-					} else {
-						return err
-					}
+					return err
 				}
 			}
-			for _, f := range p.State.Secrets[s.Name].FilesUsing {
-				log.Printf("Updating file '%s'", f.Path)
-				err = p.resolveFile(p.Files[f.Path])
+		case path := <-p.refresh:
+			for _, name := range p.secretsForPath(path) {
+				infof("Secret '%s' changed in Vault, updating it", name)
+				err = p.updateSecretAndFiles(name)
 				if err != nil {
 					return err
 				}
 			}
+		case path := <-p.tampered:
+			p.healFile(path)
+		case <-nextNotification:
+			// Debounced notifiers are run at the start of the loop
+		case req := <-p.controlRequests:
+			p.runControl(req)
+		case name := <-p.changed:
+			if _, found := p.Secrets[name]; !found {
+				// Watched before reloading the configuration
+				debugf("Secret '%s' changed, but it is not configured anymore", name)
+				break
+			}
+			infof("Secret '%s' changed, updating it", name)
+			err = p.updateSecretAndFiles(name)
+			if err != nil {
+				return err
+			}
+		case exited := <-p.execExited():
+			infof("Command exited with code %d", exited.Code)
+			return exited
 		case <-ctx.Done():
+			var exited *ExecExitError
+			if p.exec != nil {
+				exited = p.stopExec()
+			}
+			if p.shredOnExit {
+				p.shredFiles()
+			}
+			if exited != nil {
+				return exited
+			}
 			return nil
 		}
 	}
 }
 
+// Login obtains a session in Vault, it can be called before Run to have
+// the session ready before starting, e.g. in standby instances, it
+// doesn't write anything
+func (p *pouch) Login() error {
+	if p.loggedIn {
+		return nil
+	}
+	err := p.Vault.Login()
+	if err != nil {
+		return err
+	}
+	for name, provider := range p.providers {
+		err := provider.Login()
+		if err != nil {
+			return fmt.Errorf("couldn't login in provider '%s': %v", name, err)
+		}
+	}
+	p.loggedIn = true
+	return nil
+}
+
+// TakeOver reloads the state written by the active instance and uses its
+// token, that can be different to the one the session was started with if
+// it was renewed or obtained again, Run logs in again with it
+func (p *pouch) TakeOver() error {
+	err := p.State.Reload()
+	if err != nil {
+		return err
+	}
+	if p.State.Token != "" {
+		p.Vault.SetToken(p.State.Token)
+	}
+	p.loggedIn = false
+	return nil
+}
+
 func NewPouch(s *PouchState, vc vault.Vault, sc map[string]SecretConfig, fc []FileConfig, nc map[string]NotifierConfig) Pouch {
 	fileMap := make(map[string]FileConfig)
 	for _, f := range fc {
 		fileMap[f.Path] = f
 	}
-	return &pouch{State: s, Vault: vc, Secrets: sc, Files: fileMap, Notifiers: nc}
+	return &pouch{State: s, Vault: vc, Secrets: sc, Files: fileMap, Notifiers: nc, Metrics: newMetricsRegistry(), Events: NewEventLog(0)}
+}
+
+func (p *pouch) MetricsTextfile(path string) {
+	p.MetricsTextfilePath = path
 }
 
 func (p *pouch) ServiceReloader(r Reloader) {
 	p.Reloader = r
 }
 
+func (p *pouch) AddServiceReloader(name string, r Reloader) {
+	if p.Reloaders == nil {
+		p.Reloaders = make(map[string]Reloader)
+	}
+	p.Reloaders[name] = r
+}
+
+// serviceReloader returns the reloader selected by a notifier, or the
+// default one if none is selected
+func (p *pouch) serviceReloader(name string) (Reloader, error) {
+	if name == "" {
+		if p.Reloader == nil {
+			return nil, fmt.Errorf("no service reloader available")
+		}
+		return p.Reloader, nil
+	}
+	r, found := p.Reloaders[name]
+	if !found {
+		return nil, fmt.Errorf("unknown service manager: %s", name)
+	}
+	return r, nil
+}
+
 func (p *pouch) AddStatusNotifier(n StatusNotifier) {
 	p.statusNotifiers = append(p.statusNotifiers, n)
 }
 
-func (p *pouch) NotifyReady() {
-	for _, n := range p.statusNotifiers {
-		err := n.NotifyReady()
-		if err != nil {
-			log.Println(err)
+func (p *pouch) addForNotify(file string, notifiers ...NotifyConfig) {
+	if p.pendingNotifiers == nil {
+		p.pendingNotifiers = make(map[NotifyConfig][]string)
+		p.pendingSince = make(map[NotifyConfig]time.Time)
+	}
+	for _, n := range notifiers {
+		if _, found := p.pendingNotifiers[n]; !found {
+			p.pendingSince[n] = time.Now()
 		}
+		p.pendingNotifiers[n] = append(p.pendingNotifiers[n], file)
 	}
 }
 
-func (p *pouch) addForNotify(names ...string) {
-	if p.pendingNotifiers == nil {
-		p.pendingNotifiers = make(map[string]bool)
+// notifyDebounce returns the time a notifier waits to coalesce the
+// notifications triggered during this window
+func (p *pouch) notifyDebounce(n NotifyConfig) time.Duration {
+	config := p.Notifiers[n.Notifier]
+	if config.Debounce == "" {
+		return 0
 	}
-	for _, name := range names {
-		p.pendingNotifiers[name] = true
+	d, err := time.ParseDuration(config.Debounce)
+	if err != nil {
+		warnf("Incorrect debounce for notifier '%s': %v", n.Notifier, err)
+		return 0
 	}
+	return d
 }
 
-func (p *pouch) notifyPending() {
+// nextNotification returns when the next debounced or delayed notifier is
+// due, false if there is none pending
+func (p *pouch) nextNotification() (time.Time, bool) {
+	var next time.Time
+	now := time.Now()
 	for pending := range p.pendingNotifiers {
-		p.Notify(pending)
+		due := p.pendingSince[pending].Add(p.notifyDebounce(pending))
+		if due.Before(now) {
+			due = now
+		}
+		if window, found := p.notifyWindow(pending, due); !found {
+			continue
+		} else if window.After(due) {
+			due = window
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// notifyPending runs the pending notifiers that are due and in their
+// maintenance windows, notifiers run after the ones in their dependencies
+func (p *pouch) notifyPending() error {
+	var due []NotifyConfig
+	now := time.Now()
+	for pending := range p.pendingNotifiers {
+		if now.Sub(p.pendingSince[pending]) < p.notifyDebounce(pending) {
+			continue
+		}
+		if window, found := p.notifyWindow(pending, now); !found || window.After(now) {
+			// Kept till its maintenance window
+			continue
+		}
+		due = append(due, pending)
+	}
+	for _, pending := range p.notifyOrder(due) {
+		files := p.pendingNotifiers[pending]
 		delete(p.pendingNotifiers, pending)
+		delete(p.pendingSince, pending)
+		if p.filesUnchangedSinceNotified(pending, files) {
+			infof("Files notified by '%s' didn't change since last notification, skipping it", pending.Notifier)
+			p.Metrics.Add(MetricNotificationsSkipped, metrics.Labels{"notifier": pending.Notifier}, 1)
+			continue
+		}
+		err := p.Notify(pending, files)
+		p.recordNotifiedChecksums(pending, files)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filesUnchangedSinceNotified returns true if all the files that triggered
+// a notifier have the same content they had when it was last run, as when
+// they changed and then went back to their previous content
+func (p *pouch) filesUnchangedSinceNotified(n NotifyConfig, files []string) bool {
+	notified, found := p.notifiedChecksums[n]
+	if !found {
+		return false
+	}
+	for _, file := range files {
+		checksum, found := p.State.FileChecksums[file]
+		if !found || notified[file] != checksum {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pouch) recordNotifiedChecksums(n NotifyConfig, files []string) {
+	if p.notifiedChecksums == nil {
+		p.notifiedChecksums = make(map[NotifyConfig]map[string]string)
+	}
+	if p.notifiedChecksums[n] == nil {
+		p.notifiedChecksums[n] = make(map[string]string)
+	}
+	for _, file := range files {
+		if checksum, found := p.State.FileChecksums[file]; found {
+			p.notifiedChecksums[n][file] = checksum
+		} else {
+			delete(p.notifiedChecksums[n], file)
+		}
 	}
 }
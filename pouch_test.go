@@ -18,11 +18,20 @@ package pouch
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
+	"text/template"
+	"time"
 
+	"github.com/tuenti/pouch/pkg/pouchtest"
 	"github.com/tuenti/pouch/pkg/vault"
 
 	"github.com/fsnotify/fsnotify"
@@ -42,6 +51,8 @@ type DummyVault struct {
 	SecretID string
 
 	Responses map[string]*api.Secret
+
+	Events chan vault.Event
 }
 
 func (v *DummyVault) Login() error {
@@ -83,6 +94,25 @@ func (v *DummyVault) GetToken() string {
 	return v.Token
 }
 
+func (v *DummyVault) SetToken(token string) {
+	v.Token = token
+}
+
+func (v *DummyVault) Renew(leaseID string, increment int) (*api.Secret, error) {
+	s, ok := v.Responses["RENEW"+leaseID]
+	if !ok {
+		return nil, fmt.Errorf("lease not found")
+	}
+	return s, nil
+}
+
+func (v *DummyVault) Subscribe(ctx context.Context, eventType string) (<-chan vault.Event, error) {
+	if v.Events == nil {
+		return nil, fmt.Errorf("events not supported")
+	}
+	return v.Events, nil
+}
+
 func newTestState() (state *PouchState, cleanup func()) {
 	f, _ := ioutil.TempFile("", "pouch-state-test")
 	f.Close()
@@ -224,7 +254,7 @@ func TestPouchWatch(t *testing.T) {
 
 	finished := make(chan error)
 	go func() {
-		finished <- pouch.Watch(secretWrapPath.Name())
+		finished <- pouch.Watch(context.Background(), secretWrapPath.Name())
 	}()
 
 	secretWrapPath.Write([]byte("wrap"))
@@ -261,6 +291,32 @@ func TestDirPerms(t *testing.T) {
 	}
 }
 
+func TestParentDirs(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := NewPouch(NewState(""), nil, nil, nil, nil).(*pouch)
+
+	fc := FileConfig{Path: path.Join(tmpdir, "a", "foo"), Mode: 0600, DirMode: 0751, Template: "foo"}
+	assert.NoError(t, p.resolveFile(fc))
+	info, err := os.Stat(path.Dir(fc.Path))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0751), info.Mode().Perm())
+	}
+
+	createDirs := false
+	fc = FileConfig{Path: path.Join(tmpdir, "b", "foo"), Template: "foo", CreateDirs: &createDirs}
+	assert.Error(t, p.resolveFile(fc))
+	_, err = os.Stat(path.Dir(fc.Path))
+	assert.True(t, os.IsNotExist(err))
+
+	fc.Path = path.Join(tmpdir, "a", "bar")
+	assert.NoError(t, p.resolveFile(fc))
+}
+
 func TestResolveDataTemplates(t *testing.T) {
 	env := "TESTENV"
 	envValue := "foo"
@@ -269,13 +325,710 @@ func TestResolveDataTemplates(t *testing.T) {
 
 	hostname, _ := os.Hostname()
 
+	f, err := ioutil.TempFile("", "pouch-data-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	csr := "-----BEGIN CERTIFICATE REQUEST-----\n...\n-----END CERTIFICATE REQUEST-----\n"
+	f.WriteString(csr)
+	f.Close()
+
 	data := map[string]interface{}{
 		"env":      "{{ env \"TESTENV\" }}",
 		"hostname": "{{ hostname }}",
+		"csr":      "{{ file \"" + f.Name() + "\" }}",
+		"upper":    "{{ env \"TESTENV\" | upper }}",
+		"uuid":     "{{ uuid }}",
+		"random":   "{{ randomString 12 }}",
+		"time":     "{{ timestamp }}",
+		"year":     "{{ now.Year }}",
+		"ip":       "{{ lookupIP \"localhost\" | join \",\" }}",
 	}
 
 	resolvedData := resolveData(data)
 
 	assert.Equal(t, envValue, resolvedData["env"])
 	assert.Equal(t, hostname, resolvedData["hostname"])
+	assert.Equal(t, csr, resolvedData["csr"])
+	assert.Equal(t, "FOO", resolvedData["upper"])
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", resolvedData["uuid"])
+	assert.Regexp(t, "^[a-zA-Z0-9]{12}$", resolvedData["random"])
+	_, err = time.Parse(time.RFC3339, resolvedData["time"].(string))
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(time.Now().Year()), resolvedData["year"])
+	assert.NotEmpty(t, resolvedData["ip"])
+}
+
+type upperTemplateEngine struct{}
+
+func (*upperTemplateEngine) Render(name, source string, ctx *RenderContext) (string, error) {
+	data, err := ctx.Secret(source)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(data["foo"].(string)), nil
+}
+
+func TestTemplateEngines(t *testing.T) {
+	RegisterTemplateEngine("upper", &upperTemplateEngine{})
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"foo": "secretfoo"}})
+	ctx := &RenderContext{
+		Secret: func(name string) (SecretData, error) {
+			return state.Secrets[name].Data, nil
+		},
+	}
+
+	content, err := getFileContent(FileConfig{Template: "foo", Engine: "upper"}, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "SECRETFOO", content)
+
+	_, err = getFileContent(FileConfig{Template: "foo", Engine: "unknown"}, ctx)
+	assert.Error(t, err)
+}
+
+func TestPouchRunGlobSecrets(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"LIST/v1/secret/app": &api.Secret{
+				Data: map[string]interface{}{"keys": []interface{}{"a", "b", "sub/"}},
+			},
+			"GET/v1/secret/app/a": &api.Secret{
+				Data: map[string]interface{}{"value": "secreta"},
+			},
+			"GET/v1/secret/app/b": &api.Secret{
+				Data: map[string]interface{}{"value": "secretb"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"app": {
+			VaultURL:   "/v1/secret/app/*",
+			HTTPMethod: "GET",
+		},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "foo"), Template: `{{ secret "app/a" "value" }} {{ secret "app/b" "value" }}`},
+		{
+			Path:     path.Join(tmpdir, `{{ .Key }}-{{ secret .Secret "value" }}`),
+			ForEach:  "app",
+			Template: `{{ secret .Secret "value" }}`,
+		},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	pouch := NewPouch(state, v, secrets, files, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pouch.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, state.Secrets, 2)
+	d, err := ioutil.ReadFile(path.Join(tmpdir, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secreta secretb", string(d))
+
+	for _, key := range []string{"a", "b"} {
+		d, err := ioutil.ReadFile(path.Join(tmpdir, key+"-secret"+key))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "secret"+key, string(d))
+		}
+	}
+}
+
+func TestGlobSecretsHeaders(t *testing.T) {
+	v := pouchtest.NewVault()
+	v.Login()
+	v.SetResponse(ListMethod, "/v1/secret/app", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"a"}},
+	})
+	headers := map[string]string{"X-Vault-Namespace": "team"}
+	p := &pouch{
+		Vault: v,
+		Secrets: map[string]SecretConfig{
+			"app": {VaultURL: "/v1/secret/app/*", Headers: headers},
+		},
+	}
+	err := p.expandSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, headers, p.Secrets["app/a"].Headers)
+	requests := v.Requests()
+	if assert.Len(t, requests, 1) && assert.NotNil(t, requests[0].Options) {
+		assert.Equal(t, headers, requests[0].Options.Headers)
+	}
+}
+
+func TestSecretHeaders(t *testing.T) {
+	var namespaces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespaces = append(namespaces, r.Header.Get("X-Vault-Namespace"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/secret/metadata/db" {
+			w.Write([]byte(`{"data": {"current_version": 2}}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"password": "secret"}}`))
+	}))
+	defer server.Close()
+
+	v := vault.New(vault.Config{Address: server.URL, Token: "token"})
+	secrets := map[string]SecretConfig{
+		"db": {
+			VaultURL:     "/v1/secret/db",
+			MetadataURL:  "/v1/secret/metadata/db",
+			HTTPMethod:   "GET",
+			PollInterval: "1m",
+			Headers:      map[string]string{"X-Vault-Namespace": "team"},
+		},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, nil, nil).(*pouch)
+
+	_, err := p.resolveSecret("db", secrets["db"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"team"}, namespaces)
+	assert.Equal(t, "secret", state.Secrets["db"].Data["password"])
+
+	// Headers are also sent when polling
+	changed, err := p.pollSecret("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, changed)
+	assert.Equal(t, []string{"team", "team"}, namespaces)
+}
+
+func TestPouchRunVaultEvents(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/data/foo": &api.Secret{
+				Data: map[string]interface{}{"value": "old"},
+			},
+		},
+		Events: make(chan vault.Event),
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {
+			VaultURL:   "/v1/secret/data/foo",
+			HTTPMethod: "GET",
+		},
+	}
+	filePath := path.Join(tmpdir, "foo")
+	files := []FileConfig{
+		{Path: filePath, Template: `{{ secret "foo" "value" }}`},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	pouch := NewPouch(state, v, secrets, files, nil)
+	pouch.VaultEvents("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan error)
+	go func() {
+		finished <- pouch.Run(ctx)
+	}()
+
+	// Subscription starts after files are written
+	v.Events <- vault.Event{Path: "secret/data/other"}
+	d, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, "old", string(d))
+
+	v.Responses["GET/v1/secret/data/foo"] = &api.Secret{
+		Data: map[string]interface{}{"value": "new"},
+	}
+	v.Events <- vault.Event{Path: "secret/data/foo"}
+
+	timeout := time.After(5 * time.Second)
+	for string(d) != "new" {
+		select {
+		case <-timeout:
+			t.Fatalf("file not updated after event, content: %s", d)
+		case <-time.After(10 * time.Millisecond):
+		}
+		d, _ = ioutil.ReadFile(filePath)
+	}
+
+	cancel()
+	err = <-finished
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVaultPath(t *testing.T) {
+	cases := map[string]string{
+		"/v1/secret/data/foo":          "secret/data/foo",
+		"v1/secret/foo/":               "secret/foo",
+		"/v1/secret/data/foo?version=": "secret/data/foo",
+	}
+	for url, expected := range cases {
+		assert.Equal(t, expected, vaultPath(url))
+	}
+}
+
+func TestPollSecret(t *testing.T) {
+	metadata := &api.Secret{
+		Data: map[string]interface{}{"current_version": json.Number("2")},
+	}
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/metadata/foo": metadata,
+		},
+	}
+	state := NewState("")
+	p := &pouch{
+		State: state,
+		Vault: v,
+		Secrets: map[string]SecretConfig{
+			"foo": {VaultURL: "/v1/secret/data/foo", PollInterval: "1m"},
+		},
+	}
+
+	changed, err := p.pollSecret("foo")
+	assert.NoError(t, err)
+	assert.True(t, changed, "unknown secrets should be considered changed")
+
+	state.SetSecret("foo", &api.Secret{
+		Data: map[string]interface{}{
+			"data":     map[string]interface{}{"value": "foo"},
+			"metadata": map[string]interface{}{"version": json.Number("2")},
+		},
+	})
+	assert.Equal(t, 2, state.Secrets["foo"].Version)
+
+	changed, err = p.pollSecret("foo")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	metadata.Data["current_version"] = json.Number("3")
+	changed, err = p.pollSecret("foo")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.NoError(t, p.schedulePoll("foo"))
+	name, next := p.nextPoll()
+	assert.Equal(t, "foo", name)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), next, time.Second)
+
+	// Secrets not polled anymore are not scheduled
+	p.Secrets["foo"] = SecretConfig{VaultURL: "/v1/secret/data/foo", PollInterval: "wrong"}
+	assert.Error(t, p.schedulePoll("foo"))
+	name, _ = p.nextPoll()
+	assert.Equal(t, "", name)
+}
+
+func TestWasmTemplateFunctions(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Fake runtime that replies with its input
+	runtime := path.Join(tmpdir, "runtime")
+	err = ioutil.WriteFile(runtime, []byte("#!/bin/sh\ncat\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewWasmFunction("echo", TemplateFunctionConfig{Module: "echo.wasm", Runtime: runtime})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Data: SecretData{"value": "secretfoo"}},
+	}
+	p := &pouch{State: state, Metrics: newMetricsRegistry(), Events: NewEventLog(0)}
+	p.AddTemplateFunction("echo", f.Call)
+
+	filePath := path.Join(tmpdir, "foo")
+	err = p.resolveFile(FileConfig{Path: filePath, Template: `{{ echo (secret "foo" "value") 42 }}`})
+	assert.NoError(t, err)
+	d, _ := ioutil.ReadFile(filePath)
+	assert.Equal(t, `["secretfoo",42]`, string(d))
+
+	_, err = NewWasmFunction("none", TemplateFunctionConfig{})
+	assert.Error(t, err)
+}
+
+type staticProvider struct {
+	data map[string]interface{}
+}
+
+func (p *staticProvider) Login() error {
+	return nil
+}
+
+func (p *staticProvider) Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error) {
+	return &api.Secret{Data: map[string]interface{}{"path": path, "value": p.data[path]}}, nil, nil
+}
+
+func (p *staticProvider) Renew(leaseID string, increment int) (*api.Secret, error) {
+	return nil, fmt.Errorf("leases not supported")
+}
+
+func TestSecretProviders(t *testing.T) {
+	RegisterSecretProvider("test", func(config json.RawMessage) (SecretProvider, error) {
+		var data map[string]interface{}
+		err := json.Unmarshal(config, &data)
+		return &staticProvider{data: data}, err
+	})
+
+	_, err := NewSecretProvider("unknown", nil)
+	assert.Error(t, err)
+
+	provider, err := NewSecretProvider("test", json.RawMessage(`{"foo": "bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := &pouch{
+		State:   state,
+		Vault:   &DummyVault{T: t},
+		Metrics: newMetricsRegistry(),
+		Events:  NewEventLog(0),
+	}
+	p.AddSecretProvider("test", provider)
+
+	_, err = p.resolveSecret("foo", SecretConfig{VaultURL: "test://foo"})
+	assert.NoError(t, err)
+	assert.Equal(t, SecretData{"path": "foo", "value": "bar"}, p.State.Secrets["foo"].Data)
+
+	_, err = p.resolveSecret("bar", SecretConfig{VaultURL: "unknown://bar"})
+	assert.Error(t, err)
+}
+
+type watchingProvider struct {
+	staticProvider
+	changes chan string
+}
+
+func (p *watchingProvider) Watch(ctx context.Context, path string) error {
+	for {
+		select {
+		case changed := <-p.changes:
+			if changed == path {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestWatchSecrets(t *testing.T) {
+	provider := &watchingProvider{changes: make(chan string)}
+	p := &pouch{
+		Secrets: map[string]SecretConfig{
+			"foo": {VaultURL: "watching://foo"},
+			"bar": {VaultURL: "/v1/secret/bar"},
+		},
+		changed: make(chan string),
+	}
+	p.AddSecretProvider("watching", provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.watchSecrets(ctx)
+
+	provider.changes <- "foo"
+	select {
+	case name := <-p.changed:
+		assert.Equal(t, "foo", name)
+	case <-time.After(time.Second):
+		t.Fatal("change not received")
+	}
+}
+
+func TestRenewSecret(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"RENEWlease": &api.Secret{LeaseID: "lease", LeaseDuration: 200, Renewable: true},
+		},
+	}
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{
+		LeaseID:       "lease",
+		LeaseDuration: 100,
+		Renewable:     true,
+		Data:          map[string]interface{}{"password": "foo"},
+	})
+	state.Secrets["foo"].Timestamp = time.Now().Add(-time.Minute)
+	p := &pouch{
+		State: state,
+		Vault: v,
+		Secrets: map[string]SecretConfig{
+			"foo": {VaultURL: "/v1/database/creds/foo", RenewLease: true},
+		},
+	}
+
+	assert.NoError(t, p.updateSecretAndFiles("foo"))
+	assert.Equal(t, 200, state.Secrets["foo"].LeaseDuration)
+	assert.WithinDuration(t, time.Now(), state.Secrets["foo"].Timestamp, time.Second)
+	assert.Equal(t, SecretData{"password": "foo"}, state.Secrets["foo"].Data)
+}
+
+func TestResolveFileUnchanged(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Template: `{{ secret "foo" "password" }}`,
+		Notify:   NotifyNames("service"),
+	}
+
+	assert.NoError(t, p.resolveFile(fc))
+	assert.Equal(t, []string{fc.Path}, p.pendingNotifiers[NotifyConfig{Notifier: "service"}])
+
+	// Same content is not written again, nor notified
+	p.pendingNotifiers = nil
+	info, err := os.Stat(fc.Path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chtimes(fc.Path, info.ModTime().Add(-time.Hour), info.ModTime().Add(-time.Hour)))
+	assert.NoError(t, p.resolveFile(fc))
+	assert.Empty(t, p.pendingNotifiers)
+	newInfo, err := os.Stat(fc.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime().Add(-time.Hour), newInfo.ModTime())
+
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "bar"}})
+	assert.NoError(t, p.resolveFile(fc))
+	assert.Equal(t, []string{fc.Path}, p.pendingNotifiers[NotifyConfig{Notifier: "service"}])
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "bar", string(d))
+}
+
+func TestFileEncoding(t *testing.T) {
+	binary := []byte{0x30, 0x82, 0x00, 0xff, 0x0a}
+	state := NewState("")
+	state.SetSecret("keystore", &api.Secret{Data: map[string]interface{}{
+		"der": base64.StdEncoding.EncodeToString(binary),
+	}})
+	ctx := &RenderContext{
+		Funcs: template.FuncMap{
+			"secret": func(name, key string) interface{} { return state.Secrets[name].Data[key] },
+		},
+	}
+
+	content, err := getFileContent(FileConfig{Template: "{{ secret \"keystore\" \"der\" }}\n", Encoding: Base64Encoding}, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, binary, []byte(content))
+
+	_, err = getFileContent(FileConfig{Template: "not base64", Encoding: Base64Encoding}, ctx)
+	assert.Error(t, err)
+
+	_, err = getFileContent(FileConfig{Template: "foo", Encoding: "unknown"}, ctx)
+	assert.Error(t, err)
+}
+
+func TestSecretOrDefault(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Template: `{{ secretOrDefault "foo" "password" "" }} {{ secretOrDefault "foo" "port" 8080 }} {{ secretOrDefault "bar" "user" "admin" }}`,
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo 8080 admin", string(d))
+	assert.Len(t, state.Secrets["foo"].FilesUsing, 1)
+}
+
+func TestTemplatePartials(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	templatesDir := path.Join(tmpdir, "templates")
+	os.Mkdir(templatesDir, 0700)
+	ioutil.WriteFile(path.Join(templatesDir, "tls.tmpl"), []byte(`ssl_certificate {{ .cert }};`), 0600)
+	ioutil.WriteFile(path.Join(templatesDir, "ignored.txt"), []byte(`ignored`), 0600)
+	templates, err := LoadTemplatesDir(templatesDir)
+	assert.NoError(t, err)
+	assert.Len(t, templates, 1)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{"password": "foo"}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	for name, source := range templates {
+		p.AddTemplate(name, source)
+	}
+	p.AddTemplate("password", `password={{ secret "foo" "password" }}`)
+
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Template: `{{ template "tls" (dict "cert" "/etc/ssl/app.pem") }} {{ template "password" }}`,
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "ssl_certificate /etc/ssl/app.pem; password=foo", string(d))
+	assert.Len(t, state.Secrets["foo"].FilesUsing, 1)
+}
+
+func TestSecretMeta(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{
+		LeaseID:       "lease",
+		LeaseDuration: 3600,
+		Renewable:     true,
+		Data:          map[string]interface{}{"password": "foo"},
+	})
+	readTime := state.Secrets["foo"].Timestamp
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:     path.Join(tmpdir, "foo"),
+		Template: `{{ secretMeta "foo" "lease_id" }} {{ secretMeta "foo" "lease_duration" }} {{ secretMeta "foo" "renewable" }} {{ (secretMeta "foo" "expiration_time").Unix }}`,
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, fmt.Sprintf("lease 3600 true %d", readTime.Add(time.Hour).Unix()), string(d))
+
+	fc.Template = `{{ secretMeta "foo" "unknown" }}`
+	assert.Error(t, p.resolveFile(fc))
+}
+
+func TestConsulTemplateEngine(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("db", &api.Secret{Data: map[string]interface{}{
+		"data": map[string]interface{}{"password": "foo"},
+	}})
+	state.SetSecret("host", &api.Secret{Data: map[string]interface{}{"value": "db.example.com"}})
+	state.SetSecret("web", &api.Secret{Data: map[string]interface{}{
+		"instances": []interface{}{
+			map[string]interface{}{"Name": "web", "Address": "10.0.0.1", "Port": 8080.0, "Tags": []interface{}{"primary"}},
+		},
+	}})
+	secrets := map[string]SecretConfig{
+		"db":   {VaultURL: "/v1/secret/data/db"},
+		"host": {VaultURL: "consul://app/db/host"},
+		"web":  {VaultURL: "consul-service://primary.web"},
+	}
+	p := NewPouch(state, nil, secrets, nil, nil).(*pouch)
+	fc := FileConfig{
+		Path:   path.Join(tmpdir, "foo"),
+		Engine: ConsulTemplateEngine,
+		Template: `{{ with secret "secret/data/db" }}{{ .Data.data.password }}{{ end }} ` +
+			`{{ key "app/db/host" }} {{ keyOrDefault "app/db/port" "5432" }} ` +
+			`{{ range service "primary.web" }}{{ .Address }}:{{ .Port }}{{ end }}`,
+	}
+	assert.NoError(t, p.resolveFile(fc))
+	d, _ := ioutil.ReadFile(fc.Path)
+	assert.Equal(t, "foo db.example.com 5432 10.0.0.1:8080", string(d))
+	assert.Len(t, state.Secrets["db"].FilesUsing, 1)
+	assert.Len(t, state.Secrets["web"].FilesUsing, 1)
+
+	fc.Template = `{{ with secret "secret/data/unknown" }}{{ end }}`
+	assert.Error(t, p.resolveFile(fc))
+}
+
+func TestStrictTemplates(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.SetSecret("foo", &api.Secret{Data: map[string]interface{}{
+		"data": map[string]interface{}{"password": "foo"},
+	}})
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+	fc := FileConfig{Path: path.Join(tmpdir, "foo"), Strict: true}
+	fc.Template = `{{ (secret "foo" "data").password }}`
+	assert.NoError(t, p.resolveFile(fc))
+
+	for _, template := range []string{
+		`{{ (secret "foo" "data").user }}`,
+		`{{ .Missing }}`,
+		`{{ secretOrDefault "foo" "missing" nil }}`,
+	} {
+		fc.Template = template
+		assert.Error(t, p.resolveFile(fc), template)
+		d, _ := ioutil.ReadFile(fc.Path)
+		assert.Equal(t, "foo", string(d))
+
+		lenient := fc
+		lenient.Strict = false
+		lenient.Path = path.Join(tmpdir, "lenient")
+		assert.NoError(t, p.resolveFile(lenient), template)
+	}
 }
@@ -17,30 +17,123 @@ limitations under the License.
 package pouch
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
 
+	"github.com/tuenti/pouch/pkg/plugin"
+	"github.com/tuenti/pouch/pkg/remote"
+	"github.com/tuenti/pouch/pkg/supervision"
 	"github.com/tuenti/pouch/pkg/vault"
 
 	"github.com/ghodss/yaml"
 )
 
+const (
+	PouchfileVersion1 = 1
+
+	// Version 2 accepts notifiers with parameters in files
+	PouchfileVersion2 = 2
+
+	CurrentPouchfileVersion = PouchfileVersion2
+)
+
 type Pouchfile struct {
+	// Version of the configuration format, 1 if not set
+	Version int `json:"version,omitempty"`
+
 	WrappedSecretIDPath string `json:"wrapped_secret_id_path,omitempty"`
 	StatePath           string `json:"state_path,omitempty"`
 
-	Vault     vault.Config              `json:"vault,omitempty"`
-	Systemd   SystemdConfig             `json:"systemd,omitempty"`
-	Notifiers map[string]NotifierConfig `json:"notifiers,omitempty"`
-	Secrets   map[string]SecretConfig   `json:"secrets,omitempty"`
-	Files     []FileConfig              `json:"files,omitempty"`
+	// Network channels where wrapped secret IDs are accepted
+	WrappedSecretIDListener *WrappedSecretIDListenerConfig `json:"wrapped_secret_id_listener,omitempty"`
+
+	Log         LogConfig                 `json:"log,omitempty"`
+	Vault       vault.Config              `json:"vault,omitempty"`
+	VaultEvents VaultEventsConfig         `json:"vault_events,omitempty"`
+	Systemd     SystemdConfig             `json:"systemd,omitempty"`
+	Metrics     MetricsConfig             `json:"metrics,omitempty"`
+	Status      StatusConfig              `json:"status,omitempty"`
+	Notifiers   map[string]NotifierConfig `json:"notifiers,omitempty"`
+	Secrets     map[string]SecretConfig   `json:"secrets,omitempty"`
+	Files       []FileConfig              `json:"files,omitempty"`
+
+	// Channels where operators are alerted about problems rotating secrets
+	Alerts map[string]AlertConfig `json:"alerts,omitempty"`
+
+	// Append-only log of secret access and file writes
+	Audit *AuditConfig `json:"audit,omitempty"`
+
+	// OpenTelemetry collector where spans of the operations are exported
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+
+	// Unix socket where administrative commands are received
+	Control *ControlConfig `json:"control,omitempty"`
+
+	// Unix socket where the gRPC API is served to local applications
+	GRPC *GRPCConfig `json:"grpc,omitempty"`
+
+	// Unix socket where secrets are served to authorized local
+	// applications
+	SecretsServer *SecretsServerConfig `json:"secrets_server,omitempty"`
+
+	// Experimental FUSE mount where secrets are exposed as files
+	FUSE *FUSEConfig `json:"fuse,omitempty"`
+
+	// Init or supervision system used instead of systemd to notify services
+	ServiceManager *supervision.Config `json:"service_manager,omitempty"`
+
+	// Options inherited by all files that don't set them
+	FileDefaults FileDefaultsConfig       `json:"file_defaults,omitempty"`
+	Plugins      map[string]plugin.Config `json:"plugins,omitempty"`
+
+	// Record of the secrets used to render each file
+	Provenance ProvenanceConfig `json:"provenance,omitempty"`
+
+	// Conditions verified after each cycle
+	Expectations map[string]ExpectationConfig `json:"expectations,omitempty"`
+
+	// Replication of the state with instances in other hosts
+	Replication *ReplicationConfig `json:"replication,omitempty"`
+
+	// Remote hosts where files can be pushed, experimental
+	Hosts map[string]remote.Config `json:"hosts,omitempty"`
+
+	TemplateFunctions map[string]TemplateFunctionConfig `json:"template_functions,omitempty"`
+
+	// Partial templates that file templates can include, inline or
+	// in files with .tmpl extension in a directory
+	Templates    map[string]string `json:"templates,omitempty"`
+	TemplatesDir string            `json:"templates_dir,omitempty"`
+
+	// Configuration of secret providers other than Vault
+	Providers map[string]json.RawMessage `json:"providers,omitempty"`
+
+	// Command run with secrets in its environment
+	Exec *ExecConfig `json:"exec,omitempty"`
+
+	// If managed files and the state are overwritten and removed when
+	// pouch is stopped
+	ShredOnExit bool `json:"shred_on_exit,omitempty"`
+
+	// What to do with files written by pouch that are not in the
+	// configuration anymore, they are removed by default
+	OrphanedFiles string `json:"orphaned_files,omitempty"`
 }
 
 type SystemdConfig struct {
 	// If pouch should enable systemd support. Defaults to true
 	// if systemd is available
 	Enabled *bool `json:"enabled,omitempty"`
+
+	// Path where drop-ins for units are created
+	UnitsPath string `json:"units_path,omitempty"`
+
+	// Environment variables for units obtained from secrets
+	Environment []SystemdEnvironmentConfig `json:"environment,omitempty"`
 }
 
 type systemdConfigurer struct {
@@ -58,25 +151,411 @@ func (s *SystemdConfig) Configurer() *systemdConfigurer {
 }
 
 type SecretConfig struct {
-	VaultURL   string     `json:"vault_url,omitempty"`
-	HTTPMethod string     `json:"http_method,omitempty"`
-	Data       SecretData `json:"data,omitempty"`
+	VaultURL   string            `json:"vault_url,omitempty"`
+	HTTPMethod string            `json:"http_method,omitempty"`
+	Data       SecretData        `json:"data,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+
+	// URL to list when the secret is a glob, by default the prefix of
+	// the Vault URL
+	ListURL string `json:"list_url,omitempty"`
+
+	// Optional secrets don't affect readiness
+	Optional bool `json:"optional,omitempty"`
+
+	// Plugin used to read the secret instead of Vault
+	Plugin string `json:"plugin,omitempty"`
+
+	// If set, the version of the secret is checked with this interval,
+	// and the secret is read again if it changes
+	PollInterval string `json:"poll_interval,omitempty"`
+
+	// URL to read the metadata of the secret when polling, by default
+	// the Vault URL replacing its data part by metadata
+	MetadataURL string `json:"metadata_url,omitempty"`
+
+	// If the secret has a renewable lease, renew it instead of
+	// requesting the secret again
+	RenewLease bool `json:"renew_lease,omitempty"`
+
+	// Thresholds to detect that the secret rotates much more often
+	// than usual
+	RotationAnomaly *RotationAnomalyConfig `json:"rotation_anomaly,omitempty"`
+
+	// Local users and groups that can request the secret to the
+	// secrets server, it is not served to anyone if not set
+	Consumers *SecretConsumers `json:"consumers,omitempty"`
 }
 
 type FileConfig struct {
-	Path         string   `json:"path,omitempty"`
-	Mode         int      `json:"mode,omitempty"`
-	Template     string   `json:"template,omitempty"`
-	TemplateFile string   `json:"template_file,omitempty"`
-	Notify       []string `json:"notify,omitempty"`
-	Priority     int      `json:"priority,omitempty"`
+	Path         string     `json:"path,omitempty"`
+	Mode         FileMode   `json:"mode,omitempty"`
+	Template     string     `json:"template,omitempty"`
+	TemplateFile string     `json:"template_file,omitempty"`
+	Engine       string     `json:"engine,omitempty"`
+	Secrets      []string   `json:"secrets,omitempty"`
+	Notify       NotifyList `json:"notify,omitempty"`
+	Priority     int        `json:"priority,omitempty"`
+
+	// If set, the path is a directory where each key of the secrets
+	// of the file is written in its own file, named after the filename
+	// template, the key by default
+	PerKey   bool   `json:"per_key,omitempty"`
+	Filename string `json:"filename,omitempty"`
+
+	// How per-key files are updated, "symlink" writes them in a new
+	// directory and replaces a symlink to it, files are updated in
+	// place by default
+	UpdateStrategy string `json:"update_strategy,omitempty"`
+
+	// Name of a glob secret, the file is expanded to one file for each
+	// secret found, the path must be a template to make them different
+	ForEach string `json:"for_each,omitempty"`
+
+	// Secret and key of the glob secret this file was expanded for
+	forEach *secretKey
+
+	// Encoding of the rendered content, it is decoded before writing
+	// it, "base64" is supported for binary files
+	Encoding string `json:"encoding,omitempty"`
+
+	// Shortcuts to add read permissions to the mode of the file
+	GroupReadable bool `json:"group_readable,omitempty"`
+	WorldReadable bool `json:"world_readable,omitempty"`
+
+	// Owner and group of the file, as names or numeric ids
+	Owner string `json:"owner,omitempty"`
+	Group string `json:"group,omitempty"`
+
+	// Mode for the parent directories created, by default derived
+	// from the mode of the file
+	DirMode FileMode `json:"dir_mode,omitempty"`
+
+	// If false, parent directories are not created and the file is
+	// not written if they don't exist, true by default
+	CreateDirs *bool `json:"create_dirs,omitempty"`
+
+	// If set, the file is written again if it is modified or removed
+	// externally
+	SelfHeal bool `json:"self_heal,omitempty"`
+
+	// If set, the file is overwritten even if it already exists and
+	// it was not written by pouch
+	Force bool `json:"force,omitempty"`
+
+	// Number of previous versions of the file kept when it is
+	// overwritten, none by default
+	Backups int `json:"backups,omitempty"`
+
+	// If set, the file is not written if its template uses missing
+	// keys or prints missing values
+	Strict bool `json:"strict,omitempty"`
+
+	// Commands run before and after writing the file locally, the
+	// file is not written if the command run before fails
+	BeforeWrite *HookConfig `json:"before_write,omitempty"`
+	AfterWrite  *HookConfig `json:"after_write,omitempty"`
+
+	// Format the rendered content must have, json, yaml or ini, and
+	// path to a JSON Schema it must match, the file is not written if
+	// they are not valid
+	Format string `json:"format,omitempty"`
+	Schema string `json:"schema,omitempty"`
+
+	// Command run on the new content before replacing the file, with
+	// %s replaced by the path of a temporary file with the content, the
+	// file is only replaced if it succeeds
+	CheckCmd string `json:"check_cmd,omitempty"`
+
+	// If set, the file is a named pipe, and the content is written
+	// each time it is opened for reading, so it is never on disk
+	FIFO bool `json:"fifo,omitempty"`
+
+	// If set, the file is never written, and its content is only served
+	// through the gRPC API
+	Served bool `json:"served,omitempty"`
+
+	// Plugin used to deliver the file instead of writing it locally
+	Plugin string `json:"plugin,omitempty"`
+
+	// Remote hosts where the file is pushed instead of writing it
+	// locally, experimental
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// FileDefaultsConfig contains the options of files that can be set for
+// all of them
+type FileDefaultsConfig struct {
+	Mode       FileMode   `json:"mode,omitempty"`
+	DirMode    FileMode   `json:"dir_mode,omitempty"`
+	CreateDirs *bool      `json:"create_dirs,omitempty"`
+	Owner      string     `json:"owner,omitempty"`
+	Group      string     `json:"group,omitempty"`
+	Notify     NotifyList `json:"notify,omitempty"`
+	Backups    int        `json:"backups,omitempty"`
+	Strict     bool       `json:"strict,omitempty"`
+}
+
+// applyFileDefaults sets the default options in files that don't set them
+func (p *Pouchfile) applyFileDefaults() {
+	d := p.FileDefaults
+	for i := range p.Files {
+		fc := &p.Files[i]
+		if fc.Mode == 0 {
+			fc.Mode = d.Mode
+		}
+		if fc.DirMode == 0 {
+			fc.DirMode = d.DirMode
+		}
+		if fc.CreateDirs == nil {
+			fc.CreateDirs = d.CreateDirs
+		}
+		if fc.Owner == "" {
+			fc.Owner = d.Owner
+		}
+		if fc.Group == "" {
+			fc.Group = d.Group
+		}
+		if fc.Notify == nil {
+			fc.Notify = d.Notify
+		}
+		if fc.Backups == 0 {
+			fc.Backups = d.Backups
+		}
+		if !fc.Strict {
+			fc.Strict = d.Strict
+		}
+	}
 }
 
 type NotifierConfig struct {
 	Command string `json:"command,omitempty"`
 	Service string `json:"service,omitempty"`
+	Plugin  string `json:"plugin,omitempty"`
+
+	// Remote host where the command is run
+	Host string `json:"host,omitempty"`
+
+	// Publish notifications in message systems
+	NATS  *NATSNotifierConfig  `json:"nats,omitempty"`
+	Kafka *KafkaNotifierConfig `json:"kafka,omitempty"`
+
+	// Restart containers or run commands inside them
+	Docker *DockerNotifierConfig `json:"docker,omitempty"`
+
+	// Restart workloads or delete pods in Kubernetes
+	Kubernetes *KubernetesNotifierConfig `json:"kubernetes,omitempty"`
+
+	// Send notifications to HTTP endpoints
+	Webhook *WebhookNotifierConfig `json:"webhook,omitempty"`
+
+	// Processes not managed by a service manager that are sent a signal,
+	// HUP by default
+	Process *ProcessConfig `json:"process,omitempty"`
+
+	// Service manager of the service, systemd or the type of the
+	// service_manager, the default one is used if not set
+	ServiceManager string `json:"service_manager,omitempty"`
+
+	// Options for service notifiers, to restart the service instead of
+	// reloading it, and to reload unit definitions before
+	Restart      bool `json:"restart,omitempty"`
+	DaemonReload bool `json:"daemon_reload,omitempty"`
+
+	// Action run on the service instead of reloading it, one of reload,
+	// restart, try-restart, reload-or-restart or try-reload-or-restart
+	Action string `json:"action,omitempty"`
+
+	// Skip notifications to services that are not active
+	SkipInactive bool `json:"skip_inactive,omitempty"`
+
+	// Signal sent to the service instead of reloading it, or to the
+	// processes or the container, e.g. HUP
+	Signal string `json:"signal,omitempty"`
+
+	Timeout string `json:"timeout,omitempty"`
+
+	// Arguments of the command, if set the command is run directly
+	// instead of with sh -c
+	Args []string `json:"args,omitempty"`
+
+	// Times a failed notification is retried, and interval between
+	// retries, 5s by default
+	Retries       int    `json:"retries,omitempty"`
+	RetryInterval string `json:"retry_interval,omitempty"`
+
+	// Conditions that must be met to run the notifier
+	When *NotifierConditions `json:"when,omitempty"`
+
+	// Notifiers that run before this one when both are triggered, e.g.
+	// to reload a backend before its frontend
+	After []string `json:"after,omitempty"`
+
+	// Time to wait since the notifier is triggered to run it, so
+	// notifications triggered during this window are coalesced
+	Debounce string `json:"debounce,omitempty"`
+
+	// If set, pouch stops when the notification fails after all its
+	// retries, failures are only logged by default
+	Fatal bool `json:"fatal,omitempty"`
+}
+
+// FileMode is a file mode in configurations, it can be a number or
+// a string with the mode in octal, e.g. "0644", YAML integers are
+// decimal unless they start with 0, what is easy to miss
+type FileMode int
+
+// MarshalJSON writes modes as octal strings
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#o", m))
+}
+
+func (m *FileMode) UnmarshalJSON(d []byte) error {
+	var s string
+	if err := json.Unmarshal(d, &s); err != nil {
+		var n int
+		err = json.Unmarshal(d, &n)
+		if err != nil {
+			return fmt.Errorf("mode must be a number or an octal string: %v", err)
+		}
+		*m = FileMode(n)
+		return nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("incorrect octal mode '%s'", s)
+	}
+	*m = FileMode(n)
+	return nil
+}
+
+const (
+	// Only notify services that are active
+	NotifyConditionActive = "active"
+)
+
+// NotifierConditions are conditions to run a notifier, all the ones set
+// must be met
+type NotifierConditions struct {
+	// Service that must be active
+	Active string `json:"active,omitempty"`
+
+	// Cron expression with the maintenance window when the notifier can
+	// run, notifications are delayed till the window starts
+	Window string `json:"window,omitempty"`
+
+	// Files, or glob patterns, whose changes run the notifier, it is
+	// skipped if triggered only by other files
+	Changed []string `json:"changed,omitempty"`
+}
+
+// NotifyConfig references a notifier from a file, optionally with
+// parameters that override the ones of the notifier for this file
+type NotifyConfig struct {
+	Notifier string `json:"notifier"`
+
+	// Signal sent to the service instead of reloading it
+	Signal string `json:"signal,omitempty"`
+
+	// Action run on the service instead of the one of the notifier
+	Action string `json:"action,omitempty"`
+
+	// Unit notified instead of the service of the notifier
+	Unit string `json:"unit,omitempty"`
 
 	Timeout string `json:"timeout,omitempty"`
+
+	// Condition to run the notifier, only "active" is supported, to
+	// skip notifications to services that are not running
+	Condition string `json:"condition,omitempty"`
+}
+
+// hasParameters returns true if the notifier is not referenced only by
+// its name
+func (n NotifyConfig) hasParameters() bool {
+	return n != NotifyConfig{Notifier: n.Notifier}
+}
+
+// MarshalJSON writes notifiers without parameters as their names
+func (n NotifyConfig) MarshalJSON() ([]byte, error) {
+	if !n.hasParameters() {
+		return json.Marshal(n.Notifier)
+	}
+	type notifyConfig NotifyConfig
+	return json.Marshal(notifyConfig(n))
+}
+
+func (n *NotifyConfig) UnmarshalJSON(d []byte) error {
+	var name string
+	if err := json.Unmarshal(d, &name); err == nil {
+		*n = NotifyConfig{Notifier: name}
+		return nil
+	}
+	type notifyConfig NotifyConfig
+	var c notifyConfig
+	err := json.Unmarshal(d, &c)
+	if err != nil {
+		return err
+	}
+	*n = NotifyConfig(c)
+	return nil
+}
+
+// NotifyList contains the notifiers of a file, they can be set by name,
+// as in version 1 of the Pouchfile, or with parameters
+type NotifyList []NotifyConfig
+
+// NotifyNames returns a list of notifiers without parameters
+func NotifyNames(names ...string) NotifyList {
+	l := make(NotifyList, len(names))
+	for i, name := range names {
+		l[i] = NotifyConfig{Notifier: name}
+	}
+	return l
+}
+
+// Names returns the names of the notifiers in the list
+func (l NotifyList) Names() []string {
+	names := make([]string, len(l))
+	for i, n := range l {
+		names[i] = n.Notifier
+	}
+	return names
+}
+
+// checkVersion1 fails if the notifiers use parameters not available in
+// version 1 of the Pouchfile
+func (l NotifyList) checkVersion1(where string) error {
+	for _, n := range l {
+		if n.hasParameters() {
+			return fmt.Errorf("parameters for notifier '%s' in %s need version %d of the Pouchfile", n.Notifier, where, PouchfileVersion2)
+		}
+	}
+	return nil
+}
+
+// migrate converts configurations of previous versions to the current one
+func (p *Pouchfile) migrate() error {
+	switch p.Version {
+	case 0, PouchfileVersion1:
+		// Notifiers referenced by name are already converted when
+		// parsed, parameters are not accepted
+		err := p.FileDefaults.Notify.checkVersion1("file defaults")
+		if err != nil {
+			return err
+		}
+		for _, fc := range p.Files {
+			err := fc.Notify.checkVersion1("file '" + fc.Path + "'")
+			if err != nil {
+				return err
+			}
+		}
+	case PouchfileVersion2:
+	default:
+		return fmt.Errorf("unsupported Pouchfile version: %d", p.Version)
+	}
+	p.Version = CurrentPouchfileVersion
+	return nil
 }
 
 func LoadPouchfile(path string) (*Pouchfile, error) {
@@ -98,5 +577,15 @@ func loadPouchfile(r io.Reader) (*Pouchfile, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = p.migrate()
+	if err != nil {
+		return nil, err
+	}
+	// Defaults are applied before adding files generated for systemd
+	p.applyFileDefaults()
+	err = p.expandSystemdEnvironment()
+	if err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
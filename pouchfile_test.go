@@ -17,8 +17,13 @@ limitations under the License.
 package pouch
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 var casePouchfiles = []string{
@@ -128,3 +133,187 @@ func TestWrongPouchfile(t *testing.T) {
 		t.Fatal("Pouchfile load should have failed")
 	}
 }
+
+var systemdEnvironmentPouchfile = `
+systemd:
+  units_path: /etc/systemd/system
+  environment:
+  - unit: app.service
+    secret: app
+  - unit: other.service
+    secret: other
+    environment_file: /run/other.env
+    restart: false
+`
+
+func TestSystemdEnvironmentPouchfile(t *testing.T) {
+	p, err := loadPouchfile(strings.NewReader(systemdEnvironmentPouchfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, p.Files, 3)
+	assert.Equal(t, "/etc/systemd/system/app.service.d/pouch-environment.conf", p.Files[0].Path)
+	assert.Equal(t, SystemdEnvironmentEngine, p.Files[0].Engine)
+	assert.Equal(t, []string{"app"}, p.Files[0].Secrets)
+	assert.Equal(t, "/run/other.env", p.Files[1].Path)
+	assert.Equal(t, EnvironmentFileEngine, p.Files[1].Engine)
+	assert.Equal(t, "/etc/systemd/system/other.service.d/pouch-environment.conf", p.Files[2].Path)
+	assert.Contains(t, p.Files[2].Template, "EnvironmentFile=/run/other.env")
+
+	assert.Equal(t, NotifierConfig{Service: "app.service", Restart: true, DaemonReload: true},
+		p.Notifiers["systemd-environment:app.service"])
+	assert.Equal(t, NotifierConfig{DaemonReload: true},
+		p.Notifiers["systemd-environment:other.service"])
+}
+
+func TestEnvironmentEngines(t *testing.T) {
+	ctx := &RenderContext{
+		Secret: func(string) (SecretData, error) {
+			return SecretData{"password": `a"b%c`, "db-user": "foo"}, nil
+		},
+		Secrets: []string{"app"},
+	}
+
+	e, _ := getTemplateEngine(SystemdEnvironmentEngine)
+	content, err := e.Render("dropin", "", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, dropInHeader+"[Service]\n"+
+		"Environment=\"DB_USER=foo\"\n"+
+		"Environment=\"PASSWORD=a\\\"b%%c\"\n", content)
+
+	e, _ = getTemplateEngine(EnvironmentFileEngine)
+	content, err = e.Render("env", `{"APP_PASSWORD": "password"}`, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, dropInHeader+"APP_PASSWORD=\"a\\\"b%c\"\n", content)
+
+	_, err = e.Render("env", `{"APP_PASSWORD": "unknown"}`, ctx)
+	assert.Error(t, err)
+
+	e, _ = getTemplateEngine(DockerEnvFileEngine)
+	content, err = e.Render("env", "", ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, dropInHeader+"DB_USER=foo\nPASSWORD=a\"b%c\n", content)
+
+	ctx.Secret = func(string) (SecretData, error) {
+		return SecretData{"key": "a\nb$c"}, nil
+	}
+	_, err = e.Render("env", "", ctx)
+	assert.Error(t, err)
+
+	// Template is optional for environment engines
+	content, err = getFileContent(FileConfig{Path: "app.env", Engine: DotenvEngine}, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, dropInHeader+`KEY="a\nb\$c"`+"\n", content)
+}
+
+var notifyPouchfile = `
+version: %d
+notifiers:
+  nginx:
+    service: nginx.service
+files:
+- path: /etc/nginx/ssl/server.key
+  notify:
+  - nginx
+  - notifier: nginx
+    signal: HUP
+    unit: nginx-internal.service
+    timeout: 10s
+    condition: active
+`
+
+func TestPouchfileVersions(t *testing.T) {
+	p, err := loadPouchfile(strings.NewReader(casePouchfiles[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, CurrentPouchfileVersion, p.Version)
+
+	p, err = loadPouchfile(strings.NewReader(fmt.Sprintf(notifyPouchfile, PouchfileVersion2)))
+	if assert.NoError(t, err) {
+		assert.Equal(t, NotifyList{
+			{Notifier: "nginx"},
+			{
+				Notifier:  "nginx",
+				Signal:    "HUP",
+				Unit:      "nginx-internal.service",
+				Timeout:   "10s",
+				Condition: NotifyConditionActive,
+			},
+		}, p.Files[0].Notify)
+	}
+
+	_, err = loadPouchfile(strings.NewReader(fmt.Sprintf(notifyPouchfile, PouchfileVersion1)))
+	assert.Error(t, err)
+
+	_, err = loadPouchfile(strings.NewReader(fmt.Sprintf(notifyPouchfile, 3)))
+	assert.Error(t, err)
+}
+
+func TestNotifyListMarshal(t *testing.T) {
+	d, err := json.Marshal(NotifyList{{Notifier: "foo"}, {Notifier: "bar", Signal: "HUP"}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `["foo", {"notifier": "bar", "signal": "HUP"}]`, string(d))
+}
+
+var modesPouchfile = `
+files:
+- path: /etc/foo
+  mode: "0640"
+  dir_mode: "750"
+- path: /etc/bar
+  mode: 0640
+- path: /etc/baz
+  mode: 416
+- path: /etc/qux
+  group_readable: true
+`
+
+func TestPouchfileModes(t *testing.T) {
+	p, err := loadPouchfile(strings.NewReader(modesPouchfile))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, FileMode(0640), p.Files[0].Mode)
+	assert.Equal(t, FileMode(0750), p.Files[0].DirMode)
+	assert.Equal(t, FileMode(0640), p.Files[1].Mode)
+	assert.Equal(t, FileMode(0640), p.Files[2].Mode)
+	assert.Equal(t, os.FileMode(0640), p.Files[3].FileMode())
+
+	_, err = loadPouchfile(strings.NewReader("files:\n- path: /etc/foo\n  mode: \"rw-r-----\"\n"))
+	assert.Error(t, err)
+
+	d, err := json.Marshal(FileConfig{Mode: 0644})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mode": "0644"}`, string(d))
+}
+
+var fileDefaultsPouchfile = `
+file_defaults:
+  mode: "0640"
+  owner: root
+  group: app
+  notify:
+  - app
+files:
+- path: /etc/app/foo
+- path: /etc/app/bar
+  mode: "0600"
+  group: other
+  notify: []
+`
+
+func TestFileDefaults(t *testing.T) {
+	p, err := loadPouchfile(strings.NewReader(fileDefaultsPouchfile))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, FileMode(0640), p.Files[0].Mode)
+	assert.Equal(t, "root", p.Files[0].Owner)
+	assert.Equal(t, "app", p.Files[0].Group)
+	assert.Equal(t, NotifyNames("app"), p.Files[0].Notify)
+
+	assert.Equal(t, FileMode(0600), p.Files[1].Mode)
+	assert.Equal(t, "root", p.Files[1].Owner)
+	assert.Equal(t, "other", p.Files[1].Group)
+	assert.Empty(t, p.Files[1].Notify)
+}
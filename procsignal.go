@@ -0,0 +1,157 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// Signal sent to processes if none is configured
+	DefaultProcessSignal = "HUP"
+
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// ProcessConfig identifies processes not managed by a service manager,
+// one of its options must be set
+type ProcessConfig struct {
+	// File with the pid of the process
+	Pidfile string `json:"pidfile,omitempty"`
+
+	// Regular expression matched against the command line of running
+	// processes, as in pgrep -f
+	Pattern string `json:"pattern,omitempty"`
+
+	// Cgroup whose processes are signaled, absolute or relative to
+	// /sys/fs/cgroup
+	Cgroup string `json:"cgroup,omitempty"`
+}
+
+// ProcessNotifier sends a signal to processes, for daemons that reload
+// their configuration on signals
+type ProcessNotifier struct {
+	Config ProcessConfig
+	Signal string
+}
+
+func readPidfile(path string) ([]int, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil {
+		return nil, fmt.Errorf("incorrect pid in %s: %v", path, err)
+	}
+	return []int{pid}, nil
+}
+
+// matchingPids returns the pids of the processes whose command lines
+// match a pattern, pouch itself excluded
+func matchingPids(pattern string) ([]int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect process pattern: %v", err)
+	}
+	paths, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, path := range paths {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		d, err := ioutil.ReadFile(path)
+		if err != nil || len(d) == 0 {
+			// Finished processes or kernel threads
+			continue
+		}
+		cmdline := strings.TrimSpace(strings.Replace(string(d), "\x00", " ", -1))
+		if re.MatchString(cmdline) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// cgroupPids returns the pids of the processes in a cgroup
+func cgroupPids(cgroup string) ([]int, error) {
+	if !filepath.IsAbs(cgroup) {
+		cgroup = filepath.Join(cgroupRoot, cgroup)
+	}
+	d, err := ioutil.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(d)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect pid in cgroup %s: %v", cgroup, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func (c ProcessConfig) pids() ([]int, error) {
+	switch {
+	case c.Pidfile != "" && c.Pattern == "" && c.Cgroup == "":
+		return readPidfile(c.Pidfile)
+	case c.Pattern != "" && c.Pidfile == "" && c.Cgroup == "":
+		return matchingPids(c.Pattern)
+	case c.Cgroup != "" && c.Pidfile == "" && c.Pattern == "":
+		return cgroupPids(c.Cgroup)
+	}
+	return nil, fmt.Errorf("one and only one of pidfile, pattern or cgroup must be set")
+}
+
+func (n *ProcessNotifier) Run(ctx context.Context) (string, error) {
+	name := n.Signal
+	if name == "" {
+		name = DefaultProcessSignal
+	}
+	signal, err := parseSignal(name)
+	if err != nil {
+		return "", err
+	}
+	pids, err := n.Config.pids()
+	if err != nil {
+		return "", err
+	}
+	if len(pids) == 0 {
+		return "", fmt.Errorf("no process found to send signal")
+	}
+	for _, pid := range pids {
+		err := syscall.Kill(pid, signal)
+		if err != nil {
+			return "", fmt.Errorf("couldn't send signal to process %d: %v", pid, err)
+		}
+	}
+	return fmt.Sprintf("Signal %s sent to %d processes", name, len(pids)), nil
+}
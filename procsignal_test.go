@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessNotifier(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	received := path.Join(tmpdir, "received")
+	marker := "pouch-process-notifier-test"
+	cmd := exec.Command("sh", "-c", `trap "echo signal >> `+received+`" USR1; while true; do sleep 0.01; done`, marker)
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	// Give time to the shell to set the trap
+	time.Sleep(100 * time.Millisecond)
+
+	pidfile := path.Join(tmpdir, "pid")
+	ioutil.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0600)
+	cgroup := path.Join(tmpdir, "cgroup")
+	os.Mkdir(cgroup, 0700)
+	ioutil.WriteFile(path.Join(cgroup, "cgroup.procs"), []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0600)
+
+	for i, c := range []ProcessConfig{
+		{Pidfile: pidfile},
+		{Pattern: marker},
+		{Cgroup: cgroup},
+	} {
+		n := &ProcessNotifier{Config: c, Signal: "USR1"}
+		_, err := n.Run(context.Background())
+		assert.NoError(t, err)
+
+		expected := strings.Repeat("signal\n", i+1)
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+			d, _ := ioutil.ReadFile(received)
+			if string(d) == expected {
+				break
+			}
+		}
+		d, _ := ioutil.ReadFile(received)
+		assert.Equal(t, expected, string(d))
+	}
+
+	for _, c := range []ProcessConfig{
+		{},
+		{Pidfile: pidfile, Pattern: marker},
+		{Pattern: "pouch-no-process-matches-this"},
+		{Pidfile: path.Join(tmpdir, "unknown")},
+	} {
+		n := &ProcessNotifier{Config: c, Signal: "USR1"}
+		_, err := n.Run(context.Background())
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const DefaultProvenanceMode = os.FileMode(0644)
+
+type ProvenanceConfig struct {
+	// Path to a file where the provenance of all files is written
+	Path string `json:"path,omitempty"`
+}
+
+// SecretProvenance identifies the read of a secret used to render a file
+type SecretProvenance struct {
+	Name     string    `json:"name"`
+	Source   string    `json:"source,omitempty"`
+	ReadTime time.Time `json:"read_time"`
+	LeaseID  string    `json:"lease_id,omitempty"`
+	Version  int       `json:"version,omitempty"`
+}
+
+// FileProvenance records which secret reads produced the current content
+// of a file, so any file can be tied back to specific reads
+type FileProvenance struct {
+	Path      string             `json:"path"`
+	SHA256    string             `json:"sha256"`
+	WriteTime time.Time          `json:"write_time"`
+	Secrets   []SecretProvenance `json:"secrets"`
+}
+
+// contentChecksum returns the hex-encoded SHA256 of the content of a file
+func contentChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *pouch) ProvenanceFile(path string) {
+	p.provenancePath = path
+}
+
+// recordProvenance stores the provenance of a file just written with the
+// secrets used to render it
+func (p *pouch) recordProvenance(path, content string, secrets []string) {
+	f := &FileProvenance{
+		Path:      path,
+		SHA256:    contentChecksum(content),
+		WriteTime: time.Now(),
+		Secrets:   []SecretProvenance{},
+	}
+	sort.Strings(secrets)
+	for _, name := range secrets {
+		s, found := p.State.Secrets[name]
+		if !found {
+			continue
+		}
+		f.Secrets = append(f.Secrets, SecretProvenance{
+			Name:     name,
+			Source:   p.Secrets[name].VaultURL,
+			ReadTime: s.Timestamp,
+			LeaseID:  s.LeaseID,
+			Version:  s.Version,
+		})
+	}
+
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	if p.provenance == nil {
+		p.provenance = make(map[string]*FileProvenance)
+	}
+	p.provenance[path] = f
+}
+
+// Provenance returns the provenance of the files written, sorted by path
+func (p *pouch) Provenance() []FileProvenance {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	files := make([]FileProvenance, 0, len(p.provenance))
+	for _, f := range p.provenance {
+		files = append(files, *f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// writeProvenance atomically replaces the provenance file, if configured
+func (p *pouch) writeProvenance() {
+	if p.provenancePath == "" {
+		return
+	}
+	err := writeJSONFile(p.provenancePath, p.Provenance())
+	if err != nil {
+		errorf("Couldn't write provenance to %s: %v", p.provenancePath, err)
+	}
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	d, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(d)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), DefaultProvenanceMode)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *StatusServer) provenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.pouch.Provenance())
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProvenance(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"db":     {VaultURL: "/v1/database/creds/app"},
+		"unused": {VaultURL: "/v1/secret/unused"},
+	}
+	p := NewPouch(NewState(""), nil, secrets, nil, nil).(*pouch)
+	p.State.SetSecret("db", &api.Secret{LeaseID: "database/creds/app/1234", Data: map[string]interface{}{"password": "secret"}})
+	p.State.SetSecret("unused", &api.Secret{Data: map[string]interface{}{"foo": "bar"}})
+
+	filePath := path.Join(tmpdir, "db.conf")
+	err = p.resolveFile(FileConfig{Path: filePath, Template: `password={{ secret "db" "password" }}`})
+	assert.NoError(t, err)
+
+	provenance := p.Provenance()
+	if assert.Len(t, provenance, 1) {
+		f := provenance[0]
+		assert.Equal(t, filePath, f.Path)
+		sum := sha256.Sum256([]byte("password=secret"))
+		assert.Equal(t, hex.EncodeToString(sum[:]), f.SHA256)
+		if assert.Len(t, f.Secrets, 1) {
+			assert.Equal(t, "db", f.Secrets[0].Name)
+			assert.Equal(t, "/v1/database/creds/app", f.Secrets[0].Source)
+			assert.Equal(t, "database/creds/app/1234", f.Secrets[0].LeaseID)
+		}
+	}
+
+	p.ProvenanceFile(path.Join(tmpdir, "provenance.json"))
+	p.writeProvenance()
+	d, err := ioutil.ReadFile(path.Join(tmpdir, "provenance.json"))
+	assert.NoError(t, err)
+	var written []FileProvenance
+	assert.NoError(t, json.Unmarshal(d, &written))
+	assert.Equal(t, provenance[0].SHA256, written[0].SHA256)
+
+	s := NewStatusServer("", p)
+	w := httptest.NewRecorder()
+	s.provenance(w, httptest.NewRequest("GET", "/provenance", nil))
+	assert.Contains(t, w.Body.String(), `"lease_id":"database/creds/app/1234"`)
+	assert.NotContains(t, w.Body.String(), "secret\"")
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	// Provider used for secrets whose URL has no scheme
+	VaultProvider = "vault"
+
+	providerSchemeSeparator = "://"
+)
+
+// SecretProvider is a source of secrets. Secrets are requested with paths
+// whose meaning depends on the provider. Errors with a response with a
+// 4xx status code are not retried.
+type SecretProvider interface {
+	Login() error
+	Request(method, path string, options *vault.RequestOptions) (*api.Secret, *api.Response, error)
+
+	// Renew extends the lease of a secret, if supported
+	Renew(leaseID string, increment int) (*api.Secret, error)
+}
+
+// SecretProviderFactory creates a provider from its configuration, in JSON
+type SecretProviderFactory func(config json.RawMessage) (SecretProvider, error)
+
+var (
+	secretProvidersLock sync.RWMutex
+	secretProviders     = make(map[string]SecretProviderFactory)
+)
+
+// RegisterSecretProvider makes a secret provider available with a name,
+// secrets can use it with URLs with this name as scheme, e.g. name://path
+func RegisterSecretProvider(name string, f SecretProviderFactory) {
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+	secretProviders[name] = f
+}
+
+// NewSecretProvider creates a registered secret provider
+func NewSecretProvider(name string, config json.RawMessage) (SecretProvider, error) {
+	secretProvidersLock.RLock()
+	f, found := secretProviders[name]
+	secretProvidersLock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown secret provider '%s', available: %v", name, secretProviderNames())
+	}
+	return f(config)
+}
+
+func secretProviderNames() []string {
+	secretProvidersLock.RLock()
+	defer secretProvidersLock.RUnlock()
+	var names []string
+	for name := range secretProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitProviderURL returns the provider and the path of a secret URL
+func splitProviderURL(url string) (provider, path string) {
+	parts := strings.SplitN(url, providerSchemeSeparator, 2)
+	if len(parts) != 2 {
+		return VaultProvider, url
+	}
+	return parts[0], parts[1]
+}
+
+// Provider returns the name of the provider of the secret
+func (c SecretConfig) Provider() string {
+	provider, _ := splitProviderURL(c.VaultURL)
+	return provider
+}
+
+// renewSecret tries to extend the lease of a secret, so it doesn't need
+// to be requested again, it returns true if it was renewed
+func (p *pouch) renewSecret(name string) bool {
+	state, found := p.State.Secrets[name]
+	if !found || !state.Renewable || state.LeaseID == "" {
+		return false
+	}
+	provider, _, err := p.provider(p.Secrets[name])
+	if err != nil {
+		return false
+	}
+	s, err := provider.Renew(state.LeaseID, state.LeaseDuration)
+	if err != nil {
+		warnf("Couldn't renew lease of secret '%s', it will be requested again: %v", name, err)
+		return false
+	}
+	if s.LeaseDuration <= 0 {
+		return false
+	}
+	p.State.RenewSecret(name, s)
+	infof("Lease of secret '%s' renewed for %ds", name, s.LeaseDuration)
+	return true
+}
+
+func (p *pouch) AddSecretProvider(name string, provider SecretProvider) {
+	if p.providers == nil {
+		p.providers = make(map[string]SecretProvider)
+	}
+	p.providers[name] = provider
+}
+
+// provider returns the provider for a secret, and the path to request
+func (p *pouch) provider(c SecretConfig) (SecretProvider, string, error) {
+	name, path := splitProviderURL(c.VaultURL)
+	if name == VaultProvider {
+		return p.Vault, path, nil
+	}
+	provider, found := p.providers[name]
+	if !found {
+		// Providers without configuration are created when used
+		var err error
+		provider, err = NewSecretProvider(name, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		err = provider.Login()
+		if err != nil {
+			return nil, "", fmt.Errorf("couldn't login in provider '%s': %v", name, err)
+		}
+		p.AddSecretProvider(name, provider)
+	}
+	return provider, path, nil
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/nats"
+)
+
+const (
+	KafkaContentType = "application/vnd.kafka.json.v2+json"
+)
+
+// RotationEvent is published by notifiers that send messages to other
+// systems, so they can react when files are updated
+type RotationEvent struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Notifier string    `json:"notifier"`
+	Files    []string  `json:"files,omitempty"`
+}
+
+func newRotationEvent(notifier string, files []string) RotationEvent {
+	host, _ := os.Hostname()
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	return RotationEvent{
+		Time:     time.Now().UTC(),
+		Host:     host,
+		Notifier: notifier,
+		Files:    sorted,
+	}
+}
+
+type NATSNotifierConfig struct {
+	// URL of the NATS server, as nats://[user[:password]@]host[:port]
+	URL     string `json:"url,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// NATSNotifier publishes rotation events in a NATS subject
+type NATSNotifier struct {
+	Config NATSNotifierConfig
+	Event  RotationEvent
+}
+
+func (n *NATSNotifier) Run(ctx context.Context) (string, error) {
+	payload, err := json.Marshal(n.Event)
+	if err != nil {
+		return "", err
+	}
+	p := &nats.Publisher{URL: n.Config.URL, Name: "pouch"}
+	return "", p.Publish(ctx, n.Config.Subject, payload)
+}
+
+type KafkaNotifierConfig struct {
+	// URL of a Kafka REST Proxy
+	RESTProxyURL string `json:"rest_proxy_url,omitempty"`
+	Topic        string `json:"topic,omitempty"`
+}
+
+// KafkaNotifier publishes rotation events in a Kafka topic, through a
+// REST Proxy, using the host as key
+type KafkaNotifier struct {
+	Config KafkaNotifierConfig
+	Event  RotationEvent
+}
+
+type kafkaRecord struct {
+	Key   string        `json:"key"`
+	Value RotationEvent `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (n *KafkaNotifier) Run(ctx context.Context) (string, error) {
+	if n.Config.RESTProxyURL == "" || n.Config.Topic == "" {
+		return "", fmt.Errorf("REST proxy URL and topic are required for kafka notifier")
+	}
+	body, err := json.Marshal(kafkaRecords{
+		Records: []kafkaRecord{{Key: n.Event.Host, Value: n.Event}},
+	})
+	if err != nil {
+		return "", err
+	}
+	u := strings.TrimSuffix(n.Config.RESTProxyURL, "/") + "/topics/" + url.PathEscape(n.Config.Topic)
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", KafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return string(out), fmt.Errorf("kafka REST proxy replied with status %d", resp.StatusCode)
+	}
+
+	// Records can fail even if the request succeeded
+	var offsets kafkaOffsets
+	if err := json.Unmarshal(out, &offsets); err == nil {
+		for _, o := range offsets.Offsets {
+			if o.ErrorCode != nil {
+				return string(out), fmt.Errorf("kafka couldn't store record: %s", o.Error)
+			}
+		}
+	}
+	return string(out), nil
+}
+
+type WebhookNotifierConfig struct {
+	URL string `json:"url,omitempty"`
+
+	// HTTP method, POST by default
+	Method string `json:"method,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Template of the body, it receives the rotation event, the event
+	// encoded in JSON is sent by default
+	Body string `json:"body,omitempty"`
+}
+
+// WebhookNotifier sends rotation events to an HTTP endpoint, e.g. admin
+// endpoints of services that reload their configuration
+type WebhookNotifier struct {
+	Config WebhookNotifierConfig
+	Event  RotationEvent
+}
+
+// body renders the body of a request with the given data, that is
+// encoded in JSON if there is no template
+func (c WebhookNotifierConfig) body(data interface{}) ([]byte, error) {
+	if c.Body == "" {
+		return json.Marshal(data)
+	}
+	t, err := template.New("webhook").Funcs(sprigFuncMap).Parse(c.Body)
+	if err != nil {
+		return nil, fmt.Errorf("incorrect webhook body: %v", err)
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// send sends a request to the webhook with the given data
+func (c WebhookNotifierConfig) send(ctx context.Context, data interface{}) (string, error) {
+	if c.URL == "" {
+		return "", fmt.Errorf("URL is required for webhooks")
+	}
+	method := c.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	body, err := c.body(data)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(method, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if c.Body == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return string(out), fmt.Errorf("webhook replied with status %d", resp.StatusCode)
+	}
+	return string(out), nil
+}
+
+func (n *WebhookNotifier) Run(ctx context.Context) (string, error) {
+	return n.Config.send(ctx, n.Event)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaNotifier(t *testing.T) {
+	var received kafkaRecords
+	reply := `{"offsets":[{"partition":0,"offset":1}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/rotations", r.URL.Path)
+		assert.Equal(t, KafkaContentType, r.Header.Get("Content-Type"))
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(reply))
+	}))
+	defer server.Close()
+
+	n := &KafkaNotifier{
+		Config: KafkaNotifierConfig{RESTProxyURL: server.URL, Topic: "rotations"},
+		Event:  newRotationEvent("kafka", []string{"/b", "/a"}),
+	}
+	_, err := n.Run(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, received.Records, 1) {
+		assert.Equal(t, "kafka", received.Records[0].Value.Notifier)
+		assert.Equal(t, []string{"/a", "/b"}, received.Records[0].Value.Files)
+	}
+
+	reply = `{"offsets":[{"error_code":40402,"error":"unknown topic"}]}`
+	_, err = n.Run(context.Background())
+	assert.Error(t, err)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var body, method, auth string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, method, auth = string(b), r.Method, r.Header.Get("Authorization")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n := &WebhookNotifier{
+		Config: WebhookNotifierConfig{URL: server.URL},
+		Event:  newRotationEvent("webhook", []string{"/b", "/a"}),
+	}
+	_, err := n.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPost, method)
+	var event RotationEvent
+	assert.NoError(t, json.Unmarshal([]byte(body), &event))
+	assert.Equal(t, []string{"/a", "/b"}, event.Files)
+
+	n.Config.Method = http.MethodPut
+	n.Config.Headers = map[string]string{"Authorization": "Bearer token"}
+	n.Config.Body = `reload {{ join "," .Files }}`
+	_, err = n.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, "reload /a,/b", body)
+
+	status = http.StatusInternalServerError
+	_, err = n.Run(context.Background())
+	assert.Error(t, err)
+}
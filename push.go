@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/remote"
+)
+
+// AddHost makes a remote host available to push files and to run
+// notifiers
+func (p *pouch) AddHost(h *remote.Host) {
+	if p.hosts == nil {
+		p.hosts = make(map[string]*remote.Host)
+	}
+	p.hosts[h.Name] = h
+}
+
+func (p *pouch) host(name string) (*remote.Host, error) {
+	h, found := p.hosts[name]
+	if !found {
+		return nil, fmt.Errorf("unknown host: %s", name)
+	}
+	return h, nil
+}
+
+// pushFile copies a file to the remote hosts in its configuration, it is
+// tried in all of them even if some fails
+func (p *pouch) pushFile(fc FileConfig, mode os.FileMode, content string) error {
+	var failed []string
+	for _, name := range fc.Hosts {
+		h, err := p.host(name)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultNotifyTimeout)
+			err = h.Copy(ctx, fc.Path, mode, []byte(content))
+			cancel()
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("couldn't push '%s' to %s", fc.Path, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RemoteCommandNotifier runs a command in a remote host, e.g. after files
+// have been pushed to it
+type RemoteCommandNotifier struct {
+	Host    *remote.Host
+	Command string
+}
+
+func (n *RemoteCommandNotifier) Run(ctx context.Context) (string, error) {
+	return n.Host.Run(ctx, n.Command)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// RedactedValue replaces secret values found in logs and errors
+	RedactedValue = "[REDACTED]"
+
+	// Values shorter than this are not redacted, as they would match
+	// too much unrelated text
+	minRedactedLength = 6
+)
+
+// redactor keeps the secret values known by pouch, to scrub them from
+// any text that is going to be logged or exposed
+type redactor struct {
+	sync.RWMutex
+	values   map[string]struct{}
+	replacer *strings.Replacer
+}
+
+var secretRedactor = &redactor{}
+
+// Add registers the values found in some secret data
+func (r *redactor) Add(data SecretData) {
+	var values []string
+	collectRedactedValues(map[string]interface{}(data), &values)
+	r.AddValues(values...)
+}
+
+// AddValues registers values to be redacted
+func (r *redactor) AddValues(values ...string) {
+	r.Lock()
+	defer r.Unlock()
+	added := false
+	for _, v := range values {
+		if len(v) < minRedactedLength {
+			continue
+		}
+		if r.values == nil {
+			r.values = make(map[string]struct{})
+		}
+		if _, found := r.values[v]; !found {
+			r.values[v] = struct{}{}
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// Longest values first, so values containing others are fully redacted
+	sorted := make([]string, 0, len(r.values))
+	for v := range r.values {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		pairs = append(pairs, v, RedactedValue)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces known secret values in s
+func (r *redactor) Redact(s string) string {
+	r.RLock()
+	defer r.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+func collectRedactedValues(v interface{}, values *[]string) {
+	switch v := v.(type) {
+	case string:
+		*values = append(*values, v)
+		// Errors can quote only a part of multi-line values, as
+		// certificates or keys
+		if strings.Contains(v, "\n") {
+			for _, line := range strings.Split(v, "\n") {
+				line = strings.TrimSpace(line)
+				if strings.HasPrefix(line, "-----") {
+					// PEM boundaries
+					continue
+				}
+				*values = append(*values, line)
+			}
+		}
+	case map[string]interface{}:
+		for _, value := range v {
+			collectRedactedValues(value, values)
+		}
+	case []interface{}:
+		for _, value := range v {
+			collectRedactedValues(value, values)
+		}
+	}
+}
+
+func redactSecrets(s string) string {
+	return secretRedactor.Redact(s)
+}
+
+func redactFields(fields LogFields) LogFields {
+	if len(fields) == 0 {
+		return fields
+	}
+	redacted := make(LogFields, len(fields))
+	for name, value := range fields {
+		if s, ok := value.(string); ok {
+			value = redactSecrets(s)
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/vault/api"
+)
+
+// LoadFixtures reads secrets from a JSON or YAML file, with the data of
+// each secret under its name, and sets them in the state, so files can be
+// rendered without reading them
+func LoadFixtures(state *PouchState, path string) error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fixtures map[string]SecretData
+	err = yaml.Unmarshal(d, &fixtures)
+	if err != nil {
+		return fmt.Errorf("incorrect fixtures in %s: %v", path, err)
+	}
+	for name, data := range fixtures {
+		state.SetSecret(name, &api.Secret{Data: data})
+	}
+	return nil
+}
+
+// Render returns the content of a configured file rendered with the
+// secrets in the state, without reading secrets nor writing the file,
+// so templates can be tested offline
+func (p *pouch) Render(path string) (string, error) {
+	err := p.discoverSecrets()
+	if err != nil {
+		return "", err
+	}
+	content, _, err := p.renderFile(path)
+	return content, err
+}
+
+// renderFile returns the content of a configured file rendered with the
+// secrets in the state, and the secrets used
+func (p *pouch) renderFile(path string) (string, map[string]bool, error) {
+	fc, found := p.Files[path]
+	if !found {
+		return "", nil, fmt.Errorf("file '%s' not found in configuration", path)
+	}
+	if fc.PerKey {
+		return "", nil, fmt.Errorf("file '%s' is a directory of per-key files", path)
+	}
+	if fc.ForEach != "" {
+		return "", nil, fmt.Errorf("file '%s' is expanded for each secret of '%s'", path, fc.ForEach)
+	}
+	used := make(map[string]bool)
+	content, err := getFileContent(fc, p.renderContext(fc, used))
+	return content, used, err
+}
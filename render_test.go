@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderWithFixtures(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fixtures := path.Join(tmpdir, "fixtures.json")
+	ioutil.WriteFile(fixtures, []byte(`{"app": {"password": "secret"}, "secret/db": {"password": "dbsecret"}}`), 0600)
+	state := NewState("")
+	assert.NoError(t, LoadFixtures(state, fixtures))
+
+	secrets := map[string]SecretConfig{
+		"app": {VaultURL: "/v1/secret/app"},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "app"), Template: `{{ secret "app" "password" }} {{ secret "secret/db" "password" }}`},
+		{Path: path.Join(tmpdir, "consul"), Engine: ConsulTemplateEngine, Template: `{{ with secret "secret/app" }}{{ .Data.password }}{{ end }}`},
+		{Path: path.Join(tmpdir, "keys"), PerKey: true},
+	}
+	p := NewPouch(state, nil, secrets, files, nil)
+
+	content, err := p.Render(path.Join(tmpdir, "app"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret dbsecret", content)
+	content, err = p.Render(path.Join(tmpdir, "consul"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", content)
+
+	_, err = p.Render(path.Join(tmpdir, "keys"))
+	assert.Error(t, err)
+	_, err = p.Render(path.Join(tmpdir, "unknown"))
+	assert.Error(t, err)
+
+	// Nothing is written
+	_, err = os.Stat(path.Join(tmpdir, "app"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Path of the endpoint of standby instances receiving the state
+	ReplicationEndpoint = "/v1/state"
+
+	DefaultReplicationInterval = time.Minute
+
+	// States bigger than this are rejected
+	maxReplicatedStateSize = 64 * 1024 * 1024
+
+	replicationRequestTimeout = 30 * time.Second
+)
+
+// ReplicationConfig configures the replication of the state between
+// instances in different hosts, so a standby can take over with the same
+// token and leases. Connections use TLS, with certificates of both sides
+// verified with the same CA.
+type ReplicationConfig struct {
+	// Address where a standby instance listens for states
+	Listen string `json:"listen,omitempty"`
+
+	// Addresses of standby instances where the active instance sends
+	// its state
+	Peers []string `json:"peers,omitempty"`
+
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	CAFile   string `json:"ca_file,omitempty"`
+
+	// Interval to send the state even if it hasn't changed, one minute
+	// by default
+	Interval string `json:"interval,omitempty"`
+
+	// If set, a standby instance takes over when it doesn't receive the
+	// state in this time
+	FailoverTimeout string `json:"failover_timeout,omitempty"`
+}
+
+func (c ReplicationConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" || c.CAFile == "" {
+		return nil, fmt.Errorf("certificate, key and CA needed for replication")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+func parsePositiveDuration(what, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect %s: %v", what, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", what)
+	}
+	return d, nil
+}
+
+// stateReplicator sends the state to standby instances
+type stateReplicator struct {
+	peers    []string
+	interval time.Duration
+	client   *http.Client
+
+	// Signals that the state has been saved
+	saved chan struct{}
+}
+
+// ReplicateState configures the peers where the state is sent
+func (p *pouch) ReplicateState(c ReplicationConfig) error {
+	if len(c.Peers) == 0 {
+		return fmt.Errorf("no peers to replicate the state")
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	interval, err := parsePositiveDuration("replication interval", c.Interval, DefaultReplicationInterval)
+	if err != nil {
+		return err
+	}
+	p.replicator = &stateReplicator{
+		peers:    c.Peers,
+		interval: interval,
+		client: &http.Client{
+			Timeout:   replicationRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		saved: make(chan struct{}, 1),
+	}
+	return nil
+}
+
+// saveState saves the state and sends it to the standby instances
+func (p *pouch) saveState() error {
+	if p.dryRun {
+		// Secrets read in dry runs are not kept
+		return nil
+	}
+	err := p.State.Save()
+	if err != nil {
+		return err
+	}
+	if p.replicator != nil {
+		select {
+		case p.replicator.saved <- struct{}{}:
+		default:
+			// Already pending
+		}
+	}
+	return nil
+}
+
+func (r *stateReplicator) send(peer string, d []byte) error {
+	url := "https://" + peer + ReplicationEndpoint
+	resp, err := r.client.Post(url, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("peer replied with status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// replicate sends the state read from its path when it is saved, and
+// periodically, so standby instances know that this one is alive
+func (r *stateReplicator) replicate(ctx context.Context, path string) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	failing := make(map[string]bool)
+	for {
+		select {
+		case <-r.saved:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		d, err := ioutil.ReadFile(path)
+		if err != nil {
+			errorf("Couldn't read state to replicate: %v", err)
+			continue
+		}
+		for _, peer := range r.peers {
+			err := r.send(peer, d)
+			switch {
+			case err != nil && !failing[peer]:
+				errorf("Couldn't replicate state to %s: %v", peer, err)
+				failing[peer] = true
+			case err == nil && failing[peer]:
+				infof("Replicating state to %s again", peer)
+				failing[peer] = false
+			}
+		}
+	}
+}
+
+func (p *pouch) startReplication(ctx context.Context) {
+	if p.replicator == nil {
+		return
+	}
+	path := p.State.Path
+	if path == "" {
+		path = DefaultStatePath
+	}
+	go p.replicator.replicate(ctx, path)
+}
+
+// stateReceiver stores the states received from the active instance
+type stateReceiver struct {
+	sync.Mutex
+
+	state    *PouchState
+	received chan struct{}
+}
+
+func (r *stateReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != ReplicationEndpoint {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var received PouchState
+	err := json.NewDecoder(io.LimitReader(req.Body, maxReplicatedStateSize)).Decode(&received)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	received.Path = r.state.Path
+	*r.state = received
+	err = r.state.Save()
+	if err != nil {
+		errorf("Couldn't save replicated state: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case r.received <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReceiveState is used by standby instances to receive the state of the
+// active instance, it is stored in the given state. It returns when the
+// failover timeout passes without receiving the state, or it blocks
+// forever if no timeout is configured.
+func ReceiveState(c ReplicationConfig, state *PouchState) error {
+	if c.Listen == "" {
+		return fmt.Errorf("no address to receive the state")
+	}
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	var timeout time.Duration
+	if c.FailoverTimeout != "" {
+		timeout, err = parsePositiveDuration("failover timeout", c.FailoverTimeout, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	receiver := &stateReceiver{state: state, received: make(chan struct{}, 1)}
+	server := &http.Server{Handler: receiver}
+	defer server.Shutdown(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(tls.NewListener(l, tlsConfig))
+	}()
+
+	for {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			expired = time.After(timeout)
+		}
+		select {
+		case <-receiver.received:
+		case <-expired:
+			warnf("State not received in %s", timeout)
+			return nil
+		case err := <-errs:
+			return err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateReplication(t *testing.T) {
+	primary, cleanup := newTestState()
+	defer cleanup()
+	defer os.Remove(primary.Path + PreviousStateFilePostfix)
+	standby, cleanup := newTestState()
+	defer cleanup()
+	defer os.Remove(standby.Path + PreviousStateFilePostfix)
+
+	receiver := &stateReceiver{state: standby, received: make(chan struct{}, 1)}
+	server := httptest.NewTLSServer(receiver)
+	defer server.Close()
+
+	p := &pouch{
+		State: primary,
+		replicator: &stateReplicator{
+			peers:    []string{server.Listener.Addr().String()},
+			interval: time.Hour,
+			client:   server.Client(),
+			saved:    make(chan struct{}, 1),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.startReplication(ctx)
+
+	p.State.Token = "token"
+	p.State.Secrets = map[string]*SecretState{"foo": {Name: "foo", LeaseID: "lease"}}
+	assert.NoError(t, p.saveState())
+
+	select {
+	case <-receiver.received:
+	case <-time.After(time.Second):
+		t.Fatal("state not received")
+	}
+	receiver.Lock()
+	defer receiver.Unlock()
+	assert.Equal(t, "token", standby.Token)
+	assert.Equal(t, "lease", standby.Secrets["foo"].LeaseID)
+
+	// Received state is also saved
+	loaded, err := LoadState(standby.Path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "token", loaded.Token)
+	}
+}
+
+func TestReplicationConfig(t *testing.T) {
+	p := &pouch{}
+	assert.Error(t, p.ReplicateState(ReplicationConfig{}))
+	assert.Error(t, p.ReplicateState(ReplicationConfig{Peers: []string{"peer:8443"}}))
+	assert.Error(t, ReceiveState(ReplicationConfig{}, NewState("")))
+	assert.Error(t, ReceiveState(ReplicationConfig{Listen: "127.0.0.1:0"}, NewState("")))
+}
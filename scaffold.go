@@ -0,0 +1,286 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/hcl"
+)
+
+// Path to read the rules of a policy
+const PolicyURL = "/v1/sys/policy"
+
+// Prefixes of paths in policies that are never scaffolded as secrets
+var scaffoldExcludedPrefixes = []string{"sys/", "auth/", "identity/", "cubbyhole/"}
+
+// ScaffoldOptions selects what secrets are enumerated to generate
+// a configuration
+type ScaffoldOptions struct {
+	// Prefixes of paths to enumerate, e.g. secret/myapp
+	Prefixes []string
+
+	// Policies whose paths are enumerated, if no prefixes or policies
+	// are set, the policies of the token are used
+	Policies []string
+
+	// Directory where files are rendered
+	FilesDir string
+}
+
+type policyRules struct {
+	Paths []struct {
+		Path         string   `hcl:",key"`
+		Policy       string   `hcl:"policy"`
+		Capabilities []string `hcl:"capabilities"`
+	} `hcl:"path"`
+}
+
+// readable returns the prefixes that can be enumerated under the paths of
+// a policy, paths with exact names are not considered, as reading them can
+// have side effects, such as creating dynamic credentials
+func (r *policyRules) readable() []string {
+	var prefixes []string
+	for _, p := range r.Paths {
+		allowed := p.Policy == "read" || p.Policy == "write" || p.Policy == "sudo"
+		for _, c := range p.Capabilities {
+			if c == "read" || c == "list" {
+				allowed = true
+			}
+			if c == "deny" {
+				allowed = false
+				break
+			}
+		}
+		if !allowed || !strings.HasSuffix(p.Path, "*") || strings.Contains(p.Path, "+") {
+			continue
+		}
+		prefixes = append(prefixes, strings.TrimSuffix(p.Path, "*"))
+	}
+	return prefixes
+}
+
+// tokenPolicies returns the policies of the token in use, except the
+// default one
+func tokenPolicies(v vault.Vault) ([]string, error) {
+	s, _, err := v.Request(http.MethodGet, vault.SelfTokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.Data == nil {
+		return nil, fmt.Errorf("no data found in token lookup")
+	}
+	raw, _ := s.Data["policies"].([]interface{})
+	var policies []string
+	for _, p := range raw {
+		if name, ok := p.(string); ok && name != "default" {
+			policies = append(policies, name)
+		}
+	}
+	return policies, nil
+}
+
+func policyPrefixes(v vault.Vault, name string) ([]string, error) {
+	if name == "root" {
+		return nil, fmt.Errorf("paths of the root policy cannot be enumerated, use prefixes instead")
+	}
+	s, _, err := v.Request(http.MethodGet, path.Join(PolicyURL, name), nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read policy '%s', prefixes can be used if it cannot be read: %v", name, err)
+	}
+	if s == nil || s.Data == nil {
+		return nil, fmt.Errorf("policy '%s' not found", name)
+	}
+	source, _ := s.Data["rules"].(string)
+	var rules policyRules
+	err = hcl.Decode(&rules, source)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse policy '%s': %v", name, err)
+	}
+	return rules.readable(), nil
+}
+
+// scaffolder enumerates secrets under prefixes
+type scaffolder struct {
+	vault   vault.Vault
+	secrets map[string]string
+}
+
+// listPath returns the path to list a prefix, in KV version 2 secrets are
+// listed through their metadata
+func listPath(prefix string) string {
+	return "/v1/" + strings.Replace(prefix, "/data/", "/metadata/", 1)
+}
+
+// enumerate lists a prefix recursively, adding the paths of the secrets
+// found, names of the keys need to start with the part of the prefix
+// after its last slash
+func (s *scaffolder) enumerate(prefix string) error {
+	dir, start := prefix, ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, start = prefix[:i+1], prefix[i+1:]
+	}
+	secret, resp, err := s.vault.Request(ListMethod, listPath(dir), nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok || !strings.HasPrefix(key, start) {
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			err = s.enumerate(dir + key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		p := dir + key
+		s.secrets[scaffoldSecretName(p)] = p
+	}
+	return nil
+}
+
+// scaffoldSecretName obtains the name of a secret from its path, without
+// the mount and the data part of KV version 2 paths
+func scaffoldSecretName(p string) string {
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) < 2 {
+		return p
+	}
+	return strings.TrimPrefix(parts[1], "data/")
+}
+
+// scaffoldKeys reads a secret to obtain the names of its keys, in KV
+// version 2 secrets keys are under data
+func scaffoldKeys(v vault.Vault, p string) (keys []string, nested bool, err error) {
+	s, _, err := v.Request(http.MethodGet, "/v1/"+p, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if s == nil || s.Data == nil {
+		return nil, false, nil
+	}
+	data := s.Data
+	if d, ok := s.Data["data"].(map[string]interface{}); ok && strings.Contains(p, "/data/") {
+		data, nested = d, true
+	}
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nested, nil
+}
+
+// scaffoldTemplate generates a template with a line for each key
+func scaffoldTemplate(name string, keys []string, nested bool) string {
+	var b bytes.Buffer
+	for _, k := range keys {
+		if nested {
+			fmt.Fprintf(&b, "%s={{ index (secret %q \"data\") %q }}\n", k, name, k)
+		} else {
+			fmt.Fprintf(&b, "%s={{ secret %q %q }}\n", k, name, k)
+		}
+	}
+	return b.String()
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	return strings.TrimPrefix(prefix, "v1/")
+}
+
+// Scaffold enumerates the secrets readable under some prefixes, or in the
+// paths of some policies, and generates a configuration with a secret and
+// a file for each one of them. Values of secrets are read to know their
+// keys, but they are not included in the configuration.
+func Scaffold(v vault.Vault, o ScaffoldOptions) (*Pouchfile, error) {
+	s := &scaffolder{vault: v, secrets: make(map[string]string)}
+	for _, prefix := range o.Prefixes {
+		err := s.enumerate(normalizePrefix(prefix))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list secrets under %s: %v", prefix, err)
+		}
+	}
+
+	policies := o.Policies
+	if len(o.Prefixes) == 0 && len(policies) == 0 {
+		var err error
+		policies, err = tokenPolicies(v)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't obtain policies of token: %v", err)
+		}
+	}
+	for _, policy := range policies {
+		prefixes, err := policyPrefixes(v, policy)
+		if err != nil {
+			return nil, err
+		}
+		for _, prefix := range prefixes {
+			excluded := false
+			for _, e := range scaffoldExcludedPrefixes {
+				excluded = excluded || strings.HasPrefix(prefix, e)
+			}
+			if excluded {
+				continue
+			}
+			// Not everything readable can be listed, skip what cannot
+			err := s.enumerate(prefix)
+			if err != nil {
+				warnf("Skipping %s* from policy '%s': %v", prefix, policy, err)
+			}
+		}
+	}
+
+	var names []string
+	for name := range s.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pouchfile := &Pouchfile{Secrets: make(map[string]SecretConfig)}
+	for _, name := range names {
+		p := s.secrets[name]
+		keys, nested, err := scaffoldKeys(v, p)
+		if err != nil {
+			warnf("Skipping secret %s: %v", p, err)
+			continue
+		}
+		pouchfile.Secrets[name] = SecretConfig{VaultURL: "/v1/" + p}
+		pouchfile.Files = append(pouchfile.Files, FileConfig{
+			Path:     path.Join(o.FilesDir, name),
+			Template: scaffoldTemplate(name, keys, nested),
+			Secrets:  []string{name},
+		})
+	}
+	return pouchfile, nil
+}
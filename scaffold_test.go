@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+
+	"github.com/tuenti/pouch/pkg/pouchtest"
+	"github.com/tuenti/pouch/pkg/vault"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaffoldFromPolicies(t *testing.T) {
+	v := pouchtest.NewVault()
+	v.SetResponse("GET", vault.SelfTokenURL, &api.Secret{
+		Data: map[string]interface{}{"policies": []interface{}{"default", "myapp"}},
+	})
+	v.SetResponse("GET", "/v1/sys/policy/myapp", &api.Secret{
+		Data: map[string]interface{}{"rules": `
+path "secret/myapp/*" {
+  capabilities = ["read", "list"]
+}
+path "kv/data/myapp/*" {
+  capabilities = ["read"]
+}
+path "secret/other/*" {
+  capabilities = ["deny"]
+}
+path "database/creds/myapp" {
+  capabilities = ["read"]
+}
+path "sys/leases/renew" {
+  capabilities = ["update"]
+}
+`},
+	})
+	v.SetResponse(ListMethod, "/v1/secret/myapp/", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"db", "tls/"}},
+	})
+	v.SetResponse(ListMethod, "/v1/secret/myapp/tls/", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"server"}},
+	})
+	v.SetResponse(ListMethod, "/v1/kv/metadata/myapp/", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"api"}},
+	})
+	v.SetSecret("/v1/secret/myapp/db", map[string]interface{}{"user": "app", "password": "secret"}, 0)
+	v.SetSecret("/v1/secret/myapp/tls/server", map[string]interface{}{"cert": "..."}, 0)
+	v.SetSecret("/v1/kv/data/myapp/api", map[string]interface{}{
+		"data":     map[string]interface{}{"key": "secret"},
+		"metadata": map[string]interface{}{"version": 1},
+	}, 0)
+	v.Login()
+
+	pouchfile, err := Scaffold(v, ScaffoldOptions{FilesDir: "/etc/secrets"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]SecretConfig{
+		"myapp/db":         {VaultURL: "/v1/secret/myapp/db"},
+		"myapp/tls/server": {VaultURL: "/v1/secret/myapp/tls/server"},
+		"myapp/api":        {VaultURL: "/v1/kv/data/myapp/api"},
+	}, pouchfile.Secrets)
+	assert.Equal(t, []FileConfig{
+		{
+			Path:     "/etc/secrets/myapp/api",
+			Template: "key={{ index (secret \"myapp/api\" \"data\") \"key\" }}\n",
+			Secrets:  []string{"myapp/api"},
+		},
+		{
+			Path:     "/etc/secrets/myapp/db",
+			Template: "password={{ secret \"myapp/db\" \"password\" }}\nuser={{ secret \"myapp/db\" \"user\" }}\n",
+			Secrets:  []string{"myapp/db"},
+		},
+		{
+			Path:     "/etc/secrets/myapp/tls/server",
+			Template: "cert={{ secret \"myapp/tls/server\" \"cert\" }}\n",
+			Secrets:  []string{"myapp/tls/server"},
+		},
+	}, pouchfile.Files)
+
+	for _, r := range v.Requests() {
+		assert.NotEqual(t, "/v1/database/creds/myapp", r.Path, "dynamic credentials shouldn't be read")
+	}
+}
+
+func TestScaffoldFromPrefix(t *testing.T) {
+	v := pouchtest.NewVault()
+	v.SetResponse(ListMethod, "/v1/secret/", &api.Secret{
+		Data: map[string]interface{}{"keys": []interface{}{"myapp-db", "other"}},
+	})
+	v.SetSecret("/v1/secret/myapp-db", map[string]interface{}{"password": "secret"}, 0)
+	v.Login()
+
+	pouchfile, err := Scaffold(v, ScaffoldOptions{Prefixes: []string{"/v1/secret/myapp-"}, FilesDir: "/etc"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]SecretConfig{
+		"myapp-db": {VaultURL: "/v1/secret/myapp-db"},
+	}, pouchfile.Secrets)
+	if assert.Len(t, pouchfile.Files, 1) {
+		assert.Equal(t, "/etc/myapp-db", pouchfile.Files[0].Path)
+	}
+}
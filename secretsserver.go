@@ -0,0 +1,276 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+const (
+	DefaultSecretsServerSocket = "/run/pouch/secrets.sock"
+
+	// Applications are authorized by their credentials, so any local
+	// user can connect by default
+	DefaultSecretsServerSocketMode = 0666
+
+	SecretsServerGet = "get"
+
+	// Requests are a short line, bigger requests are rejected
+	maxSecretRequestSize = 4096
+
+	// Time given to applications to send their requests and read the
+	// replies
+	secretRequestTimeout = 10 * time.Second
+)
+
+// SecretsServerConfig defines the unix socket where secrets are served
+// to co-located applications, so they don't need to be written to files
+type SecretsServerConfig struct {
+	// Path of the unix socket, /run/pouch/secrets.sock by default
+	Socket string `json:"socket,omitempty"`
+
+	// Permissions of the socket, in octal, 0666 by default
+	Mode string `json:"mode,omitempty"`
+}
+
+// SecretConsumers are the local users and groups that can request a
+// secret from the secrets server
+type SecretConsumers struct {
+	// Users allowed, by name or numeric id
+	Users []string `json:"users,omitempty"`
+
+	// Groups allowed, by name or numeric id, any of the groups of the
+	// user of the application is accepted
+	Groups []string `json:"groups,omitempty"`
+}
+
+// peerCredentials are the credentials of the process at the other end
+// of a unix socket
+type peerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// groups returns the ids of the primary and supplementary groups of
+// the peer
+func (c peerCredentials) groups() []string {
+	groups := []string{strconv.Itoa(int(c.GID))}
+	u, err := user.LookupId(strconv.Itoa(int(c.UID)))
+	if err != nil {
+		return groups
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return groups
+	}
+	return append(groups, gids...)
+}
+
+func (c peerCredentials) String() string {
+	return fmt.Sprintf("pid %d, uid %d, gid %d", c.PID, c.UID, c.GID)
+}
+
+// getPeerCredentials obtains the credentials of the peer of a unix
+// connection with SO_PEERCRED
+func getPeerCredentials(conn net.Conn) (peerCredentials, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCredentials{}, fmt.Errorf("not a unix connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return peerCredentials{}, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return peerCredentials{}, err
+	}
+	if credErr != nil {
+		return peerCredentials{}, credErr
+	}
+	return peerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
+
+// allows returns true if the peer is one of the consumers
+func (c *SecretConsumers) allows(peer peerCredentials) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	for _, name := range c.Users {
+		uid, err := lookupID(name, lookupUID)
+		if err != nil {
+			return false, fmt.Errorf("unknown user '%s': %v", name, err)
+		}
+		if uid == int(peer.UID) {
+			return true, nil
+		}
+	}
+	if len(c.Groups) == 0 {
+		return false, nil
+	}
+	groups := peer.groups()
+	for _, name := range c.Groups {
+		gid, err := lookupID(name, lookupGID)
+		if err != nil {
+			return false, fmt.Errorf("unknown group '%s': %v", name, err)
+		}
+		if stringInSlice(strconv.Itoa(gid), groups) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *pouch) SecretsServer(c SecretsServerConfig) error {
+	p.secretsServerSocket = c.Socket
+	if p.secretsServerSocket == "" {
+		p.secretsServerSocket = DefaultSecretsServerSocket
+	}
+	mode, err := parseSocketMode(c.Mode, DefaultSecretsServerSocketMode)
+	if err != nil {
+		return fmt.Errorf("incorrect mode of secrets server socket: %v", err)
+	}
+	p.secretsServerSocketMode = mode
+	p.secretsServerTimeout = secretRequestTimeout
+	return nil
+}
+
+// startSecretsServer serves secrets till the context is done
+func (p *pouch) startSecretsServer(ctx context.Context) error {
+	if p.secretsServerSocket == "" {
+		return nil
+	}
+	l, err := listenUnix(ctx, p.secretsServerSocket, p.secretsServerSocketMode)
+	if err != nil {
+		return fmt.Errorf("couldn't listen in secrets server socket: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					errorf("Secrets server failed: %v", err)
+				}
+				return
+			}
+			go p.serveSecret(ctx, conn)
+		}
+	}()
+	return nil
+}
+
+// serveSecret replies to a request of a secret, as a line with the get
+// command, the name of the secret and optionally one of its keys
+func (p *pouch) serveSecret(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.secretsServerTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, maxSecretRequestSize)).ReadString('\n')
+	// Incomplete requests are not served, e.g. on timeouts
+	if (err != nil && err != io.EOF) || line == "" {
+		return
+	}
+	var resp ControlResponse
+	if len(line) == maxSecretRequestSize && !strings.HasSuffix(line, "\n") {
+		resp.Error = "request too long"
+		json.NewEncoder(conn).Encode(resp)
+		return
+	}
+	peer, err := getPeerCredentials(conn)
+	if err != nil {
+		resp.Error = fmt.Sprintf("couldn't obtain credentials: %v", err)
+	} else {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 || fields[0] != SecretsServerGet {
+			resp.Error = "usage: get <secret> [<key>]"
+		} else {
+			resp = p.inMainLoop(ctx, func() (interface{}, error) {
+				return p.getServedSecret(peer, fields[1], fields[2:]...)
+			})
+		}
+	}
+	conn.SetWriteDeadline(time.Now().Add(p.secretsServerTimeout))
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// getServedSecret returns the data of a secret, or the value of one of
+// its keys, if the peer is authorized to read it
+func (p *pouch) getServedSecret(peer peerCredentials, name string, key ...string) (interface{}, error) {
+	labels := metrics.Labels{"secret": name}
+	sc, found := p.Secrets[name]
+	allowed := false
+	if found {
+		var err error
+		allowed, err = sc.Consumers.allows(peer)
+		if err != nil {
+			errorf("Couldn't check consumers of secret '%s': %v", name, err)
+		}
+	}
+	if !allowed {
+		p.Metrics.Add(MetricSecretRequestsDenied, labels, 1)
+		p.event(Event{
+			Type:    EventSecretAccessDenied,
+			Secret:  name,
+			Message: fmt.Sprintf("Access to secret '%s' denied to %s", name, peer),
+			Details: map[string]interface{}{"pid": peer.PID, "uid": peer.UID, "gid": peer.GID},
+		})
+		// Same error for unknown secrets, so their names aren't disclosed
+		return nil, fmt.Errorf("access to secret '%s' denied", name)
+	}
+	s, found := p.State.Secrets[name]
+	if !found {
+		return nil, fmt.Errorf("secret '%s' not read yet", name)
+	}
+	var result interface{} = s.Data
+	if len(key) > 0 {
+		value, found := s.Data[key[0]]
+		if !found {
+			return nil, fmt.Errorf("key '%s' not found in secret '%s'", key[0], name)
+		}
+		result = value
+	}
+	p.Metrics.Add(MetricSecretRequests, labels, 1)
+	p.event(Event{
+		Type:    EventSecretServed,
+		Secret:  name,
+		Message: fmt.Sprintf("Secret '%s' served to %s", name, peer),
+		Details: map[string]interface{}{"pid": peer.PID, "uid": peer.UID, "gid": peer.GID},
+	})
+	return result, nil
+}
+
+// GetSecret requests a secret, or the value of one of its keys, to the
+// secrets server listening in a socket
+func GetSecret(socket, name string, key ...string) (interface{}, error) {
+	return SendControlCommand(socket, SecretsServerGet, append([]string{name}, key...)...)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretsServer(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	uid := strconv.Itoa(os.Getuid())
+	gid := strconv.Itoa(os.Getgid())
+	state := NewState("")
+	state.Secrets = make(map[string]*SecretState)
+	for _, name := range []string{"user", "group", "nobody"} {
+		state.Secrets[name] = &SecretState{Name: name, Data: SecretData{"password": name + "-password"}}
+	}
+	secrets := map[string]SecretConfig{
+		"user":   {Consumers: &SecretConsumers{Users: []string{uid}}},
+		"group":  {Consumers: &SecretConsumers{Groups: []string{gid}}},
+		"nobody": {},
+		"unread": {Consumers: &SecretConsumers{Users: []string{uid}}},
+	}
+	p := NewPouch(state, nil, secrets, nil, nil).(*pouch)
+
+	socket := path.Join(tmpdir, "secrets.sock")
+	assert.NoError(t, p.SecretsServer(SecretsServerConfig{Socket: socket}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.controlRequests = make(chan controlRequest)
+	if !assert.NoError(t, p.startSecretsServer(ctx)) {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case req := <-p.controlRequests:
+				p.runControl(req)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	info, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0666), info.Mode().Perm())
+	}
+
+	result, err := GetSecret(socket, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "user-password"}, result)
+
+	result, err = GetSecret(socket, "group", "password")
+	assert.NoError(t, err)
+	assert.Equal(t, "group-password", result)
+
+	// Errors are compared redacted, as other tests may have used
+	// values that appear in them
+	_, err = GetSecret(socket, "group", "unknown")
+	assert.EqualError(t, err, redactSecrets("key 'unknown' not found in secret 'group'"))
+
+	_, err = GetSecret(socket, "unread")
+	assert.EqualError(t, err, redactSecrets("secret 'unread' not read yet"))
+
+	_, err = GetSecret(socket, "nobody")
+	assert.EqualError(t, err, redactSecrets("access to secret 'nobody' denied"))
+	_, err = GetSecret(socket, "unknown")
+	assert.EqualError(t, err, redactSecrets("access to secret 'unknown' denied"))
+
+	_, err = SendControlCommand(socket, "list")
+	assert.Error(t, err)
+
+	events := p.Events.Recent()
+	var served, denied int
+	for _, e := range events {
+		switch e.Type {
+		case EventSecretServed:
+			served++
+		case EventSecretAccessDenied:
+			denied++
+		}
+	}
+	assert.Equal(t, 2, served)
+	assert.Equal(t, 2, denied)
+}
+
+func TestSecretsServerRequestLimits(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+
+	p := NewPouch(NewState(""), nil, nil, nil, nil).(*pouch)
+	socket := path.Join(tmpdir, "secrets.sock")
+	assert.NoError(t, p.SecretsServer(SecretsServerConfig{Socket: socket}))
+	p.secretsServerTimeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !assert.NoError(t, p.startSecretsServer(ctx)) {
+		return
+	}
+
+	// Oversized requests are rejected
+	conn, err := net.Dial("unix", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("get " + strings.Repeat("a", 2*maxSecretRequestSize)))
+	var resp ControlResponse
+	assert.NoError(t, json.NewDecoder(conn).Decode(&resp))
+	assert.Equal(t, "request too long", resp.Error)
+
+	// Slow requests are closed
+	slow, err := net.Dial("unix", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer slow.Close()
+	slow.Write([]byte("get foo"))
+	slow.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	d, err := ioutil.ReadAll(slow)
+	assert.NoError(t, err)
+	assert.Empty(t, d)
+	assert.True(t, time.Since(start) < time.Second, "slow request should be closed")
+}
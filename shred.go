@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ShredOnExit makes pouch overwrite and remove the files it manages, and
+// its state, when it is stopped
+func (p *pouch) ShredOnExit() {
+	p.shredOnExit = true
+}
+
+// shredFile overwrites a file with random data before removing it, named
+// pipes and other special files are only removed
+func shredFile(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(f, rand.Reader, info.Size())
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// managedFiles returns the paths of the local files written by pouch
+func (p *pouch) managedFiles() []string {
+	var paths []string
+	for path, fc := range p.Files {
+		if fc.Plugin != "" || len(fc.Hosts) > 0 {
+			continue
+		}
+		if fc.PerKey {
+			for keyPath := range p.keyFiles[path] {
+				paths = append(paths, keyPath)
+				paths = append(paths, backupPaths(keyPath, fc.Backups)...)
+				if fc.UpdateStrategy == SymlinkUpdateStrategy {
+					paths = append(paths, filepath.Join(path, dataLinkName, filepath.Base(keyPath)))
+				}
+			}
+			continue
+		}
+		paths = append(paths, path)
+		paths = append(paths, backupPaths(path, fc.Backups)...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// shredFiles overwrites and removes the files managed and the state
+func (p *pouch) shredFiles() {
+	paths := p.managedFiles()
+	if p.State.Path != "" {
+		paths = append(paths, p.State.Path)
+	}
+	for _, path := range paths {
+		err := shredFile(path)
+		if err != nil {
+			errorf("Couldn't shred '%s': %v", path, err)
+			continue
+		}
+		infof("Shredded '%s'", path)
+		p.State.DeleteManagedFile(path)
+	}
+}
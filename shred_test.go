@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShredOnExit(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/app": &api.Secret{
+				Data: map[string]interface{}{"password": "secret", "user": "app"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"app": {VaultURL: "/v1/secret/app", HTTPMethod: "GET"},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "password"), Template: `{{ secret "app" "password" }}`},
+		{Path: path.Join(tmpdir, "keys"), PerKey: true, Secrets: []string{"app"}},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, files, nil)
+	p.ShredOnExit()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.Run(ctx)
+	assert.NoError(t, err)
+
+	for _, f := range []string{"password", "keys/password", "keys/user"} {
+		_, err := os.Stat(path.Join(tmpdir, f))
+		assert.True(t, os.IsNotExist(err), f)
+	}
+	_, err = os.Stat(state.Path)
+	assert.True(t, os.IsNotExist(err))
+}
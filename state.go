@@ -22,10 +22,10 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -38,6 +38,7 @@ const (
 	DefaultSecretDurationRatio = 0.75
 
 	PreviousStateFilePostfix = "-prev"
+	LockFilePostfix          = ".lock"
 )
 
 type PouchState struct {
@@ -47,6 +48,15 @@ type PouchState struct {
 	// Secrets state
 	Secrets map[string]*SecretState `json:"secrets,omitempty"`
 
+	// Checksums of the content last delivered to files, to skip
+	// deliveries to other places when content doesn't change, and to
+	// detect local files modified externally
+	FileChecksums map[string]string `json:"file_checksums,omitempty"`
+
+	// Local files written by pouch, to find the ones that are not
+	// managed anymore
+	ManagedFiles map[string]bool `json:"managed_files,omitempty"`
+
 	// Path from where this state was read
 	Path string `json:"-"`
 }
@@ -69,9 +79,23 @@ func LoadState(path string) (*PouchState, error) {
 		return nil, err
 	}
 	state.Path = path
+	for _, secret := range state.Secrets {
+		secretRedactor.Add(secret.Data)
+	}
 	return &state, nil
 }
 
+// Reload reads again the state from its path, to be used when state
+// could have been modified by another process
+func (s *PouchState) Reload() error {
+	loaded, err := LoadState(s.Path)
+	if err != nil {
+		return err
+	}
+	*s = *loaded
+	return nil
+}
+
 func (s *PouchState) Save() error {
 	path := s.Path
 	if path == "" {
@@ -113,7 +137,23 @@ var secretTTUSources = []func(*SecretState) (*time.Time, error){
 	ttuFromCertificateValidity,
 }
 
+// Sources of expiration times
+var secretExpirationSources = []func(*SecretState) (*time.Time, error){
+	expirationFromTTLOrLeaseDuration,
+	expirationFromCertificateValidity,
+}
+
 func ttuFromTTLOrLeaseDuration(s *SecretState) (*time.Time, error) {
+	return lifetimeFromTTLOrLeaseDuration(s, s.Ratio())
+}
+
+func expirationFromTTLOrLeaseDuration(s *SecretState) (*time.Time, error) {
+	return lifetimeFromTTLOrLeaseDuration(s, 1)
+}
+
+// lifetimeFromTTLOrLeaseDuration returns the time when the given portion
+// of the life of the secret has passed
+func lifetimeFromTTLOrLeaseDuration(s *SecretState, ratio float64) (*time.Time, error) {
 	ttl, ttlKnown := s.TTL()
 
 	var duration int
@@ -132,11 +172,19 @@ func ttuFromTTLOrLeaseDuration(s *SecretState) (*time.Time, error) {
 		return nil, nil
 	}
 
-	ttu := s.Timestamp.Add(time.Duration(float64(duration)*s.Ratio()) * time.Second)
-	return &ttu, nil
+	t := s.Timestamp.Add(time.Duration(float64(duration)*ratio) * time.Second)
+	return &t, nil
 }
 
 func ttuFromCertificateValidity(s *SecretState) (*time.Time, error) {
+	return lifetimeFromCertificateValidity(s, s.Ratio())
+}
+
+func expirationFromCertificateValidity(s *SecretState) (*time.Time, error) {
+	return lifetimeFromCertificateValidity(s, 1)
+}
+
+func lifetimeFromCertificateValidity(s *SecretState, ratio float64) (*time.Time, error) {
 	if s.Data == nil {
 		return nil, nil
 	}
@@ -157,8 +205,8 @@ func ttuFromCertificateValidity(s *SecretState) (*time.Time, error) {
 	}
 
 	ttl := certificate.NotAfter.Sub(certificate.NotBefore)
-	ttu := certificate.NotBefore.Add(time.Duration(float64(ttl) * s.Ratio()))
-	return &ttu, nil
+	t := certificate.NotBefore.Add(time.Duration(float64(ttl) * ratio))
+	return &t, nil
 }
 
 func (s *PouchState) SetSecret(name string, secret *api.Secret) {
@@ -169,8 +217,15 @@ func (s *PouchState) SetSecret(name string, secret *api.Secret) {
 		Name:          name,
 		Timestamp:     time.Now(),
 		LeaseDuration: secret.LeaseDuration,
+		LeaseID:       secret.LeaseID,
+		Renewable:     secret.Renewable,
 		Data:          secret.Data,
 	}
+	secretRedactor.Add(secret.Data)
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		// Version of secrets read from KV version 2
+		state.Version, _ = toInt(metadata["version"])
+	}
 
 	if _, known := state.TimeToUpdate(); !known {
 		// Without a known TTU, we don't know when to update
@@ -179,10 +234,62 @@ func (s *PouchState) SetSecret(name string, secret *api.Secret) {
 
 	if oldState, found := s.Secrets[name]; found {
 		state.FilesUsing = oldState.FilesUsing
+		state.Rotations = oldState.Rotations
 	}
 	s.Secrets[name] = state
 }
 
+// RenewSecret updates the lease of a secret, keeping its data
+func (s *PouchState) RenewSecret(name string, secret *api.Secret) {
+	state, found := s.Secrets[name]
+	if !found {
+		return
+	}
+	state.Timestamp = time.Now()
+	state.LeaseDuration = secret.LeaseDuration
+	state.Renewable = secret.Renewable
+	if secret.LeaseID != "" {
+		state.LeaseID = secret.LeaseID
+	}
+}
+
+// Metadata returns information about the read of the secret, expiration
+// time is only set for secrets with leases
+func (s *SecretState) Metadata() map[string]interface{} {
+	m := map[string]interface{}{
+		"lease_id":       s.LeaseID,
+		"lease_duration": s.LeaseDuration,
+		"renewable":      s.Renewable,
+		"version":        s.Version,
+		"read_time":      s.Timestamp,
+	}
+	if s.LeaseDuration > 0 {
+		m["expiration_time"] = s.Timestamp.Add(time.Duration(s.LeaseDuration) * time.Second)
+	}
+	return m
+}
+
+// SetFileChecksum records the checksum of the content delivered to a file
+func (s *PouchState) SetFileChecksum(path, content string) {
+	if s.FileChecksums == nil {
+		s.FileChecksums = make(map[string]string)
+	}
+	s.FileChecksums[path] = contentChecksum(content)
+}
+
+// AddManagedFile records that a local file has been written by pouch
+func (s *PouchState) AddManagedFile(path string) {
+	if s.ManagedFiles == nil {
+		s.ManagedFiles = make(map[string]bool)
+	}
+	s.ManagedFiles[path] = true
+}
+
+func (s *PouchState) DeleteManagedFile(path string) {
+	delete(s.ManagedFiles, path)
+	delete(s.FileChecksums, path)
+}
+
 func (s *PouchState) DeleteSecret(name string) {
 	delete(s.Secrets, name)
 }
@@ -249,6 +356,10 @@ type SecretState struct {
 	// Lease duration, in seconds, if any when the secret was read
 	LeaseDuration int `json:"lease_duration,omitempty"`
 
+	// Lease of the secret, if it can be renewed
+	LeaseID   string `json:"lease_id,omitempty"`
+	Renewable bool   `json:"renewable,omitempty"`
+
 	// Secret will be renewed after this portion of its life has passed
 	DurationRatio float64 `json:"duration_ratio,omitempty"`
 
@@ -258,8 +369,14 @@ type SecretState struct {
 	// Actual secret
 	Data SecretData `json:"data,omitempty"`
 
+	// Version of the secret, if read from a versioned KV store
+	Version int `json:"version,omitempty"`
+
 	// Files using this secret
 	FilesUsing PriorityFileSortedList `json:"files_using,omitempty"`
+
+	// Times when the values of the secret changed, most recent last
+	Rotations []time.Time `json:"rotations,omitempty"`
 }
 
 func (s *SecretState) Ratio() float64 {
@@ -274,30 +391,43 @@ func (s *SecretState) TTL() (int, bool) {
 	if s.Data == nil {
 		return 0, false
 	}
-	switch ttlNumber := s.Data["ttl"].(type) {
+	return toInt(s.Data["ttl"])
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
 	case json.Number:
-		ttl, err := ttlNumber.Int64()
+		i, err := n.Int64()
 		if err != nil {
 			return 0, false
 		}
-		return int(ttl), true
+		return int(i), true
 	case int:
-		return ttlNumber, true
+		return n, true
 	case int64:
-		return int(ttlNumber), true
+		return int(n), true
 	}
 	return 0, false
 }
 
 func (s *SecretState) TimeToUpdate() (minTTU time.Time, known bool) {
-	for _, source := range secretTTUSources {
-		ttu, err := source(s)
+	return s.minTime("TTU", secretTTUSources)
+}
+
+// Expiration returns the time when the secret is not valid anymore
+func (s *SecretState) Expiration() (time.Time, bool) {
+	return s.minTime("expiration", secretExpirationSources)
+}
+
+func (s *SecretState) minTime(what string, sources []func(*SecretState) (*time.Time, error)) (min time.Time, known bool) {
+	for _, source := range sources {
+		t, err := source(s)
 		if err != nil {
-			log.Printf("Error trying to obtain TTU for secret '%s': %s", s.Name, err)
+			warnf("Error trying to obtain %s for secret '%s': %s", what, s.Name, err)
 			continue
 		}
-		if ttu != nil && (!known || ttu.Before(minTTU)) {
-			minTTU = *ttu
+		if t != nil && (!known || t.Before(min)) {
+			min = *t
 			known = true
 		}
 	}
@@ -314,3 +444,67 @@ func (s *SecretState) RegisterUsage(path string, priority int) {
 	s.FilesUsing = append(s.FilesUsing, PriorityFile{Priority: priority, Path: path})
 	sort.Sort(s.FilesUsing)
 }
+
+// StateLock is an exclusive lock on a state, so only one pouch can use it
+// at the same time. Lock is released by the kernel if the process dies.
+type StateLock struct {
+	Path string
+
+	file *os.File
+}
+
+func NewStateLock(statePath string) *StateLock {
+	if statePath == "" {
+		statePath = DefaultStatePath
+	}
+	return &StateLock{Path: statePath + LockFilePostfix}
+}
+
+func (l *StateLock) open() error {
+	if l.file != nil {
+		return nil
+	}
+	dir := filepath.Dir(l.Path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err = os.MkdirAll(dir, DefaultStateDirMode)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE, DefaultStateMode)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	return nil
+}
+
+// TryLock obtains the lock, failing if it is held by another process
+func (l *StateLock) TryLock() error {
+	if err := l.open(); err != nil {
+		return err
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return fmt.Errorf("state is locked by another process (%s)", l.Path)
+	}
+	return err
+}
+
+// Lock waits till the lock is obtained
+func (l *StateLock) Lock() error {
+	if err := l.open(); err != nil {
+		return err
+	}
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_EX)
+}
+
+func (l *StateLock) Unlock() error {
+	if l.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+	return err
+}
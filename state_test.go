@@ -19,6 +19,7 @@ package pouch
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"sort"
 	"testing"
@@ -260,3 +261,149 @@ func TestConsistentTTU(t *testing.T) {
 		}
 	}
 }
+
+func TestStateLock(t *testing.T) {
+	f, err := ioutil.TempFile("", "pouch-state-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + LockFilePostfix)
+
+	primary := NewStateLock(f.Name())
+	err = primary.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	standby := NewStateLock(f.Name())
+	if err := standby.TryLock(); err == nil {
+		t.Fatal("lock should be held by primary")
+	}
+
+	locked := make(chan error)
+	go func() {
+		locked <- standby.Lock()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("standby shouldn't obtain the lock while primary holds it")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	primary.Unlock()
+	err = <-locked
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby.Unlock()
+}
+
+func TestStateReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "pouch-state-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + PreviousStateFilePostfix)
+
+	active := NewState(f.Name())
+	active.Token = "token"
+	if err := active.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	standby := NewState(f.Name())
+	if err := standby.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if standby.Token != "token" {
+		t.Fatalf("expected token from active state, found '%s'", standby.Token)
+	}
+}
+
+func TestTakeOverWithRotatedToken(t *testing.T) {
+	f, err := ioutil.TempFile("", "pouch-state-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + PreviousStateFilePostfix)
+
+	active := NewState(f.Name())
+	active.Token = "old-token"
+	if err := active.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Standby warms its session with the token found when starting
+	standby, err := LoadState(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &DummyVault{T: t, Token: standby.Token, ExpectedToken: "old-token"}
+	p := NewPouch(standby, v, nil, nil, nil).(*pouch)
+	if err := p.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Active instance obtains a new token before failing
+	active.Token = "new-token"
+	if err := active.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.TakeOver(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.login(); err != nil {
+		t.Fatal(err)
+	}
+	if token := v.GetToken(); token != "new-token" {
+		t.Fatalf("expected token of the active instance in client, found '%s'", token)
+	}
+
+	saved, err := LoadState(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Token != "new-token" {
+		t.Fatalf("expected token of the active instance in state, found '%s'", saved.Token)
+	}
+}
+
+func TestTakeOverWithoutToken(t *testing.T) {
+	f, err := ioutil.TempFile("", "pouch-state-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + PreviousStateFilePostfix)
+
+	// Standby started before the active instance logged in
+	standby := NewState(f.Name())
+	v := &DummyVault{T: t, ExpectedToken: "token"}
+	p := NewPouch(standby, v, nil, nil, nil).(*pouch)
+
+	active := NewState(f.Name())
+	active.Token = "token"
+	if err := active.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.TakeOver(); err != nil {
+		t.Fatal(err)
+	}
+	// DummyVault fails the test if it tries to login without role ID
+	if err := p.login(); err != nil {
+		t.Fatal(err)
+	}
+	if p.State.Token != "token" {
+		t.Fatalf("expected token of the active instance, found '%s'", p.State.Token)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+type Status string
+
+const (
+	StatusStarting Status = "starting"
+	StatusReady    Status = "ready"
+
+	// Some secret couldn't be updated when expected, but it's still valid
+	StatusDegraded Status = "degraded"
+
+	// Some secret has expired
+	StatusNotReady Status = "not_ready"
+)
+
+type StatusConfig struct {
+	// Address where status is served, it should be a loopback address
+	Listen string `json:"listen,omitempty"`
+
+	// Serve a read-only dashboard, only to local clients
+	UI bool `json:"ui,omitempty"`
+
+	// Serve the admin API, only to local clients
+	Admin bool `json:"admin,omitempty"`
+}
+
+// checkStatus obtains the status from the state of required secrets
+func (p *pouch) checkStatus(now time.Time) (Status, string) {
+	var stale, expired []string
+	for name, s := range p.State.Secrets {
+		if p.Secrets[name].Optional {
+			continue
+		}
+		if expiration, known := s.Expiration(); known && now.After(expiration) {
+			expired = append(expired, name)
+			continue
+		}
+		if ttu, known := s.TimeToUpdate(); known && !s.DisableAutoUpdate && now.After(ttu) {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	sort.Strings(expired)
+	failed := p.failedExpectations()
+	switch {
+	case len(expired) > 0:
+		return StatusNotReady, "expired secrets: " + strings.Join(expired, ", ")
+	case len(stale) > 0:
+		return StatusDegraded, "stale secrets: " + strings.Join(stale, ", ")
+	case len(failed) > 0:
+		return StatusDegraded, "failed expectations: " + strings.Join(failed, ", ")
+	}
+	return StatusReady, ""
+}
+
+// updateStatus notifies status changes, notifiers are only called when the
+// status actually changes
+func (p *pouch) updateStatus() {
+	status, message := p.checkStatus(time.Now())
+
+	p.statusLock.Lock()
+	changed := status != p.status || message != p.statusMessage
+	p.status, p.statusMessage = status, message
+	p.statusLock.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, s := range []Status{StatusReady, StatusDegraded, StatusNotReady} {
+		value := 0.0
+		if s == status {
+			value = 1
+		}
+		p.Metrics.Set(MetricStatus, metrics.Labels{"status": string(s)}, value)
+	}
+	if message != "" {
+		infof("Status: %s (%s)", status, message)
+	} else {
+		infof("Status: %s", status)
+	}
+	for _, n := range p.statusNotifiers {
+		var err error
+		switch status {
+		case StatusReady:
+			err = n.NotifyReady()
+		case StatusDegraded:
+			err = n.NotifyDegraded(message)
+		case StatusNotReady:
+			err = n.NotifyNotReady(message)
+		}
+		if err != nil {
+			errorf("%v", err)
+		}
+	}
+}
+
+func (p *pouch) Status() (Status, string) {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	if p.status == "" {
+		return StatusStarting, ""
+	}
+	return p.status, p.statusMessage
+}
+
+// StatusServer serves the status of pouch over HTTP
+type StatusServer struct {
+	Address string
+
+	// Serve the web UI
+	UI bool
+
+	// Serve the admin API
+	Admin bool
+
+	pouch  *pouch
+	server *http.Server
+}
+
+func NewStatusServer(address string, p *pouch) *StatusServer {
+	s := &StatusServer{Address: address, pouch: p}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.health)
+	mux.HandleFunc("/metrics", s.metrics)
+	mux.HandleFunc("/provenance", s.provenance)
+	mux.HandleFunc(AdminPathPrefix, s.adminHandler)
+	mux.HandleFunc("/", s.dashboard)
+	s.server = &http.Server{Addr: address, Handler: mux}
+	return s
+}
+
+func (s *StatusServer) ListenAndServe() error {
+	err := s.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s *StatusServer) Close() error {
+	return s.server.Close()
+}
+
+type healthResponse struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *StatusServer) health(w http.ResponseWriter, r *http.Request) {
+	status, message := s.pouch.Status()
+	code := http.StatusOK
+	switch status {
+	case StatusStarting, StatusNotReady:
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(healthResponse{Status: status, Message: message})
+}
+
+func (s *StatusServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	if !s.UI {
+		http.NotFound(w, r)
+		return
+	}
+	s.dashboardHandler(w, r)
+}
+
+func (s *StatusServer) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.pouch.Metrics.WriteTo(w)
+}
+
+func (p *pouch) StatusListener(c StatusConfig) {
+	p.statusServer = NewStatusServer(c.Listen, p)
+	p.statusServer.UI = c.UI
+	p.statusServer.Admin = c.Admin
+}
+
+func (p *pouch) startStatusServer() {
+	if p.statusServer == nil {
+		return
+	}
+	go func() {
+		err := p.statusServer.ListenAndServe()
+		if err != nil {
+			errorf("Status server failed: %v", err)
+		}
+	}()
+}
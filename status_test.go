@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingStatusNotifier struct {
+	notifications []Status
+}
+
+func (n *recordingStatusNotifier) NotifyReady() error {
+	n.notifications = append(n.notifications, StatusReady)
+	return nil
+}
+
+func (n *recordingStatusNotifier) NotifyDegraded(string) error {
+	n.notifications = append(n.notifications, StatusDegraded)
+	return nil
+}
+
+func (n *recordingStatusNotifier) NotifyNotReady(string) error {
+	n.notifications = append(n.notifications, StatusNotReady)
+	return nil
+}
+
+func TestCheckStatus(t *testing.T) {
+	now := time.Now()
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Timestamp: now, LeaseDuration: 100},
+		"bar": {Name: "bar", Timestamp: now, LeaseDuration: 100},
+	}
+	p := &pouch{
+		State: state,
+		Secrets: map[string]SecretConfig{
+			"foo": {},
+			"bar": {Optional: true},
+		},
+	}
+
+	status, _ := p.checkStatus(now)
+	assert.Equal(t, StatusReady, status)
+
+	status, message := p.checkStatus(now.Add(80 * time.Second))
+	assert.Equal(t, StatusDegraded, status)
+	assert.Equal(t, "stale secrets: foo", message)
+
+	status, message = p.checkStatus(now.Add(120 * time.Second))
+	assert.Equal(t, StatusNotReady, status)
+	assert.Equal(t, "expired secrets: foo", message)
+}
+
+func TestUpdateStatusNotifiesChanges(t *testing.T) {
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Timestamp: time.Now(), LeaseDuration: 100},
+	}
+	n := &recordingStatusNotifier{}
+	p := &pouch{
+		State:   state,
+		Secrets: map[string]SecretConfig{"foo": {}},
+		Metrics: newMetricsRegistry(),
+	}
+	p.AddStatusNotifier(n)
+
+	p.updateStatus()
+	p.updateStatus()
+	state.Secrets["foo"].Timestamp = time.Now().Add(-90 * time.Second)
+	p.updateStatus()
+	p.updateStatus()
+	state.Secrets["foo"].Timestamp = time.Now()
+	p.updateStatus()
+
+	assert.Equal(t, []Status{StatusReady, StatusDegraded, StatusReady}, n.notifications)
+}
+
+func TestStatusServerHealth(t *testing.T) {
+	p := &pouch{State: NewState(""), Metrics: newMetricsRegistry()}
+	s := NewStatusServer("", p)
+
+	w := httptest.NewRecorder()
+	s.health(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	p.updateStatus()
+	w = httptest.NewRecorder()
+	s.health(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"ready"`)
+}
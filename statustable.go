@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Errors are truncated to this length so the table stays readable
+const statusTableErrorLength = 60
+
+// WriteStatusTable writes a status report as a table for humans, with
+// the last refresh, next update, remaining time of the lease, files and
+// last error of each secret
+func WriteStatusTable(w io.Writer, report StatusReport, now time.Time) error {
+	if report.Status != "" {
+		status := string(report.Status)
+		if report.Message != "" {
+			status += " (" + report.Message + ")"
+		}
+		fmt.Fprintf(w, "Status: %s\n\n", status)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SECRET\tLAST REFRESH\tNEXT UPDATE\tLEASE TTL\tFILES\tLAST ERROR")
+	for _, s := range report.Secrets {
+		lastRefresh := fromNow(now, s.LastFetch)
+		if s.LastFetch == nil {
+			lastRefresh = "never"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Name,
+			lastRefresh,
+			orDash(fromNow(now, s.NextUpdate)),
+			leaseTTL(now, s.Expiration),
+			orDash(strings.Join(s.Files, ",")),
+			orDash(lastError(s)),
+		)
+	}
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func leaseTTL(now time.Time, expiration *time.Time) string {
+	if expiration == nil {
+		return "-"
+	}
+	ttl := expiration.Sub(now).Truncate(time.Second)
+	if ttl <= 0 {
+		return "expired"
+	}
+	return ttl.String()
+}
+
+func lastError(s SecretStatus) string {
+	if s.LastError == "" {
+		return ""
+	}
+	message := strings.Join(strings.Fields(s.LastError), " ")
+	if len(message) > statusTableErrorLength {
+		message = message[:statusTableErrorLength-3] + "..."
+	}
+	if s.Failures > 1 {
+		message = fmt.Sprintf("%s (%d failures)", message, s.Failures)
+	}
+	return message
+}
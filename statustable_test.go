@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStatusTable(t *testing.T) {
+	now := time.Date(2018, 5, 10, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"db": {
+			Name:          "db",
+			Timestamp:     *at(-10 * time.Minute),
+			LeaseDuration: 3600,
+			FilesUsing:    PriorityFileSortedList{{Path: "/etc/db.conf"}, {Path: "/etc/app.conf"}},
+		},
+	}
+	secrets := map[string]SecretConfig{"db": {}, "api": {}}
+	report := StateStatusReport(state, secrets)
+	if assert.Len(t, report.Secrets, 2) {
+		assert.Equal(t, "api", report.Secrets[0].Name)
+		assert.Nil(t, report.Secrets[0].LastFetch)
+		assert.Equal(t, []string{"/etc/db.conf", "/etc/app.conf"}, report.Secrets[1].Files)
+	}
+
+	report = StatusReport{
+		Status:  StatusDegraded,
+		Message: "stale secrets: api",
+		Secrets: []SecretStatus{
+			{
+				Name:       "api",
+				LastFetch:  at(-2 * time.Hour),
+				Expiration: at(-time.Minute),
+				LastError:  "permission denied\nwhile reading " + strings.Repeat("x", 100),
+				Failures:   3,
+			},
+			{
+				Name:       "db",
+				LastFetch:  at(-10 * time.Minute),
+				NextUpdate: at(30 * time.Minute),
+				Expiration: at(50 * time.Minute),
+				Files:      []string{"/etc/db.conf", "/etc/app.conf"},
+			},
+			{Name: "new"},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, WriteStatusTable(&buf, report, now))
+	expected := `Status: degraded (stale secrets: api)
+
+SECRET  LAST REFRESH  NEXT UPDATE  LEASE TTL  FILES                       LAST ERROR
+api     2h0m0s ago    -            expired    -                           permission denied while reading xxxxxxxxxxxxxxxxxxxxxxxxx... (3 failures)
+db      10m0s ago     in 30m0s     50m0s      /etc/db.conf,/etc/app.conf  -
+new     never         -            -          -                           -
+`
+	assert.Equal(t, expected, buf.String())
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultVaultEventType = "kv*"
+
+	// Period to wait before subscribing again when subscription to
+	// events fails, meanwhile secrets are updated by polling
+	VaultEventsRetryPeriod = time.Minute
+)
+
+type VaultEventsConfig struct {
+	// If pouch should subscribe to Vault events to update secrets
+	// as soon as they change
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Type of events to subscribe to, by default all events from
+	// KV secrets engines
+	EventType string `json:"event_type,omitempty"`
+}
+
+func (p *pouch) VaultEvents(eventType string) {
+	if eventType == "" {
+		eventType = DefaultVaultEventType
+	}
+	p.vaultEventType = eventType
+}
+
+// vaultPath returns the path of a Vault URL as found in events
+func vaultPath(vaultURL string) string {
+	p := strings.SplitN(vaultURL, "?", 2)[0]
+	p = strings.TrimPrefix(p, "/")
+	p = strings.TrimPrefix(p, "v1/")
+	return strings.TrimSuffix(p, "/")
+}
+
+// secretsForPath returns the names of the secrets read from a path,
+// only secrets read without side effects are considered
+func (p *pouch) secretsForPath(path string) []string {
+	var names []string
+	for name, c := range p.Secrets {
+		if c.Plugin != "" || c.Provider() != VaultProvider {
+			continue
+		}
+		if c.HTTPMethod != "" && c.HTTPMethod != http.MethodGet {
+			continue
+		}
+		if vaultPath(c.VaultURL) == path {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// subscribeVaultEvents sends the paths of events received from Vault to
+// the refresh channel, subscribing again if the connection is lost
+func (p *pouch) subscribeVaultEvents(ctx context.Context) {
+	for {
+		events, err := p.Vault.Subscribe(ctx, p.vaultEventType)
+		if err != nil {
+			warnf("Couldn't subscribe to Vault events, secrets will be updated when they expire: %v", err)
+		} else {
+			infof("Subscribed to Vault events of type '%s'", p.vaultEventType)
+			for e := range events {
+				paths := []string{e.Path}
+				if e.DataPath != "" && e.DataPath != e.Path {
+					paths = append(paths, e.DataPath)
+				}
+				for _, path := range paths {
+					select {
+					case p.refresh <- path:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+
+		select {
+		case <-time.After(VaultEventsRetryPeriod):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
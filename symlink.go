@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+const (
+	// Per-key files are written in a new directory, and a symlink to
+	// it is replaced
+	SymlinkUpdateStrategy = "symlink"
+
+	// Symlink to the directory with the current version of the files
+	dataLinkName = "..data"
+)
+
+// dataDirUnchanged checks if the current data directory contains exactly
+// the files with the given content
+func dataDirUnchanged(dataLink string, paths []string, contents map[string]string) bool {
+	files, err := ioutil.ReadDir(dataLink)
+	if err != nil || len(files) != len(paths) {
+		return false
+	}
+	for _, path := range paths {
+		current, err := ioutil.ReadFile(filepath.Join(dataLink, filepath.Base(path)))
+		if err != nil || string(current) != contents[path] {
+			return false
+		}
+		target, err := os.Readlink(path)
+		if err != nil || target != filepath.Join(dataLinkName, filepath.Base(path)) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeDataDir writes the files of a per-key file in a new data directory,
+// it is removed if any of them cannot be written, so no partial copies of
+// the secrets are left behind
+func writeDataDir(fc FileConfig, paths []string, contents map[string]string, mode, parentMode os.FileMode) (string, error) {
+	dir, err := ioutil.TempDir(fc.Path, ".."+time.Now().Format("2006_01_02_15_04_05."))
+	if err != nil {
+		return "", err
+	}
+	err = os.Chmod(dir, parentMode)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	for _, path := range paths {
+		keyFile := fc
+		keyFile.Path = filepath.Join(dir, filepath.Base(path))
+		err = writeFile(keyFile.Path, mode, 0, contents[path])
+		if err == nil {
+			err = chownFile(keyFile)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// swapKeysDirectory writes the files of a per-key file in a new directory
+// and atomically replaces the symlink to the current one, as Kubernetes
+// does with volumes, so consumers always see consistent versions of all
+// the files. Files in the per-key directory are symlinks to the files in
+// the current directory.
+func (p *pouch) swapKeysDirectory(fc FileConfig, paths []string, contents map[string]string, used map[string]bool) error {
+	dataLink := filepath.Join(fc.Path, dataLinkName)
+	if dataDirUnchanged(dataLink, paths, contents) {
+		infof("Content of '%s' didn't change, not written", fc.Path)
+		p.Metrics.Add(MetricFileWritesSkipped, metrics.Labels{"file": fc.Path}, 1)
+		for _, path := range paths {
+			p.State.AddManagedFile(path)
+			p.State.SetFileChecksum(path, contents[path])
+		}
+		return nil
+	}
+	for _, path := range paths {
+		keyFile := fc
+		keyFile.Path = path
+		err := p.checkUnmanaged(keyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	mode := fc.FileMode()
+	parentMode := fc.parentDirMode(mode)
+	err := parentDir(dataLink, parentMode)
+	if err != nil {
+		return err
+	}
+	if parentMode == 0 {
+		parentMode = dirMode(mode)
+	}
+	dir, err := writeDataDir(fc, paths, contents, mode, parentMode)
+	if err != nil {
+		return err
+	}
+
+	// Replace the symlink atomically with a rename
+	previous, _ := os.Readlink(dataLink)
+	tmpLink := dataLink + "_tmp"
+	os.Remove(tmpLink)
+	err = os.Symlink(filepath.Base(dir), tmpLink)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	err = os.Rename(tmpLink, dataLink)
+	if err != nil {
+		os.Remove(tmpLink)
+		os.RemoveAll(dir)
+		return fmt.Errorf("couldn't replace '%s': %v", dataLink, err)
+	}
+	if strings.HasPrefix(previous, "..") && previous != filepath.Base(dir) {
+		err = os.RemoveAll(filepath.Join(fc.Path, previous))
+		if err != nil {
+			errorf("Couldn't remove previous version of '%s': %v", fc.Path, err)
+		}
+	}
+
+	for _, path := range paths {
+		target := filepath.Join(dataLinkName, filepath.Base(path))
+		if current, err := os.Readlink(path); err != nil || current != target {
+			os.Remove(path)
+			err = os.Symlink(target, path)
+			if err != nil {
+				return err
+			}
+		}
+		p.State.AddManagedFile(path)
+		p.State.SetFileChecksum(path, contents[path])
+	}
+
+	usedNames := sortedUsedSecrets(used)
+	checksums := make(map[string]string, len(paths))
+	for _, path := range paths {
+		checksums[path] = contentChecksum(contents[path])
+	}
+	p.event(Event{
+		Type:    EventFileWritten,
+		File:    fc.Path,
+		Message: fmt.Sprintf("Written %d files into %s", len(paths), fc.Path),
+		Details: map[string]interface{}{
+			"sha256":  checksums,
+			"secrets": usedNames,
+		},
+	})
+	p.Metrics.Add(MetricFileWrites, metrics.Labels{"file": fc.Path}, 1)
+	for _, path := range paths {
+		p.recordProvenance(path, contents[path], usedNames)
+	}
+	p.addForNotify(fc.Path, fc.Notify...)
+	return nil
+}
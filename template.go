@@ -0,0 +1,174 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const DefaultTemplateEngine = "go"
+
+// Printed by go templates for missing values
+const missingValue = "<no value>"
+
+// Rendered content with this encoding is decoded before writing it, so
+// files can have binary content
+const Base64Encoding = "base64"
+
+// TemplateEngine renders the content of a file from a template source
+type TemplateEngine interface {
+	Render(name, source string, ctx *RenderContext) (string, error)
+}
+
+// RenderContext contains what engines can use to render a file
+type RenderContext struct {
+	// Functions available to templates
+	Funcs template.FuncMap
+
+	// Data passed to templates
+	Data interface{}
+
+	// Partial templates that can be included by name
+	Partials map[string]string
+
+	// Secret obtains the data of a secret, registering that it is
+	// used by the file being rendered
+	Secret func(name string) (SecretData, error)
+
+	// Names of the secrets the file declares to use, for engines that
+	// need to know them before rendering, all known secrets by default
+	Secrets []string
+
+	// URLs of the secrets by name, for engines that refer to secrets
+	// by their location
+	URLs map[string]string
+
+	// If set, rendering fails on missing keys instead of printing
+	// empty values
+	Strict bool
+}
+
+var (
+	templateEnginesLock sync.RWMutex
+	templateEngines     = map[string]TemplateEngine{
+		DefaultTemplateEngine: &goTemplateEngine{},
+	}
+)
+
+// RegisterTemplateEngine makes a template engine available for files
+// with the given name in their engine option
+func RegisterTemplateEngine(name string, e TemplateEngine) {
+	templateEnginesLock.Lock()
+	defer templateEnginesLock.Unlock()
+	templateEngines[name] = e
+}
+
+func getTemplateEngine(name string) (TemplateEngine, error) {
+	if name == "" {
+		name = DefaultTemplateEngine
+	}
+	templateEnginesLock.RLock()
+	defer templateEnginesLock.RUnlock()
+	e, found := templateEngines[name]
+	if !found {
+		return nil, fmt.Errorf("unknown template engine '%s', available: %v", name, templateEngineNames())
+	}
+	return e, nil
+}
+
+func templateEngineNames() []string {
+	var names []string
+	for name := range templateEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// templateSource obtains the template of a file and a name to refer to it
+func templateSource(fc FileConfig) (name, source string, err error) {
+	if fc.Template != "" && fc.TemplateFile != "" {
+		return "", "", fmt.Errorf("inline template and template file specified")
+	}
+	switch {
+	case fc.Template != "":
+		return "inline-template", fc.Template, nil
+	case fc.TemplateFile != "":
+		d, err := ioutil.ReadFile(fc.TemplateFile)
+		if err != nil {
+			return "", "", err
+		}
+		return fc.TemplateFile, string(d), nil
+	}
+	return "", "", fmt.Errorf("no content defined for file %s", fc.Path)
+}
+
+// decodeContent decodes rendered content in the given encoding, spaces
+// and line breaks are ignored
+func decodeContent(encoding, content string) (string, error) {
+	switch encoding {
+	case "":
+		return content, nil
+	case Base64Encoding:
+		d, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+		if err != nil {
+			return "", fmt.Errorf("couldn't decode base64 content: %v", err)
+		}
+		return string(d), nil
+	}
+	return "", fmt.Errorf("unknown encoding '%s'", encoding)
+}
+
+type goTemplateEngine struct{}
+
+func (*goTemplateEngine) Render(name, source string, ctx *RenderContext) (string, error) {
+	missingKey := "missingkey=default"
+	if ctx.Strict {
+		missingKey = "missingkey=error"
+	}
+	t, err := template.New(name).Funcs(ctx.Funcs).Option(missingKey).Parse(source)
+	if err != nil {
+		return "", err
+	}
+	for partial, partialSource := range ctx.Partials {
+		if t.Lookup(partial) != nil {
+			// Defined in the template itself
+			continue
+		}
+		_, err = t.New(partial).Option(missingKey).Parse(partialSource)
+		if err != nil {
+			return "", fmt.Errorf("incorrect partial template '%s': %v", partial, err)
+		}
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, ctx.Data)
+	if err != nil {
+		return "", err
+	}
+	if ctx.Strict && strings.Contains(b.String(), missingValue) {
+		// Nil values returned by functions are not missing keys
+		return "", fmt.Errorf("template %s printed a missing value", name)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/tracing"
+)
+
+const (
+	// Interval between exports of spans if none is configured
+	DefaultTracingInterval = 5 * time.Second
+
+	DefaultTracingServiceName = "pouch"
+
+	// Timeout of the last export when pouch stops
+	tracingFlushTimeout = 5 * time.Second
+)
+
+// TracingConfig defines an OpenTelemetry collector where spans of secret
+// reads, template rendering, file writes and notifications are exported
+type TracingConfig struct {
+	// Base URL of the OTLP/HTTP endpoint, e.g. http://localhost:4318
+	Endpoint string `json:"endpoint"`
+
+	// Headers added to export requests, e.g. for authentication
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Name of the service in the spans, pouch by default
+	ServiceName string `json:"service_name,omitempty"`
+
+	// Interval between exports, 5s by default
+	Interval string `json:"interval,omitempty"`
+}
+
+func (p *pouch) Tracing(c TracingConfig) error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("endpoint of tracing collector needed")
+	}
+	p.tracingInterval = DefaultTracingInterval
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil {
+			return fmt.Errorf("incorrect tracing interval: %v", err)
+		}
+		p.tracingInterval = d
+	}
+	serviceName := c.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultTracingServiceName
+	}
+	p.tracer = &tracing.Tracer{
+		Endpoint:    c.Endpoint,
+		Headers:     c.Headers,
+		ServiceName: serviceName,
+		Filter:      redactSecrets,
+	}
+	return nil
+}
+
+// startSpan starts a span as child of the current one, if any, and makes
+// it the current one till the returned function is called to end it.
+// Spans are only used from the main loop, so there is no need to
+// synchronize them.
+func (p *pouch) startSpan(name string, kind tracing.SpanKind, attrs tracing.Attributes) func(error) {
+	parent := p.span
+	span := p.tracer.Start(parent, name, kind, attrs)
+	if span != nil {
+		p.span = span
+	}
+	return func(err error) {
+		span.End(err)
+		p.span = parent
+	}
+}
+
+// startTracing exports spans periodically till the context is done
+func (p *pouch) startTracing(ctx context.Context) {
+	if p.tracer == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.tracingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.exportSpans(ctx)
+			case <-ctx.Done():
+				flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+				p.exportSpans(flushCtx)
+				cancel()
+				return
+			}
+		}
+	}()
+}
+
+func (p *pouch) exportSpans(ctx context.Context) {
+	err := p.tracer.Export(ctx)
+	if err != nil {
+		errorf("Couldn't export spans to %s: %v", p.tracer.Endpoint, err)
+	}
+}
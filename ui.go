@@ -0,0 +1,248 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Maximum number of events shown in the dashboard
+const dashboardEvents = 50
+
+// dashboard is a snapshot of the information shown in the web UI, it is
+// taken from the main loop so the UI doesn't access the state concurrently
+type dashboard struct {
+	Time    time.Time
+	Status  Status
+	Message string
+
+	Secrets   []dashboardSecret
+	Files     []dashboardFile
+	Notifiers []dashboardNotifier
+	Events    []Event
+}
+
+type dashboardSecret struct {
+	Name       string
+	Optional   bool
+	LastUpdate time.Time
+	NextUpdate *time.Time
+	Expiration *time.Time
+}
+
+type dashboardFile struct {
+	Path    string
+	Secrets []string
+	Notify  []string
+}
+
+type dashboardNotifier struct {
+	Name string
+
+	// Last notification found in recent events, if any
+	Last *Event
+}
+
+// updateDashboard takes a snapshot of the state for the web UI
+func (p *pouch) updateDashboard() {
+	if p.statusServer == nil || !p.statusServer.UI {
+		return
+	}
+	d := &dashboard{}
+	for name, s := range p.State.Secrets {
+		secret := dashboardSecret{
+			Name:       name,
+			Optional:   p.Secrets[name].Optional,
+			LastUpdate: s.Timestamp,
+		}
+		if ttu, known := s.TimeToUpdate(); known && !s.DisableAutoUpdate {
+			secret.NextUpdate = &ttu
+		}
+		if expiration, known := s.Expiration(); known {
+			secret.Expiration = &expiration
+		}
+		d.Secrets = append(d.Secrets, secret)
+	}
+	sort.Slice(d.Secrets, func(i, j int) bool { return d.Secrets[i].Name < d.Secrets[j].Name })
+
+	for path, fc := range p.Files {
+		d.Files = append(d.Files, dashboardFile{Path: path, Secrets: fc.Secrets, Notify: fc.Notify.Names()})
+	}
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Path < d.Files[j].Path })
+
+	for name := range p.Notifiers {
+		d.Notifiers = append(d.Notifiers, dashboardNotifier{Name: name})
+	}
+	sort.Slice(d.Notifiers, func(i, j int) bool { return d.Notifiers[i].Name < d.Notifiers[j].Name })
+
+	p.statusLock.Lock()
+	p.dashboard = d
+	p.statusLock.Unlock()
+}
+
+// currentDashboard completes the last snapshot with the current status and
+// the recent events
+func (p *pouch) currentDashboard() dashboard {
+	p.statusLock.Lock()
+	var d dashboard
+	if p.dashboard != nil {
+		d = *p.dashboard
+	}
+	p.statusLock.Unlock()
+
+	d.Time = time.Now()
+	d.Status, d.Message = p.Status()
+
+	events := p.Events.Recent()
+	notifiers := make([]dashboardNotifier, len(d.Notifiers))
+	copy(notifiers, d.Notifiers)
+	for i := range notifiers {
+		for j := len(events) - 1; j >= 0; j-- {
+			if events[j].Type == EventNotification && events[j].Notifier == notifiers[i].Name {
+				notifiers[i].Last = &events[j]
+				break
+			}
+		}
+	}
+	d.Notifiers = notifiers
+
+	// Most recent first
+	for i := len(events) - 1; i >= 0 && len(d.Events) < dashboardEvents; i-- {
+		d.Events = append(d.Events, events[i])
+	}
+	return d
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// dashboardHandler serves the web UI, only to local clients, as it shows
+// information about the secrets managed, though never their values
+func (s *StatusServer) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !isLoopback(r.RemoteAddr) {
+		http.Error(w, "dashboard only available from localhost", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, s.pouch.currentDashboard())
+	if err != nil {
+		errorf("Couldn't render dashboard: %v", err)
+	}
+}
+
+func formatTime(t interface{}) string {
+	var tt time.Time
+	switch v := t.(type) {
+	case time.Time:
+		tt = v
+	case *time.Time:
+		if v == nil {
+			return "-"
+		}
+		tt = *v
+	}
+	if tt.IsZero() {
+		return "-"
+	}
+	return tt.Format(time.RFC3339)
+}
+
+func fromNow(now time.Time, t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	d := t.Sub(now).Truncate(time.Second)
+	if d < 0 {
+		return (-d).String() + " ago"
+	}
+	return "in " + d.String()
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time":    formatTime,
+	"fromNow": fromNow,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>pouch</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #eee; }
+.ready { color: green; } .degraded { color: orange; } .not_ready, .failed { color: red; }
+</style>
+</head>
+<body>
+<h1>pouch</h1>
+<p>Status: <strong class="{{.Status}}">{{.Status}}</strong>{{with .Message}} ({{.}}){{end}}, at {{time .Time}}</p>
+
+<h2>Secrets</h2>
+<table>
+<tr><th>Name</th><th>Last update</th><th>Next update</th><th>Expiration</th></tr>
+{{$now := .Time}}{{range .Secrets}}<tr>
+<td>{{.Name}}{{if .Optional}} (optional){{end}}</td>
+<td>{{time .LastUpdate}}</td>
+<td>{{time .NextUpdate}} {{fromNow $now .NextUpdate}}</td>
+<td>{{time .Expiration}} {{fromNow $now .Expiration}}</td>
+</tr>
+{{else}}<tr><td colspan="4">No secrets</td></tr>
+{{end}}</table>
+
+<h2>Files</h2>
+<table>
+<tr><th>Path</th><th>Secrets</th><th>Notifiers</th></tr>
+{{range .Files}}<tr><td>{{.Path}}</td><td>{{range $i, $s := .Secrets}}{{if $i}}, {{end}}{{$s}}{{end}}</td><td>{{range $i, $n := .Notify}}{{if $i}}, {{end}}{{$n}}{{end}}</td></tr>
+{{else}}<tr><td colspan="3">No files</td></tr>
+{{end}}</table>
+
+<h2>Notifiers</h2>
+<table>
+<tr><th>Name</th><th>Last notification</th><th>Result</th></tr>
+{{range .Notifiers}}<tr><td>{{.Name}}</td>{{with .Last}}<td>{{time .Time}}</td><td{{if not .Details.success}} class="failed"{{end}}>{{.Message}}</td>{{else}}<td>-</td><td>No recent notifications</td>{{end}}</tr>
+{{else}}<tr><td colspan="3">No notifiers</td></tr>
+{{end}}</table>
+
+<h2>Recent events</h2>
+<table>
+<tr><th>Time</th><th>Type</th><th>Message</th></tr>
+{{range .Events}}<tr><td>{{time .Time}}</td><td>{{.Type}}</td><td>{{.Message}}</td></tr>
+{{else}}<tr><td colspan="3">No events</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
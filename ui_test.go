@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusServerDashboard(t *testing.T) {
+	p := NewPouch(NewState(""), nil,
+		map[string]SecretConfig{"foo": {}},
+		[]FileConfig{{Path: "/etc/foo.conf", Secrets: []string{"foo"}, Notify: NotifyNames("reload")}},
+		map[string]NotifierConfig{"reload": {Command: "true"}},
+	).(*pouch)
+	p.State.SetSecret("foo", &api.Secret{LeaseDuration: 3600, Data: map[string]interface{}{"password": "supersecret"}})
+	p.event(Event{Type: EventNotification, Notifier: "reload", Message: "Notification to 'reload' done", Details: map[string]interface{}{"success": true}})
+	p.StatusListener(StatusConfig{Listen: "127.0.0.1:0"})
+	s := p.statusServer
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		s.dashboard(w, r)
+		return w
+	}
+
+	// Disabled by default
+	assert.Equal(t, http.StatusNotFound, get("127.0.0.1:1234").Code)
+
+	s.UI = true
+	p.updateStatus()
+	p.updateDashboard()
+	assert.Equal(t, http.StatusForbidden, get("192.0.2.1:1234").Code)
+
+	w := get("127.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "ready")
+	assert.Contains(t, body, "<td>foo</td>")
+	assert.Contains(t, body, "/etc/foo.conf")
+	assert.Contains(t, body, "Notification to &#39;reload&#39; done")
+	assert.NotContains(t, body, "supersecret")
+
+	assert.Equal(t, http.StatusOK, get("[::1]:1234").Code)
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+)
+
+// validationFuncs returns the names of the functions available in file
+// templates of the given engine, with functions that do nothing, enough
+// to parse them
+func validationFuncs(engine string, pf *Pouchfile) template.FuncMap {
+	stub := func(...interface{}) interface{} { return nil }
+	funcs := template.FuncMap{}
+	for _, funcMap := range fileFuncMaps {
+		for name := range funcMap {
+			funcs[name] = stub
+		}
+	}
+	for name := range pf.TemplateFunctions {
+		funcs[name] = stub
+	}
+	for name := range discoveryFuncs[DefaultTemplateEngine] {
+		funcs[name] = stub
+	}
+	if engine == ConsulTemplateEngine {
+		for name := range (&consulTemplateEngine{}).funcs(&RenderContext{}) {
+			funcs[name] = stub
+		}
+	}
+	return funcs
+}
+
+// validSecretReference returns true if a name used to refer to a secret
+// is configured, or if it is a path that can be discovered
+func validSecretReference(name string, pf *Pouchfile) bool {
+	if _, found := pf.Secrets[name]; found {
+		return true
+	}
+	prefix := strings.SplitN(name, "/", 2)[0]
+	if c, found := pf.Secrets[prefix]; found && c.IsGlob() {
+		return true
+	}
+	return strings.Contains(name, "/") && prefix != name
+}
+
+// validateTemplate parses a go template and checks that the secrets it
+// uses by name are configured
+func validateTemplate(fc FileConfig, pf *Pouchfile) []error {
+	var errs []error
+	engine := fc.Engine
+	if engine == "" {
+		engine = DefaultTemplateEngine
+	}
+	name, source, err := templateSource(fc)
+	if err != nil {
+		return []error{err}
+	}
+	_, err = template.New(name).Funcs(validationFuncs(engine, pf)).Parse(source)
+	if err != nil {
+		return []error{err}
+	}
+	if engine != DefaultTemplateEngine {
+		// Secrets are referred by their location, they can always
+		// be discovered
+		return nil
+	}
+	refs, err := templateReferences(name, source, discoveryFuncs[engine])
+	if err != nil {
+		return []error{err}
+	}
+	for ref := range refs {
+		if !validSecretReference(ref, pf) {
+			errs = append(errs, fmt.Errorf("unknown secret '%s'", ref))
+		}
+	}
+	return errs
+}
+
+// validateFile checks the configuration and the template of a file
+func validateFile(fc FileConfig, pf *Pouchfile) []error {
+	var errs []error
+	if fc.Template != "" && fc.TemplateFile != "" {
+		// Checked here because per-key files and some engines don't
+		// need a template
+		errs = append(errs, fmt.Errorf("template and template_file are mutually exclusive"))
+	}
+	for _, name := range fc.Secrets {
+		if !validSecretReference(name, pf) {
+			errs = append(errs, fmt.Errorf("unknown secret '%s'", name))
+		}
+	}
+	if fc.ForEach != "" {
+		if c, found := pf.Secrets[fc.ForEach]; !found || !c.IsGlob() {
+			errs = append(errs, fmt.Errorf("'%s' is not a glob secret", fc.ForEach))
+		}
+	}
+	for _, n := range fc.Notify {
+		if _, found := pf.Notifiers[n.Notifier]; !found {
+			errs = append(errs, fmt.Errorf("unknown notifier '%s'", n.Notifier))
+		}
+	}
+	if fc.HasPathTemplate() {
+		funcs := template.FuncMap{"secret": func(string, string) string { return "" }}
+		_, err := template.New("path").Funcs(sprigFuncMap).Funcs(dataFuncMap).Funcs(funcs).Parse(fc.Path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("incorrect path template: %v", err))
+		}
+	}
+
+	engine, err := getTemplateEngine(fc.Engine)
+	if err != nil {
+		return append(errs, err)
+	}
+	if fc.PerKey || (templateOptional(engine) && fc.Template == "" && fc.TemplateFile == "") {
+		return errs
+	}
+	if fc.Template != "" && fc.TemplateFile != "" {
+		return errs
+	}
+	switch fc.Engine {
+	case "", DefaultTemplateEngine, ConsulTemplateEngine:
+		errs = append(errs, validateTemplate(fc, pf)...)
+	default:
+		// Other engines can only be checked when rendering
+		_, _, err := templateSource(fc)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// ValidatePouchfile checks the templates of the files of a Pouchfile,
+// without reading any secret, it reports syntax errors, unknown functions,
+// and references to secrets and notifiers that are not configured
+func ValidatePouchfile(pf *Pouchfile) []error {
+	var errs []error
+	partials := make(map[string]string)
+	if pf.TemplatesDir != "" {
+		templates, err := LoadTemplatesDir(pf.TemplatesDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't load templates: %v", err))
+		}
+		for name, source := range templates {
+			partials[name] = source
+		}
+	}
+	for name, source := range pf.Templates {
+		partials[name] = source
+	}
+	for name, source := range partials {
+		_, err := template.New(name).Funcs(validationFuncs(DefaultTemplateEngine, pf)).Parse(source)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("partial template '%s': %v", name, err))
+		}
+	}
+	for _, fc := range pf.Files {
+		for _, err := range validateFile(fc, pf) {
+			errs = append(errs, fmt.Errorf("file '%s': %v", fc.Path, err))
+		}
+	}
+	for name, n := range pf.Notifiers {
+		for _, dependency := range n.After {
+			if _, found := pf.Notifiers[dependency]; !found {
+				errs = append(errs, fmt.Errorf("notifier '%s': unknown notifier '%s' in after", name, dependency))
+			}
+		}
+		if n.When != nil && n.When.Window != "" {
+			if _, err := parseCronSchedule(n.When.Window); err != nil {
+				errs = append(errs, fmt.Errorf("notifier '%s': %v", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// jsonFieldName returns the name of a struct field in JSON documents, and
+// false if it is not decoded
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := strings.Split(f.Tag.Get("json"), ",")[0]
+	if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+		return "", false
+	}
+	if tag == "" {
+		return f.Name, true
+	}
+	return tag, true
+}
+
+// structField finds the field of a struct that is decoded from a key of a
+// JSON object, looking also in embedded structs, as encoding/json does
+func structField(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := jsonFieldName(f)
+		if !ok {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && f.Tag.Get("json") == "" && ft.Kind() == reflect.Struct {
+			if embedded, found := structField(ft, key); found {
+				return embedded, true
+			}
+			continue
+		}
+		if strings.EqualFold(name, key) {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields returns the keys of a decoded document that don't
+// correspond to any field of the type it is decoded into
+func unknownFields(value interface{}, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var errs []error
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		switch t.Kind() {
+		case reflect.Map:
+			for _, key := range keys {
+				name := strings.TrimPrefix(path+"."+key, ".")
+				errs = append(errs, unknownFields(v[key], t.Elem(), name)...)
+			}
+		case reflect.Struct:
+			for _, key := range keys {
+				name := strings.TrimPrefix(path+"."+key, ".")
+				ft, found := structField(t, key)
+				if !found {
+					errs = append(errs, fmt.Errorf("unknown field '%s'", name))
+					continue
+				}
+				errs = append(errs, unknownFields(v[key], ft, name)...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array || reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+			return nil
+		}
+		for i, item := range v {
+			errs = append(errs, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return errs
+}
+
+// ValidatePouchfileFields reads the Pouchfile in a path and reports the
+// options that are not known, they are ignored when it is loaded, so
+// misspelled options would be silently ignored too
+func ValidatePouchfileFields(path string) []error {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	var value interface{}
+	err = yaml.Unmarshal(d, &value)
+	if err != nil {
+		return []error{err}
+	}
+	return unknownFields(value, reflect.TypeOf(Pouchfile{}), "")
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePouchfile(t *testing.T) {
+	pf := &Pouchfile{
+		Secrets: map[string]SecretConfig{
+			"app":   {VaultURL: "/v1/secret/app"},
+			"certs": {VaultURL: "/v1/secret/certs/*"},
+		},
+		Notifiers: map[string]NotifierConfig{
+			"reload": {Command: "true"},
+		},
+		TemplateFunctions: map[string]TemplateFunctionConfig{
+			"custom": {},
+		},
+		Templates: map[string]string{
+			"header": `# {{ .Name }}`,
+		},
+		Files: []FileConfig{
+			{Path: "/valid", Template: `{{ template "header" . }}{{ secret "app" "password" | b64enc | custom }}{{ secret "certs/web" "cert" }}{{ secret "secret/db" "password" }}`, Notify: NotifyNames("reload")},
+			{Path: "/consul", Engine: ConsulTemplateEngine, Template: `{{ with secret "secret/app" }}{{ .Data.password | toJSON }}{{ end }}`},
+			{Path: "/keys", PerKey: true, Secrets: []string{"app"}},
+			{Path: "/env", Engine: DotenvEngine, Secrets: []string{"app"}},
+			{Path: "/{{ .Key }}", ForEach: "certs", Template: `{{ secret .Secret "cert" }}`},
+
+			{Path: "/syntax", Template: `{{ secret "app" "password" `},
+			{Path: "/function", Template: `{{ unknownFunction }}`},
+			{Path: "/secret", Template: `{{ secret "unknown" "password" }}`, Secrets: []string{"other"}},
+			{Path: "/notifier", Template: `foo`, Notify: NotifyNames("unknown")},
+			{Path: "/engine", Engine: "unknown", Template: `foo`},
+			{Path: "/empty"},
+			{Path: "/{{ .Key }}/glob", ForEach: "app", Template: `foo`},
+		},
+	}
+	errs := ValidatePouchfile(pf)
+	invalid := make(map[string]int)
+	for _, err := range errs {
+		path := strings.SplitN(err.Error(), "'", 3)[1]
+		invalid[path]++
+	}
+	assert.Equal(t, map[string]int{
+		"/syntax":          1,
+		"/function":        1,
+		"/secret":          2,
+		"/notifier":        1,
+		"/engine":          1,
+		"/empty":           1,
+		"/{{ .Key }}/glob": 1,
+	}, invalid)
+}
+
+func TestValidatePouchfileTemplateAndTemplateFile(t *testing.T) {
+	pf := &Pouchfile{
+		Files: []FileConfig{
+			{Path: "/both", Template: `foo`, TemplateFile: "/tmp/foo.tmpl"},
+			{Path: "/keys", PerKey: true, Template: `foo`, TemplateFile: "/tmp/foo.tmpl"},
+		},
+	}
+	errs := ValidatePouchfile(pf)
+	if assert.Len(t, errs, 2) {
+		assert.Equal(t, "file '/both': template and template_file are mutually exclusive", errs[0].Error())
+		assert.Equal(t, "file '/keys': template and template_file are mutually exclusive", errs[1].Error())
+	}
+}
+
+func TestValidatePouchfileFields(t *testing.T) {
+	f, err := ioutil.TempFile("", "Pouchfile")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+vault:
+  address: http://localhost:8200
+  ca_cert: /etc/ca.pem
+  insecure: true
+secrets:
+  db:
+    vault_url: /v1/secret/db
+    vault_ulr: /v1/secret/typo
+notifiers:
+  reload:
+    command: "true"
+    debounce: 1s
+files:
+- path: /etc/db.conf
+  mode: 0600
+  tempalte: foo
+  notify:
+  - reload
+  - notifier: reload
+    signal: SIGHUP
+    unkown: foo
+providers:
+  custom:
+    anything: goes
+bogus: 1
+`)
+	f.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var messages []string
+	for _, err := range ValidatePouchfileFields(f.Name()) {
+		messages = append(messages, err.Error())
+	}
+	assert.Equal(t, []string{
+		"unknown field 'bogus'",
+		"unknown field 'files[0].notify[1].unkown'",
+		"unknown field 'files[0].tempalte'",
+		"unknown field 'secrets.db.vault_ulr'",
+		"unknown field 'vault.insecure'",
+	}, messages)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	DefaultWasmRuntime = "wasmtime"
+	DefaultWasmTimeout = 10 * time.Second
+)
+
+type TemplateFunctionConfig struct {
+	// Path to a WASI module implementing the function
+	Module string `json:"module,omitempty"`
+
+	// Runtime used to run the module, wasmtime by default
+	Runtime string `json:"runtime,omitempty"`
+
+	// Maximum time for each call
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// WasmFunction is a template function implemented in a WASI module. The
+// module is run for each call, it receives the arguments of the function
+// as a JSON array in its standard input, and its standard output is the
+// result. The module runs in the sandbox of the runtime, without access
+// to the filesystem, the network or the environment.
+type WasmFunction struct {
+	Name    string
+	Module  string
+	Runtime string
+	Timeout time.Duration
+}
+
+func NewWasmFunction(name string, c TemplateFunctionConfig) (*WasmFunction, error) {
+	if c.Module == "" {
+		return nil, fmt.Errorf("module for template function '%s' not set", name)
+	}
+	f := &WasmFunction{
+		Name:    name,
+		Module:  c.Module,
+		Runtime: c.Runtime,
+		Timeout: DefaultWasmTimeout,
+	}
+	if f.Runtime == "" {
+		f.Runtime = DefaultWasmRuntime
+	}
+	if c.Timeout != "" {
+		t, err := time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect timeout for template function '%s': %v", name, err)
+		}
+		f.Timeout = t
+	}
+	return f, nil
+}
+
+// Call runs the module, it can be used as a template function
+func (f *WasmFunction) Call(args ...interface{}) (string, error) {
+	input, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), f.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(c, f.Runtime, "run", f.Module)
+	cmd.Env = []string{}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("template function '%s' failed: %v: %s", f.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (p *pouch) AddTemplateFunction(name string, f interface{}) {
+	if p.templateFuncs == nil {
+		p.templateFuncs = make(template.FuncMap)
+	}
+	p.templateFuncs[name] = f
+}
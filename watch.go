@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"time"
+)
+
+// Time to wait before watching again a secret after a failure
+const SecretWatchRetryPeriod = 30 * time.Second
+
+// SecretWatcher is implemented by providers that can notify changes in
+// their secrets, so they are updated as soon as they change. Watch blocks
+// till the secret in the path changes, it is called after the secret has
+// been requested at least once.
+type SecretWatcher interface {
+	Watch(ctx context.Context, path string) error
+}
+
+// startWatchers starts watching secrets and files that support it, they
+// are stopped when ctx is done, or restarted by restartWatchers
+func (p *pouch) startWatchers(ctx context.Context) error {
+	watchCtx, cancel := context.WithCancel(ctx)
+	p.watchSecrets(watchCtx)
+	err := p.watchFiles(watchCtx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	p.watchParent = ctx
+	p.cancelWatch = cancel
+	return nil
+}
+
+// restartWatchers stops the current watchers and starts them again for
+// the secrets and files configured, if they were started
+func (p *pouch) restartWatchers() error {
+	if p.cancelWatch == nil {
+		return nil
+	}
+	p.cancelWatch()
+	return p.startWatchers(p.watchParent)
+}
+
+// watchSecrets starts watching secrets from providers supporting it,
+// names of changed secrets are sent to the changed channel
+func (p *pouch) watchSecrets(ctx context.Context) {
+	for name, c := range p.Secrets {
+		if c.Plugin != "" {
+			continue
+		}
+		provider, path, err := p.provider(c)
+		if err != nil {
+			continue
+		}
+		watcher, ok := provider.(SecretWatcher)
+		if !ok {
+			continue
+		}
+		go p.watchSecret(ctx, name, path, watcher)
+	}
+}
+
+func (p *pouch) watchSecret(ctx context.Context, name, path string, watcher SecretWatcher) {
+	for {
+		err := watcher.Watch(ctx, path)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			warnf("Couldn't watch secret '%s', it will be updated when it expires: %v", name, err)
+			select {
+			case <-time.After(SecretWatchRetryPeriod):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case p.changed <- name:
+		case <-ctx.Done():
+			return
+		}
+	}
+}